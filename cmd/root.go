@@ -115,7 +115,7 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 	log.Debug("running in debug mode")
 	log.WithField("config_file", configPath).Info("loading configuration from file")
 
-	if isDockerSnap() {
+	if !config.Get().Native.Enabled && isDockerSnap() {
 		log.Error("Docker Snap installation detected. Exiting...")
 		os.Exit(1)
 	}
@@ -174,7 +174,9 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 		return
 	}
 
-	if err := environment.ConfigureDocker(cmd.Context()); err != nil {
+	if config.Get().Native.Enabled {
+		log.Info("native environment enabled: servers will run directly on the host without docker")
+	} else if err := environment.ConfigureDocker(cmd.Context()); err != nil {
 		log.WithField("error", err).Fatal("failed to configure docker environment")
 		return
 	}
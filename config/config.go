@@ -71,6 +71,122 @@ type SftpConfiguration struct {
 	ReadOnly bool `default:"false" yaml:"read_only"`
 	// If set to true users won't be able to login using their password.
 	KeyOnly bool `default:"false" yaml:"key_only"`
+	// Limits applied to SFTP sessions, these can be overridden for an individual server
+	// by the Panel.
+	Limits SftpLimits `yaml:"limits"`
+	// Lockout protects the SFTP server, and the Panel behind it, against brute-force
+	// login attempts.
+	Lockout SftpLockoutConfiguration `yaml:"lockout"`
+	// CredentialCache allows users to log in with a public key while the Panel is
+	// unreachable.
+	CredentialCache SftpCredentialCacheConfiguration `yaml:"credential_cache"`
+	// Shell allows users to access the server console, and run commands within the
+	// server container, over SSH.
+	Shell SftpShellConfiguration `yaml:"shell"`
+	// The types of host key presented to clients, which can be "ed25519", "ecdsa" and "rsa".
+	// Any key that does not exist yet is generated when the SFTP server starts.
+	HostKeys []string `default:"[\"ed25519\"]" json:"host_keys" yaml:"host_keys"`
+	// Additional addresses the SFTP server listens on, each of which is dedicated to a single
+	// server so that users can log in without the server suffix on their username.
+	Listeners []SftpListener `json:"listeners" yaml:"listeners"`
+}
+
+// SftpListener is an additional address that the SFTP server listens on for a single server,
+// such as a dedicated IP address assigned to that server.
+type SftpListener struct {
+	Address string `json:"bind_address" yaml:"bind_address"`
+	Port    int    `json:"bind_port" yaml:"bind_port"`
+	// The UUID of the server that every login on this address is for.
+	Server string `json:"server" yaml:"server"`
+	// The path of a private key presented as the host key on this address instead of
+	// the host keys of the main listener, so that a server with its own address can
+	// also have its own fingerprint. An ED25519 key is generated if it does not exist.
+	HostKey string `json:"host_key,omitempty" yaml:"host_key,omitempty"`
+}
+
+// SftpShellConfiguration controls SSH shell and exec access through the SFTP server. Both
+// require the user to have the "control.console" permission for the server.
+type SftpShellConfiguration struct {
+	// If set to true, requesting a shell attaches the user to the server console, where
+	// every line they enter is sent to the server as a command.
+	Enabled bool `default:"false" yaml:"enabled"`
+	// The commands that users can run inside the server container by passing a command
+	// to ssh, such as "ls -la" or "cat *". Commands are not passed through a shell, and
+	// every argument must match one of these commands, where "*" matches any single
+	// argument that is not an option. If this is empty no commands can be run. Neither
+	// the shell nor commands are available to users with path rules, or when SFTP is
+	// read-only.
+	AllowedCommands []string `yaml:"allowed_commands"`
+}
+
+// SftpCredentialCacheConfiguration controls the local cache of successful public key
+// logins. When enabled every successful public key login is stored in the local database,
+// and if the Panel cannot be reached a user can log in with a key that is in the cache.
+// Password logins are never cached.
+type SftpCredentialCacheConfiguration struct {
+	Enabled bool `default:"false" yaml:"enabled"`
+	// The number of seconds a cached login remains valid for after it last succeeded
+	// against the Panel.
+	TTL int `default:"86400" yaml:"ttl"`
+}
+
+// SftpLockoutConfiguration controls how IP addresses and usernames are locked out of the
+// SFTP server after repeated failed logins. While an IP address is locked out every
+// connection from it is closed immediately, and while a username is locked out any login
+// attempt for it is rejected without contacting the Panel.
+type SftpLockoutConfiguration struct {
+	// Enabled turns on locking out IP addresses and usernames. DeniedIPs are refused
+	// either way.
+	Enabled bool `default:"false" yaml:"enabled"`
+	// The number of failed logins from an IP address, or for a username, before it is
+	// locked out.
+	MaxAttempts int `default:"5" yaml:"max_attempts"`
+	// The number of seconds an IP address or username is locked out for the first time.
+	// This doubles every time it is locked out again, up to MaxDuration seconds. Failed
+	// logins are forgotten once there have been none for MaxDuration seconds.
+	Duration    int `default:"60" yaml:"duration"`
+	MaxDuration int `default:"3600" yaml:"max_duration"`
+	// IP addresses or CIDR ranges that are never locked out, such as the Panel or a
+	// trusted office network.
+	AllowedIPs []string `yaml:"allowed_ips"`
+	// IP addresses or CIDR ranges that are never allowed to connect to the SFTP server.
+	// These take precedence over AllowedIPs.
+	DeniedIPs []string `yaml:"denied_ips"`
+}
+
+// SftpLimits limits the number of concurrent SFTP sessions to a server and the bandwidth
+// they can use. Bandwidth limits are in MiB/s and apply separately to data read from the
+// server (downloads) and written to it (uploads).
+//
+// A value of 0 means there is no limit. When set for an individual server a value of 0
+// uses the limit from the Wings configuration instead, and a value less than 0 removes it.
+type SftpLimits struct {
+	// The maximum number of SFTP sessions that can be connected to a server at once.
+	MaxSessions int `default:"0" json:"max_sessions" yaml:"max_sessions"`
+	// The read and write bandwidth available to each individual SFTP session.
+	SessionReadLimit  int `default:"0" json:"session_read_limit" yaml:"session_read_limit"`
+	SessionWriteLimit int `default:"0" json:"session_write_limit" yaml:"session_write_limit"`
+	// The read and write bandwidth shared between every SFTP session to a server.
+	ServerReadLimit  int `default:"0" json:"server_read_limit" yaml:"server_read_limit"`
+	ServerWriteLimit int `default:"0" json:"server_write_limit" yaml:"server_write_limit"`
+}
+
+// Merge returns the limits with every value that is not set taken from the
+// fallback limits instead.
+func (l SftpLimits) Merge(fallback SftpLimits) SftpLimits {
+	pick := func(v, f int) int {
+		if v == 0 {
+			return f
+		}
+		return v
+	}
+	return SftpLimits{
+		MaxSessions:       pick(l.MaxSessions, fallback.MaxSessions),
+		SessionReadLimit:  pick(l.SessionReadLimit, fallback.SessionReadLimit),
+		SessionWriteLimit: pick(l.SessionWriteLimit, fallback.SessionWriteLimit),
+		ServerReadLimit:   pick(l.ServerReadLimit, fallback.ServerReadLimit),
+		ServerWriteLimit:  pick(l.ServerWriteLimit, fallback.ServerWriteLimit),
+	}
 }
 
 // ApiConfiguration defines the configuration for the internal API that is
@@ -214,6 +330,15 @@ type SystemConfiguration struct {
 	// disk usage is not a concern.
 	DiskCheckInterval int64 `default:"150" yaml:"disk_check_interval"`
 
+	// DiskQuotaBackend determines how the disk usage of a server is tracked and its disk limit
+	// enforced. With "walk" the usage is calculated by walking the data directory every
+	// DiskCheckInterval and the limit is only enforced by Wings. With "project" every data
+	// directory is assigned a kernel project quota, which requires XFS or ext4 mounted with the
+	// "prjquota" option. The usage is then read from the kernel and the limit also applies to
+	// files written by the server process itself. Data directories that do not support project
+	// quotas fall back to "walk".
+	DiskQuotaBackend string `default:"walk" yaml:"disk_quota_backend"`
+
 	// ActivitySendInterval is the amount of time that should ellapse between aggregated server activity
 	// being sent to the Panel. By default this will send activity collected over the last minute. Keep
 	// in mind that only a fixed number of activity log entries, defined by ActivitySendCount, will be sent
@@ -243,10 +368,26 @@ type SystemConfiguration struct {
 	// The ammount of lines the activity logs should log on server crash
 	CrashActivityLogLines int `default:"2" yaml:"crash_detection_activity_lines"`
 
+	// ZstdCompressionLevel is the zstd compression level (1-22) used when creating ".tar.zst"
+	// archives through the file manager, and for backups and transfers when the backup
+	// compression level is set to "zstd". Higher levels produce smaller archives at the cost
+	// of considerably more CPU time; levels above 11 are rarely worth it for game servers.
+	ZstdCompressionLevel int `default:"3" yaml:"zstd_compression_level"`
+
 	Backups Backups `yaml:"backups"`
 
 	Transfers Transfers `yaml:"transfers"`
 
+	FileJobs FileJobs `yaml:"file_jobs"`
+
+	FileWatcher FileWatcher `yaml:"file_watcher"`
+
+	Query Query `yaml:"query"`
+
+	Idle Idle `yaml:"idle"`
+
+	Traffic Traffic `yaml:"traffic"`
+
 	OpenatMode string `default:"auto" yaml:"openat_mode"`
 }
 
@@ -261,8 +402,88 @@ type CrashDetection struct {
 
 	// Timeout specifies the timeout between crashes that will not cause the server
 	// to be automatically restarted, this value is used to prevent servers from
-	// becoming stuck in a boot-loop after multiple consecutive crashes.
+	// becoming stuck in a boot-loop after multiple consecutive crashes. This is only
+	// used if MaxRestarts is 0.
 	Timeout int `default:"60" json:"timeout"`
+
+	// MaxRestarts is the number of times a server is automatically restarted after
+	// crashing within Window seconds. If it crashes again it is marked as crash-looping
+	// and is not restarted until it is started manually. This is disabled by default,
+	// in which case Timeout is used instead.
+	MaxRestarts int `default:"0" yaml:"max_restarts"`
+	Window      int `default:"600" yaml:"window"`
+
+	// Backoff is the number of seconds to wait before restarting a server after its
+	// first crash within the window. This is doubled for each further crash, up to
+	// MaxBackoff seconds.
+	Backoff    int `default:"5" yaml:"backoff"`
+	MaxBackoff int `default:"300" yaml:"max_backoff"`
+
+	// ReportLines is the number of lines of console output saved in the crash report
+	// created for each crash, and Reports is the number of reports kept for each server.
+	ReportLines int `default:"100" yaml:"report_lines"`
+	Reports     int `default:"20" yaml:"reports"`
+
+	// SendReports determines if crash reports are also sent to the Panel.
+	SendReports bool `default:"false" yaml:"send_reports"`
+}
+
+// FileWatcher configures the inotify based watcher which publishes a "file changed"
+// event whenever a file in a server's data directory is changed, regardless of whether
+// that change was made through Wings, SFTP, or by the server process itself.
+type FileWatcher struct {
+	// Enabled determines if the data directory of each server is watched for changes. Every
+	// directory within a data directory uses one inotify watch, so servers with a very large
+	// number of directories may require fs.inotify.max_user_watches to be raised.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// Debounce is the number of milliseconds that changes are collected for before they are
+	// published. A file that is written to many times within this window, such as a log file,
+	// only results in a single event.
+	Debounce int `default:"500" yaml:"debounce"`
+
+	// Ignore is a list of gitignore style patterns for files and directories that should
+	// not be watched, such as "logs/" or "*.tmp".
+	Ignore []string `yaml:"ignore"`
+}
+
+// Query configures the polling of running servers for their status, such as the
+// number of players online, using the query protocol set by their egg.
+type Query struct {
+	// Enabled determines if servers are queried at all.
+	Enabled bool `default:"true" yaml:"enabled"`
+
+	// Interval is the number of seconds between queries of a running server.
+	Interval int `default:"30" yaml:"interval"`
+
+	// Timeout is the number of seconds to wait for a server to respond to a query.
+	Timeout int `default:"5" yaml:"timeout"`
+}
+
+// Idle configures how running servers are checked for activity, so that servers
+// with an idle timeout can be stopped when nobody is using them.
+type Idle struct {
+	// CheckInterval is the number of seconds between activity checks of a running
+	// server.
+	CheckInterval int `default:"60" yaml:"check_interval"`
+
+	// NetworkThreshold is the network traffic in bytes per second below which a
+	// server is considered idle. This is only used for servers whose player count
+	// is not known, because they cannot be queried.
+	NetworkThreshold int64 `default:"1024" yaml:"network_threshold"`
+}
+
+// Traffic configures how the network traffic of servers is counted towards their
+// monthly traffic quota.
+type Traffic struct {
+	// SaveInterval is the number of seconds between saves of the traffic counted
+	// for a running server. Traffic is also saved when the server stops.
+	SaveInterval int `default:"60" yaml:"save_interval"`
+
+	// ThrottleRate is the rate in kilobits per second that the network traffic of a
+	// server is limited to once it has used its traffic quota, if the server does
+	// not set its own rate.
+	ThrottleRate int64 `default:"1024" yaml:"throttle_rate"`
 }
 
 type Backups struct {
@@ -277,10 +498,16 @@ type Backups struct {
 	WriteLimit int `default:"0" yaml:"write_limit"`
 
 	// CompressionLevel determines how much backups created by wings should be compressed.
+	// This setting also applies to the archives generated when transferring a server.
 	//
 	// "none" -> no compression will be applied
 	// "best_speed" -> uses gzip level 1 for fast speed
 	// "best_compression" -> uses gzip level 9 for minimal disk space useage
+	// "zstd" -> uses zstd at the level defined by system.zstd_compression_level
+	//
+	// Backups compressed with zstd are named with a ".tar.zst" extension. Transfer
+	// archives are compressed with gzip at level 1 instead of zstd, so that they can be
+	// read by nodes that only support gzip.
 	//
 	// Defaults to "best_speed" (level 1)
 	CompressionLevel string `default:"best_speed" yaml:"compression_level"`
@@ -330,6 +557,18 @@ type Transfers struct {
 	DownloadLimit int `default:"0" yaml:"download_limit"`
 }
 
+// FileJobs configures the file operations, such as compressing and decompressing
+// archives, that are run for servers as tracked jobs.
+type FileJobs struct {
+	// MaxJobs is the number of file jobs that can be running for a single server at
+	// once.
+	MaxJobs int `default:"3" yaml:"max_jobs"`
+
+	// Retention is the number of seconds a finished job is kept for, so that its
+	// result can still be retrieved.
+	Retention int `default:"600" yaml:"retention"`
+}
+
 type ConsoleThrottles struct {
 	// Whether or not the throttler is enabled for this instance.
 	Enabled bool `json:"enabled" yaml:"enabled" default:"true"`
@@ -375,6 +614,7 @@ type Configuration struct {
 	Api    ApiConfiguration    `json:"api" yaml:"api"`
 	System SystemConfiguration `json:"system" yaml:"system"`
 	Docker DockerConfiguration `json:"docker" yaml:"docker"`
+	Native NativeConfiguration `json:"native" yaml:"native"`
 
 	// Defines internal throttling configurations for server processes to prevent
 	// someone from running an endless loop that spams data to logs.
@@ -631,6 +871,10 @@ func FromFile(path string) error {
 		return err
 	}
 
+	if err := c.Docker.ValidateSecurityProfiles(); err != nil {
+		return err
+	}
+
 	// Store this configuration in the global state.
 	Set(c)
 	return nil
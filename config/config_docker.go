@@ -2,7 +2,12 @@ package config
 
 import (
 	"encoding/base64"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+
+	"emperror.dev/errors"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/registry"
@@ -89,6 +94,12 @@ type DockerConfiguration struct {
 	// remapping disabled
 	UsernsMode string `default:"" json:"userns_mode" yaml:"userns_mode"`
 
+	// SecurityProfiles defines named profiles that control how server containers are
+	// confined, which are selected by the egg or the server. Containers that do not
+	// select a profile use the "default" profile, which if it is not defined here
+	// applies the same confinement that Wings has always used.
+	SecurityProfiles map[string]SecurityProfile `json:"security_profiles" yaml:"security_profiles"`
+
 	// Sets the IPS that the user is able to bind to
 	SystemIps []string `default:"[]" json:"system_ips" yaml:"system_ips"`
 
@@ -187,3 +198,223 @@ func (o Overhead) GetMultiplier(memoryLimit int64) float64 {
 
 	return o.DefaultMultiplier
 }
+
+// DefaultSecurityProfile is the name of the security profile used by containers that
+// do not select one.
+const DefaultSecurityProfile = "default"
+
+// defaultCapDrop is the list of capabilities dropped from containers by a security
+// profile that does not set its own list.
+var defaultCapDrop = []string{
+	"setpcap", "mknod", "audit_write", "net_raw", "dac_override",
+	"fowner", "fsetid", "net_bind_service", "sys_chroot", "setfcap",
+	"sys_ptrace",
+}
+
+// unsafeCapabilities are the capabilities that allow a container to take control
+// of the host, which cannot be added by a security profile.
+var unsafeCapabilities = []string{
+	"ALL", "SYS_ADMIN", "SYS_MODULE", "SYS_RAWIO", "SYS_BOOT", "SYS_TIME",
+	"DAC_READ_SEARCH", "MAC_ADMIN", "MAC_OVERRIDE", "BPF", "PERFMON",
+}
+
+// SecurityProfile defines how a server container is confined. The zero value of
+// every field is the most restrictive option, so a profile only needs to set what
+// it relaxes.
+type SecurityProfile struct {
+	// Seccomp is the path to a seccomp profile on the host, or "unconfined" to run
+	// without one. If empty the default seccomp profile of Docker is used.
+	Seccomp string `json:"seccomp" yaml:"seccomp"`
+
+	// AppArmor is the name of an AppArmor profile loaded on the host, or "unconfined"
+	// to run without one. If empty the default AppArmor profile of Docker is used.
+	AppArmor string `json:"apparmor" yaml:"apparmor"`
+
+	// WritableRootfs allows the root filesystem of the container to be written to.
+	// By default it is mounted read-only.
+	WritableRootfs bool `json:"writable_rootfs" yaml:"writable_rootfs"`
+
+	// AllowNewPrivileges allows processes in the container to gain privileges, such
+	// as through setuid binaries.
+	AllowNewPrivileges bool `json:"allow_new_privileges" yaml:"allow_new_privileges"`
+
+	// CapAdd is the list of capabilities added to the container.
+	CapAdd []string `json:"cap_add" yaml:"cap_add"`
+
+	// CapDrop is the list of capabilities dropped from the container. If not set the
+	// capabilities that Wings has always dropped are used, set it to ["ALL"] to drop
+	// every capability that is not added.
+	CapDrop []string `json:"cap_drop" yaml:"cap_drop"`
+
+	// UsernsMode sets the user namespace mode of the container, either "host" to
+	// disable user namespace remapping, or empty to use the userns_mode set for all
+	// containers.
+	UsernsMode string `json:"userns_mode" yaml:"userns_mode"`
+
+	// Images restricts the profile to containers whose image is one of these
+	// repositories, or beneath one of the entries ending in "/", so that a relaxed
+	// profile meant for trusted images cannot be used with any other image. If
+	// empty the profile can be used with any image.
+	Images []string `json:"images" yaml:"images"`
+}
+
+// SecurityProfile returns the security profile with the given name, or the default
+// profile if the name is empty.
+func (c DockerConfiguration) SecurityProfile(name string) (SecurityProfile, error) {
+	if name == "" {
+		name = DefaultSecurityProfile
+	}
+	if p, ok := c.SecurityProfiles[name]; ok {
+		return p, nil
+	}
+	if name == DefaultSecurityProfile {
+		return SecurityProfile{}, nil
+	}
+	return SecurityProfile{}, errors.New("config: unknown security profile: " + name)
+}
+
+// ValidateSecurityProfiles checks that every security profile is valid, returning
+// an error for the first one that is not.
+func (c DockerConfiguration) ValidateSecurityProfiles() error {
+	names := make([]string, 0, len(c.SecurityProfiles))
+	for name := range c.SecurityProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := c.SecurityProfiles[name].Validate(); err != nil {
+			return errors.WrapIf(err, "config: invalid security profile "+name)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the profile is valid, and rejects combinations of options
+// that would leave a container without meaningful confinement.
+func (p SecurityProfile) Validate() error {
+	if p.UsernsMode != "" && p.UsernsMode != "host" {
+		return errors.New("userns_mode must be empty or \"host\"")
+	}
+	if p.Seccomp != "" && p.Seccomp != "unconfined" {
+		if !filepath.IsAbs(p.Seccomp) {
+			return errors.New("seccomp profile must be an absolute path")
+		}
+		b, err := os.ReadFile(p.Seccomp)
+		if err != nil {
+			return errors.Wrap(err, "failed to read seccomp profile")
+		}
+		if !json.Valid(b) {
+			return errors.New("seccomp profile is not valid JSON")
+		}
+	}
+
+	if p.Seccomp == "unconfined" && p.AppArmor == "unconfined" {
+		return errors.New("seccomp and apparmor cannot both be unconfined")
+	}
+	if p.AllowNewPrivileges && (p.Seccomp == "unconfined" || p.AppArmor == "unconfined") {
+		return errors.New("allow_new_privileges cannot be used with an unconfined seccomp or apparmor profile")
+	}
+
+	for _, c := range p.CapAdd {
+		c = normalizeCapability(c)
+		for _, u := range unsafeCapabilities {
+			if c == u {
+				return errors.New("capability cannot be added: " + c)
+			}
+		}
+		for _, d := range p.CapDrop {
+			if c == normalizeCapability(d) {
+				return errors.New("capability cannot be both added and dropped: " + c)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows returns true if the profile can be used by a container with the given
+// image. An entry only matches at a repository boundary: an entry ending in "/"
+// matches every repository beneath it, and any other entry must be the whole
+// repository of the image. If the entry has a tag or digest, the image must have
+// the same one.
+func (p SecurityProfile) Allows(image string) bool {
+	if len(p.Images) == 0 {
+		return true
+	}
+	repo, ref := splitImage(image)
+	for _, entry := range p.Images {
+		if strings.HasSuffix(entry, "/") {
+			if len(repo) > len(entry) && strings.HasPrefix(repo, entry) {
+				return true
+			}
+			continue
+		}
+		r, t := splitImage(entry)
+		if r == repo && (t == "" || t == ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitImage splits an image reference into its repository and the tag or digest
+// that follows it, which keeps its leading ":" or "@". The port of a registry is
+// part of the repository.
+func splitImage(image string) (string, string) {
+	i := strings.IndexByte(image, '@')
+	if i < 0 {
+		i = len(image)
+	}
+	if c := strings.LastIndexByte(image[:i], ':'); c > strings.LastIndexByte(image[:i], '/') {
+		i = c
+	}
+	return image[:i], image[i:]
+}
+
+// SecurityOpt returns the security options passed to Docker for the profile. The
+// seccomp profile is read from the disk, since Docker expects its contents rather
+// than a path.
+func (p SecurityProfile) SecurityOpt() ([]string, error) {
+	var opts []string
+	if !p.AllowNewPrivileges {
+		opts = append(opts, "no-new-privileges")
+	}
+	switch p.Seccomp {
+	case "":
+	case "unconfined":
+		opts = append(opts, "seccomp=unconfined")
+	default:
+		b, err := os.ReadFile(p.Seccomp)
+		if err != nil {
+			return nil, errors.Wrap(err, "config: failed to read seccomp profile")
+		}
+		opts = append(opts, "seccomp="+string(b))
+	}
+	if p.AppArmor != "" {
+		opts = append(opts, "apparmor="+p.AppArmor)
+	}
+	return opts, nil
+}
+
+// Capabilities returns the capabilities to add to and drop from a container using
+// the profile.
+func (p SecurityProfile) Capabilities() (add []string, drop []string) {
+	if p.CapDrop == nil {
+		return p.CapAdd, defaultCapDrop
+	}
+	return p.CapAdd, p.CapDrop
+}
+
+// Userns returns the user namespace mode of a container using the profile, given
+// the mode set for all containers.
+func (p SecurityProfile) Userns(mode string) string {
+	if p.UsernsMode != "" {
+		return p.UsernsMode
+	}
+	return mode
+}
+
+// normalizeCapability returns a capability name in the form used by Docker, such
+// as "SYS_ADMIN" for "cap_sys_admin".
+func normalizeCapability(c string) string {
+	return strings.TrimPrefix(strings.ToUpper(c), "CAP_")
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestSecurityProfiles(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("SecurityProfile", func() {
+		g.It("uses the built-in default profile", func() {
+			p, err := DockerConfiguration{}.SecurityProfile("")
+			g.Assert(err).IsNil()
+
+			opts, err := p.SecurityOpt()
+			g.Assert(err).IsNil()
+			g.Assert(opts).Equal([]string{"no-new-privileges"})
+			add, drop := p.Capabilities()
+			g.Assert(add == nil).IsTrue()
+			g.Assert(drop).Equal(defaultCapDrop)
+			g.Assert(p.WritableRootfs).IsFalse()
+			g.Assert(p.Userns("host")).Equal("host")
+		})
+
+		g.It("returns a named profile", func() {
+			c := DockerConfiguration{SecurityProfiles: map[string]SecurityProfile{
+				DefaultSecurityProfile: {CapDrop: []string{"ALL"}},
+				"internal":             {WritableRootfs: true, AppArmor: "unconfined"},
+			}}
+
+			p, err := c.SecurityProfile("")
+			g.Assert(err).IsNil()
+			_, drop := p.Capabilities()
+			g.Assert(drop).Equal([]string{"ALL"})
+
+			p, err = c.SecurityProfile("internal")
+			g.Assert(err).IsNil()
+			g.Assert(p.WritableRootfs).IsTrue()
+			opts, err := p.SecurityOpt()
+			g.Assert(err).IsNil()
+			g.Assert(opts).Equal([]string{"no-new-privileges", "apparmor=unconfined"})
+
+			_, err = c.SecurityProfile("missing")
+			g.Assert(err == nil).IsFalse()
+		})
+
+		g.It("passes the contents of a seccomp profile to Docker", func() {
+			path := filepath.Join(t.TempDir(), "seccomp.json")
+			g.Assert(os.WriteFile(path, []byte(`{"defaultAction":"SCMP_ACT_ERRNO"}`), 0o644)).IsNil()
+
+			p := SecurityProfile{Seccomp: path, AllowNewPrivileges: true}
+			g.Assert(p.Validate()).IsNil()
+			opts, err := p.SecurityOpt()
+			g.Assert(err).IsNil()
+			g.Assert(opts).Equal([]string{`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`})
+		})
+
+		g.It("restricts a profile to the images it allows", func() {
+			p := SecurityProfile{Images: []string{"ghcr.io/example/"}}
+			g.Assert(p.Allows("ghcr.io/example/java:21")).IsTrue()
+			g.Assert(p.Allows("docker.io/someone/java:21")).IsFalse()
+			g.Assert(SecurityProfile{}.Allows("docker.io/someone/java:21")).IsTrue()
+		})
+
+		g.It("only matches images at a repository boundary", func() {
+			p := SecurityProfile{Images: []string{"ghcr.io/trusted", "ghcr.io/example/", "localhost:5000/java:21"}}
+			g.Assert(p.Allows("ghcr.io/trusted")).IsTrue()
+			g.Assert(p.Allows("ghcr.io/trusted:latest")).IsTrue()
+			g.Assert(p.Allows("ghcr.io/trusted@sha256:abc")).IsTrue()
+			g.Assert(p.Allows("ghcr.io/trusted-evil/miner:latest")).IsFalse()
+			g.Assert(p.Allows("ghcr.io/trusted/miner:latest")).IsFalse()
+			g.Assert(p.Allows("ghcr.io/example-evil/java:21")).IsFalse()
+			g.Assert(p.Allows("ghcr.io/example/:21")).IsFalse()
+			g.Assert(p.Allows("localhost:5000/java:21")).IsTrue()
+			g.Assert(p.Allows("localhost:5000/java:17")).IsFalse()
+			g.Assert(p.Allows("localhost:5000/java:21-evil")).IsFalse()
+		})
+	})
+
+	g.Describe("SecurityProfile.Validate", func() {
+		g.It("rejects unsafe profiles", func() {
+			invalid := []SecurityProfile{
+				{UsernsMode: "private"},
+				{Seccomp: "seccomp.json"},
+				{Seccomp: "/does/not/exist.json"},
+				{Seccomp: "unconfined", AppArmor: "unconfined"},
+				{Seccomp: "unconfined", AllowNewPrivileges: true},
+				{CapAdd: []string{"SYS_ADMIN"}},
+				{CapAdd: []string{"cap_sys_module"}},
+				{CapAdd: []string{"all"}},
+				{CapAdd: []string{"NET_BIND_SERVICE"}, CapDrop: []string{"net_bind_service"}},
+			}
+			for _, p := range invalid {
+				g.Assert(p.Validate() == nil).IsFalse()
+			}
+		})
+
+		g.It("accepts safe profiles", func() {
+			valid := []SecurityProfile{
+				{},
+				{Seccomp: "unconfined"},
+				{AppArmor: "pelican-strict", CapDrop: []string{"ALL"}, CapAdd: []string{"NET_BIND_SERVICE"}},
+				{WritableRootfs: true, AllowNewPrivileges: true, UsernsMode: "host"},
+			}
+			for _, p := range valid {
+				g.Assert(p.Validate()).IsNil()
+			}
+		})
+
+		g.It("names the invalid profile", func() {
+			c := DockerConfiguration{SecurityProfiles: map[string]SecurityProfile{
+				"ok":     {},
+				"unsafe": {CapAdd: []string{"SYS_ADMIN"}},
+			}}
+			err := c.ValidateSecurityProfiles()
+			g.Assert(err == nil).IsFalse()
+			g.Assert(err.Error()).Equal("config: invalid security profile unsafe: capability cannot be added: SYS_ADMIN")
+		})
+	})
+}
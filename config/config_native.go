@@ -0,0 +1,35 @@
+package config
+
+// NativeConfiguration defines the configuration of the native environment, which
+// runs server processes directly on the host rather than in Docker containers. It
+// is intended for hosts where Docker cannot be used.
+type NativeConfiguration struct {
+	// If set to true every server is run using the native environment, and Docker is
+	// not used at all. Installation scripts are not run for servers in this mode, since
+	// they depend on a container image.
+	Enabled bool `default:"false" json:"enabled" yaml:"enabled"`
+
+	// The cgroup v2 directory that a cgroup is created within for each server, which
+	// is used to apply the resource limits of the server. If left empty servers are
+	// run without any resource limits.
+	CgroupRoot string `default:"/sys/fs/cgroup/pelican" json:"cgroup_root" yaml:"cgroup_root"`
+
+	// The shell that the startup command of a server is run with.
+	Shell string `default:"/bin/sh" json:"shell" yaml:"shell"`
+
+	// The maximum size of the console log kept for each server in MiB. Once the log
+	// reaches this size it is rotated, and only the previous log is kept.
+	LogMaxSize int64 `default:"10" json:"log_max_size" yaml:"log_max_size"`
+
+	// Each server is run as its own user and group, which are allocated from this
+	// range of IDs the first time the server is created. None of the IDs in the range
+	// should be used by anything else on the host.
+	UserBase  int `default:"200000" json:"user_base" yaml:"user_base"`
+	UserCount int `default:"65536" json:"user_count" yaml:"user_count"`
+
+	// The paths outside of its data directory that a server process can read and run
+	// programs from, such as the shell and the runtime of the server. The data
+	// directory of the server is the only path it can write to, apart from the
+	// devices in /dev.
+	ReadOnlyPaths []string `default:"[\"/bin\",\"/sbin\",\"/usr\",\"/lib\",\"/lib32\",\"/lib64\",\"/etc\",\"/opt\",\"/proc\",\"/sys\"]" json:"read_only_paths" yaml:"read_only_paths"`
+}
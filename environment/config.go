@@ -9,6 +9,8 @@ type Settings struct {
 	Allocations Allocations
 	Limits      Limits
 	Labels      map[string]string
+	// The name of the security profile used to confine the environment.
+	SecurityProfile string
 }
 
 // Defines the actual configuration struct for the environment with all of the settings
@@ -77,6 +79,15 @@ func (c *Configuration) Labels() map[string]string {
 	return c.settings.Labels
 }
 
+// SecurityProfile returns the name of the security profile used to confine this
+// instance, or an empty string to use the default profile.
+func (c *Configuration) SecurityProfile() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.settings.SecurityProfile
+}
+
 // Returns the environment variables associated with this instance.
 func (c *Configuration) EnvironmentVariables() []string {
 	c.mu.RLock()
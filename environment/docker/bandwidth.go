@@ -0,0 +1,173 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// bandwidthUnknown is recorded as the limits applied to the container when
+// applying them failed part way, so that they are applied again next time.
+var bandwidthUnknown = [2]int64{-1, -1}
+
+// applyBandwidthLimits limits the rate at which the container can receive and send
+// network traffic, using tc on the host side of the veth pair of the container.
+// Nothing is done if the limits have not changed since they were last applied.
+func (e *Environment) applyBandwidthLimits(ctx context.Context) error {
+	l := e.Configuration.Limits()
+	limits := [2]int64{l.IngressLimit, l.EgressLimit}
+
+	// The limits are recorded before they are applied, so that the lock is not held
+	// while waiting for Docker and tc.
+	e.bandwidthMu.Lock()
+	if limits == e.bandwidth {
+		e.bandwidthMu.Unlock()
+		return nil
+	}
+	e.bandwidth = limits
+	e.bandwidthMu.Unlock()
+
+	if err := e.setBandwidthLimits(ctx, l.IngressLimit, l.EgressLimit); err != nil {
+		e.bandwidthMu.Lock()
+		if e.bandwidth == limits {
+			e.bandwidth = bandwidthUnknown
+		}
+		e.bandwidthMu.Unlock()
+		return err
+	}
+	e.log().WithField("ingress_limit", l.IngressLimit).WithField("egress_limit", l.EgressLimit).Debug("applied network bandwidth limits to container")
+	return nil
+}
+
+// setBandwidthLimits runs the tc commands that apply the limits to the host
+// interface of the container.
+func (e *Environment) setBandwidthLimits(ctx context.Context, ingress, egress int64) error {
+	dev, err := e.hostInterface(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range bandwidthCommands(dev, ingress, egress) {
+		if err := tc(ctx, c.args...); err != nil && !c.optional {
+			return err
+		}
+	}
+	return nil
+}
+
+// tcCommand is a tc command, and whether it is allowed to fail.
+type tcCommand struct {
+	args     []string
+	optional bool
+}
+
+// bandwidthCommands returns the tc commands that apply the limits, in kilobits per
+// second, to the host interface of a container. Traffic leaving the host interface
+// is received by the container, and traffic arriving on it was sent by the
+// container. A limit of 0 removes the limit.
+func bandwidthCommands(dev string, ingress, egress int64) []tcCommand {
+	var cmds []tcCommand
+	if ingress > 0 {
+		cmds = append(cmds, tcCommand{args: []string{"qdisc", "replace", "dev", dev, "root", "tbf", "rate", kbit(ingress), "burst", burst(ingress), "latency", "50ms"}})
+	} else {
+		// This fails if there is no limit to remove, which is fine.
+		cmds = append(cmds, tcCommand{args: []string{"qdisc", "del", "dev", dev, "root"}, optional: true})
+	}
+
+	// Traffic arriving on an interface cannot be shaped, only policed, so packets over
+	// the limit are dropped and the sender is left to slow down.
+	cmds = append(cmds, tcCommand{args: []string{"qdisc", "del", "dev", dev, "ingress"}, optional: true})
+	if egress > 0 {
+		cmds = append(cmds,
+			tcCommand{args: []string{"qdisc", "add", "dev", dev, "handle", "ffff:", "ingress"}},
+			tcCommand{args: []string{"filter", "add", "dev", dev, "parent", "ffff:", "protocol", "all", "prio", "1", "u32", "match", "u32", "0", "0", "police", "rate", kbit(egress), "burst", burst(egress), "drop"}},
+		)
+	}
+	return cmds
+}
+
+// resetBandwidthLimits forgets the limits applied to the container, which must be
+// done whenever it is started since it then gets a new veth pair without any.
+func (e *Environment) resetBandwidthLimits() {
+	e.bandwidthMu.Lock()
+	e.bandwidth = [2]int64{}
+	e.bandwidthMu.Unlock()
+}
+
+// hostInterface returns the name of the host side of the veth pair connecting the
+// running container to its network. The index of the host interface is read from
+// the container's own view of its network interface.
+func (e *Environment) hostInterface(ctx context.Context) (string, error) {
+	c, err := e.ContainerInspect(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "environment/docker: could not inspect container")
+	}
+	if c.State == nil || c.State.Pid == 0 {
+		return "", errors.New("environment/docker: container is not running")
+	}
+	// A container using the host network has no interface of its own, and limiting
+	// the interface of the host would limit every other server as well.
+	if c.HostConfig != nil && c.HostConfig.NetworkMode.IsHost() {
+		return "", errors.New("environment/docker: cannot limit bandwidth of a container using the host network")
+	}
+
+	dir := "/proc/" + strconv.Itoa(c.State.Pid) + "/root/sys/class/net/eth0/"
+	index, err := readInterfaceIndex(dir + "ifindex")
+	if err != nil {
+		return "", err
+	}
+	peer, err := readInterfaceIndex(dir + "iflink")
+	if err != nil {
+		return "", err
+	}
+	if peer == index {
+		return "", errors.New("environment/docker: container network interface is not a veth pair")
+	}
+	i, err := net.InterfaceByIndex(peer)
+	if err != nil {
+		return "", errors.Wrap(err, "environment/docker: failed to find host network interface of container")
+	}
+	return i.Name, nil
+}
+
+// readInterfaceIndex reads an interface index from a file in sysfs.
+func readInterfaceIndex(p string) (int, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return 0, errors.Wrap(err, "environment/docker: failed to read container network interface")
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, errors.Wrap(err, "environment/docker: failed to parse container network interface")
+	}
+	return index, nil
+}
+
+// tc runs the tc command with the given arguments.
+func tc(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "tc", args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "environment/docker: failed to run tc %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// kbit returns a rate in kilobits per second in the format used by tc.
+func kbit(rate int64) string {
+	return strconv.FormatInt(rate, 10) + "kbit"
+}
+
+// burst returns the number of bytes that can be sent at once over a limit, which
+// is enough for 100ms of traffic at the rate, and at least 16KiB so that a rate
+// limit below the size of a few packets does not block all traffic.
+func burst(rate int64) string {
+	b := rate * 1000 / 8 / 10
+	if b < 16*1024 {
+		b = 16 * 1024
+	}
+	return strconv.FormatInt(b, 10)
+}
@@ -0,0 +1,50 @@
+package docker
+
+import (
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestBandwidthCommands(t *testing.T) {
+	g := Goblin(t)
+
+	// args returns the arguments of the commands, marking the optional ones.
+	args := func(cmds []tcCommand) [][]string {
+		var out [][]string
+		for _, c := range cmds {
+			a := c.args
+			if c.optional {
+				a = append([]string{"?"}, a...)
+			}
+			out = append(out, a)
+		}
+		return out
+	}
+
+	g.Describe("Bandwidth limits", func() {
+		g.It("shapes traffic to the container and polices traffic from it", func() {
+			g.Assert(args(bandwidthCommands("veth0", 8000, 4000))).Equal([][]string{
+				{"qdisc", "replace", "dev", "veth0", "root", "tbf", "rate", "8000kbit", "burst", "100000", "latency", "50ms"},
+				{"?", "qdisc", "del", "dev", "veth0", "ingress"},
+				{"qdisc", "add", "dev", "veth0", "handle", "ffff:", "ingress"},
+				{"filter", "add", "dev", "veth0", "parent", "ffff:", "protocol", "all", "prio", "1", "u32", "match", "u32", "0", "0", "police", "rate", "4000kbit", "burst", "50000", "drop"},
+			})
+		})
+
+		g.It("removes limits that are not set", func() {
+			g.Assert(args(bandwidthCommands("veth0", 0, 0))).Equal([][]string{
+				{"?", "qdisc", "del", "dev", "veth0", "root"},
+				{"?", "qdisc", "del", "dev", "veth0", "ingress"},
+			})
+			g.Assert(args(bandwidthCommands("veth0", 0, 1000))[0]).Equal([]string{"?", "qdisc", "del", "dev", "veth0", "root"})
+		})
+
+		g.It("allows a burst of at least 16KiB", func() {
+			g.Assert(burst(100)).Equal("16384")
+			g.Assert(burst(1000)).Equal("16384")
+			g.Assert(burst(80000)).Equal("1000000")
+			g.Assert(kbit(512)).Equal("512kbit")
+		})
+	})
+}
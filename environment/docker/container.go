@@ -101,13 +101,14 @@ func (e *Environment) Attach(ctx context.Context) error {
 
 // InSituUpdate performs an in-place update of the Docker container's resource
 // limits without actually making any changes to the operational state of the
-// container. This allows memory, cpu, and IO limitations to be adjusted on the
-// fly for individual instances.
+// container. This allows memory, cpu, IO and network limitations to be adjusted
+// on the fly for individual instances.
 func (e *Environment) InSituUpdate() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
-	if _, err := e.ContainerInspect(ctx); err != nil {
+	c, err := e.ContainerInspect(ctx)
+	if err != nil {
 		// If the container doesn't exist for some reason there really isn't anything
 		// we can do to fix that in this process (it doesn't make sense at least). In those
 		// cases just return without doing anything since we still want to save the configuration
@@ -129,6 +130,15 @@ func (e *Environment) InSituUpdate() error {
 	}); err != nil {
 		return errors.Wrap(err, "environment/docker: could not update container")
 	}
+
+	// Bandwidth limits are applied to the network interface of the container, which
+	// only exists while it is running. They are applied when it is next started
+	// otherwise.
+	if c.State != nil && c.State.Running {
+		if err := e.applyBandwidthLimits(ctx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -192,11 +202,7 @@ func (e *Environment) Create() error {
 	}
 
 	// Set the user running the container properly depending on what mode we are operating in.
-	if cfg.System.User.Rootless.Enabled {
-		conf.User = fmt.Sprintf("%d:%d", cfg.System.User.Rootless.ContainerUID, cfg.System.User.Rootless.ContainerGID)
-	} else {
-		conf.User = strconv.Itoa(cfg.System.User.Uid) + ":" + strconv.Itoa(cfg.System.User.Gid)
-	}
+	conf.User = containerUser(cfg)
 
 	networkMode := container.NetworkMode(cfg.Docker.Network.Mode)
 	if a.ForceOutgoingIP {
@@ -233,6 +239,21 @@ func (e *Environment) Create() error {
 		}
 	}
 
+	// Confine the container using the security profile selected for it, making sure
+	// that a profile restricted to trusted images is not used with any other image.
+	profile, err := cfg.Docker.SecurityProfile(e.Configuration.SecurityProfile())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !profile.Allows(conf.Image) {
+		return errors.New("environment/docker: security profile cannot be used with image: " + conf.Image)
+	}
+	securityOpt, err := profile.SecurityOpt()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	capAdd, capDrop := profile.Capabilities()
+
 	hostConf := &container.HostConfig{
 		PortBindings: a.DockerBindings(),
 
@@ -258,15 +279,12 @@ func (e *Environment) Create() error {
 		// about anything else in it.
 		LogConfig: cfg.Docker.ContainerLogConfig(),
 
-		SecurityOpt:    []string{"no-new-privileges"},
-		ReadonlyRootfs: true,
-		CapDrop: []string{
-			"setpcap", "mknod", "audit_write", "net_raw", "dac_override",
-			"fowner", "fsetid", "net_bind_service", "sys_chroot", "setfcap",
-			"sys_ptrace",
-		},
-		NetworkMode: networkMode,
-		UsernsMode:  container.UsernsMode(cfg.Docker.UsernsMode),
+		SecurityOpt:    securityOpt,
+		ReadonlyRootfs: !profile.WritableRootfs,
+		CapAdd:         capAdd,
+		CapDrop:        capDrop,
+		NetworkMode:    networkMode,
+		UsernsMode:     container.UsernsMode(profile.Userns(cfg.Docker.UsernsMode)),
 	}
 
 	var netConf *network.NetworkingConfig = nil //In case when no networking config is needed set nil
@@ -319,6 +337,14 @@ func (e *Environment) Destroy() error {
 	return err
 }
 
+// containerUser returns the user that processes in the container run as.
+func containerUser(cfg *config.Configuration) string {
+	if cfg.System.User.Rootless.Enabled {
+		return fmt.Sprintf("%d:%d", cfg.System.User.Rootless.ContainerUID, cfg.System.User.Rootless.ContainerGID)
+	}
+	return strconv.Itoa(cfg.System.User.Uid) + ":" + strconv.Itoa(cfg.System.User.Gid)
+}
+
 // SendCommand sends the specified command to the stdin of the running container
 // instance. There is no confirmation that this data is sent successfully, only
 // that it gets pushed into the stdin.
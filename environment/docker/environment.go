@@ -55,6 +55,12 @@ type Environment struct {
 
 	// Tracks the environment state.
 	st *system.AtomicString
+
+	// The network rate limits last applied to the container, in kilobits per second,
+	// so that tc is only run when they change. This has its own lock, since it is
+	// changed while waiting for Docker and tc.
+	bandwidthMu sync.Mutex
+	bandwidth   [2]int64
 }
 
 // New creates a new base Docker environment. The ID passed through will be the
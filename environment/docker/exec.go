@@ -0,0 +1,105 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+)
+
+var _ environment.Executor = (*Environment)(nil)
+
+// Exec runs a command inside of the running container using "docker exec". The
+// command runs as the same user as the server process.
+func (e *Environment) Exec(ctx context.Context, cmd []string, opts environment.ExecOptions) (environment.ExecProcess, error) {
+	var size *[2]uint
+	if opts.Tty && opts.Width > 0 && opts.Height > 0 {
+		size = &[2]uint{opts.Height, opts.Width}
+	}
+	res, err := e.client.ContainerExecCreate(ctx, e.Id, container.ExecOptions{
+		User:         containerUser(config.Get()),
+		Tty:          opts.Tty,
+		ConsoleSize:  size,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          opts.Env,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return nil, errors.WrapIf(err, "environment/docker: failed to create exec instance")
+	}
+	st, err := e.client.ContainerExecAttach(ctx, res.ID, container.ExecAttachOptions{Tty: opts.Tty, ConsoleSize: size})
+	if err != nil {
+		return nil, errors.WrapIf(err, "environment/docker: failed to attach to exec instance")
+	}
+	return &execProcess{e: e, id: res.ID, tty: opts.Tty, stream: st}, nil
+}
+
+type execProcess struct {
+	e      *Environment
+	id     string
+	tty    bool
+	stream types.HijackedResponse
+}
+
+func (p *execProcess) Write(b []byte) (int, error) {
+	return p.stream.Conn.Write(b)
+}
+
+func (p *execProcess) CloseStdin() error {
+	return p.stream.CloseWrite()
+}
+
+// Output copies the output of the process. Without a Tty Docker multiplexes
+// stdout and stderr over the same stream, so they need to be separated again.
+func (p *execProcess) Output(stdout io.Writer, stderr io.Writer) error {
+	var err error
+	if p.tty {
+		_, err = io.Copy(stdout, p.stream.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, p.stream.Reader)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return errors.WrapIf(err, "environment/docker: failed to read exec output")
+	}
+	return nil
+}
+
+func (p *execProcess) Resize(width uint, height uint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	err := p.e.client.ContainerExecResize(ctx, p.id, container.ResizeOptions{Width: width, Height: height})
+	return errors.WrapIf(err, "environment/docker: failed to resize exec instance")
+}
+
+// ExitCode waits for the process to exit. Docker has no way to wait for an exec
+// instance to exit, so it is polled until it is no longer running.
+func (p *execProcess) ExitCode(ctx context.Context) (int, error) {
+	for {
+		res, err := p.e.client.ContainerExecInspect(ctx, p.id)
+		if err != nil {
+			return 0, errors.WrapIf(err, "environment/docker: failed to inspect exec instance")
+		}
+		if !res.Running {
+			return res.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
+func (p *execProcess) Close() error {
+	p.stream.Close()
+	return nil
+}
@@ -122,6 +122,14 @@ func (e *Environment) Start(ctx context.Context) error {
 		return errors.WrapIf(err, "environment/docker: failed to start container")
 	}
 
+	// The container is connected to its network with a new veth pair each time it is
+	// started, so any bandwidth limits have to be applied again. This is not a reason
+	// to stop the server from starting.
+	e.resetBandwidthLimits()
+	if err := e.applyBandwidthLimits(actx); err != nil {
+		e.log().WithField("error", err).Error("failed to apply network bandwidth limits to container")
+	}
+
 	// No errors, good to continue through.
 	sawError = false
 	return nil
@@ -269,6 +277,24 @@ func (e *Environment) WaitForStop(ctx context.Context, duration time.Duration, t
 	return nil
 }
 
+// Signal sends a signal to the container without waiting for it to stop.
+func (e *Environment) Signal(ctx context.Context, signal string) error {
+	c, err := e.ContainerInspect(ctx)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	if !c.State.Running {
+		return nil
+	}
+	if err := e.client.ContainerKill(ctx, e.Id, signal); err != nil && !client.IsErrNotFound(err) {
+		return errors.Wrap(err, "environment/docker: failed to signal container")
+	}
+	return nil
+}
+
 // Terminate forcefully terminates the container using the signal provided.
 func (e *Environment) Terminate(ctx context.Context, signal string) error {
 	c, err := e.ContainerInspect(ctx)
@@ -2,6 +2,7 @@ package environment
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"github.com/Minenetpro/pelican-wings/events"
@@ -113,3 +114,52 @@ type ProcessEnvironment interface {
 	// SetLogCallback sets the callback that the container's log output will be passed to.
 	SetLogCallback(func([]byte))
 }
+
+// Executor is implemented by environments that are able to run additional
+// commands inside of a running server instance, alongside the server process.
+type Executor interface {
+	// Exec starts a command inside of the running server instance. The process
+	// should be closed once it is no longer needed.
+	Exec(ctx context.Context, cmd []string, opts ExecOptions) (ExecProcess, error)
+}
+
+// Signaler is implemented by environments that are able to send a signal to a
+// running server process without waiting for it to exit.
+type Signaler interface {
+	// Signal sends the signal, such as "SIGTERM", to the server process. This is a
+	// no-op if the process is not running.
+	Signal(ctx context.Context, signal string) error
+}
+
+// ExecOptions configures how a command is started by an Executor.
+type ExecOptions struct {
+	// Tty allocates a pseudo-terminal for the command with the given size in
+	// columns and rows.
+	Tty    bool
+	Width  uint
+	Height uint
+	// Additional environment variables for the command, in "KEY=value" form.
+	Env []string
+}
+
+// ExecProcess is a command that has been started by an Executor.
+type ExecProcess interface {
+	// Write writes to the standard input of the process.
+	io.Writer
+
+	// CloseStdin closes the standard input of the process.
+	CloseStdin() error
+
+	// Output copies the output of the process to stdout and stderr until the
+	// process exits. When the process has a Tty all output is written to stdout.
+	Output(stdout io.Writer, stderr io.Writer) error
+
+	// Resize changes the size of the Tty of the process.
+	Resize(width uint, height uint) error
+
+	// ExitCode waits for the process to exit and returns its exit code.
+	ExitCode(ctx context.Context) (int, error)
+
+	// Close detaches from the process.
+	Close() error
+}
@@ -0,0 +1,488 @@
+// Package fake provides an in-memory environment that implements the process
+// environment interface without running anything. Tests use it to script the
+// behavior of a server process, such as the console output it writes, the
+// resources it uses and how it exits, and to inspect the commands sent to it.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/events"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/system"
+)
+
+var ErrNotAttached = errors.Sentinel("not attached to instance")
+
+// Ensure that the fake environment is always implementing all the methods
+// from the base environment interface.
+var _ environment.ProcessEnvironment = (*Environment)(nil)
+
+type Environment struct {
+	mu sync.RWMutex
+
+	// The public identifier for this environment, which is the server UUID.
+	Id string
+
+	// The environment configuration.
+	Configuration *environment.Configuration
+
+	stop remote.ProcessStopConfiguration
+
+	// Set while the fake process is running, and closed once it exits.
+	running   bool
+	exited    chan struct{}
+	startedAt time.Time
+	starts    int
+
+	exitCode  uint32
+	oomKilled bool
+
+	// Errors returned by the next call to Start or Create, if set.
+	startErr  error
+	createErr error
+
+	onStart  func()
+	handlers map[string]func()
+	signals  map[string]func()
+	commands []string
+	logs     []string
+
+	emitter *events.Bus
+
+	logCallbackMx sync.Mutex
+	logCallback   func([]byte)
+
+	// Tracks the environment state.
+	st *system.AtomicString
+}
+
+// New creates a new fake environment. The ID passed through should be unique
+// per-server, the server UUID is used by default.
+func New(id string, c *environment.Configuration) *Environment {
+	return &Environment{
+		Id:            id,
+		Configuration: c,
+		exited:        closedChannel(),
+		handlers:      make(map[string]func()),
+		signals:       make(map[string]func()),
+		st:            system.NewAtomicString(environment.ProcessOfflineState),
+		emitter:       events.NewBus(),
+	}
+}
+
+func closedChannel() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+func (e *Environment) Type() string {
+	return "fake"
+}
+
+func (e *Environment) Config() *environment.Configuration {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.Configuration
+}
+
+func (e *Environment) Events() *events.Bus {
+	return e.emitter
+}
+
+// SetStopConfiguration sets the stop configuration for the environment.
+func (e *Environment) SetStopConfiguration(c remote.ProcessStopConfiguration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stop = c
+}
+
+// OnStart sets a function that is called in the background each time the fake
+// process is started, which can be used to script its output.
+func (e *Environment) OnStart(fn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onStart = fn
+}
+
+// OnCommand sets a function that is called when the given command is sent to
+// the fake process. If no function is set for the stop command of the server,
+// the process exits with a code of 0 when it is received.
+func (e *Environment) OnCommand(c string, fn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.handlers[c] = fn
+}
+
+// OnSignal sets a function that is called when the given signal is sent to the
+// fake process, instead of it exiting. SIGKILL cannot be handled.
+func (e *Environment) OnSignal(signal string, fn func()) {
+	sig, _ := signalNum(signal)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.signals[unix.SignalName(sig)] = fn
+}
+
+// FailStart causes the next call to Start to fail with the given error.
+func (e *Environment) FailStart(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.startErr = err
+}
+
+// FailCreate causes the next call to Create to fail with the given error.
+func (e *Environment) FailCreate(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.createErr = err
+}
+
+// Commands returns every command that has been sent to the fake process.
+func (e *Environment) Commands() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]string(nil), e.commands...)
+}
+
+// Starts returns the number of times the fake process has been started.
+func (e *Environment) Starts() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.starts
+}
+
+// Exited returns a channel that is closed once the running fake process exits.
+func (e *Environment) Exited() <-chan struct{} {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.exited
+}
+
+// Output writes lines to the console of the fake process. Lines are only passed
+// to the log callback while the process is running, but are always kept for
+// Readlog, in the same way as the log of a container.
+func (e *Environment) Output(lines ...string) {
+	for _, l := range lines {
+		e.mu.Lock()
+		e.logs = append(e.logs, l)
+		running := e.running
+		e.mu.Unlock()
+		if !running {
+			continue
+		}
+		e.logCallbackMx.Lock()
+		if e.logCallback != nil {
+			e.logCallback([]byte(l))
+		}
+		e.logCallbackMx.Unlock()
+	}
+}
+
+// PublishStats emits a resource event for the fake process. The uptime is set
+// by the environment if it is not provided.
+func (e *Environment) PublishStats(st environment.Stats) {
+	if st.Uptime == 0 {
+		st.Uptime, _ = e.Uptime(context.Background())
+	}
+	e.Events().Publish(environment.ResourceEvent, st)
+}
+
+// Exit causes the running fake process to exit with the given code. Nothing
+// happens if the process is not running.
+func (e *Environment) Exit(code uint32) {
+	e.exit(code, false)
+}
+
+// OOM causes the running fake process to exit as if it was killed by the OOM
+// killer.
+func (e *Environment) OOM() {
+	e.exit(137, true)
+}
+
+func (e *Environment) exit(code uint32, oom bool) {
+	e.mu.Lock()
+	if !e.running {
+		e.mu.Unlock()
+		return
+	}
+	e.running = false
+	e.exitCode = code
+	e.oomKilled = oom
+	close(e.exited)
+	e.mu.Unlock()
+
+	e.SetState(environment.ProcessOfflineState)
+}
+
+// Exists always returns true, since there is nothing that must exist for the
+// fake process to be started.
+func (e *Environment) Exists() (bool, error) {
+	return true, nil
+}
+
+// IsRunning determines if the fake process is currently running.
+func (e *Environment) IsRunning(_ context.Context) (bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.running, nil
+}
+
+// IsAttached determines if commands can be sent to the fake process, which is
+// the case whenever it is running.
+func (e *Environment) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.running
+}
+
+func (e *Environment) InSituUpdate() error {
+	return nil
+}
+
+func (e *Environment) OnBeforeStart(_ context.Context) error {
+	return e.Create()
+}
+
+// Start starts the fake process and runs the function set with OnStart in the
+// background.
+func (e *Environment) Start(ctx context.Context) error {
+	if ok, _ := e.IsRunning(ctx); ok {
+		e.SetState(environment.ProcessRunningState)
+		return nil
+	}
+
+	e.SetState(environment.ProcessStartingState)
+	if err := e.OnBeforeStart(ctx); err != nil {
+		e.SetState(environment.ProcessStoppingState)
+		e.SetState(environment.ProcessOfflineState)
+		return errors.WrapIf(err, "environment/fake: failed to run pre-boot process")
+	}
+
+	e.mu.Lock()
+	if err := e.startErr; err != nil {
+		e.startErr = nil
+		e.mu.Unlock()
+		e.SetState(environment.ProcessStoppingState)
+		e.SetState(environment.ProcessOfflineState)
+		return err
+	}
+	e.running = true
+	e.exited = make(chan struct{})
+	e.startedAt = time.Now()
+	e.starts++
+	e.exitCode, e.oomKilled = 0, false
+	fn := e.onStart
+	e.mu.Unlock()
+
+	if fn != nil {
+		go fn()
+	}
+	return nil
+}
+
+// Stop stops the fake process using the configured stop command or signal. If
+// no stop configuration is set the process is terminated.
+func (e *Environment) Stop(ctx context.Context) error {
+	e.mu.RLock()
+	s := e.stop
+	e.mu.RUnlock()
+
+	if s.Type == remote.ProcessStopSignal {
+		return e.Terminate(ctx, s.Value)
+	}
+
+	if e.st.Load() != environment.ProcessOfflineState {
+		e.SetState(environment.ProcessStoppingState)
+	}
+
+	if s.Type == remote.ProcessStopCommand {
+		if !e.IsAttached() {
+			return nil
+		}
+		return e.SendCommand(s.Value)
+	}
+	return e.Terminate(ctx, "SIGTERM")
+}
+
+// WaitForStop stops the fake process and waits for it to exit. If it does not
+// exit in time an error is returned, or the process is terminated depending on
+// the value of the last argument.
+func (e *Environment) WaitForStop(ctx context.Context, duration time.Duration, terminate bool) error {
+	tctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	if err := e.Stop(tctx); err != nil {
+		return err
+	}
+
+	select {
+	case <-e.Exited():
+		return nil
+	case <-tctx.Done():
+		if terminate {
+			return e.Terminate(context.Background(), "SIGKILL")
+		}
+		return tctx.Err()
+	}
+}
+
+// Terminate immediately stops the fake process, which exits with the code a
+// process killed by the given signal would.
+func (e *Environment) Terminate(_ context.Context, signal string) error {
+	sig, err := signalNum(signal)
+	if err != nil {
+		return err
+	}
+
+	if ok, _ := e.IsRunning(context.Background()); !ok {
+		if e.st.Load() != environment.ProcessOfflineState {
+			e.SetState(environment.ProcessStoppingState)
+			e.SetState(environment.ProcessOfflineState)
+		}
+		return nil
+	}
+
+	// We set it to stopping then offline to prevent crash detection from being triggered.
+	e.SetState(environment.ProcessStoppingState)
+	e.exit(128+uint32(sig), false)
+	return nil
+}
+
+// Signal sends a signal to the fake process, which exits with the code a
+// process killed by the signal would, unless a function has been set for it
+// with OnSignal.
+func (e *Environment) Signal(_ context.Context, signal string) error {
+	sig, err := signalNum(signal)
+	if err != nil {
+		return err
+	}
+	e.mu.RLock()
+	fn, ok := e.signals[unix.SignalName(sig)]
+	e.mu.RUnlock()
+	if ok && sig != unix.SIGKILL {
+		go fn()
+		return nil
+	}
+	e.exit(128+uint32(sig), false)
+	return nil
+}
+
+func signalNum(signal string) (unix.Signal, error) {
+	s := strings.ToUpper(signal)
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+	if sig := unix.SignalNum(s); sig != 0 {
+		return sig, nil
+	}
+	return 0, errors.New("environment/fake: unknown signal " + signal)
+}
+
+func (e *Environment) Destroy() error {
+	e.SetState(environment.ProcessStoppingState)
+	err := e.Terminate(context.Background(), "SIGKILL")
+	e.SetState(environment.ProcessOfflineState)
+	return err
+}
+
+func (e *Environment) ExitState() (uint32, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.exitCode, e.oomKilled, nil
+}
+
+func (e *Environment) Create() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	err := e.createErr
+	e.createErr = nil
+	return err
+}
+
+func (e *Environment) Attach(_ context.Context) error {
+	return nil
+}
+
+// SendCommand records the command and calls the function set for it with
+// OnCommand, if there is one.
+func (e *Environment) SendCommand(c string) error {
+	e.mu.Lock()
+	if !e.running {
+		e.mu.Unlock()
+		return errors.Wrap(ErrNotAttached, "environment/fake: cannot send command to process")
+	}
+	e.commands = append(e.commands, c)
+	fn, ok := e.handlers[c]
+	isStop := e.stop.Type == remote.ProcessStopCommand && c == e.stop.Value
+	e.mu.Unlock()
+
+	// If the command being processed is the same as the process stop command then we
+	// want to mark the server as entering the stopping state otherwise the process will
+	// stop and Wings will think it has crashed and attempt to restart it.
+	if isStop {
+		e.SetState(environment.ProcessStoppingState)
+	}
+	if ok {
+		go fn()
+	} else if isStop {
+		go e.Exit(0)
+	}
+	return nil
+}
+
+// Readlog returns the last lines written to the console of the fake process.
+func (e *Environment) Readlog(lines int) ([]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if len(e.logs) <= lines {
+		return append([]string(nil), e.logs...), nil
+	}
+	return append([]string(nil), e.logs[len(e.logs)-lines:]...), nil
+}
+
+func (e *Environment) State() string {
+	return e.st.Load()
+}
+
+// SetState sets the state of the environment and emits an event for it, in the
+// same way as a real environment.
+func (e *Environment) SetState(state string) {
+	if state != environment.ProcessOfflineState &&
+		state != environment.ProcessStartingState &&
+		state != environment.ProcessRunningState &&
+		state != environment.ProcessStoppingState {
+		panic(errors.New(fmt.Sprintf("invalid server state received: %s", state)))
+	}
+
+	if e.State() != state {
+		e.st.Store(state)
+		e.Events().Publish(environment.StateChangeEvent, state)
+	}
+}
+
+func (e *Environment) SetLogCallback(f func([]byte)) {
+	e.logCallbackMx.Lock()
+	defer e.logCallbackMx.Unlock()
+
+	e.logCallback = f
+}
+
+// Uptime returns the time in milliseconds since the fake process was started,
+// or 0 if it is not running.
+func (e *Environment) Uptime(_ context.Context) (int64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if !e.running {
+		return 0, nil
+	}
+	return time.Since(e.startedAt).Milliseconds(), nil
+}
@@ -0,0 +1,194 @@
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+)
+
+// cgroupControllers are the controllers enabled for the cgroup of each server.
+var cgroupControllers = []string{"cpu", "cpuset", "io", "memory", "pids"}
+
+// cgroupPath returns the path to the cgroup of the server, or an empty string
+// if servers are run without a cgroup.
+func (e *Environment) cgroupPath() string {
+	root := config.Get().Native.CgroupRoot
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, e.Id)
+}
+
+// createCgroup creates the cgroup for the server if it does not already exist,
+// and applies the resource limits of the server to it.
+func (e *Environment) createCgroup() error {
+	p := e.cgroupPath()
+	if p == "" {
+		return nil
+	}
+	root := filepath.Dir(p)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return errors.Wrap(err, "environment/native: failed to create cgroup root")
+	}
+	// Controllers have to be enabled for the children of the root before the cgroup
+	// of a server can use them. Each one is enabled separately so that a controller
+	// that is not available does not stop the others from being enabled, the limits
+	// using it are skipped instead.
+	for _, c := range cgroupControllers {
+		_ = os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+"+c), 0o644)
+	}
+	if err := os.Mkdir(p, 0o755); err != nil && !os.IsExist(err) {
+		return errors.Wrap(err, "environment/native: failed to create cgroup")
+	}
+	return e.applyLimits()
+}
+
+// applyLimits writes the resource limits of the server to its cgroup. This can
+// be done while the server is running.
+func (e *Environment) applyLimits() error {
+	p := e.cgroupPath()
+	if p == "" {
+		return nil
+	}
+	for _, l := range cgroupLimits(e.Configuration.Limits()) {
+		if err := os.WriteFile(filepath.Join(p, l.file), []byte(l.value), 0o644); err != nil {
+			if os.IsNotExist(err) {
+				e.log().WithField("file", l.file).Debug("cgroup controller is not available, skipping limit")
+				continue
+			}
+			return errors.Wrapf(err, "environment/native: failed to set %s", l.file)
+		}
+	}
+	return nil
+}
+
+type cgroupLimit struct {
+	file  string
+	value string
+}
+
+// cgroupLimits converts the limits of a server into the values written to its
+// cgroup, matching the limits that are applied to a Docker container. The OOM
+// killer cannot be disabled for a cgroup, so the OOMKiller limit is ignored and
+// every process of the server is killed together instead.
+func cgroupLimits(l environment.Limits) []cgroupLimit {
+	memory, swap := "max", "max"
+	if l.MemoryLimit > 0 {
+		memory = strconv.FormatInt(l.BoundedMemoryLimit(), 10)
+		if l.Swap >= 0 {
+			swap = strconv.FormatInt(l.Swap*1024*1024, 10)
+		}
+	}
+	cpu := "max 100000"
+	if l.CpuLimit > 0 {
+		cpu = fmt.Sprintf("%d 100000", l.CpuLimit*1_000)
+	}
+	pids := "max"
+	if p := l.ProcessLimit(); p > 0 {
+		pids = strconv.FormatInt(p, 10)
+	}
+
+	out := []cgroupLimit{
+		{file: "memory.max", value: memory},
+		{file: "memory.low", value: strconv.FormatInt(l.MemoryLimit*1024*1024, 10)},
+		{file: "memory.swap.max", value: swap},
+		{file: "memory.oom.group", value: "1"},
+		{file: "cpu.max", value: cpu},
+		{file: "cpuset.cpus", value: l.Threads},
+		{file: "pids.max", value: pids},
+	}
+	if l.IoWeight > 0 {
+		out = append(out, cgroupLimit{file: "io.weight", value: "default " + strconv.Itoa(ioWeight(l.IoWeight))})
+	}
+	return out
+}
+
+// ioWeight converts an IO weight between 10 and 1000, as used by Docker, to the
+// range of 1 to 10000 used by cgroup v2. This is the same conversion used by
+// runc.
+func ioWeight(w uint16) int {
+	v := min(max(int(w), 10), 1000)
+	return 1 + (v-10)*9999/990
+}
+
+// readCgroupValue reads a single value from a file in the cgroup.
+func (e *Environment) readCgroupValue(file string) (uint64, error) {
+	b, err := os.ReadFile(filepath.Join(e.cgroupPath(), file))
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// readCgroupKey reads a value from a flat keyed file in the cgroup, such as
+// memory.stat, returning 0 if the key is not present.
+func (e *Environment) readCgroupKey(file string, key string) (uint64, error) {
+	b, err := os.ReadFile(filepath.Join(e.cgroupPath(), file))
+	if err != nil {
+		return 0, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), " ")
+		if ok && k == key {
+			return strconv.ParseUint(v, 10, 64)
+		}
+	}
+	return 0, nil
+}
+
+// cgroupOOMKills returns the number of processes in the cgroup that have been killed
+// by the OOM killer.
+func (e *Environment) cgroupOOMKills() uint64 {
+	if e.cgroupPath() == "" {
+		return 0
+	}
+	v, _ := e.readCgroupKey("memory.events", "oom_kill")
+	return v
+}
+
+// cgroupProcesses returns true if there are any processes in the cgroup.
+func (e *Environment) cgroupProcesses() bool {
+	if e.cgroupPath() == "" {
+		return false
+	}
+	b, err := os.ReadFile(filepath.Join(e.cgroupPath(), "cgroup.procs"))
+	return err == nil && len(bytes.TrimSpace(b)) > 0
+}
+
+// killCgroup kills every process in the cgroup.
+func (e *Environment) killCgroup() error {
+	if e.cgroupPath() == "" {
+		return nil
+	}
+	err := os.WriteFile(filepath.Join(e.cgroupPath(), "cgroup.kill"), []byte("1"), 0o644)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "environment/native: failed to kill cgroup processes")
+	}
+	return nil
+}
+
+// removeCgroup removes the cgroup of the server, which can only be done once
+// every process in it has exited.
+func (e *Environment) removeCgroup() error {
+	if e.cgroupPath() == "" {
+		return nil
+	}
+	if err := os.Remove(e.cgroupPath()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "environment/native: failed to remove cgroup")
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Package native provides an environment that runs server processes directly on
+// the host, rather than within Docker containers. Processes are run as a user of
+// their own, restricted to their data directory using Landlock, limited using a
+// cgroup for each server, and attached to through a pseudo-terminal so that
+// commands can be sent to them.
+package native
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/events"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/system"
+)
+
+type Metadata struct {
+	Stop remote.ProcessStopConfiguration
+}
+
+// Ensure that the native environment is always implementing all the methods
+// from the base environment interface.
+var _ environment.ProcessEnvironment = (*Environment)(nil)
+
+type Environment struct {
+	mu sync.RWMutex
+
+	// The public identifier for this environment, which is the server UUID. It is
+	// used as the name of the cgroup and console log for the server.
+	Id string
+
+	// The environment configuration.
+	Configuration *environment.Configuration
+
+	meta *Metadata
+
+	// The ID of the user and group the server process is run as, which own the
+	// files of the server.
+	uid int
+
+	// The running server process and the pseudo-terminal attached to it. These only
+	// exist while the process is running.
+	cmd *exec.Cmd
+	pty *os.File
+
+	// Closed once the running process has exited and been cleaned up.
+	exited chan struct{}
+
+	startedAt time.Time
+
+	// The exit state of the last process to run, and the number of OOM kills in the
+	// cgroup when it was started, which is used to tell if it was killed by the OOM
+	// killer.
+	exitCode  uint32
+	oomKilled bool
+	oomKills  uint64
+
+	emitter *events.Bus
+
+	logCallbackMx sync.Mutex
+	logCallback   func([]byte)
+
+	// Tracks the environment state.
+	st *system.AtomicString
+}
+
+// New creates a new native environment. The ID passed through should be unique
+// per-server, the server UUID is used by default. A user is allocated for the
+// server if it does not have one yet.
+func New(id string, m *Metadata, c *environment.Configuration) (*Environment, error) {
+	uid, err := allocateUser(id)
+	if err != nil {
+		return nil, err
+	}
+	e := &Environment{
+		uid:           uid,
+		Id:            id,
+		Configuration: c,
+		meta:          m,
+		st:            system.NewAtomicString(environment.ProcessOfflineState),
+		emitter:       events.NewBus(),
+	}
+
+	return e, nil
+}
+
+// User returns the ID of the user and group the server process is run as, which
+// the files of the server must be owned by.
+func (e *Environment) User() (int, int) {
+	return e.uid, e.uid
+}
+
+func (e *Environment) log() *log.Entry {
+	return log.WithField("environment", e.Type()).WithField("server", e.Id)
+}
+
+func (e *Environment) Type() string {
+	return "native"
+}
+
+// IsAttached determines if the environment is attached to a running server
+// process, and can send commands to it.
+func (e *Environment) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.pty != nil
+}
+
+// Events returns an event bus for the environment.
+func (e *Environment) Events() *events.Bus {
+	return e.emitter
+}
+
+// Exists always returns true, since there is nothing that must exist before a
+// process can be started in this environment. Anything that is missing is
+// created when the server is started.
+func (e *Environment) Exists() (bool, error) {
+	return true, nil
+}
+
+// IsRunning determines if the server process is currently running.
+func (e *Environment) IsRunning(ctx context.Context) (bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.cmd != nil, nil
+}
+
+// ExitState returns the exit code of the last server process to run and whether
+// or not it was killed by the OOM killer. A process that was killed by a signal
+// has an exit code of 128 plus the signal number, as it would in a container.
+func (e *Environment) ExitState() (uint32, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.exitCode, e.oomKilled, nil
+}
+
+// Config returns the environment configuration allowing a process to make
+// modifications of the environment on the fly.
+func (e *Environment) Config() *environment.Configuration {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.Configuration
+}
+
+// SetStopConfiguration sets the stop configuration for the environment.
+func (e *Environment) SetStopConfiguration(c remote.ProcessStopConfiguration) {
+	e.mu.Lock()
+	e.meta.Stop = c
+	e.mu.Unlock()
+}
+
+func (e *Environment) State() string {
+	return e.st.Load()
+}
+
+// SetState sets the state of the environment. This emits an event that server's
+// can hook into to take their own actions and track their own state based on
+// the environment.
+func (e *Environment) SetState(state string) {
+	if state != environment.ProcessOfflineState &&
+		state != environment.ProcessStartingState &&
+		state != environment.ProcessRunningState &&
+		state != environment.ProcessStoppingState {
+		panic(errors.New(fmt.Sprintf("invalid server state received: %s", state)))
+	}
+
+	// Emit the event to any listeners that are currently registered.
+	if e.State() != state {
+		// If the state changed make sure we update the internal tracking to note that.
+		e.st.Store(state)
+		e.Events().Publish(environment.StateChangeEvent, state)
+	}
+}
+
+func (e *Environment) SetLogCallback(f func([]byte)) {
+	e.logCallbackMx.Lock()
+	defer e.logCallbackMx.Unlock()
+
+	e.logCallback = f
+}
+
+// Uptime returns the time in milliseconds since the server process was started,
+// or 0 if it is not running.
+func (e *Environment) Uptime(_ context.Context) (int64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.cmd == nil {
+		return 0, nil
+	}
+	return time.Since(e.startedAt).Milliseconds(), nil
+}
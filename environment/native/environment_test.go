@@ -0,0 +1,213 @@
+package native
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/remote"
+)
+
+func TestEnvironment(t *testing.T) {
+	g := Goblin(t)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "pelican")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Configuration{
+		AuthenticationToken: "abc",
+		System:              config.SystemConfiguration{LogDirectory: dir, RootDirectory: dir},
+		Native: config.NativeConfiguration{
+			Enabled:       true,
+			Shell:         "/bin/sh",
+			LogMaxSize:    1,
+			UserBase:      200000,
+			UserCount:     10,
+			ReadOnlyPaths: []string{"/bin", "/usr", "/lib", "/lib64", "/etc"},
+		},
+	}
+	cfg.System.User.Uid = os.Geteuid()
+	cfg.System.User.Gid = os.Getegid()
+	config.Set(cfg)
+
+	newEnvironment := func(startup string, stop remote.ProcessStopConfiguration) (*Environment, *output) {
+		c := environment.NewConfiguration(environment.Settings{
+			Mounts: []environment.Mount{{Default: true, Target: "/home/container", Source: dir}},
+		}, []string{"STARTUP=" + startup, "SERVER_PORT=25565"})
+		e, _ := New("8a4d1c6e-3b2f-4e9a-a7c5-0f1e2d3c4b5a", &Metadata{Stop: stop}, c)
+		out := &output{}
+		e.SetLogCallback(out.write)
+		return e, out
+	}
+
+	waitFor := func(e *Environment) {
+		e.mu.RLock()
+		exited := e.exited
+		e.mu.RUnlock()
+		select {
+		case <-exited:
+		case <-time.After(10 * time.Second):
+			g.Fail("timed out waiting for process to exit")
+		}
+	}
+
+	g.Describe("Native environment", func() {
+		g.It("runs the startup command with the server environment", func() {
+			e, out := newEnvironment(`echo "port $SERVER_PORT in $(pwd)"; read line; echo "got $line"; exit 3`, remote.ProcessStopConfiguration{})
+			g.Assert(e.Start(context.Background())).IsNil()
+			g.Assert(e.State()).Equal(environment.ProcessStartingState)
+			ok, _ := e.IsRunning(context.Background())
+			g.Assert(ok).IsTrue()
+
+			g.Assert(e.SendCommand("hello")).IsNil()
+			waitFor(e)
+
+			g.Assert(e.State()).Equal(environment.ProcessOfflineState)
+			code, oom, err := e.ExitState()
+			g.Assert(err).IsNil()
+			g.Assert(code).Equal(uint32(3))
+			g.Assert(oom).IsFalse()
+			g.Assert(out.contains("port 25565 in " + dir)).IsTrue()
+			g.Assert(out.contains("got hello")).IsTrue()
+
+			lines, err := e.Readlog(1)
+			g.Assert(err).IsNil()
+			g.Assert(lines).Equal([]string{"got hello"})
+
+			g.Assert(e.SendCommand("hello") != nil).IsTrue()
+		})
+
+		g.It("stops the process using the stop command", func() {
+			e, _ := newEnvironment(`while read line; do [ "$line" = "stop" ] && exit 0; done`, remote.ProcessStopConfiguration{Type: remote.ProcessStopCommand, Value: "stop"})
+			g.Assert(e.Start(context.Background())).IsNil()
+			e.SetState(environment.ProcessRunningState)
+
+			g.Assert(e.WaitForStop(context.Background(), 5*time.Second, false)).IsNil()
+			g.Assert(e.State()).Equal(environment.ProcessOfflineState)
+			code, _, _ := e.ExitState()
+			g.Assert(code).Equal(uint32(0))
+		})
+
+		g.It("terminates the process and everything it started", func() {
+			e, _ := newEnvironment(`sleep 60 & sleep 60`, remote.ProcessStopConfiguration{})
+			g.Assert(e.Start(context.Background())).IsNil()
+
+			g.Assert(e.Terminate(context.Background(), "sigterm")).IsNil()
+			g.Assert(e.State()).Equal(environment.ProcessOfflineState)
+			code, _, _ := e.ExitState()
+			g.Assert(code).Equal(uint32(143))
+			ok, _ := e.IsRunning(context.Background())
+			g.Assert(ok).IsFalse()
+		})
+
+		g.It("runs the process as the server user within its data directory", func() {
+			other, err := os.MkdirTemp(os.TempDir(), "pelican")
+			g.Assert(err).IsNil()
+			defer os.RemoveAll(other)
+			g.Assert(os.Chmod(other, 0o777)).IsNil()
+
+			e, out := newEnvironment(`id -u; id -g; echo a > own.txt && echo own; echo b > `+other+`/other.txt || echo denied`, remote.ProcessStopConfiguration{})
+			g.Assert(e.Start(context.Background())).IsNil()
+			waitFor(e)
+
+			g.Assert(out.contains("200000")).IsTrue()
+			g.Assert(out.contains("own")).IsTrue()
+			g.Assert(out.contains("denied")).IsTrue()
+			_, err = os.Stat(filepath.Join(other, "other.txt"))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+
+		g.It("does not start if the startup command cannot be run", func() {
+			config.Update(func(c *config.Configuration) {
+				c.Native.Shell = "/nonexistent/sh"
+			})
+			defer config.Update(func(c *config.Configuration) {
+				c.Native.Shell = "/bin/sh"
+			})
+
+			e, _ := newEnvironment(`exit 0`, remote.ProcessStopConfiguration{})
+			g.Assert(e.Start(context.Background()) != nil).IsTrue()
+			ok, _ := e.IsRunning(context.Background())
+			g.Assert(ok).IsFalse()
+		})
+
+		g.It("allocates a user to each server", func() {
+			c := environment.NewConfiguration(environment.Settings{}, nil)
+			e, err := New("6b1f0e2a-9c4d-4f3e-8a7b-5d2c1e0f9a8b", &Metadata{}, c)
+			g.Assert(err).IsNil()
+			uid, gid := e.User()
+			g.Assert(uid).Equal(200001)
+			g.Assert(gid).Equal(200001)
+
+			e, _ = New("8a4d1c6e-3b2f-4e9a-a7c5-0f1e2d3c4b5a", &Metadata{}, c)
+			uid, _ = e.User()
+			g.Assert(uid).Equal(200000)
+
+			g.Assert(releaseUser("6b1f0e2a-9c4d-4f3e-8a7b-5d2c1e0f9a8b")).IsNil()
+			e, _ = New("3c9e8d7f-1a2b-4c5d-9e8f-7a6b5c4d3e2f", &Metadata{}, c)
+			uid, _ = e.User()
+			g.Assert(uid).Equal(200001)
+		})
+	})
+
+	g.Describe("Cgroup limits", func() {
+		g.It("converts server limits to cgroup values", func() {
+			limits := cgroupLimits(environment.Limits{MemoryLimit: 1024, Swap: 0, CpuLimit: 150, IoWeight: 500, Threads: "0-1"})
+			values := make(map[string]string)
+			for _, l := range limits {
+				values[l.file] = l.value
+			}
+			g.Assert(values["memory.low"]).Equal("1073741824")
+			g.Assert(values["memory.swap.max"]).Equal("0")
+			g.Assert(values["cpu.max"]).Equal("150000 100000")
+			g.Assert(values["cpuset.cpus"]).Equal("0-1")
+			g.Assert(values["io.weight"]).Equal("default 4950")
+		})
+
+		g.It("leaves unset limits unlimited", func() {
+			values := make(map[string]string)
+			for _, l := range cgroupLimits(environment.Limits{Swap: -1}) {
+				values[l.file] = l.value
+			}
+			g.Assert(values["memory.max"]).Equal("max")
+			g.Assert(values["memory.swap.max"]).Equal("max")
+			g.Assert(values["cpu.max"]).Equal("max 100000")
+			g.Assert(values["pids.max"]).Equal("max")
+			_, ok := values["io.weight"]
+			g.Assert(ok).IsFalse()
+		})
+	})
+}
+
+// output collects the console output of a process.
+type output struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (o *output) write(b []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lines = append(o.lines, string(b))
+}
+
+func (o *output) contains(line string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, l := range o.lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
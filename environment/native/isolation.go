@@ -0,0 +1,158 @@
+package native
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+	"golang.org/x/sys/unix"
+)
+
+// initName is the name the Wings binary is run as to start a server process. The
+// process restricts itself to the data directory of the server and drops its
+// privileges before running the startup command, since there is no way to do so
+// between forking and running the command from Go.
+const initName = "pelican-native-init"
+
+// initConfig is passed to the init process as its only argument.
+type initConfig struct {
+	Uid           int      `json:"uid"`
+	Gid           int      `json:"gid"`
+	Dir           string   `json:"dir"`
+	ReadOnlyPaths []string `json:"read_only_paths"`
+	Args          []string `json:"args"`
+}
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == initName {
+		runInit()
+	}
+}
+
+// runInit runs the startup command of a server, and never returns. If the command
+// cannot be run, the reason is written to the pipe passed as the fourth file of
+// the process. The pipe is closed once the command is run, which is how Wings
+// knows that it was started.
+func runInit() {
+	errs := os.NewFile(3, "errors")
+	unix.CloseOnExec(3)
+	err := execStartup()
+	_, _ = errs.WriteString(err.Error())
+	os.Exit(126)
+}
+
+// execStartup restricts the process to the data directory of the server, drops
+// its privileges and runs the startup command. It only returns if this fails.
+func execStartup() error {
+	// Landlock restricts the thread that applies it, which must be the one that
+	// runs the startup command.
+	runtime.LockOSThread()
+
+	var c initConfig
+	if len(os.Args) != 2 || json.Unmarshal([]byte(os.Args[1]), &c) != nil || len(c.Args) == 0 {
+		return errors.New("invalid arguments")
+	}
+	path, err := exec.LookPath(c.Args[0])
+	if err != nil {
+		return err
+	}
+	if err := restrictFilesystem(c.Dir, c.ReadOnlyPaths); err != nil {
+		return err
+	}
+	if err := syscall.Setgroups(nil); err != nil {
+		return errors.Wrap(err, "failed to drop supplementary groups")
+	}
+	if err := syscall.Setgid(c.Gid); err != nil {
+		return errors.Wrap(err, "failed to change group")
+	}
+	if err := syscall.Setuid(c.Uid); err != nil {
+		return errors.Wrap(err, "failed to change user")
+	}
+	return errors.Wrap(syscall.Exec(path, c.Args, os.Environ()), "failed to run startup command")
+}
+
+const (
+	// The filesystem access rights of a path that can be read and run.
+	accessRead = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	// The filesystem access rights that apply to files, rather than directories.
+	accessFile = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+// restrictFilesystem uses Landlock to limit the process to writing to the data
+// directory of the server and the devices in /dev, and reading and running the
+// files in the read-only paths. Read-only paths that do not exist are skipped.
+func restrictFilesystem(dir string, readOnly []string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return errors.Wrap(errno, "landlock is not supported by the kernel")
+	}
+	// Rights added in later versions of Landlock can only be handled if the kernel
+	// supports them.
+	handled := uint64(unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		handled |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "failed to create landlock ruleset")
+	}
+	defer unix.Close(int(fd))
+
+	if err := allowPath(int(fd), dir, handled); err != nil {
+		return err
+	}
+	devices := handled & (accessFile | unix.LANDLOCK_ACCESS_FS_READ_DIR) &^ unix.LANDLOCK_ACCESS_FS_EXECUTE
+	for _, p := range append([]string{"/dev"}, readOnly...) {
+		access := uint64(accessRead)
+		if p == "/dev" {
+			access = devices
+		}
+		if err := allowPath(int(fd), p, access); err != nil && !errors.Is(err, unix.ENOENT) {
+			return err
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return errors.Wrap(err, "failed to set no_new_privs")
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return errors.Wrap(errno, "failed to apply landlock ruleset")
+	}
+	return nil
+}
+
+// allowPath adds a rule to the ruleset allowing the access to everything beneath
+// the path.
+func allowPath(ruleset int, p string, access uint64) error {
+	fd, err := unix.Open(p, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", p)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return errors.Wrapf(err, "failed to stat %s", p)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= accessFile
+	}
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return errors.Wrapf(errno, "failed to allow access to %s", p)
+	}
+	return nil
+}
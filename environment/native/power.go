@@ -0,0 +1,202 @@
+package native
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"time"
+
+	"emperror.dev/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/remote"
+)
+
+// OnBeforeStart kills any processes left in the cgroup of the server, which can
+// happen if Wings was restarted while the server was running, and then creates
+// the environment again so that the latest limits for the server are used.
+func (e *Environment) OnBeforeStart(_ context.Context) error {
+	if e.cgroupProcesses() {
+		e.log().Warn("killing processes left running in server cgroup")
+		if err := e.killCgroup(); err != nil {
+			return err
+		}
+	}
+	return e.Create()
+}
+
+// Start starts the server process and begins piping output to the event
+// listeners for the console.
+func (e *Environment) Start(ctx context.Context) error {
+	if ok, _ := e.IsRunning(ctx); ok {
+		e.SetState(environment.ProcessRunningState)
+		return nil
+	}
+
+	e.SetState(environment.ProcessStartingState)
+
+	if err := e.OnBeforeStart(ctx); err != nil {
+		// If we don't set it to stopping first, you'll trigger crash detection which
+		// we don't want to do at this point since it'll just immediately try to do the
+		// exact same action that lead to it crashing in the first place...
+		e.SetState(environment.ProcessStoppingState)
+		e.SetState(environment.ProcessOfflineState)
+		return errors.WrapIf(err, "environment/native: failed to run pre-boot process")
+	}
+	if err := e.start(); err != nil {
+		e.SetState(environment.ProcessStoppingState)
+		e.SetState(environment.ProcessOfflineState)
+		return err
+	}
+	return nil
+}
+
+// Stop stops the server process using the configured stop command or signal. If
+// no stop configuration is set the process is sent SIGTERM.
+//
+// You most likely want to be using WaitForStop() rather than this function,
+// since this will return as soon as the command is sent, rather than waiting
+// for the process to be completed stopped.
+func (e *Environment) Stop(ctx context.Context) error {
+	e.mu.RLock()
+	s := e.meta.Stop
+	e.mu.RUnlock()
+
+	if s.Type == remote.ProcessStopSignal {
+		signal := "SIGKILL"
+		switch strings.ToUpper(s.Value) {
+		case "SIGABRT":
+			signal = "SIGABRT"
+		case "SIGINT", "C":
+			signal = "SIGINT"
+		case "SIGTERM":
+			signal = "SIGTERM"
+		}
+		return e.Terminate(ctx, signal)
+	}
+
+	if e.st.Load() != environment.ProcessOfflineState {
+		e.SetState(environment.ProcessStoppingState)
+	}
+
+	if e.IsAttached() && s.Type == remote.ProcessStopCommand {
+		return e.SendCommand(s.Value)
+	}
+
+	if s.Type == "" {
+		e.log().Warn("no stop configuration detected for environment, using termination procedure")
+	}
+	return e.signal(syscall.SIGTERM)
+}
+
+// WaitForStop attempts to gracefully stop a server using the defined stop
+// command. If the server does not stop after seconds have passed, an error will
+// be returned, or the instance will be terminated forcefully depending on the
+// value of the second argument.
+func (e *Environment) WaitForStop(ctx context.Context, duration time.Duration, terminate bool) error {
+	tctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	doTermination := func(s string) error {
+		e.log().WithField("step", s).WithField("duration", duration).Warn("process stop did not complete in time, terminating process...")
+		return e.Terminate(context.Background(), "SIGKILL")
+	}
+
+	if err := e.Stop(tctx); err != nil {
+		if terminate && errors.Is(err, context.DeadlineExceeded) {
+			return doTermination("stop")
+		}
+		return err
+	}
+
+	e.mu.RLock()
+	exited := e.exited
+	running := e.cmd != nil
+	e.mu.RUnlock()
+	if !running {
+		return nil
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-tctx.Done():
+		if terminate {
+			step := "wait"
+			if ctx.Err() != nil {
+				step = "parent-context"
+			}
+			return doTermination(step)
+		}
+		return tctx.Err()
+	}
+}
+
+// Terminate sends the given signal to the server process, and kills it if it
+// has not stopped after 10 seconds.
+func (e *Environment) Terminate(ctx context.Context, signal string) error {
+	e.mu.RLock()
+	exited := e.exited
+	running := e.cmd != nil
+	e.mu.RUnlock()
+
+	if !running {
+		// If the process is not running, but we're not already in a stopped state go ahead
+		// and update things to indicate we should be completely stopped now. Set to stopping
+		// first so crash detection is not triggered.
+		if e.st.Load() != environment.ProcessOfflineState {
+			e.SetState(environment.ProcessStoppingState)
+			e.SetState(environment.ProcessOfflineState)
+		}
+		return nil
+	}
+
+	sig := unix.SignalNum(strings.ToUpper(signal))
+	if sig == 0 {
+		return errors.New("environment/native: unknown signal " + signal)
+	}
+
+	// We set it to stopping then offline to prevent crash detection from being triggered.
+	e.SetState(environment.ProcessStoppingState)
+	if err := e.signal(sig); err != nil {
+		return err
+	}
+
+	select {
+	case <-exited:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+		e.log().Debug("sent SIGKILL to process: graceful shutdown timed out")
+		if err := e.signal(syscall.SIGKILL); err != nil {
+			return err
+		}
+		<-exited
+	}
+	e.SetState(environment.ProcessOfflineState)
+	return nil
+}
+
+// Signal sends a signal to the server process without waiting for it to exit.
+func (e *Environment) Signal(_ context.Context, signal string) error {
+	sig := unix.SignalNum(strings.ToUpper(signal))
+	if sig == 0 {
+		return errors.New("environment/native: unknown signal " + signal)
+	}
+	return e.signal(sig)
+}
+
+// signal sends a signal to the process group of the server process, which
+// includes any processes it has started that have not left it.
+func (e *Environment) signal(sig syscall.Signal) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.cmd == nil {
+		return nil
+	}
+	if err := syscall.Kill(-e.cmd.Process.Pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return errors.Wrap(err, "environment/native: failed to signal process")
+	}
+	return nil
+}
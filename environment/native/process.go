@@ -0,0 +1,368 @@
+package native
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/creack/pty"
+	"github.com/goccy/go-json"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/system"
+)
+
+var ErrNotAttached = errors.Sentinel("not attached to instance")
+
+// defaultPath is the PATH that server processes are started with.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Create creates the cgroup for the server and the directory its console log is
+// written to. Mounts other than the data directory of the server cannot be
+// provided to a process running on the host, so they are ignored.
+func (e *Environment) Create() error {
+	if err := e.createCgroup(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.logPath()), 0o755); err != nil {
+		return errors.Wrap(err, "environment/native: failed to create console log directory")
+	}
+	for _, m := range e.Configuration.Mounts() {
+		if !m.Default {
+			e.log().WithField("target", m.Target).Debug("mounts are not supported by the native environment, ignoring mount")
+		}
+	}
+	return nil
+}
+
+// Destroy stops the server process if it is running and removes the cgroup and
+// console logs of the server, and frees the user allocated to it.
+func (e *Environment) Destroy() error {
+	// We set it to stopping than offline to prevent crash detection from being triggered.
+	e.SetState(environment.ProcessStoppingState)
+	err := e.Terminate(context.Background(), "SIGKILL")
+	e.SetState(environment.ProcessOfflineState)
+	if err != nil {
+		return err
+	}
+
+	if err := e.removeCgroup(); err != nil {
+		return err
+	}
+	for _, p := range []string{e.logPath(), e.logPath() + ".1"} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "environment/native: failed to remove console log")
+		}
+	}
+	return releaseUser(e.Id)
+}
+
+// Attach does nothing, since the environment is attached to the server process
+// when it is started, and the pseudo-terminal of a process cannot be attached
+// to again once Wings has been restarted.
+func (e *Environment) Attach(_ context.Context) error {
+	return nil
+}
+
+// InSituUpdate applies the resource limits of the server to its cgroup, which
+// takes effect immediately for the running process.
+func (e *Environment) InSituUpdate() error {
+	if e.cgroupPath() == "" {
+		return nil
+	}
+	if _, err := os.Stat(e.cgroupPath()); os.IsNotExist(err) {
+		return nil
+	}
+	return e.applyLimits()
+}
+
+// SendCommand writes the command to the pseudo-terminal of the running server
+// process. There is no confirmation that the command was received, only that it
+// was written.
+func (e *Environment) SendCommand(c string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.pty == nil {
+		return errors.Wrap(ErrNotAttached, "environment/native: cannot send command to process")
+	}
+
+	// If the command being processed is the same as the process stop command then we
+	// want to mark the server as entering the stopping state otherwise the process will
+	// stop and Wings will think it has crashed and attempt to restart it.
+	if e.meta.Stop.Type == "command" && c == e.meta.Stop.Value {
+		e.SetState(environment.ProcessStoppingState)
+	}
+
+	_, err := e.pty.Write([]byte(c + "\n"))
+
+	return errors.Wrap(err, "environment/native: could not write to process")
+}
+
+// Readlog returns the last lines of the console log of the server. This does not
+// care if the server is running or not.
+func (e *Environment) Readlog(lines int) ([]string, error) {
+	out := make([]string, 0, lines)
+	for _, p := range []string{e.logPath() + ".1", e.logPath()} {
+		f, err := os.Open(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		for s.Scan() {
+			if len(out) == lines {
+				out = append(out[:0], out[1:]...)
+			}
+			out = append(out, s.Text())
+		}
+		_ = f.Close()
+		if err := s.Err(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return out, nil
+}
+
+// logPath returns the path of the console log of the server.
+func (e *Environment) logPath() string {
+	return filepath.Join(config.Get().System.LogDirectory, "console", e.Id+".log")
+}
+
+// dataDirectory returns the data directory of the server, which is the working
+// directory of the server process.
+func (e *Environment) dataDirectory() string {
+	for _, m := range e.Configuration.Mounts() {
+		if m.Default {
+			return m.Source
+		}
+	}
+	return ""
+}
+
+// start starts the server process in its cgroup, attached to a new
+// pseudo-terminal. The startup command is run with the configured shell, which
+// expands any variables in it using the environment variables of the server. The
+// process is run as the user of the server and restricted to its data directory,
+// and is not started at all if that cannot be done.
+func (e *Environment) start() error {
+	dir := e.dataDirectory()
+	if dir == "" {
+		return errors.New("environment/native: server has no data directory")
+	}
+	if os.Geteuid() != 0 {
+		return errors.New("environment/native: wings must run as root to run servers as their own user")
+	}
+
+	cfg := config.Get()
+	var startup string
+	env := []string{"HOME=" + dir, "PATH=" + defaultPath, "TERM=xterm"}
+	for _, v := range e.Configuration.EnvironmentVariables() {
+		if s, ok := strings.CutPrefix(v, "STARTUP="); ok {
+			startup = s
+		}
+		env = append(env, v)
+	}
+	if startup == "" {
+		return errors.New("environment/native: server has no startup command")
+	}
+	if err := os.Lchown(dir, e.uid, e.uid); err != nil {
+		return errors.Wrap(err, "environment/native: failed to chown data directory")
+	}
+
+	args, err := json.Marshal(initConfig{
+		Uid:           e.uid,
+		Gid:           e.uid,
+		Dir:           dir,
+		ReadOnlyPaths: cfg.Native.ReadOnlyPaths,
+		Args:          []string{cfg.Native.Shell, "-c", startup},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// The Wings binary is run again as the init process, see runInit.
+	cmd := &exec.Cmd{Path: "/proc/self/exe", Args: []string{initName, string(args)}, Dir: dir, Env: env}
+	attrs := &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	var oomKills uint64
+	if p := e.cgroupPath(); p != "" {
+		cg, err := os.Open(p)
+		if err != nil {
+			return errors.Wrap(err, "environment/native: failed to open cgroup")
+		}
+		defer cg.Close()
+		attrs.UseCgroupFD = true
+		attrs.CgroupFD = int(cg.Fd())
+		oomKills = e.cgroupOOMKills()
+	}
+
+	clog, err := newConsoleLog(e.logPath(), cfg.Native.LogMaxSize*1024*1024)
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		_ = clog.Close()
+		return errors.Wrap(err, "environment/native: failed to create pipe")
+	}
+	defer r.Close()
+	cmd.ExtraFiles = []*os.File{w}
+	f, err := pty.StartWithAttrs(cmd, nil, attrs)
+	_ = w.Close()
+	if err != nil {
+		_ = clog.Close()
+		return errors.Wrap(err, "environment/native: failed to start process")
+	}
+	// The pipe is closed without anything being written to it once the startup
+	// command has been run.
+	if msg, _ := io.ReadAll(r); len(msg) > 0 {
+		_ = cmd.Wait()
+		_ = f.Close()
+		_ = clog.Close()
+		return errors.New("environment/native: failed to start process: " + string(msg))
+	}
+
+	exited := make(chan struct{})
+	e.mu.Lock()
+	e.cmd = cmd
+	e.pty = f
+	e.exited = exited
+	e.startedAt = time.Now()
+	e.oomKills = oomKills
+	e.mu.Unlock()
+
+	go e.wait(cmd, f, clog, exited)
+	return nil
+}
+
+// wait copies the output of the server process to the console until it exits,
+// and then records its exit state. Like a container, anything else started by
+// the server process is killed once it has exited.
+func (e *Environment) wait(cmd *exec.Cmd, f *os.File, clog *consoleLog, exited chan struct{}) {
+	output := make(chan struct{})
+	go func() {
+		defer close(output)
+		// Reading from the pseudo-terminal returns EIO once every process using it
+		// has exited, which is the end of the output.
+		if err := system.ScanReader(f, func(v []byte) {
+			clog.Write(v)
+			e.logCallbackMx.Lock()
+			defer e.logCallbackMx.Unlock()
+			if e.logCallback != nil {
+				e.logCallback(v)
+			}
+		}); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, syscall.EIO) && !errors.Is(err, os.ErrClosed) {
+			e.log().WithField("error", err).Warn("error processing scanner line in console output")
+		}
+	}()
+
+	pollCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if err := e.pollResources(pollCtx, exited); err != nil && !errors.Is(err, context.Canceled) {
+			e.log().WithField("error", err).Error("error during environment resource polling")
+		}
+	}()
+
+	_ = cmd.Wait()
+	cancel()
+	e.killAll(cmd.Process.Pid)
+	select {
+	case <-output:
+	case <-time.After(time.Second * 5):
+	}
+	_ = f.Close()
+	<-output
+	_ = clog.Close()
+
+	code := cmd.ProcessState.ExitCode()
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		code = 128 + int(ws.Signal())
+	}
+
+	e.mu.Lock()
+	e.exitCode = uint32(code)
+	e.oomKilled = e.cgroupOOMKills() > e.oomKills
+	e.cmd = nil
+	e.pty = nil
+	e.mu.Unlock()
+	close(exited)
+
+	e.SetState(environment.ProcessOfflineState)
+}
+
+// killAll kills every process that belongs to the server, which are those in its
+// cgroup and its process group.
+func (e *Environment) killAll(pid int) {
+	if err := e.killCgroup(); err != nil {
+		e.log().WithField("error", err).Warn("failed to kill processes in cgroup")
+	}
+	_ = syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// consoleLog writes the console output of a server to disk. Once the log reaches
+// its maximum size it is rotated, keeping only the previous log.
+type consoleLog struct {
+	mu   sync.Mutex
+	path string
+	max  int64
+	size int64
+	f    *os.File
+}
+
+// newConsoleLog creates a new, empty console log at the given path.
+func newConsoleLog(path string, max int64) (*consoleLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, errors.Wrap(err, "environment/native: failed to create console log directory")
+	}
+	_ = os.Remove(path + ".1")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "environment/native: failed to open console log")
+	}
+	return &consoleLog{path: path, max: max, f: f}, nil
+}
+
+// Write writes a line of output to the log. Errors are ignored, since failing to
+// write the log should not affect the server.
+func (l *consoleLog) Write(line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	if l.max > 0 && l.size+int64(len(line))+1 > l.max && l.size > 0 {
+		_ = l.f.Close()
+		_ = os.Rename(l.path, l.path+".1")
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			l.f = nil
+			return
+		}
+		l.f, l.size = f, 0
+	}
+	n, _ := l.f.Write(line)
+	m, _ := l.f.Write([]byte{'\n'})
+	l.size += int64(n + m)
+}
+
+func (l *consoleLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
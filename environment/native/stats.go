@@ -0,0 +1,74 @@
+package native
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/Minenetpro/pelican-wings/environment"
+)
+
+// pollResources emits the resource usage of the server every second until the
+// process exits. Usage is read from the cgroup of the server, so only the uptime
+// is reported if servers are run without one. Network usage cannot be measured
+// for a process on the host and is always reported as zero.
+func (e *Environment) pollResources(ctx context.Context, exited <-chan struct{}) error {
+	e.log().Info("starting resource polling for process")
+	defer e.log().Debug("stopped resource polling for process")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastUsage uint64
+	var lastRead time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-exited:
+			return nil
+		case <-ticker.C:
+		}
+
+		uptime, _ := e.Uptime(ctx)
+		st := environment.Stats{Uptime: uptime}
+		if e.cgroupPath() != "" {
+			st.Memory = e.memoryUsage()
+			st.MemoryLimit, _ = e.readCgroupValue("memory.max")
+
+			now := time.Now()
+			if usage, err := e.readCgroupKey("cpu.stat", "usage_usec"); err == nil {
+				if !lastRead.IsZero() && usage >= lastUsage {
+					st.CpuAbsolute = calculateAbsoluteCpu(usage-lastUsage, now.Sub(lastRead))
+				}
+				lastUsage, lastRead = usage, now
+			}
+		}
+
+		e.Events().Publish(environment.ResourceEvent, st)
+	}
+}
+
+// memoryUsage returns the memory used by the cgroup, excluding inactive file
+// cache in the same way as "docker stats".
+func (e *Environment) memoryUsage() uint64 {
+	usage, err := e.readCgroupValue("memory.current")
+	if err != nil {
+		return 0
+	}
+	if v, err := e.readCgroupKey("memory.stat", "inactive_file"); err == nil && v < usage {
+		return usage - v
+	}
+	return usage
+}
+
+// calculateAbsoluteCpu returns the CPU usage as a percentage of a single thread,
+// given the CPU time used in microseconds over a period of time. A process using
+// two threads fully is at 200%.
+func calculateAbsoluteCpu(usec uint64, period time.Duration) float64 {
+	if period <= 0 {
+		return 0
+	}
+	percent := float64(usec) / float64(period.Microseconds()) * 100
+	return math.Round(percent*1000) / 1000
+}
@@ -0,0 +1,102 @@
+package native
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+
+	"github.com/Minenetpro/pelican-wings/config"
+)
+
+// usersMu guards the file that records the user allocated to each server.
+var usersMu sync.Mutex
+
+// usersPath returns the path of the file that records the user allocated to each
+// server, which is kept so that a server keeps the same user, and so keeps access
+// to its files, when Wings is restarted.
+func usersPath() string {
+	return filepath.Join(config.Get().System.RootDirectory, "native_users.json")
+}
+
+func readUsers() (map[string]int, error) {
+	users := make(map[string]int)
+	b, err := os.ReadFile(usersPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return users, nil
+		}
+		return nil, errors.Wrap(err, "environment/native: failed to read server users")
+	}
+	if err := json.Unmarshal(b, &users); err != nil {
+		return nil, errors.Wrap(err, "environment/native: failed to parse server users")
+	}
+	return users, nil
+}
+
+func writeUsers(users map[string]int) error {
+	b, err := json.Marshal(users)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(usersPath()), 0o700); err != nil {
+		return errors.Wrap(err, "environment/native: failed to create root directory")
+	}
+	tmp := usersPath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return errors.Wrap(err, "environment/native: failed to write server users")
+	}
+	return errors.Wrap(os.Rename(tmp, usersPath()), "environment/native: failed to write server users")
+}
+
+// allocateUser returns the ID of the user and group the server is run as,
+// allocating the lowest free ID in the configured range if the server does not
+// have one yet.
+func allocateUser(id string) (int, error) {
+	usersMu.Lock()
+	defer usersMu.Unlock()
+
+	users, err := readUsers()
+	if err != nil {
+		return 0, err
+	}
+	if uid, ok := users[id]; ok {
+		return uid, nil
+	}
+
+	used := make(map[int]bool, len(users))
+	for _, uid := range users {
+		used[uid] = true
+	}
+	cfg := config.Get().Native
+	for uid := cfg.UserBase; uid < cfg.UserBase+cfg.UserCount; uid++ {
+		if uid <= 0 || used[uid] {
+			continue
+		}
+		users[id] = uid
+		if err := writeUsers(users); err != nil {
+			return 0, err
+		}
+		return uid, nil
+	}
+	return 0, errors.New("environment/native: no free user IDs left for the server")
+}
+
+// releaseUser frees the user allocated to the server, so it can be given to
+// another server.
+func releaseUser(id string) error {
+	usersMu.Lock()
+	defer usersMu.Unlock()
+
+	users, err := readUsers()
+	if err != nil {
+		return err
+	}
+	if _, ok := users[id]; !ok {
+		return nil
+	}
+	delete(users, id)
+	return writeUsers(users)
+}
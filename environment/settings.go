@@ -57,6 +57,14 @@ type Limits struct {
 	Threads string `json:"threads"`
 
 	OOMKiller bool `json:"oom_killer"`
+
+	// The rate in kilobits per second at which the server is allowed to receive
+	// network traffic. If this is 0 the rate is not limited.
+	IngressLimit int64 `json:"ingress_limit"`
+
+	// The rate in kilobits per second at which the server is allowed to send
+	// network traffic. If this is 0 the rate is not limited.
+	EgressLimit int64 `json:"egress_limit"`
 }
 
 // ConvertedCpuLimit converts the CPU limit for a server build into a number
@@ -0,0 +1,145 @@
+// Package acl implements the per-path access rules the Panel can attach to a
+// user's SFTP session or file API requests, limiting them to parts of a
+// server's data directory.
+package acl
+
+import (
+	"path"
+	"strings"
+)
+
+// Mode is a type of access to a path.
+type Mode string
+
+const (
+	// Read allows a file to be read, or a directory to be listed.
+	Read Mode = "read"
+	// Write allows a file or directory to be created, modified, or be the
+	// target of a rename.
+	Write Mode = "write"
+	// Delete allows a file or directory to be deleted, or renamed to another
+	// location.
+	Delete Mode = "delete"
+)
+
+// Rule allows, or denies, access to the paths matching a pattern and
+// everything beneath them.
+//
+// Patterns are relative to the root of the data directory, with "*" matching
+// anything within a single path segment and "**" matching any number of
+// segments. For example "plugins" matches the plugins directory and all of its
+// contents, and "world*/**/*.mca" matches region files within any world.
+type Rule struct {
+	Path string `json:"path"`
+	// Modes is the types of access the rule applies to. If no modes are set
+	// the rule applies to all of them.
+	Modes []Mode `json:"modes"`
+	// Deny denies access to the matching paths, rather than allowing it. Deny
+	// rules always take precedence over allow rules.
+	Deny bool `json:"deny"`
+}
+
+// Rules is a set of rules for a user. When there are no rules the user has
+// access to everything, otherwise access to anything not explicitly allowed
+// is denied.
+type Rules []Rule
+
+// Allows returns whether the rules allow the given type of access to the path.
+func (r Rules) Allows(p string, m Mode) bool {
+	return r.allows(p, m, false)
+}
+
+// AllowsTree returns whether the rules allow the given type of access to the
+// path and everything beneath it. This should be used for any operation that
+// acts on the contents of a directory, such as deleting or compressing it.
+func (r Rules) AllowsTree(p string, m Mode) bool {
+	return r.allows(p, m, true)
+}
+
+// Visible returns whether the path can be listed or stat'd, which is the case
+// if the path can be read or if it is a parent directory of a path that the
+// rules allow any access to. This allows a user to navigate to the
+// directories they have access to.
+func (r Rules) Visible(p string) bool {
+	if r.Allows(p, Read) {
+		return true
+	}
+	name := split(p)
+	for _, rule := range r {
+		if rule.Deny {
+			continue
+		}
+		if _, parent := match(split(rule.Path), name); parent {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rules) allows(p string, m Mode, tree bool) bool {
+	if len(r) == 0 {
+		return true
+	}
+	name := split(p)
+	var allowed bool
+	for _, rule := range r {
+		if !rule.appliesTo(m) {
+			continue
+		}
+		matched, parent := match(split(rule.Path), name)
+		if rule.Deny {
+			if matched || (tree && parent) {
+				return false
+			}
+		} else if matched {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func (rule Rule) appliesTo(m Mode) bool {
+	if len(rule.Modes) == 0 {
+		return true
+	}
+	for _, v := range rule.Modes {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+// split cleans the path and splits it into its segments. The root of the data
+// directory has no segments.
+func split(p string) []string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// match returns whether the path matches the pattern, either directly or by
+// being beneath a path that does. If the path does not match, parent is true
+// when something beneath the path could.
+func match(pattern, name []string) (matched, parent bool) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if m, _ := match(pattern[1:], name[i:]); m {
+					return true, false
+				}
+			}
+			return false, true
+		}
+		if len(name) == 0 {
+			return false, true
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false, false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return true, false
+}
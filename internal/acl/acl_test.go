@@ -0,0 +1,70 @@
+package acl_test
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/internal/acl"
+)
+
+func TestRules(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Rules", func() {
+		rules := acl.Rules{
+			{Path: "plugins"},
+			{Path: "plugins/secret.yml", Modes: []acl.Mode{acl.Read, acl.Write}, Deny: true},
+			{Path: "world*/**/*.mca", Modes: []acl.Mode{acl.Read}},
+			{Path: "/uploads/", Modes: []acl.Mode{acl.Write}},
+		}
+
+		g.It("allows everything when there are no rules", func() {
+			g.Assert(acl.Rules(nil).Allows("/server.properties", acl.Delete)).IsTrue()
+			g.Assert(acl.Rules(nil).AllowsTree("/", acl.Delete)).IsTrue()
+			g.Assert(acl.Rules(nil).Visible("/")).IsTrue()
+		})
+
+		g.It("allows access to paths beneath an allowed directory", func() {
+			g.Assert(rules.Allows("plugins", acl.Delete)).IsTrue()
+			g.Assert(rules.Allows("/plugins/Essentials/config.yml", acl.Write)).IsTrue()
+			g.Assert(rules.Allows("/plugins/../plugins/plugin.jar", acl.Read)).IsTrue()
+		})
+
+		g.It("denies access to paths that are not allowed", func() {
+			g.Assert(rules.Allows("/server.properties", acl.Read)).IsFalse()
+			g.Assert(rules.Allows("/plugins/../server.properties", acl.Read)).IsFalse()
+			g.Assert(rules.Allows("/", acl.Read)).IsFalse()
+			g.Assert(rules.Allows("/uploads/file.txt", acl.Read)).IsFalse()
+			g.Assert(rules.Allows("/uploads/file.txt", acl.Write)).IsTrue()
+		})
+
+		g.It("matches glob patterns", func() {
+			g.Assert(rules.Allows("/world/region/r.0.0.mca", acl.Read)).IsTrue()
+			g.Assert(rules.Allows("/world_nether/DIM-1/region/r.0.0.mca", acl.Read)).IsTrue()
+			g.Assert(rules.Allows("/world/r.0.0.mca", acl.Read)).IsTrue()
+			g.Assert(rules.Allows("/world/level.dat", acl.Read)).IsFalse()
+			g.Assert(rules.Allows("/world/region/r.0.0.mca", acl.Write)).IsFalse()
+		})
+
+		g.It("gives deny rules precedence", func() {
+			g.Assert(rules.Allows("/plugins/secret.yml", acl.Read)).IsFalse()
+			g.Assert(rules.Allows("/plugins/secret.yml", acl.Delete)).IsTrue()
+			g.Assert(rules.Allows("/plugins", acl.Read)).IsTrue()
+		})
+
+		g.It("checks deny rules beneath a directory when checking the tree", func() {
+			g.Assert(rules.AllowsTree("/plugins", acl.Read)).IsFalse()
+			g.Assert(rules.AllowsTree("/plugins", acl.Delete)).IsTrue()
+			g.Assert(rules.AllowsTree("/plugins/Essentials", acl.Read)).IsTrue()
+		})
+
+		g.It("makes parents of allowed paths visible", func() {
+			g.Assert(rules.Visible("/")).IsTrue()
+			g.Assert(rules.Visible("/world_the_end")).IsTrue()
+			g.Assert(rules.Visible("/plugins/plugin.jar")).IsTrue()
+			g.Assert(rules.Visible("/logs")).IsFalse()
+			g.Assert(rules.Visible("/plugins/secret.yml")).IsFalse()
+		})
+	})
+}
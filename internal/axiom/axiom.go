@@ -14,6 +14,7 @@ import (
 	"github.com/Minenetpro/pelican-wings/config"
 	"github.com/Minenetpro/pelican-wings/environment"
 	"github.com/Minenetpro/pelican-wings/events"
+	"github.com/Minenetpro/pelican-wings/internal/query"
 	"github.com/Minenetpro/pelican-wings/server"
 	"github.com/Minenetpro/pelican-wings/system"
 )
@@ -33,6 +34,11 @@ type AxiomEvent struct {
 	Uptime           int64   `json:"uptime,omitempty"`
 	DiskBytes        int64   `json:"disk_bytes,omitempty"`
 	State            string  `json:"state,omitempty"`
+	Players          *int    `json:"players,omitempty"`
+	MaxPlayers       int     `json:"max_players,omitempty"`
+	Motd             string  `json:"motd,omitempty"`
+	Map              string  `json:"map,omitempty"`
+	Version          string  `json:"version,omitempty"`
 }
 
 // Ingestor subscribes to server events and console output, batches them, and
@@ -164,9 +170,10 @@ type statsEventData struct {
 // We embed the real environment.Stats to stay in sync with upstream changes,
 // and only define the additional fields from ResourceUsage.
 type statsEventPayload struct {
-	environment.Stats        // Embeds: Memory, MemoryLimit, CpuAbsolute, Network, Uptime
-	State             *string `json:"state,omitempty"` // AtomicString marshals as plain string
-	Disk              int64   `json:"disk_bytes"`
+	environment.Stats               // Embeds: Memory, MemoryLimit, CpuAbsolute, Network, Uptime
+	State             *string       `json:"state,omitempty"` // AtomicString marshals as plain string
+	Disk              int64         `json:"disk_bytes"`
+	Query             *query.Result `json:"query,omitempty"`
 }
 
 // statusEventData mirrors the structure published by server.Events().Publish(StatusEvent, ...).
@@ -209,6 +216,15 @@ func (ing *Ingestor) processEvent(serverID string, data []byte) {
 		if stats.Data.State != nil {
 			ev.State = *stats.Data.State
 		}
+		// Players is a pointer so that a server with no players online is still
+		// distinguishable from one that could not be queried.
+		if q := stats.Data.Query; q != nil {
+			ev.Players = &q.Players
+			ev.MaxPlayers = q.MaxPlayers
+			ev.Motd = q.Name
+			ev.Map = q.Map
+			ev.Version = q.Version
+		}
 		ing.enqueue(ev)
 
 	case server.StatusEvent:
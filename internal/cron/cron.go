@@ -90,5 +90,14 @@ func Scheduler(ctx context.Context, m *server.Manager) (gocron.Scheduler, error)
 		return nil, errors.Wrap(err, "cron: failed to create sftp job")
 	}
 
+	// Server schedule jobs
+	sc := &scheduleCron{scheduler: s}
+	for _, srv := range m.All() {
+		if err := sc.sync(ctx, srv); err != nil {
+			l.WithField("cron", "schedule").WithField("server", srv.ID()).WithField("error", err).Error("failed to configure server schedules")
+		}
+	}
+	schedules.Store(sc)
+
 	return s, nil
 }
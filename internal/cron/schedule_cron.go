@@ -0,0 +1,82 @@
+package cron
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/go-co-op/gocron/v2"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/server"
+)
+
+// scheduleCron registers the schedules saved for each server as jobs with the
+// scheduler, so that they run at the times given by their cron expressions in
+// the timezone of the server.
+type scheduleCron struct {
+	mu        sync.Mutex
+	scheduler gocron.Scheduler
+}
+
+var schedules atomic.Pointer[scheduleCron]
+
+// SyncSchedules registers the enabled schedules saved for the server with the
+// scheduler, replacing any that were registered for it before. This does nothing
+// if the scheduler has not been configured.
+func SyncSchedules(ctx context.Context, s *server.Server) error {
+	if sc := schedules.Load(); sc != nil {
+		return sc.sync(ctx, s)
+	}
+	return nil
+}
+
+// RemoveSchedules removes every schedule registered with the scheduler for the
+// server.
+func RemoveSchedules(s *server.Server) {
+	if sc := schedules.Load(); sc != nil {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		sc.scheduler.RemoveByTags(s.ID())
+	}
+}
+
+func (sc *scheduleCron) sync(ctx context.Context, s *server.Server) error {
+	list, err := s.Schedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.scheduler.RemoveByTags(s.ID())
+
+	tz := server.DetermineServerTimezone(s.Config().EnvVars, config.Get().System.Timezone)
+	l := log.WithField("subsystem", "cron").WithField("cron", "schedule").WithField("server", s.ID())
+	for _, schedule := range list {
+		if !schedule.Enabled {
+			continue
+		}
+		_, err := sc.scheduler.NewJob(
+			gocron.CronJob("CRON_TZ="+tz+" "+schedule.Cron, false),
+			gocron.NewTask(func() {
+				l.WithField("schedule", schedule.ID).Debug("running server schedule")
+				if err := s.RunSchedule(s.Context(), schedule); err != nil {
+					if errors.Is(err, server.ErrScheduleRunning) {
+						l.WithField("schedule", schedule.ID).Warn("server schedule is already running, skipping...")
+					} else {
+						l.WithField("schedule", schedule.ID).WithField("error", err).Error("server schedule failed to execute")
+					}
+				}
+			}),
+			gocron.WithName(schedule.Name),
+			gocron.WithTags(s.ID()),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "cron: failed to create job for schedule %d", schedule.ID)
+		}
+	}
+	return nil
+}
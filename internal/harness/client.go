@@ -0,0 +1,144 @@
+package harness
+
+import (
+	"context"
+	"sync"
+
+	"emperror.dev/errors"
+
+	"github.com/Minenetpro/pelican-wings/internal/models"
+	"github.com/Minenetpro/pelican-wings/remote"
+)
+
+// Ensure that the stubbed client is always implementing all the methods of
+// the Panel client.
+var _ remote.Client = (*Client)(nil)
+
+// Client is a stubbed Panel client. It returns the configuration of servers
+// added to the harness, and records the state changes, crash reports and
+// scheduled backups that are sent to it.
+// Every other request succeeds without doing anything.
+type Client struct {
+	mu      sync.RWMutex
+	servers map[string]remote.ServerConfigurationResponse
+	states  map[string][]remote.ServerStateChange
+	crashes map[string][]models.CrashReport
+	backups map[string][]remote.ScheduledBackupRequest
+}
+
+// NewClient returns a new stubbed Panel client without any servers.
+func NewClient() *Client {
+	return &Client{
+		servers: make(map[string]remote.ServerConfigurationResponse),
+		states:  make(map[string][]remote.ServerStateChange),
+		crashes: make(map[string][]models.CrashReport),
+		backups: make(map[string][]remote.ScheduledBackupRequest),
+	}
+}
+
+// SetServer sets the configuration returned by the client for a server.
+func (c *Client) SetServer(uuid string, data remote.ServerConfigurationResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.servers[uuid] = data
+}
+
+// StateChanges returns the state changes that have been pushed for a server.
+func (c *Client) StateChanges(uuid string) []remote.ServerStateChange {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]remote.ServerStateChange(nil), c.states[uuid]...)
+}
+
+func (c *Client) GetBackupRemoteUploadURLs(_ context.Context, _ string, _ int64) (remote.BackupRemoteUploadResponse, error) {
+	return remote.BackupRemoteUploadResponse{}, nil
+}
+
+func (c *Client) GetInstallationScript(_ context.Context, _ string) (remote.InstallationScript, error) {
+	return remote.InstallationScript{}, nil
+}
+
+func (c *Client) GetServerConfiguration(_ context.Context, uuid string) (remote.ServerConfigurationResponse, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	data, ok := c.servers[uuid]
+	if !ok {
+		return remote.ServerConfigurationResponse{}, errors.New("harness: server does not exist")
+	}
+	return data, nil
+}
+
+func (c *Client) GetServers(_ context.Context, _ int) ([]remote.RawServerData, error) {
+	return nil, nil
+}
+
+func (c *Client) ResetServersState(_ context.Context) error {
+	return nil
+}
+
+func (c *Client) SetArchiveStatus(_ context.Context, _ string, _ bool) error {
+	return nil
+}
+
+func (c *Client) SetBackupStatus(_ context.Context, _ string, _ remote.BackupRequest) error {
+	return nil
+}
+
+// RegisterScheduledBackup records the backup, which can be read using
+// ScheduledBackups.
+func (c *Client) RegisterScheduledBackup(_ context.Context, uuid string, data remote.ScheduledBackupRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backups[uuid] = append(c.backups[uuid], data)
+	return nil
+}
+
+// ScheduledBackups returns the backups that schedules have registered for a
+// server.
+func (c *Client) ScheduledBackups(uuid string) []remote.ScheduledBackupRequest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]remote.ScheduledBackupRequest(nil), c.backups[uuid]...)
+}
+
+func (c *Client) SendRestorationStatus(_ context.Context, _ string, _ bool) error {
+	return nil
+}
+
+func (c *Client) SetInstallationStatus(_ context.Context, _ string, _ remote.InstallStatusRequest) error {
+	return nil
+}
+
+func (c *Client) SetTransferStatus(_ context.Context, _ string, _ bool) error {
+	return nil
+}
+
+func (c *Client) ValidateSftpCredentials(_ context.Context, _ remote.SftpAuthRequest) (remote.SftpAuthResponse, error) {
+	return remote.SftpAuthResponse{}, &remote.SftpInvalidCredentialsError{}
+}
+
+func (c *Client) SendActivityLogs(_ context.Context, _ []models.Activity) error {
+	return nil
+}
+
+func (c *Client) PushServerStateChange(_ context.Context, sid string, sc remote.ServerStateChange) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.states[sid] = append(c.states[sid], sc)
+	return nil
+}
+
+// SendCrashReport records the crash report, which can be read using CrashReports.
+func (c *Client) SendCrashReport(_ context.Context, uuid string, report models.CrashReport) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.crashes[uuid] = append(c.crashes[uuid], report)
+	return nil
+}
+
+// CrashReports returns the crash reports that have been sent for a server.
+func (c *Client) CrashReports(uuid string) []models.CrashReport {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]models.CrashReport(nil), c.crashes[uuid]...)
+}
@@ -0,0 +1,228 @@
+// Package harness boots a server manager, the HTTP router and a stubbed Panel
+// client so that the full lifecycle of a server can be tested without Docker.
+// Servers added to the harness run in fake environments, which are scripted by
+// the test to produce console output, resource usage and process exits.
+package harness
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/environment/fake"
+	"github.com/Minenetpro/pelican-wings/internal/acl"
+	"github.com/Minenetpro/pelican-wings/internal/database"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/router"
+	"github.com/Minenetpro/pelican-wings/router/tokens"
+	"github.com/Minenetpro/pelican-wings/server"
+)
+
+// Token is the authentication token of the node, which is sent with every
+// request made using the harness.
+const Token = "harness"
+
+// The database can only be initialized once per process, so every harness in a
+// test binary shares the database of the first one.
+var (
+	databaseOnce sync.Once
+	databaseErr  error
+)
+
+type Harness struct {
+	Manager *server.Manager
+	Router  *gin.Engine
+	Client  *Client
+
+	mu   sync.Mutex
+	envs map[string]*fake.Environment
+}
+
+// New configures Wings to store everything in the given directory and returns a
+// harness with an empty server manager. The configuration is global, so only one
+// harness should be used at a time.
+func New(dir string) (*Harness, error) {
+	cfg, err := config.NewAtPath(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		return nil, err
+	}
+	cfg.AuthenticationToken = Token
+	cfg.System.RootDirectory = dir
+	cfg.System.LogDirectory = filepath.Join(dir, "logs")
+	cfg.System.Data = filepath.Join(dir, "volumes")
+	cfg.System.ArchiveDirectory = filepath.Join(dir, "archives")
+	cfg.System.BackupDirectory = filepath.Join(dir, "backups")
+	cfg.System.TmpDirectory = filepath.Join(dir, "tmp")
+	cfg.System.User.Uid = os.Geteuid()
+	cfg.System.User.Gid = os.Getegid()
+	cfg.System.MachineID.Enable = false
+	cfg.System.FileWatcher.Enabled = false
+	// Restart crashed servers immediately, rather than waiting for the backoff.
+	cfg.System.CrashDetection.Backoff = 0
+	config.Set(cfg)
+	// Wings creates the backup directory when it boots, and a backup is written
+	// to a directory of the server beneath it.
+	if err := os.MkdirAll(cfg.System.BackupDirectory, 0o700); err != nil {
+		return nil, err
+	}
+
+	databaseOnce.Do(func() {
+		databaseErr = database.Initialize()
+	})
+	if databaseErr != nil {
+		return nil, databaseErr
+	}
+
+	h := &Harness{
+		Client: NewClient(),
+		envs:   make(map[string]*fake.Environment),
+	}
+	h.Manager = server.NewEmptyManager(h.Client)
+	h.Manager.SetEnvironmentFactory(func(s *server.Server, c *environment.Configuration) (environment.ProcessEnvironment, error) {
+		e := fake.New(s.ID(), c)
+		e.SetStopConfiguration(s.ProcessConfiguration().Stop)
+		h.mu.Lock()
+		h.envs[s.ID()] = e
+		h.mu.Unlock()
+		return e, nil
+	})
+	h.Router = router.Configure(h.Manager, h.Client)
+	return h, nil
+}
+
+// Close stops the background tasks of every server in the harness.
+func (h *Harness) Close() {
+	for _, s := range h.Manager.All() {
+		s.CtxCancel()
+	}
+}
+
+// AddServer creates a server with the given UUID and process configuration, the
+// JSON the Panel returns for it, and adds it to the manager. Crash detection is
+// enabled for the server, and it is allocated port 25565.
+func (h *Harness) AddServer(uuid string, process string) (*server.Server, *fake.Environment, error) {
+	settings, err := json.Marshal(map[string]interface{}{
+		"uuid":                    uuid,
+		"crash_detection_enabled": true,
+		"invocation":              "./start.sh",
+		"allocations": map[string]interface{}{
+			"default": map[string]interface{}{"ip": "127.0.0.1", "port": 25565},
+		},
+	})
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	var p remote.ProcessConfiguration
+	if err := json.Unmarshal([]byte(process), &p); err != nil {
+		return nil, nil, errors.Wrap(err, "harness: failed to parse process configuration")
+	}
+
+	data := remote.ServerConfigurationResponse{Settings: settings, ProcessConfiguration: &p}
+	h.Client.SetServer(uuid, data)
+	s, err := h.Manager.InitServer(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	h.Manager.Add(s)
+	return s, h.Environment(uuid), nil
+}
+
+// StartedServer adds a server to the harness in the same way as AddServer, with
+// an environment that outputs a done line each time it is started, so that the
+// server is marked as running once it is started.
+func (h *Harness) StartedServer(uuid string, process string) (*server.Server, *fake.Environment, error) {
+	s, e, err := h.AddServer(uuid, process)
+	if err != nil {
+		return nil, nil, err
+	}
+	e.OnStart(func() {
+		e.Output("Done (1.0s)!")
+	})
+	return s, e, nil
+}
+
+// Environment returns the fake environment of a server in the harness.
+func (h *Harness) Environment(uuid string) *fake.Environment {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.envs[uuid]
+}
+
+// Request makes an authenticated request to the router, encoding the body as
+// JSON if one is given.
+func (h *Harness) Request(method string, path string, body interface{}) *httptest.ResponseRecorder {
+	return h.request(method, path, body, nil)
+}
+
+// Power sends a power action for a server to the router, returning the status
+// code of the response. The action is performed in the background.
+func (h *Harness) Power(uuid string, action string) int {
+	return h.Request(http.MethodPost, Path(uuid, "power"), map[string]interface{}{"action": action, "wait_seconds": 5}).Code
+}
+
+// RequestWithRules makes a request to the router on behalf of a user whose access
+// to the server is limited by the path rules.
+func (h *Harness) RequestWithRules(uuid string, rules acl.Rules, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	token, err := jwt.Sign(&tokens.PathRulesPayload{
+		Payload:    jwt.Payload{ExpirationTime: jwt.NumericDate(time.Now().Add(time.Minute))},
+		ServerUuid: uuid,
+		PathRules:  rules,
+	}, config.GetJwtAlgorithm())
+	if err != nil {
+		panic(err)
+	}
+	return h.request(method, path, body, map[string]string{"X-Path-Rules": string(token)})
+}
+
+func (h *Harness) request(method string, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	var b []byte
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(b))
+	r.Header.Set("Authorization", "Bearer "+Token)
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, r)
+	return w
+}
+
+// Eventually checks the condition until it is true, returning false if it is
+// still not true after five seconds.
+func Eventually(condition func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Path returns a request path for a server, such as "power" or "commands".
+func Path(uuid string, p string) string {
+	return "/api/servers/" + uuid + "/" + p
+}
+
+// WaitForState waits for the environment of a server to be in the given state,
+// returning false if it does not reach it within five seconds.
+func WaitForState(s *server.Server, state string) bool {
+	return Eventually(func() bool {
+		return s.Environment.State() == state
+	})
+}
@@ -0,0 +1,966 @@
+package harness
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+
+	. "github.com/franela/goblin"
+	"github.com/goccy/go-json"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/environment/fake"
+	"github.com/Minenetpro/pelican-wings/events"
+	"github.com/Minenetpro/pelican-wings/internal/acl"
+	"github.com/Minenetpro/pelican-wings/internal/database"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+	"github.com/Minenetpro/pelican-wings/internal/progress"
+	"github.com/Minenetpro/pelican-wings/internal/query"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/router/jobs"
+	"github.com/Minenetpro/pelican-wings/server"
+	"github.com/Minenetpro/pelican-wings/server/backup"
+	"github.com/Minenetpro/pelican-wings/system"
+)
+
+const (
+	uuid    = "0b5c2c2e-7f3a-4d9b-8e1f-6a2d4c8b9e10"
+	process = `{"startup":{"done":["Done (","regex:^Listening on \\d+$"]},"stop":{"type":"command","value":"stop"}}`
+)
+
+// setup returns a harness with a single server, which is marked as running once
+// it is started.
+func setup(t *testing.T, g *G, process string) (*Harness, *server.Server, *fake.Environment) {
+	h, err := New(t.TempDir())
+	if err != nil {
+		g.Fail(err)
+	}
+	s, e, err := h.StartedServer(uuid, process)
+	if err != nil {
+		g.Fail(err)
+	}
+	return h, s, e
+}
+
+func TestHarness(t *testing.T) {
+	g := Goblin(t, "-goblin.timeout=15s")
+
+	var h *Harness
+	var s *server.Server
+	var e *fake.Environment
+
+	g.Describe("Server lifecycle", func() {
+		g.BeforeEach(func() {
+			h, s, e = setup(t, g, process)
+		})
+
+		g.AfterEach(func() {
+			h.Close()
+		})
+
+		g.It("marks the server as running once a done line is output", func() {
+			e.OnStart(func() {
+				e.Output("Loading world", "Done (3.2s)! For help, type \"help\"")
+			})
+
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+			g.Assert(Eventually(func() bool { return s.IsRunning() })).IsTrue()
+			g.Assert(e.Starts()).Equal(1)
+
+			g.Assert(Eventually(func() bool {
+				for _, sc := range h.Client.StateChanges(uuid) {
+					if sc.NewState == environment.ProcessRunningState {
+						return true
+					}
+				}
+				return false
+			})).IsTrue()
+		})
+
+		g.It("matches done lines using a regex", func() {
+			e.OnStart(func() {
+				e.Output("Listening on port 25565", "Listening on 25565")
+			})
+
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+		})
+
+		g.It("sends console output to listeners", func() {
+			c := make(chan []byte, 8)
+			s.Sink(system.LogSink).On(c)
+			defer s.Sink(system.LogSink).Off(c)
+
+			e.OnStart(func() {
+				e.Output("hello world")
+			})
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+
+			g.Assert(Eventually(func() bool {
+				for {
+					select {
+					case v := <-c:
+						if string(v) == "hello world" {
+							return true
+						}
+					default:
+						return false
+					}
+				}
+			})).IsTrue()
+		})
+
+		g.It("restarts the server after it crashes", func() {
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+
+			e.Exit(1)
+			g.Assert(Eventually(func() bool { return e.Starts() == 2 })).IsTrue()
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+		})
+
+		g.It("restarts the server after it runs out of memory", func() {
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+
+			e.OOM()
+			g.Assert(Eventually(func() bool { return e.Starts() == 2 })).IsTrue()
+		})
+
+		g.It("does not restart the server after a clean exit", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection.DetectCleanExitAsCrash = false
+			})
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+
+			e.Exit(0)
+			g.Assert(WaitForState(s, environment.ProcessOfflineState)).IsTrue()
+			time.Sleep(200 * time.Millisecond)
+			g.Assert(e.Starts()).Equal(1)
+			g.Assert(s.Environment.State()).Equal(environment.ProcessOfflineState)
+		})
+
+		g.It("stops the server using the stop command", func() {
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+
+			g.Assert(h.Power(uuid, "stop")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessOfflineState)).IsTrue()
+			g.Assert(e.Commands()).Equal([]string{"stop"})
+			g.Assert(e.Starts()).Equal(1)
+		})
+
+		g.It("sends commands to the running server", func() {
+			g.Assert(h.Request(http.MethodPost, Path(uuid, "commands"), map[string][]string{"commands": {"say hi"}}).Code).Equal(http.StatusBadGateway)
+
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(Eventually(e.IsAttached)).IsTrue()
+
+			g.Assert(h.Request(http.MethodPost, Path(uuid, "commands"), map[string][]string{"commands": {"say hi"}}).Code).Equal(http.StatusNoContent)
+			g.Assert(e.Commands()).Equal([]string{"say hi"})
+		})
+
+		g.It("reports resource usage for the server", func() {
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+
+			e.PublishStats(environment.Stats{Memory: 1024, MemoryLimit: 4096, CpuAbsolute: 12.5})
+			g.Assert(Eventually(func() bool {
+				p := s.Proc()
+				return p.Memory == 1024 && p.CpuAbsolute == 12.5
+			})).IsTrue()
+		})
+	})
+
+}
+
+func TestStopPolicy(t *testing.T) {
+	g := Goblin(t, "-goblin.timeout=15s")
+
+	var h *Harness
+	var s *server.Server
+	var e *fake.Environment
+
+	g.Describe("Stop policy", func() {
+		g.BeforeEach(func() {
+			h, s, e = setup(t, g, `{"startup":{"done":["Done ("]},"stop":{"type":"command","value":"stop","steps":[`+
+				`{"type":"command","value":"save-all","timeout":1},`+
+				`{"type":"command","value":"stop","timeout":1},`+
+				`{"type":"signal","value":"SIGTERM","timeout":1}]}}`)
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+		})
+
+		g.AfterEach(func() {
+			h.Close()
+		})
+
+		g.It("stops once the process exits", func() {
+			e.OnCommand("save-all", func() {})
+
+			g.Assert(h.Power(uuid, "stop")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessOfflineState)).IsTrue()
+			g.Assert(e.Commands()).Equal([]string{"save-all", "stop"})
+			code, _, _ := e.ExitState()
+			g.Assert(code).Equal(uint32(0))
+			g.Assert(e.Starts()).Equal(1)
+		})
+
+		g.It("escalates to a signal", func() {
+			e.OnCommand("save-all", func() {})
+			e.OnCommand("stop", func() {})
+
+			g.Assert(s.WaitForStop(context.Background(), time.Minute, true)).IsNil()
+			g.Assert(e.Commands()).Equal([]string{"save-all", "stop"})
+			code, _, _ := e.ExitState()
+			g.Assert(code).Equal(uint32(143))
+		})
+
+		g.It("kills a process that does not stop", func() {
+			e.OnCommand("save-all", func() {})
+			e.OnCommand("stop", func() {})
+			e.OnSignal("SIGTERM", func() {})
+
+			c := make(chan []byte, 64)
+			s.Events().On(c)
+			defer s.Events().Off(c)
+
+			g.Assert(s.WaitForStop(context.Background(), time.Minute, true)).IsNil()
+			g.Assert(s.Environment.State()).Equal(environment.ProcessOfflineState)
+			code, _, _ := e.ExitState()
+			g.Assert(code).Equal(uint32(137))
+
+			var lines []string
+			for len(c) > 0 {
+				var ev events.Event
+				if err := events.DecodeTo(<-c, &ev); err == nil && ev.Topic == server.ConsoleOutputEvent {
+					lines = append(lines, ev.Data.(string))
+				}
+			}
+			g.Assert(len(lines)).Equal(4)
+			g.Assert(strings.Contains(lines[0], `Stopping server (step 1 of 3): sending command "save-all"`)).IsTrue()
+			g.Assert(strings.Contains(lines[2], "Stopping server (step 3 of 3): sending SIGTERM to process")).IsTrue()
+			g.Assert(strings.Contains(lines[3], "killing process")).IsTrue()
+		})
+
+		g.It("leaves the process running if it should not be terminated", func() {
+			e.OnCommand("save-all", func() {})
+			e.OnCommand("stop", func() {})
+			e.OnSignal("SIGTERM", func() {})
+
+			err := s.WaitForStop(context.Background(), 1500*time.Millisecond, false)
+			g.Assert(errors.Is(err, context.DeadlineExceeded)).IsTrue()
+			g.Assert(e.Commands()).Equal([]string{"save-all", "stop"})
+			ok, _ := e.IsRunning(context.Background())
+			g.Assert(ok).IsTrue()
+		})
+	})
+}
+
+func TestCrashHandling(t *testing.T) {
+	g := Goblin(t, "-goblin.timeout=15s")
+
+	var h *Harness
+	var s *server.Server
+	var e *fake.Environment
+
+	crashes := func() []models.CrashReport {
+		var r struct {
+			Data []models.CrashReport `json:"data"`
+		}
+		w := h.Request(http.MethodGet, Path(uuid, "crashes"), nil)
+		if w.Code != http.StatusOK {
+			return nil
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+			return nil
+		}
+		return r.Data
+	}
+
+	g.Describe("Crash handling", func() {
+		g.BeforeEach(func() {
+			h, s, e = setup(t, g, process)
+			if err := s.DeleteCrashReports(context.Background()); err != nil {
+				g.Fail(err)
+			}
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+		})
+
+		g.AfterEach(func() {
+			h.Close()
+		})
+
+		g.It("saves a crash report", func() {
+			e.PublishStats(environment.Stats{Memory: 2048, MemoryLimit: 4096, CpuAbsolute: 50})
+			g.Assert(Eventually(func() bool { return s.Proc().Memory == 2048 })).IsTrue()
+
+			e.Output("Exception in server tick loop")
+			e.Exit(1)
+			g.Assert(Eventually(func() bool { return len(crashes()) == 1 })).IsTrue()
+
+			r := crashes()[0]
+			g.Assert(r.Server).Equal(uuid)
+			g.Assert(r.ExitCode).Equal(uint32(1))
+			g.Assert(r.OomKilled).IsFalse()
+			g.Assert(r.Action).Equal(server.CrashActionRestarted)
+			g.Assert(r.Stats.Memory).Equal(uint64(2048))
+			g.Assert(r.Logs[len(r.Logs)-1]).Equal("Exception in server tick loop")
+			g.Assert(Eventually(func() bool { return e.Starts() == 2 })).IsTrue()
+			g.Assert(len(h.Client.CrashReports(uuid))).Equal(0)
+		})
+
+		g.It("stops restarting a crash-looping server", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection.MaxRestarts = 2
+			})
+			defer config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection.MaxRestarts = 0
+			})
+
+			c := make(chan []byte, 64)
+			s.Events().On(c)
+			defer s.Events().Off(c)
+
+			for i := 2; i <= 3; i++ {
+				e.Exit(1)
+				g.Assert(Eventually(func() bool { return e.Starts() == i })).IsTrue()
+				g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+				// The server is marked as running before the restart releases the power
+				// lock, and a crash is not handled while it is held.
+				g.Assert(Eventually(func() bool { return !s.ExecutingPowerAction() })).IsTrue()
+			}
+			e.Exit(1)
+			g.Assert(Eventually(func() bool { return s.ToAPIResponse().CrashLooping })).IsTrue()
+			g.Assert(Eventually(func() bool { return len(crashes()) == 3 })).IsTrue()
+			g.Assert(crashes()[0].Action).Equal(server.CrashActionCrashLooping)
+			time.Sleep(200 * time.Millisecond)
+			g.Assert(e.Starts()).Equal(3)
+
+			// Starting the server again clears the crash-looping state.
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+			g.Assert(s.ToAPIResponse().CrashLooping).IsFalse()
+
+			var looping []interface{}
+			for len(c) > 0 {
+				var ev events.Event
+				if err := events.DecodeTo(<-c, &ev); err == nil && ev.Topic == server.CrashLoopingEvent {
+					looping = append(looping, ev.Data)
+				}
+			}
+			g.Assert(looping).Equal([]interface{}{true, false})
+		})
+
+		g.It("cancels a pending restart when a power action is sent", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection.MaxRestarts = 2
+				c.System.CrashDetection.Backoff = 1
+			})
+			defer config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection.MaxRestarts = 0
+				c.System.CrashDetection.Backoff = 0
+			})
+
+			e.Exit(1)
+			g.Assert(Eventually(func() bool { return len(crashes()) == 1 })).IsTrue()
+			g.Assert(h.Power(uuid, "stop")).Equal(http.StatusAccepted)
+			time.Sleep(1500 * time.Millisecond)
+			g.Assert(e.Starts()).Equal(1)
+			g.Assert(s.Environment.State()).Equal(environment.ProcessOfflineState)
+		})
+
+		g.It("sends crash reports to the Panel", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection.SendReports = true
+			})
+			defer config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection.SendReports = false
+			})
+
+			e.OOM()
+			g.Assert(Eventually(func() bool { return len(h.Client.CrashReports(uuid)) == 1 })).IsTrue()
+			r := h.Client.CrashReports(uuid)[0]
+			g.Assert(r.OomKilled).IsTrue()
+			g.Assert(r.ExitCode).Equal(uint32(137))
+		})
+	})
+}
+
+func TestHealthChecks(t *testing.T) {
+	g := Goblin(t, "-goblin.timeout=15s")
+
+	var h *Harness
+	var s *server.Server
+	var e *fake.Environment
+
+	g.Describe("Health checks", func() {
+		g.BeforeEach(func() {
+			h, s, e = setup(t, g, `{"startup":{"done":["Done ("]},"stop":{"type":"command","value":"stop"},`+
+				`"health":{"type":"command","command":"list","expect":"regex:^There are \\d+ players","interval":1,"timeout":1,"retries":2,"restart_after":3}}`)
+		})
+
+		g.AfterEach(func() {
+			h.Close()
+		})
+
+		g.It("marks a responding server as healthy", func() {
+			e.OnCommand("list", func() {
+				e.Output("There are 0 players online")
+			})
+			g.Assert(s.Health()).Equal(server.HealthNone)
+
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+			g.Assert(Eventually(func() bool { return s.Health() == server.HealthStarting })).IsTrue()
+			g.Assert(Eventually(func() bool { return s.Health() == server.HealthHealthy })).IsTrue()
+
+			var r struct {
+				Utilization struct {
+					Health string `json:"health"`
+				} `json:"utilization"`
+			}
+			g.Assert(json.Unmarshal(h.Request(http.MethodGet, "/api/servers/"+uuid, nil).Body.Bytes(), &r)).IsNil()
+			g.Assert(r.Utilization.Health).Equal(server.HealthHealthy)
+
+			g.Assert(h.Power(uuid, "stop")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessOfflineState)).IsTrue()
+			g.Assert(Eventually(func() bool { return s.Health() == server.HealthNone })).IsTrue()
+		})
+
+		g.It("restarts a server that stops responding", func() {
+			e.OnCommand("list", func() {})
+
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+			g.Assert(Eventually(func() bool { return s.Health() == server.HealthUnhealthy })).IsTrue()
+			g.Assert(Eventually(func() bool { return e.Starts() == 2 })).IsTrue()
+			g.Assert(e.Commands()[:3]).Equal([]string{"list", "list", "list"})
+			g.Assert(e.Commands()[3]).Equal("stop")
+		})
+	})
+}
+
+func TestQuery(t *testing.T) {
+	g := Goblin(t, "-goblin.timeout=15s")
+
+	var h *Harness
+	var s *server.Server
+
+	// A stand-in for a game server that responds to A2S_INFO queries.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		info := append([]byte{0xff, 0xff, 0xff, 0xff, 'I', 17}, "My Server\x00de_dust2\x00csgo\x00Counter-Strike\x00\xda\x02\x05\x18\x00dl\x00\x011.38.7.9\x00"...)
+		b := make([]byte, 1400)
+		for {
+			n, addr, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			if n > 4 && b[4] == 'T' {
+				_, _ = conn.WriteTo(info, addr)
+			}
+		}
+	}()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	g.Describe("Query", func() {
+		g.BeforeEach(func() {
+			h, s, _ = setup(t, g, `{"startup":{"done":["Done ("]},"stop":{"type":"command","value":"stop"},"query":{"protocol":"a2s","port":`+strconv.Itoa(port)+`}}`)
+		})
+
+		g.AfterEach(func() {
+			h.Close()
+		})
+
+		g.It("queries a running server for its status", func() {
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+			g.Assert(Eventually(func() bool { return s.QueryResult() != nil })).IsTrue()
+			g.Assert(s.QueryResult().Players).Equal(5)
+			g.Assert(s.Proc().Query.MaxPlayers).Equal(24)
+
+			var r struct {
+				Data query.Result `json:"data"`
+			}
+			w := h.Request(http.MethodGet, Path(uuid, "query?refresh=true"), nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(json.Unmarshal(w.Body.Bytes(), &r)).IsNil()
+			g.Assert(r.Data.Name).Equal("My Server")
+			g.Assert(r.Data.Map).Equal("de_dust2")
+
+			g.Assert(h.Power(uuid, "stop")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessOfflineState)).IsTrue()
+			g.Assert(Eventually(func() bool { return s.QueryResult() == nil })).IsTrue()
+		})
+
+		g.It("does not query a server without a query protocol", func() {
+			s, _, err := h.AddServer("8d1d3f5e-2b7c-4a0e-9f6d-3c5b7a9e1f20", process)
+			g.Assert(err).IsNil()
+			g.Assert(s.CanQuery()).IsFalse()
+			g.Assert(h.Request(http.MethodGet, Path(s.ID(), "query"), nil).Code).Equal(http.StatusBadRequest)
+		})
+	})
+}
+
+func TestSchedules(t *testing.T) {
+	g := Goblin(t, "-goblin.timeout=15s")
+
+	var h *Harness
+	var s *server.Server
+	var e *fake.Environment
+
+	put := func(schedules ...models.Schedule) int {
+		return h.Request(http.MethodPut, Path(uuid, "schedules"), map[string]interface{}{"schedules": schedules}).Code
+	}
+
+	activity := func(event models.Event) []models.Activity {
+		var a []models.Activity
+		database.Instance().Where("server = ? AND event = ?", uuid, event).Find(&a)
+		return a
+	}
+
+	g.Describe("Schedules", func() {
+		g.BeforeEach(func() {
+			h, s, e = setup(t, g, process)
+			if err := s.DeleteSchedules(context.Background()); err != nil {
+				g.Fail(err)
+			}
+			database.Instance().Where("server = ?", uuid).Delete(&models.Activity{})
+		})
+
+		g.AfterEach(func() {
+			h.Close()
+		})
+
+		g.It("saves the schedules pushed by the Panel", func() {
+			g.Assert(put(models.Schedule{ID: 1, Name: "Restart", Cron: "0 4 * * *", Enabled: true, Tasks: []models.ScheduleTask{
+				{Action: server.ScheduleActionPower, Payload: "restart"},
+			}})).Equal(http.StatusNoContent)
+
+			var r struct {
+				Data []models.Schedule `json:"data"`
+			}
+			w := h.Request(http.MethodGet, Path(uuid, "schedules"), nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(json.Unmarshal(w.Body.Bytes(), &r)).IsNil()
+			g.Assert(len(r.Data)).Equal(1)
+			g.Assert(r.Data[0].Server).Equal(uuid)
+			g.Assert(r.Data[0].Tasks[0].Payload).Equal("restart")
+
+			g.Assert(h.Request(http.MethodDelete, Path(uuid, "schedules/1"), nil).Code).Equal(http.StatusNoContent)
+			g.Assert(h.Request(http.MethodDelete, Path(uuid, "schedules/1"), nil).Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("rejects invalid schedules", func() {
+			g.Assert(put(models.Schedule{ID: 1, Cron: "every day"})).Equal(http.StatusBadRequest)
+			g.Assert(put(models.Schedule{ID: 1, Cron: "CRON_TZ=UTC 0 4 * * *"})).Equal(http.StatusBadRequest)
+			g.Assert(put(models.Schedule{ID: 1, Cron: "0 4 * * *", Tasks: []models.ScheduleTask{{Action: "reinstall"}}})).Equal(http.StatusBadRequest)
+			g.Assert(put(models.Schedule{ID: 1, Cron: "0 4 * * *", Tasks: []models.ScheduleTask{{Action: server.ScheduleActionPower, Payload: "explode"}}})).Equal(http.StatusBadRequest)
+			g.Assert(put(models.Schedule{ID: 1, Cron: "0 4 * * *", Tasks: []models.ScheduleTask{{Action: server.ScheduleActionBackup, Payload: "tape"}}})).Equal(http.StatusBadRequest)
+			g.Assert(put(models.Schedule{ID: 1, Cron: "0 4 * * *"}, models.Schedule{ID: 1, Cron: "0 5 * * *"})).Equal(http.StatusBadRequest)
+		})
+
+		g.It("runs the tasks of a schedule in order", func() {
+			g.Assert(put(models.Schedule{ID: 1, Name: "Announce", Cron: "0 4 * * *", Enabled: true, Tasks: []models.ScheduleTask{
+				{Action: server.ScheduleActionCommand, Payload: "say saving"},
+				{Action: server.ScheduleActionDelay, Payload: "0"},
+				{Action: server.ScheduleActionCommand, Payload: "say offline", Condition: server.ScheduleConditionOffline},
+				{Action: server.ScheduleActionCommand, Payload: "save-all", Condition: server.ScheduleConditionOnline},
+			}})).Equal(http.StatusNoContent)
+
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+
+			g.Assert(h.Request(http.MethodPost, Path(uuid, "schedules/1/run"), nil).Code).Equal(http.StatusAccepted)
+			g.Assert(Eventually(func() bool { return len(activity(server.ActivityScheduleCompleted)) == 1 })).IsTrue()
+			g.Assert(e.Commands()).Equal([]string{"say saving", "save-all"})
+
+			sc, err := s.Schedule(context.Background(), 1)
+			g.Assert(err).IsNil()
+			g.Assert(sc.LastRunAt != nil).IsTrue()
+		})
+
+		g.It("stops at the first task that fails", func() {
+			g.Assert(put(models.Schedule{ID: 2, Name: "Backup", Cron: "0 4 * * *", Enabled: true, Tasks: []models.ScheduleTask{
+				{Action: server.ScheduleActionCommand, Payload: "save-off", ContinueOnFailure: true},
+				{Action: server.ScheduleActionCommand, Payload: "save-all"},
+				{Action: server.ScheduleActionPower, Payload: "start"},
+			}})).Equal(http.StatusNoContent)
+
+			g.Assert(h.Request(http.MethodPost, Path(uuid, "schedules/2/run"), nil).Code).Equal(http.StatusAccepted)
+			g.Assert(Eventually(func() bool { return len(activity(server.ActivityScheduleFailed)) == 1 })).IsTrue()
+			g.Assert(len(activity(server.ActivityScheduleFailed)[0].Metadata["failures"].([]interface{}))).Equal(2)
+			g.Assert(e.Starts()).Equal(0)
+		})
+
+		g.It("registers a backup with the Panel before creating it", func() {
+			g.Assert(put(models.Schedule{ID: 4, Name: "Nightly", Cron: "0 4 * * *", Enabled: true, Tasks: []models.ScheduleTask{
+				{Action: server.ScheduleActionBackup},
+			}})).Equal(http.StatusNoContent)
+
+			g.Assert(h.Request(http.MethodPost, Path(uuid, "schedules/4/run"), nil).Code).Equal(http.StatusAccepted)
+			g.Assert(Eventually(func() bool { return len(activity(server.ActivityScheduleCompleted)) == 1 })).IsTrue()
+
+			backups := h.Client.ScheduledBackups(uuid)
+			g.Assert(len(backups)).Equal(1)
+			g.Assert(backups[0].Adapter).Equal(string(backup.LocalBackupAdapter))
+			g.Assert(backups[0].Schedule).Equal(4)
+			files, err := filepath.Glob(filepath.Join(config.Get().System.BackupDirectory, uuid, backups[0].Uuid+".*"))
+			g.Assert(err).IsNil()
+			g.Assert(len(files)).Equal(1)
+		})
+
+		g.It("skips a schedule that only runs when the server is online", func() {
+			g.Assert(s.RunSchedule(context.Background(), models.Schedule{ID: 3, OnlyWhenOnline: true, Tasks: []models.ScheduleTask{
+				{Action: server.ScheduleActionPower, Payload: "start"},
+			}})).IsNil()
+			g.Assert(e.Starts()).Equal(0)
+			g.Assert(len(activity(server.ActivityScheduleCompleted))).Equal(0)
+		})
+	})
+}
+
+func TestIdle(t *testing.T) {
+	g := Goblin(t, "-goblin.timeout=15s")
+
+	var h *Harness
+	var s *server.Server
+	var e *fake.Environment
+	var port int
+
+	activity := func(event models.Event) []models.Activity {
+		var a []models.Activity
+		database.Instance().Where("server = ? AND event = ?", uuid, event).Find(&a)
+		return a
+	}
+
+	g.Describe("Idle servers", func() {
+		g.BeforeEach(func() {
+			var err error
+			if h, err = New(t.TempDir()); err != nil {
+				g.Fail(err)
+			}
+			config.Update(func(c *config.Configuration) {
+				c.System.Idle.CheckInterval = 1
+			})
+			if s, e, err = h.StartedServer(uuid, `{"startup":{"done":["Done ("]},"stop":{"type":"command","value":"stop"},"query":{"protocol":"slp"}}`); err != nil {
+				g.Fail(err)
+			}
+
+			// Use a free port for the server, so that it can be held while the server
+			// is stopped.
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				g.Fail(err)
+			}
+			port = l.Addr().(*net.TCPAddr).Port
+			_ = l.Close()
+			settings, _ := json.Marshal(map[string]interface{}{
+				"uuid":                    uuid,
+				"crash_detection_enabled": true,
+				"invocation":              "./start.sh",
+				"allocations": map[string]interface{}{
+					"default": map[string]interface{}{"ip": "127.0.0.1", "port": port},
+				},
+				"idle": map[string]interface{}{"timeout": 1, "wake": true, "message": "Waking up!"},
+			})
+			h.Client.SetServer(uuid, remote.ServerConfigurationResponse{Settings: settings, ProcessConfiguration: s.ProcessConfiguration()})
+			if err := s.Sync(); err != nil {
+				g.Fail(err)
+			}
+			database.Instance().Where("server = ?", uuid).Delete(&models.Activity{})
+		})
+
+		g.AfterEach(func() {
+			h.Close()
+		})
+
+		g.It("stops an idle server and starts it when somebody connects", func() {
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+			g.Assert(Eventually(func() bool { return s.IsSleeping() })).IsTrue()
+			g.Assert(s.Environment.State()).Equal(environment.ProcessOfflineState)
+			g.Assert(s.ToAPIResponse().Sleeping).IsTrue()
+			g.Assert(Eventually(func() bool { return len(activity(server.ActivityIdleStop)) == 1 })).IsTrue()
+
+			// Connections that are not from a Minecraft client do not wake the server,
+			// and the UDP port is not held since the game does not use it.
+			addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+			scan, err := net.Dial("tcp", addr)
+			g.Assert(err).IsNil()
+			_, err = scan.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+			g.Assert(err).IsNil()
+			_ = scan.(*net.TCPConn).CloseWrite()
+			_, _ = io.ReadAll(scan)
+			_ = scan.Close()
+			udp, err := net.ListenPacket("udp", addr)
+			g.Assert(err).IsNil()
+			_ = udp.Close()
+			time.Sleep(100 * time.Millisecond)
+			g.Assert(s.IsSleeping()).IsTrue()
+			g.Assert(e.Starts()).Equal(1)
+
+			// Try to log in to the server, as a Minecraft client would.
+			conn, err := net.Dial("tcp", addr)
+			g.Assert(err).IsNil()
+			defer conn.Close()
+			handshake := []byte{0x00, 0xff, 0x05, 0x09}
+			handshake = append(handshake, "127.0.0.1"...)
+			handshake = append(handshake, byte(port>>8), byte(port), 0x02)
+			_, err = conn.Write(append([]byte{byte(len(handshake))}, handshake...))
+			g.Assert(err).IsNil()
+			b, err := io.ReadAll(conn)
+			g.Assert(err).IsNil()
+			g.Assert(strings.Contains(string(b), `{"text":"Waking up!"}`)).IsTrue()
+
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+			g.Assert(e.Starts()).Equal(2)
+			g.Assert(s.IsSleeping()).IsFalse()
+			g.Assert(Eventually(func() bool { return len(activity(server.ActivityIdleWake)) == 1 })).IsTrue()
+			g.Assert(activity(server.ActivityIdleWake)[0].IP).Equal("127.0.0.1")
+		})
+
+		g.It("keeps a server with network traffic running", func() {
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+
+			for i := uint64(1); i <= 30; i++ {
+				e.PublishStats(environment.Stats{Network: environment.NetworkStats{RxBytes: i * 4096}})
+				time.Sleep(100 * time.Millisecond)
+			}
+			g.Assert(s.Environment.State()).Equal(environment.ProcessRunningState)
+			g.Assert(len(activity(server.ActivityIdleStop))).Equal(0)
+		})
+	})
+}
+
+func TestTraffic(t *testing.T) {
+	g := Goblin(t, "-goblin.timeout=15s")
+
+	var h *Harness
+	var s *server.Server
+	var e *fake.Environment
+
+	configure := func(traffic map[string]interface{}) {
+		settings, _ := json.Marshal(map[string]interface{}{
+			"uuid":       uuid,
+			"invocation": "./start.sh",
+			"allocations": map[string]interface{}{
+				"default": map[string]interface{}{"ip": "127.0.0.1", "port": 25565},
+			},
+			"build":   map[string]interface{}{"egress_limit": 2048},
+			"traffic": traffic,
+		})
+		h.Client.SetServer(uuid, remote.ServerConfigurationResponse{Settings: settings, ProcessConfiguration: s.ProcessConfiguration()})
+		if err := s.Sync(); err != nil {
+			g.Fail(err)
+		}
+	}
+
+	traffic := func(rx uint64, tx uint64) func() bool {
+		return func() bool {
+			t := s.Proc().Traffic
+			return t.RxBytes == rx && t.TxBytes == tx
+		}
+	}
+
+	g.Describe("Server traffic", func() {
+		g.BeforeEach(func() {
+			h, s, e = setup(t, g, process)
+			database.Instance().Where("server = ?", uuid).Delete(&models.Traffic{})
+			database.Instance().Where("server = ?", uuid).Delete(&models.Activity{})
+		})
+
+		g.AfterEach(func() {
+			h.Close()
+		})
+
+		g.It("counts traffic across restarts of the server", func() {
+			configure(nil)
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+
+			e.PublishStats(environment.Stats{Network: environment.NetworkStats{RxBytes: 1000, TxBytes: 500}})
+			g.Assert(Eventually(traffic(1000, 500))).IsTrue()
+			e.PublishStats(environment.Stats{Network: environment.NetworkStats{RxBytes: 3000, TxBytes: 1500}})
+			g.Assert(Eventually(traffic(3000, 1500))).IsTrue()
+			g.Assert(s.Proc().Traffic.Month).Equal(time.Now().Format("2006-01"))
+
+			g.Assert(h.Power(uuid, "stop")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessOfflineState)).IsTrue()
+			g.Assert(s.Proc().Network.RxBytes).Equal(uint64(0))
+			g.Assert(Eventually(func() bool {
+				var saved models.Traffic
+				database.Instance().Where("server = ?", uuid).First(&saved)
+				return saved.RxBytes == 3000 && saved.TxBytes == 1500
+			})).IsTrue()
+
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+			e.PublishStats(environment.Stats{Network: environment.NetworkStats{RxBytes: 200, TxBytes: 100}})
+			g.Assert(Eventually(traffic(3200, 1600))).IsTrue()
+		})
+
+		g.It("stops a server that has used its quota", func() {
+			configure(map[string]interface{}{"quota": 1, "action": "stop"})
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+
+			e.PublishStats(environment.Stats{Network: environment.NetworkStats{RxBytes: 1 << 20}})
+			g.Assert(WaitForState(s, environment.ProcessOfflineState)).IsTrue()
+			g.Assert(s.Proc().Traffic.Exceeded).IsTrue()
+			g.Assert(Eventually(func() bool {
+				var a []models.Activity
+				database.Instance().Where("server = ? AND event = ?", uuid, server.ActivityTrafficExceeded).Find(&a)
+				return len(a) == 1
+			})).IsTrue()
+
+			g.Assert(errors.Is(s.HandlePowerAction(server.PowerActionStart, 5), server.ErrTrafficQuota)).IsTrue()
+			g.Assert(e.Starts()).Equal(1)
+
+			// Raising the quota allows the server to be started again.
+			configure(map[string]interface{}{"quota": 2, "action": "stop"})
+			g.Assert(s.HandlePowerAction(server.PowerActionStart, 5)).IsNil()
+			g.Assert(e.Starts()).Equal(2)
+		})
+
+		g.It("throttles a server that has used its quota", func() {
+			configure(map[string]interface{}{"quota": 1, "action": "throttle", "throttle_rate": 512})
+			g.Assert(h.Power(uuid, "start")).Equal(http.StatusAccepted)
+			g.Assert(WaitForState(s, environment.ProcessRunningState)).IsTrue()
+			g.Assert(e.Config().Limits().IngressLimit).Equal(int64(0))
+			g.Assert(e.Config().Limits().EgressLimit).Equal(int64(2048))
+
+			e.PublishStats(environment.Stats{Network: environment.NetworkStats{TxBytes: 1 << 20}})
+			g.Assert(Eventually(func() bool {
+				l := e.Config().Limits()
+				return l.IngressLimit == 512 && l.EgressLimit == 512
+			})).IsTrue()
+			g.Assert(s.Environment.State()).Equal(environment.ProcessRunningState)
+		})
+	})
+}
+
+func TestFileJobs(t *testing.T) {
+	g := Goblin(t, "-goblin.timeout=15s")
+
+	var h *Harness
+	var s *server.Server
+
+	g.Describe("File jobs", func() {
+		g.BeforeEach(func() {
+			h, s, _ = setup(t, g, process)
+			config.Update(func(c *config.Configuration) {
+				c.System.FileJobs.MaxJobs = 1
+				c.System.FileJobs.Retention = 1
+			})
+			if err := s.Filesystem().Writefile("file.txt", strings.NewReader("hello")); err != nil {
+				g.Fail(err)
+			}
+		})
+
+		g.AfterEach(func() {
+			h.Close()
+		})
+
+		g.It("compresses files in the foreground by default", func() {
+			res := h.Request(http.MethodPost, Path(uuid, "files/compress"), map[string]interface{}{
+				"root": "/", "files": []string{"file.txt"}, "name": "archive", "extension": "tar.gz",
+			})
+			g.Assert(res.Code).Equal(http.StatusOK)
+			var st map[string]interface{}
+			g.Assert(json.Unmarshal(res.Body.Bytes(), &st)).IsNil()
+			g.Assert(st["name"]).Equal("archive.tar.gz")
+		})
+
+		g.It("keeps a finished background job until it expires", func() {
+			res := h.Request(http.MethodPost, Path(uuid, "files/compress"), map[string]interface{}{
+				"root": "/", "files": []string{"file.txt"}, "name": "archive", "background": true,
+			})
+			g.Assert(res.Code).Equal(http.StatusAccepted)
+			var data struct {
+				Identifier string `json:"identifier"`
+			}
+			g.Assert(json.Unmarshal(res.Body.Bytes(), &data)).IsNil()
+
+			status := func() string {
+				res := h.Request(http.MethodGet, Path(uuid, "files/jobs/"+data.Identifier), nil)
+				if res.Code != http.StatusOK {
+					return strconv.Itoa(res.Code)
+				}
+				var job struct {
+					Status string `json:"status"`
+				}
+				_ = json.Unmarshal(res.Body.Bytes(), &job)
+				return job.Status
+			}
+			g.Assert(Eventually(func() bool { return status() == "completed" })).IsTrue()
+			g.Assert(Eventually(func() bool { return status() == "404" })).IsTrue()
+		})
+
+		g.It("limits the number of running jobs", func() {
+			release := make(chan struct{})
+			job, err := jobs.New(s, jobs.TypeCopy)
+			g.Assert(err).IsNil()
+			go func() {
+				_ = job.Execute(func(ctx context.Context, _ *progress.Progress) error {
+					<-release
+					return nil
+				})
+			}()
+
+			_, err = jobs.New(s, jobs.TypeCopy)
+			g.Assert(errors.Is(err, jobs.ErrLimitReached)).IsTrue()
+			res := h.Request(http.MethodPost, Path(uuid, "files/compress"), map[string]interface{}{"root": "/", "files": []string{"file.txt"}})
+			g.Assert(res.Code).Equal(http.StatusBadRequest)
+			g.Assert(strings.Contains(res.Body.String(), "limit of 1 simultaneous file jobs")).IsTrue()
+
+			// A finished job no longer counts towards the limit.
+			close(release)
+			g.Assert(Eventually(job.IsFinished)).IsTrue()
+			job, err = jobs.New(s, jobs.TypeCopy)
+			g.Assert(err).IsNil()
+			_ = job.Execute(func(context.Context, *progress.Progress) error { return nil })
+		})
+
+		g.It("does not pull a remote file onto a path the rules deny", func() {
+			rules := acl.Rules{{Path: "**"}, {Path: "server.properties", Deny: true, Modes: []acl.Mode{acl.Write}}}
+			res := h.RequestWithRules(uuid, rules, http.MethodPost, Path(uuid, "files/pull"), map[string]interface{}{
+				"root": "/", "url": "https://example.com/server.properties", "file_name": "server.properties", "foreground": true,
+			})
+			g.Assert(res.Code).Equal(http.StatusForbidden)
+		})
+
+		g.It("cancels a foreground job once its request is done", func() {
+			job, err := jobs.New(s, jobs.TypeCopy)
+			g.Assert(err).IsNil()
+			ctx, cancel := context.WithCancel(context.Background())
+			job.CancelWith(ctx)
+			cancel()
+
+			err = job.Execute(func(ctx context.Context, _ *progress.Progress) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			g.Assert(errors.Is(err, context.Canceled)).IsTrue()
+			g.Assert(job.Status()).Equal(jobs.StatusCanceled)
+		})
+	})
+}
@@ -0,0 +1,259 @@
+// Package lockout tracks failed SFTP logins by IP address and username, and
+// temporarily locks out those with too many of them so that brute-force
+// attempts never reach the Panel.
+package lockout
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/Minenetpro/pelican-wings/config"
+)
+
+// ErrLockedOut is returned when a login is attempted from an IP address, or for
+// a username, that is currently locked out.
+var ErrLockedOut = errors.Sentinel("lockout: too many failed login attempts")
+
+// Type is the kind of value that failed logins are tracked against.
+type Type string
+
+const (
+	TypeIP       Type = "ip"
+	TypeUsername Type = "username"
+)
+
+// Ban is an IP address or username that is currently locked out.
+type Ban struct {
+	Type  Type   `json:"type"`
+	Value string `json:"value"`
+	// Lockouts is the number of times the value has been locked out, which
+	// determines how long the current lockout lasts.
+	Lockouts  int       `json:"lockouts"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type key struct {
+	t     Type
+	value string
+}
+
+type entry struct {
+	failures int
+	lockouts int
+	last     time.Time
+	until    time.Time
+}
+
+// Tracker keeps track of failed logins. The configuration is read every time
+// it is used, so changes to the lockout configuration apply immediately.
+type Tracker struct {
+	mu      sync.Mutex
+	entries map[key]*entry
+	pruned  time.Time
+	now     func() time.Time
+}
+
+var instance = New()
+
+// New returns a new tracker with no failed logins.
+func New() *Tracker {
+	return &Tracker{entries: make(map[key]*entry), now: time.Now}
+}
+
+// Connect returns whether a connection from the IP address should be accepted.
+// Connections are refused from denied IP addresses and IP addresses that are
+// locked out.
+func Connect(ip string) bool {
+	return instance.Connect(ip)
+}
+
+// Check returns ErrLockedOut if the IP address or username is locked out.
+func Check(ip, username string) error {
+	return instance.Check(ip, username)
+}
+
+// Fail records a failed login from the IP address for the username, locking
+// out either of them once they reach the maximum number of attempts. If the
+// username is empty the failure is only recorded against the IP address.
+func Fail(ip, username string) {
+	instance.Fail(ip, username)
+}
+
+// Succeed forgets the failed logins for the username after a successful login.
+// The failed logins from the address the login came from are kept, since a single valid login
+// must not reset the count of an address guessing the passwords of other users.
+// They are forgotten once they expire.
+func Succeed(username string) {
+	instance.Succeed(username)
+}
+
+// Bans returns every IP address and username that is currently locked out.
+func Bans() []Ban {
+	return instance.Bans()
+}
+
+// Clear forgets the failed logins for a single IP address or username, which
+// lifts any lockout for it.
+func Clear(t Type, value string) {
+	instance.Clear(t, value)
+}
+
+// ClearAll forgets every failed login, lifting all lockouts.
+func ClearAll() {
+	instance.ClearAll()
+}
+
+func (t *Tracker) Connect(ip string) bool {
+	cfg := config.Get().System.Sftp.Lockout
+	addr := net.ParseIP(host(ip))
+	if contains(cfg.DeniedIPs, addr) {
+		return false
+	}
+	if !cfg.Enabled || contains(cfg.AllowedIPs, addr) {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.locked(key{TypeIP, host(ip)})
+}
+
+func (t *Tracker) Check(ip, username string) error {
+	cfg := config.Get().System.Sftp.Lockout
+	if !cfg.Enabled || contains(cfg.AllowedIPs, net.ParseIP(host(ip))) {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.locked(key{TypeIP, host(ip)}) || (username != "" && t.locked(key{TypeUsername, username})) {
+		return ErrLockedOut
+	}
+	return nil
+}
+
+func (t *Tracker) Fail(ip, username string) {
+	cfg := config.Get().System.Sftp.Lockout
+	if !cfg.Enabled || contains(cfg.AllowedIPs, net.ParseIP(host(ip))) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.prune(now, cfg)
+	t.fail(key{TypeIP, host(ip)}, now, cfg)
+	if username != "" {
+		t.fail(key{TypeUsername, username}, now, cfg)
+	}
+}
+
+func (t *Tracker) Succeed(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key{TypeUsername, username})
+}
+
+func (t *Tracker) Bans() []Ban {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	bans := make([]Ban, 0)
+	for k, e := range t.entries {
+		if e.until.After(now) {
+			bans = append(bans, Ban{Type: k.t, Value: k.value, Lockouts: e.lockouts, ExpiresAt: e.until})
+		}
+	}
+	slices.SortFunc(bans, func(a, b Ban) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return bans
+}
+
+func (t *Tracker) Clear(typ Type, value string) {
+	if typ == TypeIP {
+		value = host(value)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key{typ, value})
+}
+
+func (t *Tracker) ClearAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = make(map[key]*entry)
+}
+
+// locked returns whether the key is currently locked out. The caller must hold
+// the lock.
+func (t *Tracker) locked(k key) bool {
+	e, ok := t.entries[k]
+	return ok && e.until.After(t.now())
+}
+
+// fail records a failure against the key, locking it out once it reaches the
+// maximum number of attempts. Every lockout lasts twice as long as the one
+// before it. The caller must hold the lock.
+func (t *Tracker) fail(k key, now time.Time, cfg config.SftpLockoutConfiguration) {
+	e, ok := t.entries[k]
+	if !ok {
+		e = &entry{}
+		t.entries[k] = e
+	}
+	e.last = now
+	e.failures++
+	if e.failures < cfg.MaxAttempts {
+		return
+	}
+	e.failures = 0
+	e.lockouts++
+	d := time.Duration(cfg.Duration) * time.Second
+	for i := 1; i < e.lockouts && d < time.Duration(cfg.MaxDuration)*time.Second; i++ {
+		d *= 2
+	}
+	e.until = now.Add(min(d, time.Duration(cfg.MaxDuration)*time.Second))
+}
+
+// prune forgets the keys that are not locked out and have not failed a login
+// for the maximum lockout duration. This runs at most once a minute. The caller
+// must hold the lock.
+func (t *Tracker) prune(now time.Time, cfg config.SftpLockoutConfiguration) {
+	if now.Sub(t.pruned) < time.Minute {
+		return
+	}
+	t.pruned = now
+	for k, e := range t.entries {
+		if e.until.Before(now) && now.Sub(e.last) > time.Duration(cfg.MaxDuration)*time.Second {
+			delete(t.entries, k)
+		}
+	}
+}
+
+// host strips the port from an address, if there is one.
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(strings.TrimSpace(addr)); err == nil {
+		return h
+	}
+	return strings.TrimSpace(addr)
+}
+
+// contains returns whether the IP address is in any of the IP addresses or CIDR
+// ranges in the list. Invalid entries are ignored.
+func contains(list []string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, v := range list {
+		if strings.Contains(v, "/") {
+			if _, n, err := net.ParseCIDR(v); err == nil && n.Contains(ip) {
+				return true
+			}
+		} else if other := net.ParseIP(v); other != nil && other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
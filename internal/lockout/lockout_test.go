@@ -0,0 +1,125 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+)
+
+func TestTracker(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Tracker", func() {
+		var tr *Tracker
+		var now time.Time
+
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{
+				AuthenticationToken: "abc",
+				System: config.SystemConfiguration{
+					Sftp: config.SftpConfiguration{
+						Lockout: config.SftpLockoutConfiguration{
+							Enabled:     true,
+							MaxAttempts: 3,
+							Duration:    60,
+							MaxDuration: 200,
+							AllowedIPs:  []string{"10.0.0.0/8"},
+							DeniedIPs:   []string{"192.0.2.1", "10.1.0.0/16"},
+						},
+					},
+				},
+			})
+			now = time.Now()
+			tr = New()
+			tr.now = func() time.Time { return now }
+		})
+
+		g.It("locks out an address after too many failed logins", func() {
+			tr.Fail("203.0.113.10:52144", "user.abcd1234")
+			tr.Fail("203.0.113.10:52145", "other.abcd1234")
+			g.Assert(tr.Check("203.0.113.10", "")).IsNil()
+			g.Assert(tr.Connect("203.0.113.10:52146")).IsTrue()
+
+			tr.Fail("203.0.113.10:52146", "another.abcd1234")
+			g.Assert(tr.Check("203.0.113.10", "")).Equal(ErrLockedOut)
+			g.Assert(tr.Connect("203.0.113.10:52147")).IsFalse()
+			g.Assert(tr.Check("203.0.113.11", "user.abcd1234")).IsNil()
+
+			now = now.Add(time.Second * 61)
+			g.Assert(tr.Connect("203.0.113.10:52148")).IsTrue()
+		})
+
+		g.It("locks out a username from any address", func() {
+			for _, ip := range []string{"203.0.113.10", "203.0.113.11", "203.0.113.12"} {
+				tr.Fail(ip, "user.abcd1234")
+			}
+			g.Assert(tr.Check("203.0.113.13", "user.abcd1234")).Equal(ErrLockedOut)
+			g.Assert(tr.Check("203.0.113.13", "other.abcd1234")).IsNil()
+		})
+
+		g.It("doubles the lockout each time up to the maximum", func() {
+			expires := func() time.Duration {
+				for i := 0; i < 3; i++ {
+					tr.Fail("203.0.113.10", "")
+				}
+				bans := tr.Bans()
+				g.Assert(len(bans)).Equal(1)
+				return bans[0].ExpiresAt.Sub(now)
+			}
+			g.Assert(expires()).Equal(time.Second * 60)
+			now = now.Add(time.Second * 61)
+			g.Assert(expires()).Equal(time.Second * 120)
+			now = now.Add(time.Second * 121)
+			g.Assert(expires()).Equal(time.Second * 200)
+			g.Assert(tr.Bans()[0].Lockouts).Equal(3)
+		})
+
+		g.It("forgets the failures of a username after a successful login", func() {
+			tr.Fail("203.0.113.10", "user.abcd1234")
+			tr.Fail("203.0.113.10", "user.abcd1234")
+			tr.Succeed("user.abcd1234")
+			tr.Fail("203.0.113.11", "user.abcd1234")
+			g.Assert(tr.Check("203.0.113.12", "user.abcd1234")).IsNil()
+		})
+
+		g.It("keeps the failures of an address after a successful login", func() {
+			tr.Fail("203.0.113.10", "other.abcd1234")
+			tr.Fail("203.0.113.10", "another.abcd1234")
+			tr.Succeed("user.abcd1234")
+			tr.Fail("203.0.113.10", "third.abcd1234")
+			g.Assert(tr.Check("203.0.113.10", "")).Equal(ErrLockedOut)
+		})
+
+		g.It("never locks out allowed addresses", func() {
+			for i := 0; i < 5; i++ {
+				tr.Fail("10.0.0.5", "user.abcd1234")
+			}
+			g.Assert(tr.Check("10.0.0.5", "")).IsNil()
+			g.Assert(tr.Check("203.0.113.10", "user.abcd1234")).IsNil()
+			g.Assert(len(tr.Bans())).Equal(0)
+		})
+
+		g.It("refuses connections from denied addresses", func() {
+			g.Assert(tr.Connect("192.0.2.1:22")).IsFalse()
+			g.Assert(tr.Connect("10.1.2.3:22")).IsFalse()
+			g.Assert(tr.Connect("10.2.2.3:22")).IsTrue()
+		})
+
+		g.It("clears lockouts", func() {
+			for i := 0; i < 3; i++ {
+				tr.Fail("203.0.113.10", "user.abcd1234")
+			}
+			g.Assert(len(tr.Bans())).Equal(2)
+
+			tr.Clear(TypeIP, "203.0.113.10")
+			g.Assert(tr.Connect("203.0.113.10")).IsTrue()
+			g.Assert(tr.Check("203.0.113.10", "user.abcd1234")).Equal(ErrLockedOut)
+
+			tr.ClearAll()
+			g.Assert(tr.Check("203.0.113.10", "user.abcd1234")).IsNil()
+		})
+	})
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// CrashReport records the state of a server process when it crashed, so that the
+// cause of the crash can be looked into after the server has been restarted.
+type CrashReport struct {
+	ID int `gorm:"primaryKey;not null" json:"id"`
+	// Server is the UUID of the server that crashed.
+	Server    string `gorm:"type:uuid;index;not null" json:"server"`
+	ExitCode  uint32 `gorm:"not null" json:"exit_code"`
+	OomKilled bool   `gorm:"not null" json:"oom_killed"`
+	// Logs are the last lines of console output before the process exited.
+	Logs []string `gorm:"serializer:json" json:"logs"`
+	// Stats is the last resource usage reported for the process before it exited.
+	Stats CrashStats `gorm:"serializer:json" json:"stats"`
+	// Action is what Wings did after the crash, either "restarted", "crash_looping",
+	// or "none" if the server was not restarted for another reason.
+	Action    string    `gorm:"not null" json:"action"`
+	Timestamp time.Time `gorm:"not null" json:"timestamp"`
+}
+
+// CrashStats is the resource usage of a server process when it crashed.
+type CrashStats struct {
+	Memory      uint64  `json:"memory_bytes"`
+	MemoryLimit uint64  `json:"memory_limit_bytes"`
+	CpuAbsolute float64 `json:"cpu_absolute"`
+	Uptime      int64   `json:"uptime"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// Schedule is a list of tasks that Wings runs for a server at the times given by
+// its cron expression. Schedules are pushed to Wings by the Panel, so that they
+// keep running when the Panel is unavailable.
+type Schedule struct {
+	// ID is the ID of the schedule on the Panel.
+	ID int `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	// Server is the UUID of the server that the schedule belongs to.
+	Server  string `gorm:"type:uuid;primaryKey" json:"server"`
+	Name    string `gorm:"not null" json:"name"`
+	Cron    string `gorm:"not null" json:"cron"`
+	Enabled bool   `gorm:"not null" json:"enabled"`
+	// OnlyWhenOnline skips the schedule entirely if the server is not running at
+	// the time it is due.
+	OnlyWhenOnline bool           `gorm:"not null" json:"only_when_online"`
+	Tasks          []ScheduleTask `gorm:"serializer:json" json:"tasks"`
+	LastRunAt      *time.Time     `json:"last_run_at"`
+}
+
+// ScheduleTask is a single step of a schedule. The tasks of a schedule are run in
+// order, and the schedule stops at the first task that fails unless the task is
+// set to continue on failure.
+type ScheduleTask struct {
+	// Action is one of "command", "power", "backup" or "delay".
+	Action string `json:"action"`
+	// Payload is the command to send, the power action to perform, the backup
+	// adapter to use or the number of seconds to wait, depending on the action.
+	Payload string `json:"payload"`
+	// Condition is either "online" or "offline" to only run the task when the
+	// server is in that state, or empty to always run it.
+	Condition         string `json:"condition,omitempty"`
+	ContinueOnFailure bool   `json:"continue_on_failure"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/Minenetpro/pelican-wings/internal/acl"
+)
+
+// SftpCredential is a public key that successfully authenticated a user against a server
+// over SFTP. These are cached so that users are still able to log in when the Panel cannot
+// be reached.
+type SftpCredential struct {
+	ID int `gorm:"primaryKey;not null"`
+	// KeyHash is the SHA-256 hash of the username that was used to log in and the public
+	// key, the key itself is never stored.
+	KeyHash string `gorm:"uniqueIndex;not null"`
+	// User is the UUID of the user the Panel matched the credentials to.
+	User string `gorm:"type:uuid;index;not null"`
+	// Server is the UUID of the server the Panel matched the credentials to.
+	Server      string    `gorm:"type:uuid;index;not null"`
+	Permissions []string  `gorm:"serializer:json"`
+	PathRules   acl.Rules `gorm:"serializer:json"`
+	// ValidatedAt is the last time the credentials were validated by the Panel.
+	ValidatedAt time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"index;not null"`
+}
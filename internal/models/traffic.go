@@ -0,0 +1,13 @@
+package models
+
+// Traffic is the network traffic of a server during a calendar month, which is
+// kept across restarts of the server and of Wings.
+type Traffic struct {
+	// Server is the UUID of the server that the traffic belongs to.
+	Server string `gorm:"type:uuid;primaryKey" json:"-"`
+	// Month is the month the traffic was counted in, such as "2026-10", in the
+	// timezone of the system.
+	Month   string `gorm:"primaryKey" json:"month"`
+	RxBytes uint64 `gorm:"not null" json:"rx_bytes"`
+	TxBytes uint64 `gorm:"not null" json:"tx_bytes"`
+}
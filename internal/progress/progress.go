@@ -54,6 +54,13 @@ func (p *Progress) Write(v []byte) (int, error) {
 	return n, nil
 }
 
+// Add records n bytes as processed without passing any data through to the
+// underlying writer. This is useful for operations that complete a known amount
+// of work at once, such as renaming a file rather than copying it.
+func (p *Progress) Add(n uint64) {
+	atomic.AddUint64(&p.written, n)
+}
+
 // Progress returns a formatted progress string for the current progress.
 func (p *Progress) Progress(width int) string {
 	// current = 100 (Progress, dynamic)
@@ -0,0 +1,403 @@
+// Package query implements the protocols used by game servers to report their
+// status, such as the number of players online, so that Wings can report it and
+// check that a server is responding to players rather than only that its process
+// is alive.
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+)
+
+// The protocols supported by Query.
+const (
+	// ProtocolA2S is the Source engine A2S_INFO and A2S_PLAYER query, also used
+	// by many non-Source games.
+	ProtocolA2S = "a2s"
+	// ProtocolMinecraft is the GameSpy4 based query protocol of Minecraft Java
+	// Edition, which is enabled using "enable-query" in server.properties.
+	ProtocolMinecraft = "minecraft"
+	// ProtocolSLP is the Server List Ping of Minecraft Java Edition, which is
+	// always enabled and uses the game port.
+	ProtocolSLP = "slp"
+	// ProtocolBedrock is the unconnected ping of Minecraft Bedrock Edition.
+	ProtocolBedrock = "bedrock"
+)
+
+// ErrInvalidResponse is returned when a server responds with something that is
+// not a valid response for the protocol.
+var ErrInvalidResponse = errors.Sentinel("query: invalid response from server")
+
+// Result is the status reported by a server.
+type Result struct {
+	// Name is the name of the server, or its MOTD for Minecraft servers.
+	Name       string `json:"name"`
+	Map        string `json:"map"`
+	Version    string `json:"version"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players"`
+	// PlayerList contains the names of the players online, if the protocol
+	// reports them. Minecraft servers only report some of them.
+	PlayerList []string `json:"player_list,omitempty"`
+}
+
+// Query queries the server at the given address using a protocol, returning
+// the status it reports. If the context has no deadline, the query times out
+// after five seconds.
+func Query(ctx context.Context, protocol string, addr string) (*Result, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+	}
+
+	network := "udp"
+	switch protocol {
+	case ProtocolA2S, ProtocolMinecraft, ProtocolBedrock:
+	case ProtocolSLP:
+		network = "tcp"
+	default:
+		return nil, errors.New("query: unknown protocol: " + protocol)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	switch protocol {
+	case ProtocolA2S:
+		res, err := a2sInfo(conn)
+		if err != nil {
+			return nil, err
+		}
+		// Servers may not respond to player queries, in which case only the number
+		// of players is known. Don't wait as long for them to do so.
+		if deadline, _ := ctx.Deadline(); time.Now().Add(time.Second).Before(deadline) {
+			_ = conn.SetDeadline(time.Now().Add(time.Second))
+		}
+		if players, err := a2sPlayers(conn); err == nil {
+			res.PlayerList = players
+		}
+		return res, nil
+	case ProtocolMinecraft:
+		return gamespy4(conn)
+	case ProtocolSLP:
+		return slp(conn, addr)
+	default:
+		return bedrock(conn)
+	}
+}
+
+// request writes a packet to the connection and reads the response.
+func request(conn net.Conn, p []byte) ([]byte, error) {
+	if _, err := conn.Write(p); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	b := make([]byte, 1400)
+	n, err := conn.Read(b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b[:n], nil
+}
+
+// reader reads the fields of a response, remembering the first error so that
+// it only has to be checked once all the fields have been read.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.err = ErrInvalidResponse
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) skip(n int) {
+	if r.err != nil || len(r.b) < n {
+		r.err = ErrInvalidResponse
+		return
+	}
+	r.b = r.b[n:]
+}
+
+// string reads a null terminated string.
+func (r *reader) string() string {
+	i := bytes.IndexByte(r.b, 0)
+	if r.err != nil || i < 0 {
+		r.err = ErrInvalidResponse
+		return ""
+	}
+	v := string(r.b[:i])
+	r.b = r.b[i+1:]
+	return v
+}
+
+var a2sHeader = []byte{0xff, 0xff, 0xff, 0xff}
+
+// a2sInfo sends an A2S_INFO request, answering the challenge the server may
+// respond with first.
+func a2sInfo(conn net.Conn) (*Result, error) {
+	p := append(append([]byte{}, a2sHeader...), append([]byte{'T'}, "Source Engine Query\x00"...)...)
+	b, err := request(conn, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 9 && bytes.HasPrefix(b, a2sHeader) && b[4] == 'A' {
+		if b, err = request(conn, append(p, b[5:9]...)); err != nil {
+			return nil, err
+		}
+	}
+	if len(b) < 5 || !bytes.HasPrefix(b, a2sHeader) || b[4] != 'I' {
+		return nil, ErrInvalidResponse
+	}
+
+	r := reader{b: b[5:]}
+	var res Result
+	r.skip(1) // protocol
+	res.Name = r.string()
+	res.Map = r.string()
+	r.string() // folder
+	r.string() // game
+	r.skip(2)  // app id
+	res.Players = int(r.byte())
+	res.MaxPlayers = int(r.byte())
+	r.skip(5) // bots, server type, environment, visibility and VAC
+	res.Version = r.string()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &res, nil
+}
+
+// a2sPlayers sends an A2S_PLAYER request, answering the challenge the server
+// responds with first, and returns the names of the players online.
+func a2sPlayers(conn net.Conn) ([]string, error) {
+	p := append(append([]byte{}, a2sHeader...), 'U', 0xff, 0xff, 0xff, 0xff)
+	b, err := request(conn, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 9 && bytes.HasPrefix(b, a2sHeader) && b[4] == 'A' {
+		copy(p[5:], b[5:9])
+		if b, err = request(conn, p); err != nil {
+			return nil, err
+		}
+	}
+	if len(b) < 6 || !bytes.HasPrefix(b, a2sHeader) || b[4] != 'D' {
+		return nil, ErrInvalidResponse
+	}
+
+	r := reader{b: b[5:]}
+	players := make([]string, 0, int(r.byte()))
+	for i := 0; i < cap(players); i++ {
+		r.skip(1) // index
+		name := r.string()
+		r.skip(8) // score and duration
+		if name != "" {
+			players = append(players, name)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return players, nil
+}
+
+// gamespy4 performs the handshake of the GameSpy4 protocol and then requests
+// the basic status of the server.
+func gamespy4(conn net.Conn) (*Result, error) {
+	session := uint32(time.Now().UnixNano()) & 0x0f0f0f0f
+	p := []byte{0xfe, 0xfd, 0x09}
+	p = binary.BigEndian.AppendUint32(p, session)
+	b, err := request(conn, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 5 || b[0] != 0x09 {
+		return nil, ErrInvalidResponse
+	}
+	r := reader{b: b[5:]}
+	challenge, err := strconv.ParseInt(r.string(), 10, 32)
+	if r.err != nil || err != nil {
+		return nil, ErrInvalidResponse
+	}
+
+	p = []byte{0xfe, 0xfd, 0x00}
+	p = binary.BigEndian.AppendUint32(p, session)
+	p = binary.BigEndian.AppendUint32(p, uint32(challenge))
+	if b, err = request(conn, p); err != nil {
+		return nil, err
+	}
+	if len(b) < 5 || b[0] != 0x00 {
+		return nil, ErrInvalidResponse
+	}
+
+	r = reader{b: b[5:]}
+	var res Result
+	res.Name = r.string()
+	r.string() // game type
+	res.Map = r.string()
+	players, _ := strconv.Atoi(r.string())
+	maxPlayers, _ := strconv.Atoi(r.string())
+	if r.err != nil {
+		return nil, r.err
+	}
+	res.Players = players
+	res.MaxPlayers = maxPlayers
+	return &res, nil
+}
+
+// slp performs the status request of the Server List Ping protocol.
+func slp(conn net.Conn, addr string) (*Result, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	p, _ := strconv.ParseUint(port, 10, 16)
+
+	// The handshake, using a protocol version of -1 as the version of the server
+	// is not known, followed by the status request.
+	handshake := []byte{0x00}
+	handshake = binary.AppendUvarint(handshake, 0xffffffff)
+	handshake = binary.AppendUvarint(handshake, uint64(len(host)))
+	handshake = append(handshake, host...)
+	handshake = binary.BigEndian.AppendUint16(handshake, uint16(p))
+	handshake = append(handshake, 0x01)
+	packet := binary.AppendUvarint(nil, uint64(len(handshake)))
+	packet = append(append(packet, handshake...), 0x01, 0x00)
+	if _, err := conn.Write(packet); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	br := bufio.NewReader(conn)
+	length, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if length > 1<<20 {
+		return nil, ErrInvalidResponse
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// The packet ID, followed by the length of the JSON status.
+	id, n := binary.Uvarint(b)
+	if n <= 0 || id != 0x00 {
+		return nil, ErrInvalidResponse
+	}
+	size, m := binary.Uvarint(b[n:])
+	if m <= 0 || uint64(len(b[n+m:])) < size {
+		return nil, ErrInvalidResponse
+	}
+
+	var status struct {
+		Version struct {
+			Name string `json:"name"`
+		} `json:"version"`
+		Players struct {
+			Max    int `json:"max"`
+			Online int `json:"online"`
+			Sample []struct {
+				Name string `json:"name"`
+			} `json:"sample"`
+		} `json:"players"`
+		Description json.RawMessage `json:"description"`
+	}
+	if err := json.Unmarshal(b[n+m:n+m+int(size)], &status); err != nil {
+		return nil, ErrInvalidResponse
+	}
+
+	res := &Result{
+		Name:       chatText(status.Description),
+		Version:    status.Version.Name,
+		Players:    status.Players.Online,
+		MaxPlayers: status.Players.Max,
+	}
+	for _, p := range status.Players.Sample {
+		res.PlayerList = append(res.PlayerList, p.Name)
+	}
+	return res, nil
+}
+
+// chatText returns the plain text of a Minecraft chat component, which is
+// either a string or an object with text and further components.
+func chatText(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var c struct {
+		Text  string            `json:"text"`
+		Extra []json.RawMessage `json:"extra"`
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(c.Text)
+	for _, e := range c.Extra {
+		sb.WriteString(chatText(e))
+	}
+	return sb.String()
+}
+
+// The magic bytes included in every unconnected RakNet packet.
+var raknetMagic = []byte{0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe, 0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78}
+
+// bedrock sends an unconnected ping to a Bedrock server, which responds with its
+// status as a list of fields separated by semicolons.
+func bedrock(conn net.Conn) (*Result, error) {
+	p := []byte{0x01}
+	p = binary.BigEndian.AppendUint64(p, uint64(time.Now().UnixMilli()))
+	p = append(p, raknetMagic...)
+	p = binary.BigEndian.AppendUint64(p, 0)
+	b, err := request(conn, p)
+	if err != nil {
+		return nil, err
+	}
+	// The packet ID, the time of the ping, the GUID of the server, the magic bytes
+	// and the length of the status.
+	if len(b) < 35 || b[0] != 0x1c || !bytes.Equal(b[17:33], raknetMagic) {
+		return nil, ErrInvalidResponse
+	}
+	size := int(binary.BigEndian.Uint16(b[33:35]))
+	if len(b[35:]) < size {
+		return nil, ErrInvalidResponse
+	}
+
+	// MCPE;MOTD;protocol;version;players;max players;server ID;level name;...
+	fields := strings.Split(string(b[35:35+size]), ";")
+	if len(fields) < 6 {
+		return nil, ErrInvalidResponse
+	}
+	res := &Result{Name: fields[1], Version: fields[3]}
+	res.Players, _ = strconv.Atoi(fields[4])
+	res.MaxPlayers, _ = strconv.Atoi(fields[5])
+	if len(fields) > 7 {
+		res.Map = fields[7]
+	}
+	return res, nil
+}
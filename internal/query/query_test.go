@@ -0,0 +1,321 @@
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/goccy/go-json"
+)
+
+// serve answers every packet sent to a local UDP listener using the handler,
+// returning the address of the listener.
+func serve(t *testing.T, handler func(p []byte) []byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		b := make([]byte, 1400)
+		for {
+			n, addr, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			if res := handler(b[:n]); res != nil {
+				_, _ = conn.WriteTo(res, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestQuery(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	ctx := context.Background()
+
+	g.Describe("A2S", func() {
+		info := append([]byte{0xff, 0xff, 0xff, 0xff, 'I', 17}, "My Server\x00de_dust2\x00csgo\x00Counter-Strike\x00"...)
+		info = append(info, 0xda, 0x02, 7, 24, 0, 'd', 'l', 0, 1)
+		info = append(info, "1.38.7.9\x00"...)
+
+		g.It("returns the status of the server", func() {
+			addr := serve(t, func(p []byte) []byte {
+				if !bytes.Equal(p[4:], []byte("TSource Engine Query\x00")) {
+					return nil
+				}
+				return info
+			})
+
+			res, err := Query(ctx, ProtocolA2S, addr)
+			g.Assert(err).IsNil()
+			g.Assert(*res).Equal(Result{Name: "My Server", Map: "de_dust2", Version: "1.38.7.9", Players: 7, MaxPlayers: 24})
+		})
+
+		g.It("returns the players online", func() {
+			challenge := []byte{9, 8, 7, 6}
+			addr := serve(t, func(p []byte) []byte {
+				switch p[4] {
+				case 'T':
+					return info
+				case 'U':
+					if !bytes.Equal(p[5:], challenge) {
+						return append([]byte{0xff, 0xff, 0xff, 0xff, 'A'}, challenge...)
+					}
+					res := []byte{0xff, 0xff, 0xff, 0xff, 'D', 3}
+					for i, name := range []string{"alice", "", "bob"} {
+						res = append(res, byte(i))
+						res = append(res, name+"\x00"...)
+						res = append(res, make([]byte, 8)...)
+					}
+					return res
+				}
+				return nil
+			})
+
+			res, err := Query(ctx, ProtocolA2S, addr)
+			g.Assert(err).IsNil()
+			g.Assert(res.Players).Equal(7)
+			g.Assert(res.PlayerList).Equal([]string{"alice", "bob"})
+		})
+
+		g.It("answers a challenge", func() {
+			challenge := []byte{1, 2, 3, 4}
+			addr := serve(t, func(p []byte) []byte {
+				if !bytes.HasSuffix(p, challenge) {
+					return append([]byte{0xff, 0xff, 0xff, 0xff, 'A'}, challenge...)
+				}
+				return info
+			})
+
+			res, err := Query(ctx, ProtocolA2S, addr)
+			g.Assert(err).IsNil()
+			g.Assert(res.Name).Equal("My Server")
+		})
+
+		g.It("rejects an invalid response", func() {
+			addr := serve(t, func(p []byte) []byte {
+				return []byte{0xff, 0xff, 0xff, 0xff, 'I', 17, 'x'}
+			})
+
+			_, err := Query(ctx, ProtocolA2S, addr)
+			g.Assert(err).Equal(ErrInvalidResponse)
+		})
+	})
+
+	g.Describe("Minecraft", func() {
+		g.It("returns the status of the server", func() {
+			addr := serve(t, func(p []byte) []byte {
+				session := p[3:7]
+				switch p[2] {
+				case 0x09:
+					return append(append([]byte{0x09}, session...), "9513307\x00"...)
+				case 0x00:
+					if binary.BigEndian.Uint32(p[7:11]) != 9513307 {
+						return nil
+					}
+					res := append([]byte{0x00}, session...)
+					return append(res, "A Minecraft Server\x00SMP\x00world\x003\x0020\x00\xdd\x63127.0.0.1\x00"...)
+				}
+				return nil
+			})
+
+			res, err := Query(ctx, ProtocolMinecraft, addr)
+			g.Assert(err).IsNil()
+			g.Assert(*res).Equal(Result{Name: "A Minecraft Server", Map: "world", Players: 3, MaxPlayers: 20})
+		})
+	})
+
+	g.Describe("Server List Ping", func() {
+		g.It("returns the status of the server", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				// Read the handshake and the status request.
+				for i := 0; i < 2; i++ {
+					n, err := binary.ReadUvarint(r)
+					if err != nil {
+						return
+					}
+					if _, err := io.ReadFull(r, make([]byte, n)); err != nil {
+						return
+					}
+				}
+				status := `{"version":{"name":"1.21.1","protocol":767},"players":{"max":20,"online":2,"sample":[{"name":"alice","id":"4566e69f-c907-48ee-8d71-d7ba5aa00d20"}]},"description":{"text":"A ","extra":["Minecraft ",{"text":"Server"}]}}`
+				b := binary.AppendUvarint([]byte{0x00}, uint64(len(status)))
+				b = append(b, status...)
+				_, _ = conn.Write(append(binary.AppendUvarint(nil, uint64(len(b))), b...))
+			}()
+
+			res, err := Query(ctx, ProtocolSLP, l.Addr().String())
+			g.Assert(err).IsNil()
+			g.Assert(*res).Equal(Result{Name: "A Minecraft Server", Version: "1.21.1", Players: 2, MaxPlayers: 20, PlayerList: []string{"alice"}})
+		})
+	})
+
+	g.Describe("Bedrock", func() {
+		g.It("returns the status of the server", func() {
+			addr := serve(t, func(p []byte) []byte {
+				if p[0] != 0x01 || !bytes.Equal(p[9:25], raknetMagic) {
+					return nil
+				}
+				status := "MCPE;Dedicated Server;712;1.21.20;4;10;13253860892328930865;Bedrock level;Survival;1;19132;19133;"
+				res := append([]byte{0x1c}, p[1:9]...)
+				res = append(res, make([]byte, 8)...)
+				res = append(res, raknetMagic...)
+				res = binary.BigEndian.AppendUint16(res, uint16(len(status)))
+				return append(res, status...)
+			})
+
+			res, err := Query(ctx, ProtocolBedrock, addr)
+			g.Assert(err).IsNil()
+			g.Assert(*res).Equal(Result{Name: "Dedicated Server", Map: "Bedrock level", Version: "1.21.20", Players: 4, MaxPlayers: 10})
+		})
+	})
+
+	g.Describe("Query", func() {
+		g.It("times out if the server does not respond", func() {
+			addr := serve(t, func(p []byte) []byte { return nil })
+
+			ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			_, err := Query(ctx, ProtocolA2S, addr)
+			g.Assert(err == nil).IsFalse()
+		})
+
+		g.It("returns an error for an unknown protocol", func() {
+			_, err := Query(ctx, "gopher", "127.0.0.1:1")
+			g.Assert(err == nil).IsFalse()
+		})
+	})
+}
+
+func TestStarting(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// handshake returns a handshake packet for the given next state.
+	handshake := func(next byte) []byte {
+		b := binary.AppendUvarint([]byte{0x00}, 767)
+		b = appendString(b, []byte("localhost"))
+		b = append(binary.BigEndian.AppendUint16(b, 25565), next)
+		return append(binary.AppendUvarint(nil, uint64(len(b))), b...)
+	}
+
+	g.Describe("Server List Ping", func() {
+		g.It("reports that the server is starting", func() {
+			client, conn := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				if h, err := ReadHandshake(br); err == nil {
+					_ = Starting(conn, br, h, "Starting, please wait...")
+				}
+			}()
+
+			_, err := client.Write(append(handshake(1), 0x01, 0x00))
+			g.Assert(err).IsNil()
+			br := bufio.NewReader(client)
+			p, err := readPacket(br)
+			g.Assert(err).IsNil()
+			g.Assert(p[0]).Equal(byte(0x00))
+			_, n := binary.Uvarint(p[1:])
+
+			var status struct {
+				Version struct {
+					Protocol int `json:"protocol"`
+				} `json:"version"`
+				Description struct {
+					Text string `json:"text"`
+				} `json:"description"`
+			}
+			g.Assert(json.Unmarshal(p[1+n:], &status)).IsNil()
+			g.Assert(status.Version.Protocol).Equal(767)
+			g.Assert(status.Description.Text).Equal("Starting, please wait...")
+
+			_, err = client.Write([]byte{0x09, 0x01, 1, 2, 3, 4, 5, 6, 7, 8})
+			g.Assert(err).IsNil()
+			p, err = readPacket(br)
+			g.Assert(err).IsNil()
+			g.Assert(p).Equal([]byte{0x01, 1, 2, 3, 4, 5, 6, 7, 8})
+		})
+
+		g.It("disconnects a player trying to log in", func() {
+			client, conn := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				if h, err := ReadHandshake(br); err == nil {
+					_ = Starting(conn, br, h, "Starting, please wait...")
+				}
+			}()
+
+			_, err := client.Write(handshake(2))
+			g.Assert(err).IsNil()
+			p, err := readPacket(bufio.NewReader(client))
+			g.Assert(err).IsNil()
+			g.Assert(p[0]).Equal(byte(0x00))
+			g.Assert(string(p[2:])).Equal(`{"text":"Starting, please wait..."}`)
+		})
+
+		g.It("only accepts a valid handshake", func() {
+			h, err := ReadHandshake(bufio.NewReader(bytes.NewReader(handshake(2))))
+			g.Assert(err).IsNil()
+			g.Assert(h.Version).Equal(uint64(767))
+			g.Assert(h.Next).Equal(uint64(2))
+
+			for _, b := range [][]byte{
+				handshake(4),
+				[]byte("GET / HTTP/1.1\r\n\r\n"),
+				{0x03, 0x00, 0x01, 0x20},
+				append(handshake(1)[:len(handshake(1))-3], 0x01),
+			} {
+				_, err := ReadHandshake(bufio.NewReader(bytes.NewReader(b)))
+				g.Assert(err == nil).IsFalse()
+			}
+		})
+	})
+
+	g.Describe("Client packets", func() {
+		g.It("recognises A2S queries", func() {
+			g.Assert(IsClientPacket(ProtocolA2S, append([]byte{0xff, 0xff, 0xff, 0xff, 'T'}, "Source Engine Query\x00"...))).IsTrue()
+			g.Assert(IsClientPacket(ProtocolA2S, []byte{0xff, 0xff, 0xff, 0xff, 'U', 0xff, 0xff, 0xff, 0xff})).IsTrue()
+			g.Assert(IsClientPacket(ProtocolA2S, []byte{0xff, 0xff, 0xff, 0xff, 'T'})).IsFalse()
+			g.Assert(IsClientPacket(ProtocolA2S, []byte("hello"))).IsFalse()
+		})
+
+		g.It("recognises Bedrock pings and connection requests", func() {
+			ping := append(append([]byte{0x01}, make([]byte, 8)...), raknetMagic...)
+			g.Assert(IsClientPacket(ProtocolBedrock, ping)).IsTrue()
+			g.Assert(IsClientPacket(ProtocolBedrock, append(ping, make([]byte, 8)...))).IsTrue()
+			g.Assert(IsClientPacket(ProtocolBedrock, append(append([]byte{0x05}, raknetMagic...), 11, 0, 0))).IsTrue()
+			g.Assert(IsClientPacket(ProtocolBedrock, make([]byte, 33))).IsFalse()
+		})
+
+		g.It("only uses UDP for UDP protocols", func() {
+			g.Assert(Network(ProtocolSLP)).Equal("tcp")
+			g.Assert(Network(ProtocolMinecraft)).Equal("tcp")
+			g.Assert(Network(ProtocolA2S)).Equal("udp")
+			g.Assert(Network(ProtocolBedrock)).Equal("udp")
+			g.Assert(IsClientPacket(ProtocolSLP, []byte{0x00})).IsFalse()
+		})
+	})
+}
@@ -0,0 +1,125 @@
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+)
+
+// ErrInvalidRequest is returned when a client sends something that is not a
+// valid request for the protocol.
+var ErrInvalidRequest = errors.Sentinel("query: invalid request from client")
+
+// Handshake is the first packet a Minecraft Java Edition client sends to a
+// server, before asking for its status or logging in.
+type Handshake struct {
+	// Version is the protocol version of the client.
+	Version uint64
+	// Next is 1 if the client wants the status of the server, 2 if it is logging
+	// in and 3 if it is being transferred from another server.
+	Next uint64
+}
+
+// ReadHandshake reads the handshake sent by a Minecraft Java Edition client,
+// which is used by the Server List Ping and to log in. ErrInvalidRequest is
+// returned if the client sent anything else.
+func ReadHandshake(br *bufio.Reader) (*Handshake, error) {
+	p, err := readPacket(br)
+	if err != nil {
+		return nil, err
+	}
+	// The handshake contains the protocol version of the client, the address and
+	// port it connected to, and whether it wants the status or to log in.
+	r := bytes.NewReader(p)
+	if id, err := binary.ReadUvarint(r); err != nil || id != 0x00 {
+		return nil, ErrInvalidRequest
+	}
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrInvalidRequest
+	}
+	host, err := binary.ReadUvarint(r)
+	if err != nil || host > 255 || int64(host)+2 > int64(r.Len()) {
+		return nil, ErrInvalidRequest
+	}
+	if _, err := r.Seek(int64(host)+2, io.SeekCurrent); err != nil {
+		return nil, ErrInvalidRequest
+	}
+	next, err := binary.ReadUvarint(r)
+	if err != nil || next < 1 || next > 3 || r.Len() != 0 {
+		return nil, ErrInvalidRequest
+	}
+	return &Handshake{Version: version, Next: next}, nil
+}
+
+// Starting responds to a Minecraft Java Edition client that connected to a server
+// while the server is being started, once its handshake has been read from br.
+// The message is shown in the server list, or when a player tries to join.
+func Starting(conn net.Conn, br *bufio.Reader, h *Handshake, message string) error {
+	text, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if h.Next != 1 {
+		// Disconnect a player trying to log in, showing them the message.
+		return writePacket(conn, 0x00, appendString(nil, text))
+	}
+
+	// Wait for the status request, then respond with the message as the MOTD,
+	// using the version of the client so that it is not shown as outdated.
+	if _, err := readPacket(br); err != nil {
+		return err
+	}
+	status, err := json.Marshal(map[string]interface{}{
+		"version":     map[string]interface{}{"name": "Starting", "protocol": int32(h.Version)},
+		"players":     map[string]int{"max": 0, "online": 0},
+		"description": json.RawMessage(text),
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := writePacket(conn, 0x00, appendString(nil, status)); err != nil {
+		return err
+	}
+	// The client may follow up with a ping, which is answered with the same
+	// payload so that it can show the latency.
+	ping, err := readPacket(br)
+	if err != nil || len(ping) != 9 || ping[0] != 0x01 {
+		return nil
+	}
+	return writePacket(conn, 0x01, ping[1:])
+}
+
+// readPacket reads a packet prefixed with its length.
+func readPacket(br *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if length > 1<<16 {
+		return nil, ErrInvalidRequest
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b, nil
+}
+
+// writePacket writes a packet with the given ID, prefixed with its length.
+func writePacket(w io.Writer, id byte, data []byte) error {
+	b := binary.AppendUvarint(nil, uint64(len(data)+1))
+	b = append(append(b, id), data...)
+	_, err := w.Write(b)
+	return errors.WithStack(err)
+}
+
+// appendString appends a string prefixed with its length.
+func appendString(b []byte, s []byte) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
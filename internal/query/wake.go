@@ -0,0 +1,49 @@
+package query
+
+import "bytes"
+
+// Network returns the network, "tcp" or "udp", that clients of the protocol use
+// to connect to the game port of a server, or an empty string if the protocol is
+// not known.
+func Network(protocol string) string {
+	switch protocol {
+	case ProtocolSLP, ProtocolMinecraft:
+		// Both are used by Minecraft Java Edition servers, which players connect to
+		// over TCP using the handshake read by ReadHandshake.
+		return "tcp"
+	case ProtocolA2S, ProtocolBedrock:
+		return "udp"
+	}
+	return ""
+}
+
+// IsClientPacket returns whether a packet sent to the game port of a server over
+// UDP is a request a client of the protocol sends before joining it, such as a
+// query for its status or the start of a connection.
+func IsClientPacket(protocol string, p []byte) bool {
+	switch protocol {
+	case ProtocolA2S:
+		if len(p) < 5 || !bytes.HasPrefix(p, a2sHeader) {
+			return false
+		}
+		switch p[4] {
+		case 'T':
+			return bytes.HasPrefix(p[5:], []byte("Source Engine Query\x00"))
+		case 'U', 'V':
+			// A2S_PLAYER and A2S_RULES are followed by a challenge.
+			return len(p) == 9
+		}
+	case ProtocolBedrock:
+		switch {
+		case (len(p) == 33 || len(p) == 25) && (p[0] == 0x01 || p[0] == 0x02):
+			// An unconnected ping, with the time of the ping and the magic bytes,
+			// optionally followed by the GUID of the client.
+			return bytes.Equal(p[9:25], raknetMagic)
+		case len(p) >= 18 && p[0] == 0x05:
+			// An open connection request, with the magic bytes and protocol version,
+			// padded to the size of the MTU.
+			return bytes.Equal(p[1:17], raknetMagic)
+		}
+	}
+	return false
+}
@@ -25,6 +25,9 @@ var (
 	// ErrNotRegular is an error for when an operation that operates only on
 	// regular files is passed something other than a regular file.
 	ErrNotRegular = errors.New("not a regular file")
+	// ErrQuotaExceeded is an error for when a write would cause a filesystem
+	// to exceed its quota.
+	ErrQuotaExceeded = errors.New("quota exceeded")
 
 	// ErrClosed is an error for when an entry was accessed after being closed.
 	ErrClosed = iofs.ErrClosed
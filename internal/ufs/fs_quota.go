@@ -30,6 +30,11 @@ type Quota struct {
 	//
 	// If usage is set to `-1`, it hasn't been calculated yet.
 	usage atomic.Int64
+
+	// added is the total of every adjustment made to the usage with Add, which
+	// lets anything else tracking changes to the filesystem tell apart the
+	// changes that have already been accounted for.
+	added atomic.Int64
 }
 
 // NewQuota creates a new Quota filesystem using an existing UnixFS and a limit.
@@ -65,8 +70,14 @@ func (fs *Quota) SetUsage(newUsage int64) int64 {
 	return fs.usage.Swap(newUsage)
 }
 
+// Added returns the total of every adjustment made to the usage with Add.
+func (fs *Quota) Added() int64 {
+	return fs.added.Load()
+}
+
 // Add adds `i` to the tracked usage total.
 func (fs *Quota) Add(i int64) int64 {
+	fs.added.Add(i)
 	usage := fs.Usage()
 
 	// If adding `i` to the usage will put us below 0, cap it. (`i` can be negative)
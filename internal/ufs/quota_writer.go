@@ -4,8 +4,12 @@
 package ufs
 
 import (
+	"errors"
 	"io"
+	"sync"
 	"sync/atomic"
+
+	"golang.org/x/sys/unix"
 )
 
 // CountedWriter is a writer that counts the amount of data written to the
@@ -113,3 +117,139 @@ func (r *CountedReader) Read(p []byte) (int, error) {
 	}
 	return n, nil
 }
+
+// QuotaWriter is a writer that accounts every byte written to the underlying
+// file against the usage of a [Quota] filesystem as it is written. Any write
+// that would grow the file past the limit of the filesystem fails with
+// [ErrQuotaExceeded] before anything is written to the disk.
+//
+// Only growth of the file is accounted, overwriting existing data within the
+// file does not change the usage of the filesystem.
+type QuotaWriter struct {
+	File
+
+	fs *Quota
+
+	mu     sync.Mutex
+	size   int64
+	offset int64
+	append bool
+}
+
+var (
+	_ io.Writer   = (*QuotaWriter)(nil)
+	_ io.WriterAt = (*QuotaWriter)(nil)
+)
+
+// NewQuotaWriter returns a new QuotaWriter for the file. The file must have
+// been opened from the given filesystem, and the current size of the file is
+// assumed to already be accounted for in its usage.
+func NewQuotaWriter(fs *Quota, f File) (*QuotaWriter, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return nil, NewSyscallError("fcntl", err)
+	}
+	return &QuotaWriter{File: f, fs: fs, size: st.Size(), offset: offset, append: flags&O_APPEND != 0}, nil
+}
+
+// Write writes bytes to the underlying file at the current offset.
+func (w *QuotaWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Writes to a file opened for appending always happen at the end of the
+	// file, regardless of the current offset.
+	if w.append {
+		w.offset = w.size
+	}
+	if err := w.reserve(w.offset, len(p)); err != nil {
+		return 0, err
+	}
+	n, err := w.File.Write(p)
+	w.commit(w.offset, n)
+	w.offset += int64(n)
+	return n, quotaError(err)
+}
+
+// WriteAt writes bytes to the underlying file at the given offset.
+func (w *QuotaWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.reserve(off, len(p)); err != nil {
+		return 0, err
+	}
+	n, err := w.File.WriteAt(p, off)
+	w.commit(off, n)
+	return n, quotaError(err)
+}
+
+// ReadFrom copies the reader into the file in chunks so that every chunk is
+// checked against the quota, rather than letting the underlying file copy
+// everything at once.
+func (w *QuotaWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// Seek sets the offset for the next Write.
+func (w *QuotaWriter) Seek(offset int64, whence int) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	o, err := w.File.Seek(offset, whence)
+	if err == nil {
+		w.offset = o
+	}
+	return o, err
+}
+
+// Truncate changes the size of the file, releasing or accounting for the
+// difference in size.
+func (w *QuotaWriter) Truncate(size int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if size > w.size && !w.fs.CanFit(size-w.size) {
+		return ErrQuotaExceeded
+	}
+	if err := w.File.Truncate(size); err != nil {
+		return quotaError(err)
+	}
+	w.fs.Add(size - w.size)
+	w.size = size
+	return nil
+}
+
+// reserve checks that writing n bytes at the offset would not exceed the
+// quota of the filesystem.
+func (w *QuotaWriter) reserve(off int64, n int) error {
+	if grow := off + int64(n) - w.size; grow > 0 && !w.fs.CanFit(grow) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// commit accounts for n bytes having been written at the offset.
+func (w *QuotaWriter) commit(off int64, n int) {
+	if grow := off + int64(n) - w.size; grow > 0 {
+		w.fs.Add(grow)
+		w.size += grow
+	}
+}
+
+// quotaError converts errors returned by the kernel when a disk quota, such as a
+// project quota on the directory, has been exceeded into ErrQuotaExceeded.
+func quotaError(err error) error {
+	if errors.Is(err, unix.EDQUOT) {
+		return ErrQuotaExceeded
+	}
+	return err
+}
@@ -28,12 +28,14 @@ type Client interface {
 	ResetServersState(ctx context.Context) error
 	SetArchiveStatus(ctx context.Context, uuid string, successful bool) error
 	SetBackupStatus(ctx context.Context, backup string, data BackupRequest) error
+	RegisterScheduledBackup(ctx context.Context, uuid string, data ScheduledBackupRequest) error
 	SendRestorationStatus(ctx context.Context, backup string, successful bool) error
 	SetInstallationStatus(ctx context.Context, uuid string, data InstallStatusRequest) error
 	SetTransferStatus(ctx context.Context, uuid string, successful bool) error
 	ValidateSftpCredentials(ctx context.Context, request SftpAuthRequest) (SftpAuthResponse, error)
 	SendActivityLogs(ctx context.Context, activity []models.Activity) error
 	PushServerStateChange(ctx context.Context, sid string, stateChange ServerStateChange) error
+	SendCrashReport(ctx context.Context, uuid string, report models.CrashReport) error
 }
 
 type client struct {
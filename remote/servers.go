@@ -168,6 +168,17 @@ func (c *client) SetBackupStatus(ctx context.Context, backup string, data Backup
 	return nil
 }
 
+// RegisterScheduledBackup tells the Panel about a backup that a schedule is about
+// to create. The backup is only created if the Panel accepts it.
+func (c *client) RegisterScheduledBackup(ctx context.Context, uuid string, data ScheduledBackupRequest) error {
+	resp, err := c.Post(ctx, fmt.Sprintf("/servers/%s/backups", uuid), data)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
 // SendRestorationStatus triggers a request to the Panel to notify it that a
 // restoration has been completed and the server should be marked as being
 // activated again.
@@ -221,3 +232,13 @@ func (c *client) PushServerStateChange(ctx context.Context, sid string, sc Serve
 	_ = resp.Body.Close()
 	return nil
 }
+
+// SendCrashReport sends the report of a server crash to the Panel.
+func (c *client) SendCrashReport(ctx context.Context, uuid string, report models.CrashReport) error {
+	resp, err := c.Post(ctx, fmt.Sprintf("/servers/%s/crashes", uuid), d{"data": report})
+	if err != nil {
+		return errors.WithStackIf(err)
+	}
+	_ = resp.Body.Close()
+	return nil
+}
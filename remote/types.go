@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/goccy/go-json"
 
+	"github.com/Minenetpro/pelican-wings/internal/acl"
 	"github.com/Minenetpro/pelican-wings/parser"
 )
 
@@ -84,11 +86,13 @@ type SftpAuthRequest struct {
 // SftpAuthResponse is returned by the Panel when a pair of SFTP credentials
 // is successfully validated. This will include the specific server that was
 // matched as well as the permissions that are assigned to the authenticated
-// user for the SFTP subsystem.
+// user for the SFTP subsystem, and any rules limiting the user to specific
+// paths within the server's data directory.
 type SftpAuthResponse struct {
-	Server      string   `json:"server"`
-	User        string   `json:"user"`
-	Permissions []string `json:"permissions"`
+	Server      string    `json:"server"`
+	User        string    `json:"user"`
+	Permissions []string  `json:"permissions"`
+	PathRules   acl.Rules `json:"path_rules"`
 }
 
 type OutputLineMatcher struct {
@@ -112,6 +116,11 @@ func (olm *OutputLineMatcher) String() string {
 	return string(olm.raw)
 }
 
+// MarshalJSON marshals the matcher back into its raw comparison string.
+func (olm *OutputLineMatcher) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(olm.raw))
+}
+
 // UnmarshalJSON unmarshals the startup lines into individual structs for easier
 // matching abilities.
 func (olm *OutputLineMatcher) UnmarshalJSON(data []byte) error {
@@ -136,6 +145,107 @@ func (olm *OutputLineMatcher) UnmarshalJSON(data []byte) error {
 type ProcessStopConfiguration struct {
 	Type  string `json:"type"`
 	Value string `json:"value"`
+
+	// Steps is an optional stop policy for the instance. When it is set the steps
+	// are run in order when the instance is stopped, until the process exits,
+	// rather than only using the type and value above.
+	Steps []ProcessStopStep `json:"steps,omitempty"`
+}
+
+// ProcessStopStep is a single step of a stop policy. A command is sent to the
+// process, or a signal is sent to it, and then Wings waits for the process to
+// exit before moving on to the next step.
+type ProcessStopStep struct {
+	// The type of step, either "command" or "signal".
+	Type string `json:"type"`
+	// The command or signal to send to the process.
+	Value string `json:"value"`
+	// The number of seconds to wait for the process to exit after this step,
+	// defaulting to 30 seconds.
+	Timeout int `json:"timeout"`
+}
+
+// Wait returns how long to wait for the process to exit after the step is run.
+func (s ProcessStopStep) Wait() time.Duration {
+	if s.Timeout <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(s.Timeout) * time.Second
+}
+
+// String returns a description of the step for the server console.
+func (s ProcessStopStep) String() string {
+	if s.Type == ProcessStopSignal {
+		return "sending " + strings.ToUpper(s.Value) + " to process"
+	}
+	return "sending command \"" + s.Value + "\""
+}
+
+// The types of health check that can be run against a server instance.
+const (
+	HealthCheckTCP     = "tcp"
+	HealthCheckQuery   = "query"
+	HealthCheckCommand = "command"
+	HealthCheckExec    = "exec"
+)
+
+// HealthCheck defines how to check that a running instance is still responding,
+// rather than only that its process is alive. The check is run periodically once
+// the instance has finished starting.
+type HealthCheck struct {
+	// The type of check, one of "tcp", "query", "command" or "exec". A "tcp" check
+	// connects to the default allocation of the instance, a "query" check queries
+	// it using Protocol, a "command" check sends Command to the console and waits
+	// for a line matching Expect, and an "exec" check runs Command inside of the
+	// instance and checks that it exits successfully.
+	Type string `json:"type"`
+	// The query protocol used by a "query" check, either "a2s" or "minecraft".
+	Protocol string `json:"protocol,omitempty"`
+	// The port to check, if it is not the port of the default allocation.
+	Port int `json:"port,omitempty"`
+	// The console command sent by a "command" check, or the shell command run by
+	// an "exec" check.
+	Command string `json:"command,omitempty"`
+	// The output that must be seen for the check to pass. This is required by a
+	// "command" check, and optional for an "exec" check.
+	Expect *OutputLineMatcher `json:"expect,omitempty"`
+
+	// The number of seconds between checks, defaulting to 30 seconds.
+	Interval int `json:"interval"`
+	// The number of seconds a check may take before it fails, defaulting to 5
+	// seconds.
+	Timeout int `json:"timeout"`
+	// The number of consecutive failed checks before the instance is marked as
+	// unhealthy, defaulting to 3.
+	Retries int `json:"retries"`
+	// The number of consecutive failed checks before the instance is restarted. If
+	// this is 0 the instance is never restarted.
+	RestartAfter int `json:"restart_after"`
+}
+
+// Every returns how long to wait between checks.
+func (h HealthCheck) Every() time.Duration {
+	if h.Interval <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(h.Interval) * time.Second
+}
+
+// Wait returns how long a check may take before it fails.
+func (h HealthCheck) Wait() time.Duration {
+	if h.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(h.Timeout) * time.Second
+}
+
+// FailureThreshold returns the number of consecutive failed checks before the
+// instance is marked as unhealthy.
+func (h HealthCheck) FailureThreshold() int {
+	if h.Retries <= 0 {
+		return 3
+	}
+	return h.Retries
 }
 
 // ProcessConfiguration defines the process configuration for a given server
@@ -150,6 +260,21 @@ type ProcessConfiguration struct {
 	} `json:"startup"`
 	Stop               ProcessStopConfiguration   `json:"stop"`
 	ConfigurationFiles []parser.ConfigurationFile `json:"configs"`
+
+	// Health is an optional health check for the instance.
+	Health *HealthCheck `json:"health,omitempty"`
+
+	// Query is the optional query protocol the instance can be queried with for
+	// its status, such as the number of players online.
+	Query *QueryConfiguration `json:"query,omitempty"`
+}
+
+// QueryConfiguration defines how to query an instance for its status.
+type QueryConfiguration struct {
+	// The query protocol, one of "a2s", "minecraft", "slp" or "bedrock".
+	Protocol string `json:"protocol"`
+	// The port to query, if it is not the port of the default allocation.
+	Port int `json:"port,omitempty"`
 }
 
 type BackupRemoteUploadResponse struct {
@@ -170,6 +295,14 @@ type BackupRequest struct {
 	Parts        []BackupPart `json:"parts"`
 }
 
+// ScheduledBackupRequest is sent to the Panel before a schedule creates a backup,
+// so that the Panel has a record of the backup to update once it completes.
+type ScheduledBackupRequest struct {
+	Uuid     string `json:"uuid"`
+	Adapter  string `json:"adapter"`
+	Schedule int    `json:"schedule"`
+}
+
 type InstallStatusRequest struct {
 	Successful bool `json:"successful"`
 	Reinstall  bool `json:"reinstall"`
@@ -2,7 +2,11 @@ package downloader
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"net"
@@ -82,6 +86,7 @@ var instance = &Downloader{
 	// primarily used to make things quicker and keep the code a little more
 	// legible throughout here.
 	serverCache: make(map[string][]string),
+	active:      make(map[string]struct{}),
 }
 
 // Internal IP ranges that should be blocked if the resource requested resolves within.
@@ -100,10 +105,18 @@ const (
 	ErrInternalResolution = errors.Sentinel("downloader: destination resolves to internal network location")
 	ErrInvalidIPAddress   = errors.Sentinel("downloader: invalid IP address")
 	ErrDownloadFailed     = errors.Sentinel("downloader: download request failed")
+	ErrChecksumMismatch   = errors.Sentinel("downloader: checksum of downloaded file does not match")
+	ErrRemoteFileChanged  = errors.Sentinel("downloader: remote file changed while it was being downloaded")
+	ErrDownloadInProgress = errors.Sentinel("downloader: the same file is already being downloaded")
+	ErrPathNotAllowed     = errors.Sentinel("downloader: file cannot be written to the path it would be downloaded to")
 )
 
 const defaultMaxRedirects = 10
 
+// The number of times a download will be resumed from where it left off when
+// the connection is interrupted before giving up.
+const maxResumeAttempts = 3
+
 type Counter struct {
 	total   int
 	onWrite func(total int)
@@ -121,6 +134,23 @@ type DownloadRequest struct {
 	URL       *url.URL
 	FileName  string
 	UseHeader bool
+	// Headers are additional headers sent along with the request, such as an
+	// Authorization header for sources that require authentication. They are
+	// only sent to the host of the original URL, and are dropped if a redirect
+	// points to a different host or from https to http.
+	Headers map[string]string
+	// Checksum is the expected hex encoded hash of the file, and ChecksumType the
+	// algorithm used to generate it ("sha256" or "sha1"). When set, the file is
+	// only moved into place if the downloaded contents match.
+	Checksum     string
+	ChecksumType string
+	// Extract unpacks the downloaded archive into the directory it was downloaded
+	// to, and then removes the archive.
+	Extract bool
+	// Allows, when set, is called with the path the file will be written to once it
+	// is known, which may depend on the response of the remote server. Nothing is
+	// written if it returns false.
+	Allows func(p string) bool
 }
 
 type Download struct {
@@ -177,85 +207,286 @@ func (dl Download) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// NewHash returns the hash implementation for the given checksum type, or an
+// error if the type is not supported.
+func NewHash(checksumType string) (hash.Hash, error) {
+	switch strings.ToLower(checksumType) {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	default:
+		return nil, errors.New("downloader: unsupported checksum type: " + checksumType)
+	}
+}
+
 // Execute executes a given download for the server and begins writing the file to the disk. Once
 // completed the download will be removed from the cache.
+//
+// The file is downloaded to a partial file in the temporary directory, outside the data directory
+// of the server, and is only written to the server once the download has completed and the
+// checksum (if any) has been verified. If a partial file was left by an earlier attempt at the
+// same download, or the connection is interrupted part of the way through, the download is
+// resumed using a range request that is conditional on the remote file not having changed.
 func (dl *Download) Execute() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Hour*12)
 	dl.cancelFunc = &cancel
 	defer dl.Cancel()
 
-	// At this point we have verified the destination is not within the local network, so we can
-	// now make a request to that URL and pull down the file, saving it to the server's data
-	// directory.
-	currentURL := dl.req.URL
-	if currentURL == nil {
+	if dl.req.URL == nil {
 		return errors.New("downloader: download request url is nil")
 	}
 
+	var h hash.Hash
+	if dl.req.Checksum != "" {
+		var err error
+		if h, err = NewHash(dl.req.ChecksumType); err != nil {
+			return err
+		}
+	}
+
+	removeAbandonedPartials()
+	key := dl.key()
+	if !instance.claim(key) {
+		return errors.WithStack(ErrDownloadInProgress)
+	}
+	defer instance.release(key)
+
+	// Pick up where a previous attempt at this download left off, if there is one. The
+	// remote server sends the complete file instead if it has changed since.
+	part, offset := dl.loadPartial()
+	res, finalURL, err := dl.request(ctx, offset, part.validator())
+	if err != nil {
+		return err
+	}
+	if offset > 0 && res.StatusCode == http.StatusPartialContent {
+		if err := checkContentRange(res, offset, part.Size); err != nil {
+			res.Body.Close()
+			return err
+		}
+	} else {
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return errors.New("downloader: got bad response status from endpoint: " + res.Status)
+		}
+		if res.ContentLength < 1 {
+			res.Body.Close()
+			return errors.New("downloader: request is missing ContentLength")
+		}
+		offset = 0
+		if err := part.reset(res); err != nil {
+			res.Body.Close()
+			return err
+		}
+	}
+	total := part.Size
+
+	if err := dl.setPath(res, finalURL); err != nil {
+		res.Body.Close()
+		return err
+	}
+	if dl.req.Allows != nil && !dl.req.Allows(dl.Path()) {
+		res.Body.Close()
+		return errors.WithStack(ErrPathNotAllowed)
+	}
+
+	// The partial file does not count towards the disk usage of the server until it is
+	// written to the server, so make sure it will fit before downloading any of it.
+	fs := dl.server.Filesystem()
+	p := dl.Path()
+	var existing int64
+	if st, err := fs.Stat(p); err == nil && !st.IsDir() {
+		existing = st.Size()
+	}
+	if err := fs.HasSpaceFor(total - existing); err != nil {
+		res.Body.Close()
+		return err
+	}
+
+	f, err := part.open()
+	if err != nil {
+		res.Body.Close()
+		return err
+	}
+	defer f.Close()
+
+	// Any data that has already been downloaded needs to be included in the checksum.
+	if h != nil && offset > 0 {
+		if _, err := io.Copy(h, io.LimitReader(f, offset)); err != nil {
+			res.Body.Close()
+			return errors.Wrap(err, "downloader: failed to read partial download")
+		}
+	}
+
+	dl.server.Log().WithField("path", p).WithField("offset", offset).Debug("writing remote file to disk")
+	counter := dl.counter(total)
+	for attempt := 0; ; attempt++ {
+		if res == nil {
+			if res, err = dl.resume(ctx, offset, part); err != nil {
+				return err
+			}
+		}
+
+		var w io.Writer = counter
+		if h != nil {
+			w = io.MultiWriter(counter, h)
+		}
+		counter.total = int(offset)
+		if _, err = f.Seek(offset, io.SeekStart); err == nil {
+			var n int64
+			n, err = io.Copy(f, io.TeeReader(io.LimitReader(res.Body, total-offset), w))
+			offset += n
+		}
+		res.Body.Close()
+		res = nil
+		if err == nil && offset < total {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil {
+			break
+		}
+		// Only attempt to resume when the connection was interrupted, not when the
+		// download was canceled.
+		if ctx.Err() != nil || attempt >= maxResumeAttempts || part.validator() == "" {
+			return errors.WrapIf(err, "downloader: failed to download remote file")
+		}
+		dl.server.Log().WithField("path", p).WithField("offset", offset).WithField("error", err).
+			Warn("remote file download was interrupted, attempting to resume")
+	}
+
+	if h != nil {
+		if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, dl.req.Checksum) {
+			part.remove()
+			return errors.WithStack(ErrChecksumMismatch)
+		}
+	}
+
+	// Write the completed download to the server, replacing any existing file. Write
+	// accounts for the disk usage of the file being replaced.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "downloader: failed to read partial download")
+	}
+	if err := fs.Write(p, f, total, 0o644); err != nil {
+		return errors.WrapIf(err, "downloader: failed to write file to server directory")
+	}
+	part.remove()
+
+	if dl.req.Extract {
+		dir, name := filepath.Split(p)
+		if err := fs.SpaceAvailableForDecompression(ctx, dir, name); err != nil {
+			return errors.WrapIf(err, "downloader: failed to extract downloaded file")
+		}
+		if err := fs.DecompressFile(ctx, dir, name, nil); err != nil {
+			return errors.WrapIf(err, "downloader: failed to extract downloaded file")
+		}
+		if err := fs.Delete(p); err != nil {
+			return errors.WrapIf(err, "downloader: failed to remove extracted archive")
+		}
+	}
+	return nil
+}
+
+// request performs a GET request against the download URL, following any redirects
+// up to the configured limit. If offset is greater than zero a range request is made
+// for the remainder of the file, which the remote server only honors if the file
+// still matches the validator. The final URL that was requested is returned along
+// with the response.
+func (dl *Download) request(ctx context.Context, offset int64, validator string) (*http.Response, *url.URL, error) {
+	currentURL := dl.req.URL
 	visited := make(map[string]struct{})
-	var res *http.Response
-	var finalURL *url.URL
 
 	maxRedirects := maxRedirectAttempts()
 	for redirects := 0; redirects < maxRedirects; redirects++ {
 		urlStr := currentURL.String()
 		if _, seen := visited[urlStr]; seen {
-			return errors.New("downloader: detected redirect loop")
+			return nil, nil, errors.New("downloader: detected redirect loop")
 		}
 		visited[urlStr] = struct{}{}
 
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
 		if err != nil {
-			return errors.WrapIf(err, "downloader: failed to create request")
+			return nil, nil, errors.WrapIf(err, "downloader: failed to create request")
 		}
 
+		// Never leak credentials meant for the original host to wherever we have been
+		// redirected to, or send them in plain text if they were meant to be encrypted.
+		if currentURL.Host == dl.req.URL.Host && currentURL.Scheme == dl.req.URL.Scheme {
+			for k, v := range dl.req.Headers {
+				req.Header.Set(k, v)
+			}
+		}
 		req.Header.Set("User-Agent", "Pelican Panel (https://pelican.dev)")
-		res, err = client.Do(req)
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", validator)
+		}
+		res, err := client.Do(req)
 		if err != nil {
-			return errors.WrapIf(err, "downloader: failed to perform request")
+			return nil, nil, errors.WrapIf(err, "downloader: failed to perform request")
 		}
 
 		if res.StatusCode >= http.StatusMultipleChoices && res.StatusCode < http.StatusBadRequest {
 			location := res.Header.Get("Location")
 			res.Body.Close()
 			if location == "" {
-				return errors.New("downloader: redirect response missing location header")
+				return nil, nil, errors.New("downloader: redirect response missing location header")
 			}
 
 			nextURL, err := currentURL.Parse(location)
 			if err != nil {
-				return errors.WrapIf(err, "downloader: invalid redirect location")
+				return nil, nil, errors.WrapIf(err, "downloader: invalid redirect location")
 			}
 			if nextURL.Scheme != "http" && nextURL.Scheme != "https" {
-				return errors.New("downloader: redirect to unsupported scheme")
+				return nil, nil, errors.New("downloader: redirect to unsupported scheme")
 			}
 
 			currentURL = nextURL
-			finalURL = nextURL
 			continue
 		}
 
-		finalURL = currentURL
-		break
+		return res, currentURL, nil
 	}
 
-	if res == nil {
-		return errors.New("downloader: exceeded maximum redirect attempts")
-	}
-	defer res.Body.Close()
+	return nil, nil, errors.New("downloader: exceeded maximum redirect attempts")
+}
 
-	if res.StatusCode >= http.StatusMultipleChoices && res.StatusCode < http.StatusBadRequest {
-		return errors.New("downloader: exceeded maximum redirect attempts")
+// resume requests the remainder of the file starting at the given offset. An error
+// is returned if the remote server does not respond with the expected range, which
+// is also the case if the file has changed since the download was started.
+func (dl *Download) resume(ctx context.Context, offset int64, part *partial) (*http.Response, error) {
+	res, _, err := dl.request(ctx, offset, part.validator())
+	if err != nil {
+		return nil, err
 	}
-
-	if res.StatusCode != http.StatusOK {
-		return errors.New("downloader: got bad response status from endpoint: " + res.Status)
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			part.remove()
+			return nil, errors.WithStack(ErrRemoteFileChanged)
+		}
+		return nil, errors.New("downloader: remote server did not honor range request: " + res.Status)
 	}
+	if err := checkContentRange(res, offset, part.Size); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res, nil
+}
 
-	if res.ContentLength < 1 {
-		return errors.New("downloader: request is missing ContentLength")
+// checkContentRange checks that a response to a range request contains the rest of
+// the file from the offset.
+func checkContentRange(res *http.Response, offset, total int64) error {
+	expected := fmt.Sprintf("bytes %d-%d/%d", offset, total-1, total)
+	if cr := res.Header.Get("Content-Range"); cr != expected {
+		return errors.New("downloader: unexpected content range in response: " + cr)
 	}
+	return nil
+}
 
+// setPath determines the name of the file being downloaded, either from the
+// Content-Disposition header of the response, the requested file name, or the
+// last segment of the URL path.
+func (dl *Download) setPath(res *http.Response, finalURL *url.URL) error {
 	if dl.req.UseHeader {
 		if contentDisposition := res.Header.Get("Content-Disposition"); contentDisposition != "" {
 			_, params, err := mime.ParseMediaType(contentDisposition)
@@ -272,29 +503,15 @@ func (dl *Download) Execute() error {
 		if dl.req.FileName != "" {
 			dl.path = dl.req.FileName
 		} else {
-			pathSource := dl.req.URL
-			if finalURL != nil {
-				pathSource = finalURL
-			}
-			parts := strings.Split(pathSource.Path, "/")
+			parts := strings.Split(finalURL.Path, "/")
 			dl.path = parts[len(parts)-1]
 		}
 	}
-
-	p := dl.Path()
-	dl.server.Log().WithField("path", p).Debug("writing remote file to disk")
-
-	// Write the file while tracking the progress, Write will check that the
-	// size of the file won't exceed the disk limit.
-	r := io.TeeReader(res.Body, dl.counter(res.ContentLength))
-	if err := dl.server.Filesystem().Write(p, r, res.ContentLength, 0o644); err != nil {
-		return errors.WrapIf(err, "downloader: failed to write file to server directory")
-	}
 	return nil
 }
 
 // Cancel cancels a running download and frees up the associated resources. If a file is being
-// written a partial file will remain present on the disk.
+// downloaded the partial file is kept, so that the download can be resumed later.
 func (dl *Download) Cancel() {
 	if dl.cancelFunc != nil {
 		(*dl.cancelFunc)()
@@ -338,6 +555,8 @@ type Downloader struct {
 	mu            sync.RWMutex
 	downloadCache map[string]*Download
 	serverCache   map[string][]string
+	// The keys of the downloads currently writing to a partial file.
+	active map[string]struct{}
 }
 
 // track tracks a download in the internal cache for this instance.
@@ -354,6 +573,25 @@ func (d *Downloader) track(dl *Download) {
 	}
 }
 
+// claim marks the partial file of a download as being written to, returning false
+// if another download is already writing to it.
+func (d *Downloader) claim(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.active[key]; ok {
+		return false
+	}
+	d.active[key] = struct{}{}
+	return true
+}
+
+// release marks the partial file of a download as no longer being written to.
+func (d *Downloader) release(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.active, key)
+}
+
 // find finds a given download entry using the provided ID and returns it.
 func (d *Downloader) find(dlid string) *Download {
 	d.mu.RLock()
@@ -0,0 +1,253 @@
+package downloader_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/harness"
+	"github.com/Minenetpro/pelican-wings/router/downloader"
+	"github.com/Minenetpro/pelican-wings/server"
+)
+
+const uuid = "5d0f5a63-2b1c-4f7e-9a4d-3c8e1b6f2a90"
+
+// The contents of the remote file, which is large enough to be interrupted
+// part of the way through.
+var content = bytes.Repeat([]byte("pelican!"), 4096)
+
+// remote serves the contents of a file and records the requests made for it.
+// The first interrupt requests fail, with requests for the whole file receiving
+// half of it before the connection is closed.
+type remote struct {
+	mu        sync.Mutex
+	etag      string
+	interrupt int
+	requests  []*http.Request
+}
+
+func (r *remote) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	etag := r.etag
+	interrupt := r.interrupt > 0
+	r.interrupt--
+	r.mu.Unlock()
+
+	w.Header().Set("ETag", etag)
+	if !interrupt {
+		http.ServeContent(w, req, "file.jar", time.Time{}, bytes.NewReader(content))
+		return
+	}
+	if req.Header.Get("Range") != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", "32768")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content[:len(content)/2])
+	w.(http.Flusher).Flush()
+	conn, _, _ := w.(http.Hijacker).Hijack()
+	_ = conn.Close()
+}
+
+func (r *remote) Requests() []*http.Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+func TestDownload(t *testing.T) {
+	g := Goblin(t)
+	downloader.AllowInternalRequests()
+
+	var h *harness.Harness
+	var s *server.Server
+	var r *remote
+	var srv *httptest.Server
+
+	download := func(req downloader.DownloadRequest) error {
+		if req.URL == nil {
+			req.URL, _ = url.Parse(srv.URL + "/file.jar")
+		}
+		req.Directory = "/"
+		return downloader.New(s, req).Execute()
+	}
+	contents := func(p string) string {
+		f, _, err := s.Filesystem().File(p)
+		if err != nil {
+			return err.Error()
+		}
+		defer f.Close()
+		b, _ := io.ReadAll(f)
+		return string(b)
+	}
+
+	g.Describe("Download", func() {
+		g.BeforeEach(func() {
+			var err error
+			if h, err = harness.New(t.TempDir()); err != nil {
+				g.Fail(err)
+			}
+			if s, _, err = h.AddServer(uuid, `{}`); err != nil {
+				g.Fail(err)
+			}
+			r = &remote{etag: `"v1"`}
+			srv = httptest.NewServer(r)
+		})
+
+		g.AfterEach(func() {
+			srv.Close()
+			h.Close()
+		})
+
+		g.It("downloads a file", func() {
+			g.Assert(download(downloader.DownloadRequest{})).IsNil()
+			g.Assert(contents("file.jar") == string(content)).IsTrue()
+			g.Assert(len(r.Requests())).Equal(1)
+
+			// The partial download is removed once it has been written to the server.
+			partials, _ := os.ReadDir(filepath.Join(config.Get().System.TmpDirectory, "downloads", uuid))
+			g.Assert(len(partials)).Equal(0)
+		})
+
+		g.It("only sends headers to the original host", func() {
+			redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				g.Assert(req.Header.Get("Authorization")).Equal("Bearer secret")
+				http.Redirect(w, req, srv.URL+"/file.jar", http.StatusFound)
+			}))
+			defer redirect.Close()
+
+			u, _ := url.Parse(redirect.URL + "/latest")
+			err := download(downloader.DownloadRequest{URL: u, FileName: "file.jar", Headers: map[string]string{"Authorization": "Bearer secret"}})
+			g.Assert(err).IsNil()
+			g.Assert(contents("file.jar") == string(content)).IsTrue()
+
+			requests := r.Requests()
+			g.Assert(len(requests)).Equal(1)
+			g.Assert(requests[0].Header.Get("Authorization")).Equal("")
+			g.Assert(requests[0].Header.Get("User-Agent")).Equal("Pelican Panel (https://pelican.dev)")
+		})
+
+		g.It("does not send headers after a redirect from https to http", func() {
+			secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				g.Assert(req.Header.Get("Authorization")).Equal("Bearer secret")
+				http.Redirect(w, req, "http://"+req.Host+"/file.jar", http.StatusFound)
+			}))
+			defer secure.Close()
+
+			// Plain requests are sent to the remote instead, as if it was served on the
+			// same host as the secure server.
+			transport := secure.Client().Transport.(*http.Transport).Clone()
+			transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+			}
+			transport.DialTLSContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := tls.Dialer{Config: transport.TLSClientConfig}
+				return d.DialContext(ctx, network, secure.Listener.Addr().String())
+			}
+			downloader.UseTransport(transport)
+			defer downloader.AllowInternalRequests()
+
+			u, _ := url.Parse(secure.URL + "/latest")
+			err := download(downloader.DownloadRequest{URL: u, FileName: "file.jar", Headers: map[string]string{"Authorization": "Bearer secret"}})
+			g.Assert(err).IsNil()
+
+			requests := r.Requests()
+			g.Assert(len(requests)).Equal(1)
+			g.Assert(requests[0].Host).Equal(u.Host)
+			g.Assert(requests[0].Header.Get("Authorization")).Equal("")
+		})
+
+		g.It("does not write a file to a path that is not allowed", func() {
+			_ = s.Filesystem().Write("server.properties", strings.NewReader("existing"), 8, 0o644)
+
+			u, _ := url.Parse(srv.URL + "/server.properties")
+			err := download(downloader.DownloadRequest{URL: u, Allows: func(p string) bool {
+				return p != "/server.properties"
+			}})
+			g.Assert(errors.Is(err, downloader.ErrPathNotAllowed)).IsTrue()
+			g.Assert(contents("server.properties")).Equal("existing")
+		})
+
+		g.It("does not write a file that does not match the checksum", func() {
+			sum := sha256.Sum256(content)
+			g.Assert(download(downloader.DownloadRequest{Checksum: hex.EncodeToString(sum[:]), ChecksumType: "sha256"})).IsNil()
+
+			err := download(downloader.DownloadRequest{FileName: "other.jar", Checksum: strings.Repeat("0", 64), ChecksumType: "sha256"})
+			g.Assert(errors.Is(err, downloader.ErrChecksumMismatch)).IsTrue()
+			_, err = s.Filesystem().Stat("other.jar")
+			g.Assert(err == nil).IsFalse()
+		})
+
+		g.It("resumes an interrupted download if the file has not changed", func() {
+			r.interrupt = 1
+			g.Assert(s.Filesystem().Writefile("file.jar.part", strings.NewReader("mine"))).IsNil()
+
+			g.Assert(download(downloader.DownloadRequest{})).IsNil()
+			g.Assert(contents("file.jar") == string(content)).IsTrue()
+
+			requests := r.Requests()
+			g.Assert(len(requests)).Equal(2)
+			g.Assert(requests[0].Header.Get("Range")).Equal("")
+			g.Assert(requests[1].Header.Get("Range")).Equal("bytes=16384-")
+			g.Assert(requests[1].Header.Get("If-Range")).Equal(`"v1"`)
+
+			// Partial downloads are not stored with the files of the server.
+			g.Assert(contents("file.jar.part")).Equal("mine")
+		})
+
+		g.It("resumes a download left by an earlier attempt", func() {
+			r.interrupt = 2
+			err := download(downloader.DownloadRequest{})
+			g.Assert(err == nil).IsFalse()
+			_, err = s.Filesystem().Stat("file.jar")
+			g.Assert(err == nil).IsFalse()
+
+			r.mu.Lock()
+			r.interrupt, r.requests = 0, nil
+			r.mu.Unlock()
+			g.Assert(download(downloader.DownloadRequest{})).IsNil()
+			g.Assert(contents("file.jar") == string(content)).IsTrue()
+
+			// The download is resumed by the first request, without requesting the whole
+			// file beforehand.
+			requests := r.Requests()
+			g.Assert(len(requests)).Equal(1)
+			g.Assert(requests[0].Header.Get("Range")).Equal("bytes=16384-")
+		})
+
+		g.It("starts over if the file has changed", func() {
+			r.interrupt = 2
+			g.Assert(download(downloader.DownloadRequest{}) == nil).IsFalse()
+
+			r.mu.Lock()
+			r.interrupt, r.requests, r.etag = 0, nil, `"v2"`
+			r.mu.Unlock()
+			g.Assert(download(downloader.DownloadRequest{})).IsNil()
+			g.Assert(contents("file.jar") == string(content)).IsTrue()
+
+			requests := r.Requests()
+			g.Assert(len(requests)).Equal(1)
+			g.Assert(requests[0].Header.Get("If-Range")).Equal(`"v1"`)
+		})
+	})
+}
@@ -0,0 +1,15 @@
+package downloader
+
+import "net/http"
+
+// AllowInternalRequests lets downloads be made to servers listening on the
+// loopback interface, which is where the servers started by the tests listen.
+func AllowInternalRequests() {
+	client.Transport = http.DefaultTransport
+}
+
+// UseTransport makes downloads using the given transport, until
+// AllowInternalRequests is called again.
+func UseTransport(t http.RoundTripper) {
+	client.Transport = t
+}
@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/goccy/go-json"
+
+	"github.com/Minenetpro/pelican-wings/config"
+)
+
+// Partial downloads that have not been written to in this long are assumed to
+// have been abandoned, and are removed. This is longer than the timeout of a
+// download, so a partial file is never removed while it is being downloaded.
+const partialExpiry = time.Hour * 24
+
+// partial is a download that has not been completed yet. The data downloaded so
+// far is kept in the temporary directory of Wings, outside the data directory of
+// the server, along with the details needed to check that the remote file has not
+// changed before the download is resumed.
+type partial struct {
+	path string
+
+	// Size is the size of the complete file.
+	Size int64 `json:"size"`
+	// ETag and LastModified are the validators of the remote file returned when the
+	// download was started.
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+}
+
+// partialDirectory returns the directory in which the partial downloads of a
+// server are stored.
+func partialDirectory(sid string) string {
+	return filepath.Join(config.Get().System.TmpDirectory, "downloads", sid)
+}
+
+// key returns a name that identifies the download, so that a download of the same
+// URL to the same location resumes the partial file left by an earlier attempt.
+func (dl *Download) key() string {
+	h := sha256.New()
+	for _, v := range []string{dl.server.ID(), dl.req.URL.String(), dl.req.Directory, dl.req.FileName} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	if dl.req.UseHeader {
+		h.Write([]byte{1})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadPartial returns the partial download for the given download, and the number
+// of bytes that can be resumed from it. Nothing can be resumed if there is no
+// partial file, or the remote file had no validator to check it against.
+func (dl *Download) loadPartial() (*partial, int64) {
+	p := &partial{path: filepath.Join(partialDirectory(dl.server.ID()), dl.key())}
+	b, err := os.ReadFile(p.path + ".json")
+	if err != nil || json.Unmarshal(b, p) != nil || p.validator() == "" {
+		return p, 0
+	}
+	st, err := os.Stat(p.path + ".part")
+	if err != nil || !st.Mode().IsRegular() || st.Size() >= p.Size {
+		return p, 0
+	}
+	return p, st.Size()
+}
+
+// reset starts the partial download over for the remote file in the response.
+func (p *partial) reset(res *http.Response) error {
+	p.Size = res.ContentLength
+	p.ETag, p.LastModified = "", ""
+	if strings.Contains(res.Header.Get("Accept-Ranges"), "bytes") {
+		// A range request cannot be made conditional on a weak ETag, only on the date
+		// the file was last modified.
+		if etag := res.Header.Get("ETag"); !strings.HasPrefix(etag, "W/") {
+			p.ETag = etag
+		}
+		p.LastModified = res.Header.Get("Last-Modified")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o700); err != nil {
+		return errors.Wrap(err, "downloader: failed to create partial download directory")
+	}
+	if err := os.Remove(p.path + ".part"); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "downloader: failed to remove partial download")
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.WriteFile(p.path+".json", b, 0o600); err != nil {
+		return errors.Wrap(err, "downloader: failed to save partial download")
+	}
+	return nil
+}
+
+// validator returns the value of the If-Range header used to resume the download,
+// preferring the ETag of the remote file over the date it was last modified.
+func (p *partial) validator() string {
+	if p.ETag != "" {
+		return p.ETag
+	}
+	return p.LastModified
+}
+
+// open opens the file the partial download is written to.
+func (p *partial) open() (*os.File, error) {
+	f, err := os.OpenFile(p.path+".part", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "downloader: failed to open partial download")
+	}
+	return f, nil
+}
+
+// remove removes the partial download.
+func (p *partial) remove() {
+	for _, ext := range []string{".part", ".json"} {
+		if err := os.Remove(p.path + ext); err != nil && !os.IsNotExist(err) {
+			log.WithField("path", p.path+ext).WithField("error", err).Warn("downloader: failed to remove partial download")
+		}
+	}
+}
+
+// RemovePartials removes all the partial downloads of a server.
+func RemovePartials(sid string) error {
+	if err := os.RemoveAll(partialDirectory(sid)); err != nil {
+		return errors.Wrap(err, "downloader: failed to remove partial downloads")
+	}
+	return nil
+}
+
+// removeAbandonedPartials removes the partial downloads of every server that have
+// not been written to since the expiry.
+func removeAbandonedPartials() {
+	files, err := filepath.Glob(filepath.Join(config.Get().System.TmpDirectory, "downloads", "*", "*"))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if st, err := os.Stat(f); err == nil && time.Since(st.ModTime()) > partialExpiry {
+			_ = os.Remove(f)
+		}
+	}
+}
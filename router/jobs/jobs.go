@@ -0,0 +1,288 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/progress"
+	"github.com/Minenetpro/pelican-wings/server"
+)
+
+// ErrLimitReached is returned when a job cannot be created because the server
+// already has the maximum number of jobs running.
+var ErrLimitReached = errors.Sentinel("jobs: server has reached its limit of running file jobs")
+
+// Type is the kind of file operation being performed by a job.
+type Type string
+
+const (
+	TypeCompress   Type = "compress"
+	TypeDecompress Type = "decompress"
+	TypeCopy       Type = "copy"
+	TypeMove       Type = "move"
+)
+
+// Status is the current state of a job.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+var instance = &Tracker{
+	// Tracks all the active and recently finished jobs.
+	jobCache: make(map[string]*Job),
+	// Tracks all the jobs active for a given server instance.
+	serverCache: make(map[string][]string),
+}
+
+// Job is a long-running file operation being performed for a server in the
+// background, such as compressing an archive or copying a directory.
+type Job struct {
+	Identifier string
+	Type       Type
+
+	mu       sync.RWMutex
+	server   *server.Server
+	status   Status
+	err      error
+	progress *progress.Progress
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// New creates a new tracked job for the server, returning ErrLimitReached if the
+// server already has the maximum number of jobs running. The job's context is
+// derived from the server context so that deleting the server also stops any
+// jobs that are still running for it.
+func New(s *server.Server, t Type) (*Job, error) {
+	ctx, cancel := context.WithCancel(s.Context())
+	j := Job{
+		Identifier: uuid.Must(uuid.NewRandom()).String(),
+		Type:       t,
+		server:     s,
+		status:     StatusPending,
+		progress:   progress.NewProgress(0),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	if !instance.track(&j, config.Get().System.FileJobs.MaxJobs) {
+		cancel()
+		return nil, ErrLimitReached
+	}
+	return &j, nil
+}
+
+// ByServer returns all the tracked jobs for a given server instance, including
+// jobs that have finished recently.
+func ByServer(sid string) []*Job {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	var jobs []*Job
+	if v, ok := instance.serverCache[sid]; ok {
+		for _, id := range v {
+			if j, ok := instance.jobCache[id]; ok {
+				jobs = append(jobs, j)
+			}
+		}
+	}
+	return jobs
+}
+
+// ByID returns a single job matching the given identifier, or nil if there is
+// no job being tracked with that identifier.
+func ByID(id string) *Job {
+	return instance.find(id)
+}
+
+func (j *Job) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Identifier string `json:"identifier"`
+		Type       Type   `json:"type"`
+		Status     Status `json:"status"`
+		Written    uint64 `json:"bytes_processed"`
+		Total      uint64 `json:"bytes_total"`
+	}{
+		Identifier: j.Identifier,
+		Type:       j.Type,
+		Status:     j.Status(),
+		Written:    j.progress.Written(),
+		Total:      j.progress.Total(),
+	})
+}
+
+// Execute runs the provided function for the job, passing along the job's
+// context and progress tracker. Once the function returns a completion event
+// is published to the server event bus. The finished job is kept in the cache
+// for the configured retention period so that its result can be retrieved.
+func (j *Job) Execute(fn func(ctx context.Context, p *progress.Progress) error) error {
+	defer func() {
+		j.cancel()
+		retention := time.Duration(config.Get().System.FileJobs.Retention) * time.Second
+		time.AfterFunc(retention, func() {
+			instance.remove(j.Identifier)
+		})
+	}()
+
+	j.setStatus(StatusRunning, nil)
+	err := fn(j.ctx, j.progress)
+	switch {
+	case err == nil:
+		j.setStatus(StatusCompleted, nil)
+	case errors.Is(err, context.Canceled) || j.ctx.Err() != nil:
+		j.setStatus(StatusCanceled, err)
+	default:
+		j.setStatus(StatusFailed, err)
+	}
+
+	data := map[string]interface{}{
+		"identifier":    j.Identifier,
+		"type":          j.Type,
+		"status":        j.Status(),
+		"is_successful": err == nil,
+		"error":         "",
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	j.server.Events().Publish(server.FileJobCompletedEvent+":"+j.Identifier, data)
+
+	return err
+}
+
+// Cancel cancels a running job, which is then kept in the cache as canceled
+// until it expires. A job that has already finished is removed from the cache
+// straight away. Any partially written output is cleaned up by the operation
+// being performed.
+func (j *Job) Cancel() {
+	if j.IsFinished() {
+		instance.remove(j.Identifier)
+		return
+	}
+	j.cancel()
+}
+
+// CancelWith cancels the job if the given context is done before the job has
+// finished. This is used for jobs run in the foreground of a request, which
+// should not keep running once the client has disconnected.
+func (j *Job) CancelWith(ctx context.Context) {
+	stop := context.AfterFunc(ctx, j.cancel)
+	context.AfterFunc(j.ctx, func() { stop() })
+}
+
+// BelongsTo checks if the given job belongs to the provided server.
+func (j *Job) BelongsTo(s *server.Server) bool {
+	return j.server.ID() == s.ID()
+}
+
+// Status returns the current status of the job.
+func (j *Job) Status() Status {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.status
+}
+
+// IsFinished returns true if the job has completed, failed or been canceled.
+func (j *Job) IsFinished() bool {
+	switch j.Status() {
+	case StatusPending, StatusRunning:
+		return false
+	}
+	return true
+}
+
+// Err returns the error the job failed with, if any.
+func (j *Job) Err() error {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.err
+}
+
+// Progress returns the progress tracker for the job.
+func (j *Job) Progress() *progress.Progress {
+	return j.progress
+}
+
+func (j *Job) setStatus(s Status, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = s
+	j.err = err
+}
+
+// Tracker keeps track of all the file jobs running on the machine, and those that
+// have finished recently.
+type Tracker struct {
+	mu          sync.RWMutex
+	jobCache    map[string]*Job
+	serverCache map[string][]string
+}
+
+// track tracks a job in the internal cache for this instance, returning false
+// if the server the job belongs to already has max jobs running. The limit is
+// checked while holding the lock so that concurrent requests cannot exceed it.
+func (t *Tracker) track(j *Job, max int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sid := j.server.ID()
+	running := 0
+	for _, id := range t.serverCache[sid] {
+		if v, ok := t.jobCache[id]; ok && !v.IsFinished() {
+			running++
+		}
+	}
+	if max > 0 && running >= max {
+		return false
+	}
+	if _, ok := t.jobCache[j.Identifier]; !ok {
+		t.jobCache[j.Identifier] = j
+		t.serverCache[sid] = append(t.serverCache[sid], j.Identifier)
+	}
+	return true
+}
+
+// find finds a given job using the provided ID and returns it.
+func (t *Tracker) find(id string) *Job {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if entry, ok := t.jobCache[id]; ok {
+		return entry
+	}
+	return nil
+}
+
+// remove removes the given job from the cache, and from the slice of active
+// jobs for the server it belongs to.
+func (t *Tracker) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	j, ok := t.jobCache[id]
+	if !ok {
+		return
+	}
+	sid := j.server.ID()
+	delete(t.jobCache, id)
+	if tracked, ok := t.serverCache[sid]; ok {
+		var out []string
+		for _, k := range tracked {
+			if k != id {
+				out = append(out, k)
+			}
+		}
+		if len(out) == 0 {
+			delete(t.serverCache, sid)
+		} else {
+			t.serverCache[sid] = out
+		}
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/acl"
 	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/router/tokens"
 	"github.com/Minenetpro/pelican-wings/server"
 	"github.com/Minenetpro/pelican-wings/system"
 )
@@ -189,6 +191,26 @@ func RequireAuthorization() gin.HandlerFunc {
 	}
 }
 
+// AttachPathRules parses the path rules token that the Panel sends in the
+// X-Path-Rules header for requests made on behalf of a user whose access is
+// limited to specific paths, and attaches the rules to the request context. The
+// token must have been issued for the server being accessed.
+func AttachPathRules() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rules acl.Rules
+		if t := c.GetHeader("X-Path-Rules"); t != "" {
+			var token tokens.PathRulesPayload
+			if err := tokens.ParseToken([]byte(t), &token); err != nil || token.ServerUuid != ExtractServer(c).ID() {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "The path rules provided for this request are not valid."})
+				return
+			}
+			rules = token.PathRules
+		}
+		c.Set("path_rules", rules)
+		c.Next()
+	}
+}
+
 // RemoteDownloadEnabled checks if remote downloads are enabled for this instance
 // and if not aborts the request.
 func RemoteDownloadEnabled() gin.HandlerFunc {
@@ -223,6 +245,15 @@ func ExtractServer(c *gin.Context) *server.Server {
 	return v.(*server.Server)
 }
 
+// ExtractPathRules returns the path rules attached to the request context, or
+// nil if the request is not limited to any paths.
+func ExtractPathRules(c *gin.Context) acl.Rules {
+	if v, ok := c.Get("path_rules"); ok {
+		return v.(acl.Rules)
+	}
+	return nil
+}
+
 // ExtractApiClient returns the API client defined for the routes.
 func ExtractApiClient(c *gin.Context) remote.Client {
 	if v, ok := c.Get("api_client"); ok {
@@ -68,6 +68,11 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 	protected.DELETE("/api/transfers/:server", deleteTransfer)
 	protected.POST("/api/deauthorize-user", postDeauthorizeUser)
 	protected.GET("/api/events", getServerEvents)
+	protected.GET("/api/sftp/sessions", getSftpSessions)
+	protected.GET("/api/sftp/bans", getSftpBans)
+	protected.DELETE("/api/sftp/bans", deleteSftpBans)
+	protected.GET("/api/sftp/host-keys", getSftpHostKeys)
+	protected.POST("/api/sftp/host-keys/rotate", postSftpRotateHostKeys)
 
 	// These are server specific routes, and require that the request be authorized.
 	server := router.Group("/api/servers/:server")
@@ -89,6 +94,12 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 			serverExisting.GET("/logs", getServerLogs)
 			serverExisting.GET("/console", getServerConsole)
 			serverExisting.GET("/install-logs", getServerInstallLogs)
+			serverExisting.GET("/crashes", getServerCrashes)
+			serverExisting.GET("/query", getServerQuery)
+			serverExisting.GET("/schedules", getServerSchedules)
+			serverExisting.PUT("/schedules", putServerSchedules)
+			serverExisting.DELETE("/schedules/:schedule", deleteServerSchedule)
+			serverExisting.POST("/schedules/:schedule/run", postServerScheduleRun)
 			serverExisting.POST("/power", postServerPower)
 			serverExisting.POST("/commands", postServerCommands)
 			serverExisting.POST("/install", postServerInstall)
@@ -96,6 +107,10 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 			serverExisting.POST("/sync", postServerSync)
 			serverExisting.POST("/ws/deny", postServerDenyWSTokens)
 
+			serverExisting.GET("/sftp/sessions", getServerSftpSessions)
+			serverExisting.GET("/sftp/sessions/:session", getServerSftpSession)
+			serverExisting.DELETE("/sftp/sessions/:session", deleteServerSftpSession)
+
 			// This archive request causes the archive to start being created
 			// this should only be triggered by the panel.
 			serverExisting.POST("/transfer", postServerTransfer)
@@ -105,11 +120,14 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 			serverExisting.DELETE("deleteAllBackups", deleteAllServerBackups)
 
 			files := serverExisting.Group("/files")
+			files.Use(middleware.AttachPathRules())
 			{
 				files.GET("/contents", getServerFileContents)
 				files.GET("/list-directory", getServerListDirectory)
 				files.PUT("/rename", putServerRenameFiles)
 				files.POST("/copy", postServerCopyFile)
+				files.POST("/batch-copy", postServerBatchCopyFiles)
+				files.POST("/batch-move", postServerBatchMoveFiles)
 				files.POST("/write", postServerWriteFile)
 				files.POST("/create-directory", postServerCreateDirectory)
 				files.POST("/delete", postServerDeleteFiles)
@@ -118,6 +136,10 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 				files.POST("/chmod", postServerChmodFile)
 				files.GET("/search", getFilesBySearch)
 
+				files.GET("/jobs", getServerFileJobs)
+				files.GET("/jobs/:job", getServerFileJob)
+				files.DELETE("/jobs/:job", deleteServerFileJob)
+
 				files.GET("/pull", middleware.RemoteDownloadEnabled(), getServerPullingFiles)
 				files.POST("/pull", middleware.RemoteDownloadEnabled(), postServerPullRemoteFile)
 				files.DELETE("/pull/:download", middleware.RemoteDownloadEnabled(), deleteServerPullRemoteFile)
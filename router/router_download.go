@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"github.com/Minenetpro/pelican-wings/internal/acl"
 	"github.com/Minenetpro/pelican-wings/router/middleware"
 	"github.com/Minenetpro/pelican-wings/router/tokens"
 	"github.com/Minenetpro/pelican-wings/server/backup"
@@ -93,6 +94,10 @@ func getDownloadFile(c *gin.Context) {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
+	if !token.PathRules.Allows(token.FilePath, acl.Read) {
+		abortPathRules(c)
+		return
+	}
 
 	f, st, err := s.Filesystem().File(token.FilePath)
 	if err != nil {
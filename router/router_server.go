@@ -9,12 +9,15 @@ import (
 	"strings"
 
 	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
 
 	"emperror.dev/errors"
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 
+	"github.com/Minenetpro/pelican-wings/internal/cron"
 	"github.com/Minenetpro/pelican-wings/router/downloader"
+	"github.com/Minenetpro/pelican-wings/router/jobs"
 	"github.com/Minenetpro/pelican-wings/router/middleware"
 	"github.com/Minenetpro/pelican-wings/router/tokens"
 	"github.com/Minenetpro/pelican-wings/server"
@@ -75,6 +78,44 @@ func getServerInstallLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": output})
 }
 
+// Returns the crash reports saved for a server, newest first.
+func getServerCrashes(c *gin.Context) {
+	reports, err := middleware.ExtractServer(c).CrashReports(c.Request.Context())
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": reports})
+}
+
+// Returns the status last reported by a server when it was queried, such as the
+// number of players online. If "refresh" is set the server is queried immediately,
+// rather than returning the result of the last query.
+func getServerQuery(c *gin.Context) {
+	s := middleware.ExtractServer(c)
+	if !s.CanQuery() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "This server does not support being queried.",
+		})
+		return
+	}
+
+	if refresh, _ := strconv.ParseBool(c.Query("refresh")); !refresh || s.Environment.State() != environment.ProcessRunningState {
+		c.JSON(http.StatusOK, gin.H{"data": s.QueryResult()})
+		return
+	}
+
+	res, err := s.Query(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": "The server did not respond to the query.",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": res})
+}
+
 // Handles a request to control the power state of a server. If the action being passed
 // through is invalid a 404 is returned. Otherwise, a HTTP/202 Accepted response is returned
 // and the actual power action is run asynchronously so that we don't have to block the
@@ -245,6 +286,13 @@ func deleteServer(c *gin.Context) {
 	for _, dl := range downloader.ByServer(s.ID()) {
 		dl.Cancel()
 	}
+	if err := downloader.RemovePartials(s.ID()); err != nil {
+		log.WithFields(log.Fields{"server_id": ID, "error": err}).Warn("failed to remove partial downloads during deletion process")
+	}
+	// Stop any compression or decompression jobs that are still running.
+	for _, job := range jobs.ByServer(s.ID()) {
+		job.Cancel()
+	}
 
 	// Remove the install log from this server
 	filename := filepath.Join(config.Get().System.LogDirectory, "install", ID+".log")
@@ -253,6 +301,22 @@ func deleteServer(c *gin.Context) {
 		log.WithFields(log.Fields{"server_id": ID, "error": err}).Warn("failed to remove server install log during deletion process")
 	}
 
+	// Remove the crash reports saved for this server
+	if err := s.DeleteCrashReports(context.Background()); err != nil {
+		log.WithFields(log.Fields{"server_id": ID, "error": err}).Warn("failed to remove server crash reports during deletion process")
+	}
+
+	// Remove the schedules saved for this server
+	cron.RemoveSchedules(s)
+	if err := s.DeleteSchedules(context.Background()); err != nil {
+		log.WithFields(log.Fields{"server_id": ID, "error": err}).Warn("failed to remove server schedules during deletion process")
+	}
+
+	// Remove the traffic counted for this server
+	if err := s.DeleteTraffic(context.Background()); err != nil {
+		log.WithFields(log.Fields{"server_id": ID, "error": err}).Warn("failed to remove server traffic during deletion process")
+	}
+
 	// Remove all server backups unless config setting is specified
 	if config.Get().System.Backups.RemoveBackupsOnServerDelete == true {
 		if err := s.RemoveAllServerBackups(); err != nil {
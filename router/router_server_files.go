@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -19,9 +20,12 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/acl"
 	"github.com/Minenetpro/pelican-wings/internal/models"
+	"github.com/Minenetpro/pelican-wings/internal/progress"
 	"github.com/Minenetpro/pelican-wings/internal/ufs"
 	"github.com/Minenetpro/pelican-wings/router/downloader"
+	"github.com/Minenetpro/pelican-wings/router/jobs"
 	"github.com/Minenetpro/pelican-wings/router/middleware"
 	"github.com/Minenetpro/pelican-wings/router/tokens"
 	"github.com/Minenetpro/pelican-wings/server"
@@ -36,6 +40,10 @@ func getServerFileContents(c *gin.Context) {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
+	if !middleware.ExtractPathRules(c).Allows(p, acl.Read) {
+		abortPathRules(c)
+		return
+	}
 	f, st, err := s.Filesystem().File(p)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -94,6 +102,11 @@ func getServerFileContents(c *gin.Context) {
 func getServerListDirectory(c *gin.Context) {
 	s := middleware.ExtractServer(c)
 	dir := c.Query("directory")
+	rules := middleware.ExtractPathRules(c)
+	if !rules.Visible(dir) {
+		abortPathRules(c)
+		return
+	}
 	if stats, err := s.Filesystem().ListDirectory(dir); err != nil {
 		// If the error is that the folder does not exist return a 404.
 		if errors.Is(err, os.ErrNotExist) {
@@ -104,6 +117,13 @@ func getServerListDirectory(c *gin.Context) {
 		}
 		middleware.CaptureAndAbort(c, err)
 	} else {
+		// Only return the entries the user is able to see, so a user that only
+		// has access to a subdirectory can still navigate to it.
+		if len(rules) > 0 {
+			stats = slices.DeleteFunc(stats, func(st filesystem.Stat) bool {
+				return !rules.Visible(path.Join(dir, st.Name()))
+			})
+		}
 		c.JSON(http.StatusOK, stats)
 	}
 }
@@ -133,6 +153,14 @@ func putServerRenameFiles(c *gin.Context) {
 		return
 	}
 
+	rules := middleware.ExtractPathRules(c)
+	for _, p := range data.Files {
+		if !rules.AllowsTree(path.Join(data.Root, p.From), acl.Delete) || !rules.AllowsTree(path.Join(data.Root, p.To), acl.Write) {
+			abortPathRules(c)
+			return
+		}
+	}
+
 	g, ctx := errgroup.WithContext(c.Request.Context())
 	// Loop over the array of files passed in and perform the move or rename action against each.
 	for _, p := range data.Files {
@@ -197,6 +225,11 @@ func postServerCopyFile(c *gin.Context) {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
+	// The copy is created alongside the original file.
+	if rules := middleware.ExtractPathRules(c); !rules.Allows(data.Location, acl.Read) || !rules.Allows(data.Location, acl.Write) {
+		abortPathRules(c)
+		return
+	}
 	if err := s.Filesystem().Copy(data.Location); err != nil {
 		middleware.CaptureAndAbort(c, err)
 		return
@@ -205,6 +238,113 @@ func postServerCopyFile(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// postServerBatchCopyFiles copies a set of files and directories into another
+// directory on the server as a tracked file job.
+func postServerBatchCopyFiles(c *gin.Context) {
+	batchCopyOrMoveFiles(c, jobs.TypeCopy)
+}
+
+// postServerBatchMoveFiles moves a set of files and directories into another
+// directory on the server as a tracked file job.
+func postServerBatchMoveFiles(c *gin.Context) {
+	batchCopyOrMoveFiles(c, jobs.TypeMove)
+}
+
+// batchCopyOrMoveFiles handles both the batch copy and move endpoints, which
+// only differ in the filesystem operation that is performed for the job. The
+// job runs in the background unless "foreground" is set in the request.
+func batchCopyOrMoveFiles(c *gin.Context, t jobs.Type) {
+	s := ExtractServer(c)
+
+	var data struct {
+		Root        string                    `json:"root"`
+		Files       []string                  `json:"files"`
+		Destination string                    `binding:"required" json:"destination"`
+		Conflict    filesystem.ConflictPolicy `json:"conflict"`
+		Foreground  bool                      `json:"foreground"`
+	}
+	// BindJSON sends 400 if the request fails, all we need to do is return
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+
+	if len(data.Files) == 0 {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "No files to " + string(t) + " were provided.",
+		})
+		return
+	}
+	if data.Conflict == "" {
+		data.Conflict = filesystem.ConflictSkip
+	}
+	if !data.Conflict.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The conflict policy provided is not supported, should be one of \"skip\", \"overwrite\" or \"rename\".",
+		})
+		return
+	}
+
+	fs := s.Filesystem()
+	rules := middleware.ExtractPathRules(c)
+	mode := acl.Read
+	if t == jobs.TypeMove {
+		mode = acl.Delete
+	}
+	for _, f := range data.Files {
+		p := path.Join(data.Root, f)
+		target := path.Join(data.Destination, path.Base(p))
+		// Ignore any files on the denylist, both as the source and as the
+		// destination they would end up at.
+		if err := fs.IsIgnored(p, target); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		if !rules.AllowsTree(p, mode) || !rules.AllowsTree(target, acl.Write) {
+			abortPathRules(c)
+			return
+		}
+	}
+
+	job, ok := newFileJob(c, s, t)
+	if !ok {
+		return
+	}
+	run := func() error {
+		return job.Execute(func(ctx context.Context, p *progress.Progress) error {
+			if t == jobs.TypeMove {
+				return fs.MoveFiles(ctx, data.Root, data.Files, data.Destination, data.Conflict, p)
+			}
+			return fs.CopyFiles(ctx, data.Root, data.Files, data.Destination, data.Conflict, p)
+		})
+	}
+
+	if !data.Foreground {
+		go func() {
+			if err := run(); err != nil {
+				s.Log().WithField("job_id", job.Identifier).WithField("error", err).Warn("failed to " + string(t) + " files")
+			}
+		}()
+		c.JSON(http.StatusAccepted, gin.H{
+			"identifier": job.Identifier,
+		})
+		return
+	}
+
+	job.CancelWith(c.Request.Context())
+	if err := run(); err != nil {
+		if errors.Is(err, filesystem.ErrPathOverlap) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "A directory cannot be copied or moved into itself.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 // Deletes files from a server.
 func postServerDeleteFiles(c *gin.Context) {
 	s := ExtractServer(c)
@@ -225,6 +365,14 @@ func postServerDeleteFiles(c *gin.Context) {
 		return
 	}
 
+	rules := middleware.ExtractPathRules(c)
+	for _, p := range data.Files {
+		if !rules.AllowsTree(path.Join(data.Root, p), acl.Delete) {
+			abortPathRules(c)
+			return
+		}
+	}
+
 	g, ctx := errgroup.WithContext(context.Background())
 
 	// Loop over the array of files passed in and delete them. If any of the file deletions
@@ -262,6 +410,10 @@ func postServerWriteFile(c *gin.Context) {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
+	if !middleware.ExtractPathRules(c).Allows(f, acl.Write) {
+		abortPathRules(c)
+		return
+	}
 
 	// A content length of -1 means the actual length is unknown.
 	if c.Request.ContentLength == -1 {
@@ -302,17 +454,50 @@ func postServerPullRemoteFile(c *gin.Context) {
 	s := ExtractServer(c)
 	var data struct {
 		// Deprecated
-		Directory  string `binding:"required_without=RootPath,omitempty" json:"directory"`
-		RootPath   string `binding:"required_without=Directory,omitempty" json:"root"`
-		URL        string `binding:"required" json:"url"`
-		FileName   string `json:"file_name"`
-		UseHeader  bool   `json:"use_header"`
-		Foreground bool   `json:"foreground"`
+		Directory    string            `binding:"required_without=RootPath,omitempty" json:"directory"`
+		RootPath     string            `binding:"required_without=Directory,omitempty" json:"root"`
+		URL          string            `binding:"required" json:"url"`
+		FileName     string            `json:"file_name"`
+		UseHeader    bool              `json:"use_header"`
+		Foreground   bool              `json:"foreground"`
+		Headers      map[string]string `json:"headers"`
+		Checksum     string            `json:"checksum"`
+		ChecksumType string            `json:"checksum_type"`
+		Extract      bool              `json:"extract"`
 	}
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
 
+	for k := range data.Headers {
+		switch http.CanonicalHeaderKey(k) {
+		case "Host", "Range", "Content-Length", "Transfer-Encoding", "Connection":
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The \"" + k + "\" header cannot be set on a remote file download.",
+			})
+			return
+		}
+	}
+
+	// Infer the checksum type from the length of the checksum if one was not
+	// explicitly provided.
+	if data.Checksum != "" && data.ChecksumType == "" {
+		switch len(data.Checksum) {
+		case 64:
+			data.ChecksumType = "sha256"
+		case 40:
+			data.ChecksumType = "sha1"
+		}
+	}
+	if data.Checksum != "" {
+		if _, err := downloader.NewHash(data.ChecksumType); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The checksum type provided is not supported, should be one of \"sha256\" or \"sha1\".",
+			})
+			return
+		}
+	}
+
 	// Handle the deprecated Directory field in the struct until it is removed.
 	if data.Directory != "" && data.RootPath == "" {
 		data.RootPath = data.Directory
@@ -342,11 +527,27 @@ func postServerPullRemoteFile(c *gin.Context) {
 		return
 	}
 
+	// The name of the file is not known until the remote server responds unless it
+	// was provided, so the download checks the final path against the rules again.
+	// An extracted archive can write anywhere within the directory it is downloaded
+	// to.
+	rules := middleware.ExtractPathRules(c)
+	if !rules.Allows(path.Join(data.RootPath, data.FileName), acl.Write) || (data.Extract && !rules.AllowsTree(data.RootPath, acl.Write)) {
+		abortPathRules(c)
+		return
+	}
 	dl := downloader.New(s, downloader.DownloadRequest{
-		Directory: data.RootPath,
-		URL:       u,
-		FileName:  data.FileName,
-		UseHeader: data.UseHeader,
+		Directory:    data.RootPath,
+		URL:          u,
+		FileName:     data.FileName,
+		UseHeader:    data.UseHeader,
+		Headers:      data.Headers,
+		Checksum:     data.Checksum,
+		ChecksumType: data.ChecksumType,
+		Extract:      data.Extract,
+		Allows: func(p string) bool {
+			return rules.Allows(p, acl.Write)
+		},
 	})
 	if err := s.Filesystem().IsIgnored(dl.Path()); err != nil {
 		middleware.CaptureAndAbort(c, err)
@@ -373,10 +574,27 @@ func postServerPullRemoteFile(c *gin.Context) {
 	}
 
 	if err := download(); err != nil {
+		if errors.Is(err, downloader.ErrChecksumMismatch) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The checksum of the downloaded file does not match the checksum provided.",
+			})
+			return
+		}
+		if errors.Is(err, downloader.ErrPathNotAllowed) {
+			abortPathRules(c)
+			return
+		}
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
 
+	// The archive is removed once it has been extracted, so there is nothing
+	// left to return details for.
+	if data.Extract {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
 	st, err := s.Filesystem().Stat(dl.Path())
 	if err != nil {
 		middleware.CaptureAndAbort(c, err)
@@ -407,6 +625,11 @@ func postServerCreateDirectory(c *gin.Context) {
 		return
 	}
 
+	if !middleware.ExtractPathRules(c).Allows(path.Join(data.Path, data.Name), acl.Write) {
+		abortPathRules(c)
+		return
+	}
+
 	if err := s.Filesystem().CreateDirectory(data.Name, data.Path); err != nil {
 		if errors.Is(err, ufs.ErrNotDirectory) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
@@ -428,14 +651,19 @@ func postServerCreateDirectory(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// postServerCompressFiles creates an archive containing the provided files and
+// returns its details once it has been created. If "background" is set in the
+// request the archive is instead generated as a tracked background job, and the
+// identifier of the job is returned straight away.
 func postServerCompressFiles(c *gin.Context) {
 	s := ExtractServer(c)
 
 	var data struct {
-		RootPath  string   `json:"root"`
-		Files     []string `json:"files"`
-		Name      string   `json:"name"`
-		Extension string   `json:"extension"`
+		RootPath   string   `json:"root"`
+		Files      []string `json:"files"`
+		Name       string   `json:"name"`
+		Extension  string   `json:"extension"`
+		Background bool     `json:"background"`
 	}
 
 	if err := c.BindJSON(&data); err != nil {
@@ -449,6 +677,19 @@ func postServerCompressFiles(c *gin.Context) {
 		return
 	}
 
+	// The archive is created in the root directory, alongside the files.
+	rules := middleware.ExtractPathRules(c)
+	if !rules.Allows(data.RootPath, acl.Write) {
+		abortPathRules(c)
+		return
+	}
+	for _, f := range data.Files {
+		if !rules.AllowsTree(path.Join(data.RootPath, f), acl.Read) {
+			abortPathRules(c)
+			return
+		}
+	}
+
 	if !s.Filesystem().HasSpaceAvailable(true) {
 		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
 			"error": "This server does not have enough available disk space to generate a compressed archive.",
@@ -456,37 +697,71 @@ func postServerCompressFiles(c *gin.Context) {
 		return
 	}
 
+	job, ok := newFileJob(c, s, jobs.TypeCompress)
+	if !ok {
+		return
+	}
+
 	// The extention comes from the panel
-	// Supported are: zip, tar.gz, tar.bz2, tar.xz
+	// Supported are: zip, tar.gz, tar.bz2, tar.xz, tar.zst
 	// No need to check if it is empty or wrong as if data.Extention is wrong the function falls back to tar.gz
-	f, mimetype, err := s.Filesystem().CompressFiles(data.RootPath, data.Name, data.Files, data.Extension)
-	if err != nil {
+	var st filesystem.Stat
+	compress := func() error {
+		return job.Execute(func(ctx context.Context, p *progress.Progress) error {
+			f, mimetype, err := s.Filesystem().CompressFiles(ctx, data.RootPath, data.Name, data.Files, data.Extension, p)
+			if err != nil {
+				return err
+			}
+			st = filesystem.Stat{FileInfo: f, Mimetype: mimetype}
+			return nil
+		})
+	}
+
+	if data.Background {
+		go func() {
+			if err := compress(); err != nil {
+				s.Log().WithField("job_id", job.Identifier).WithField("error", err).Warn("failed to compress files")
+			}
+		}()
+		c.JSON(http.StatusAccepted, gin.H{
+			"identifier": job.Identifier,
+		})
+		return
+	}
+
+	job.CancelWith(c.Request.Context())
+	if err := compress(); err != nil {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, &filesystem.Stat{
-		FileInfo: f,
-		Mimetype: mimetype,
-	})
+	c.JSON(http.StatusOK, &st)
 }
 
 // postServerDecompressFiles receives the HTTP request and starts the process
 // of unpacking an archive that exists on the server into the provided RootPath
-// for the server.
+// for the server. If "background" is set in the request the archive is unpacked
+// as a tracked background job, otherwise this blocks until it is unpacked.
 func postServerDecompressFiles(c *gin.Context) {
 	var data struct {
-		RootPath string `json:"root"`
-		File     string `json:"file"`
+		RootPath   string `json:"root"`
+		File       string `json:"file"`
+		Background bool   `json:"background"`
 	}
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
 
 	s := middleware.ExtractServer(c)
+	// The contents of the archive can end up anywhere within the root directory.
+	if rules := middleware.ExtractPathRules(c); !rules.Allows(path.Join(data.RootPath, data.File), acl.Read) || !rules.AllowsTree(data.RootPath, acl.Write) {
+		abortPathRules(c)
+		return
+	}
+
 	lg := middleware.ExtractLogger(c).WithFields(log.Fields{"root_path": data.RootPath, "file": data.File})
 	lg.Debug("checking if space is available for file decompression")
-	err := s.Filesystem().SpaceAvailableForDecompression(context.Background(), data.RootPath, data.File)
+	err := s.Filesystem().SpaceAvailableForDecompression(c.Request.Context(), data.RootPath, data.File)
 	if err != nil {
 		if filesystem.IsErrorCode(err, filesystem.ErrCodeUnknownArchive) {
 			lg.WithField("error", err).Warn("failed to decompress file: unknown archive format")
@@ -497,8 +772,32 @@ func postServerDecompressFiles(c *gin.Context) {
 		return
 	}
 
+	job, ok := newFileJob(c, s, jobs.TypeDecompress)
+	if !ok {
+		return
+	}
+	lg = lg.WithField("job_id", job.Identifier)
 	lg.Info("starting file decompression")
-	if err := s.Filesystem().DecompressFile(context.Background(), data.RootPath, data.File); err != nil {
+	decompress := func() error {
+		return job.Execute(func(ctx context.Context, p *progress.Progress) error {
+			return s.Filesystem().DecompressFile(ctx, data.RootPath, data.File, p)
+		})
+	}
+
+	if data.Background {
+		go func() {
+			if err := decompress(); err != nil {
+				lg.WithField("error", errors.WithStackIf(err)).Warn("failed to decompress file")
+			}
+		}()
+		c.JSON(http.StatusAccepted, gin.H{
+			"identifier": job.Identifier,
+		})
+		return
+	}
+
+	job.CancelWith(c.Request.Context())
+	if err := decompress(); err != nil {
 		// If the file is busy for some reason just return a nicer error to the user since there is not
 		// much we specifically can do. They'll need to stop the running server process in order to overwrite
 		// a file like this.
@@ -515,6 +814,66 @@ func postServerDecompressFiles(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// abortPathRules aborts the request because the path rules attached to it do
+// not allow the user to access one of the paths in the request.
+func abortPathRules(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error": "You do not have permission to access that path.",
+	})
+}
+
+// newFileJob creates a tracked file job for the server. If the server already
+// has the maximum number of file jobs running the request is aborted and false
+// is returned.
+func newFileJob(c *gin.Context, s *server.Server, t jobs.Type) (*jobs.Job, bool) {
+	job, err := jobs.New(s, t)
+	if err != nil {
+		if errors.Is(err, jobs.ErrLimitReached) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "This server has reached its limit of " + strconv.Itoa(config.Get().System.FileJobs.MaxJobs) + " simultaneous file jobs at once. Please wait for one to complete before trying again.",
+			})
+			return nil, false
+		}
+		middleware.CaptureAndAbort(c, err)
+		return nil, false
+	}
+	return job, true
+}
+
+// getServerFileJobs returns all of the file jobs running for the server, and
+// those that finished recently, along with their progress. A "file job
+// completed" event is emitted over the websocket and SSE stream once a job
+// finishes.
+func getServerFileJobs(c *gin.Context) {
+	s := ExtractServer(c)
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": jobs.ByServer(s.ID()),
+	})
+}
+
+// getServerFileJob returns a single file job and its current progress.
+func getServerFileJob(c *gin.Context) {
+	s := ExtractServer(c)
+	job := jobs.ByID(c.Param("job"))
+	if job == nil || !job.BelongsTo(s) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested file job was not found.",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// Cancels a running file job if it exists and belongs to this server, or removes
+// a finished job.
+func deleteServerFileJob(c *gin.Context) {
+	s := ExtractServer(c)
+	if job := jobs.ByID(c.Param("job")); job != nil && job.BelongsTo(s) {
+		job.Cancel()
+	}
+	c.Status(http.StatusNoContent)
+}
+
 type chmodFile struct {
 	File string `json:"file"`
 	Mode string `json:"mode"`
@@ -542,6 +901,14 @@ func postServerChmodFile(c *gin.Context) {
 		return
 	}
 
+	rules := middleware.ExtractPathRules(c)
+	for _, p := range data.Files {
+		if !rules.Allows(path.Join(data.Root, p.File), acl.Write) {
+			abortPathRules(c)
+			return
+		}
+	}
+
 	g, ctx := errgroup.WithContext(context.Background())
 
 	// Loop over the array of files passed in and perform the move or rename action against each.
@@ -620,6 +987,12 @@ func postServerUploadFiles(c *gin.Context) {
 	}
 
 	directory := c.Query("directory")
+	for _, header := range headers {
+		if !token.PathRules.Allows(filepath.Join(directory, header.Filename), acl.Write) {
+			abortPathRules(c)
+			return
+		}
+	}
 
 	maxFileSize := config.Get().Api.UploadLimit
 	maxFileSizeBytes := maxFileSize * 1024 * 1024
@@ -3,6 +3,7 @@ package router
 import (
 	"net/http"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -122,9 +123,22 @@ func getFilesBySearch(c *gin.Context) {
 	matchedEntries := []filesystem.Stat{}
 	matchedDirectories := []string{}
 
+	rules := middleware.ExtractPathRules(c)
+	if !rules.Visible(dir) {
+		abortPathRules(c)
+		return
+	}
+
 	// Start the search from the initial directory
 	searchDirectory(s, dir, patternLower, 0, &matchedEntries, &matchedDirectories, c)
 
+	// Only return the matches the user is able to see.
+	if len(rules) > 0 {
+		matchedEntries = slices.DeleteFunc(matchedEntries, func(st filesystem.Stat) bool {
+			return !rules.Visible(st.Name())
+		})
+	}
+
 	// Return all matched files with their stats and the name now included the directory
 	c.JSON(http.StatusOK, matchedEntries)
 
@@ -0,0 +1,141 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Minenetpro/pelican-wings/internal/cron"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+	"github.com/Minenetpro/pelican-wings/router/middleware"
+	"github.com/Minenetpro/pelican-wings/server"
+)
+
+// getServerSchedules returns the schedules saved for a server.
+func getServerSchedules(c *gin.Context) {
+	schedules, err := middleware.ExtractServer(c).Schedules(c.Request.Context())
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": schedules})
+}
+
+// putServerSchedules replaces every schedule saved for a server with the ones
+// provided by the Panel, and registers them to be run by Wings.
+func putServerSchedules(c *gin.Context) {
+	s := middleware.ExtractServer(c)
+
+	var data struct {
+		Schedules []models.Schedule `json:"schedules"`
+	}
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+
+	seen := make(map[int]bool, len(data.Schedules))
+	for _, sc := range data.Schedules {
+		err := server.ValidateSchedule(sc)
+		if err == nil && seen[sc.ID] {
+			err = errors.New("schedule " + strconv.Itoa(sc.ID) + " is provided more than once")
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		seen[sc.ID] = true
+	}
+
+	if err := s.SetSchedules(c.Request.Context(), data.Schedules); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if err := cron.SyncSchedules(context.Background(), s); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// deleteServerSchedule removes a single schedule saved for a server.
+func deleteServerSchedule(c *gin.Context) {
+	s := middleware.ExtractServer(c)
+	id, ok := scheduleID(c)
+	if !ok {
+		return
+	}
+
+	if err := s.DeleteSchedule(c.Request.Context(), id); err != nil {
+		if errors.Is(err, server.ErrScheduleNotFound) {
+			abortScheduleNotFound(c)
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if err := cron.SyncSchedules(context.Background(), s); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// postServerScheduleRun runs a schedule saved for a server immediately, in the
+// background. The result is recorded in the activity log of the server once it
+// has finished.
+func postServerScheduleRun(c *gin.Context) {
+	s := middleware.ExtractServer(c)
+	id, ok := scheduleID(c)
+	if !ok {
+		return
+	}
+
+	sc, err := s.Schedule(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, server.ErrScheduleNotFound) {
+			abortScheduleNotFound(c)
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if s.IsScheduleRunning(sc.ID) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "The requested schedule is already running.",
+		})
+		return
+	}
+
+	go func(sc models.Schedule) {
+		if err := s.RunSchedule(s.Context(), sc); err != nil {
+			s.Log().WithField("schedule", sc.ID).WithField("error", err).Warn("failed to run server schedule")
+		}
+	}(sc)
+
+	c.Status(http.StatusAccepted)
+}
+
+// scheduleID returns the schedule ID from the request path, aborting the request
+// if it is not a valid ID.
+func scheduleID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("schedule"))
+	if err != nil {
+		abortScheduleNotFound(c)
+		return 0, false
+	}
+	return id, true
+}
+
+func abortScheduleNotFound(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+		"error": "The requested schedule does not exist for this server.",
+	})
+}
@@ -0,0 +1,122 @@
+package router
+
+import (
+	"net/http"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Minenetpro/pelican-wings/internal/lockout"
+	"github.com/Minenetpro/pelican-wings/router/middleware"
+	"github.com/Minenetpro/pelican-wings/server"
+	"github.com/Minenetpro/pelican-wings/sftp"
+)
+
+// getSftpSessions returns every active SFTP session across all of the servers
+// on this node.
+func getSftpSessions(c *gin.Context) {
+	out := make([]*server.SftpSession, 0)
+	for _, s := range middleware.ExtractManager(c).All() {
+		out = append(out, s.SftpSessions()...)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": out,
+	})
+}
+
+// getServerSftpSessions returns the active SFTP sessions for a server.
+func getServerSftpSessions(c *gin.Context) {
+	s := ExtractServer(c)
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": s.SftpSessions(),
+	})
+}
+
+// getServerSftpSession returns a single active SFTP session for a server.
+func getServerSftpSession(c *gin.Context) {
+	s := ExtractServer(c)
+	ss, ok := s.SftpSession(c.Param("session"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested SFTP session was not found.",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ss)
+}
+
+// deleteServerSftpSession terminates an active SFTP session for a server. The
+// client is disconnected immediately, and any transfers in progress are
+// aborted.
+func deleteServerSftpSession(c *gin.Context) {
+	s := ExtractServer(c)
+	if ss, ok := s.SftpSession(c.Param("session")); ok {
+		ss.Terminate()
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getSftpBans returns every IP address and username that is currently locked
+// out of the SFTP server because of too many failed logins.
+func getSftpBans(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"bans": lockout.Bans(),
+	})
+}
+
+// deleteSftpBans lifts the lockout for the IP address or username given in
+// the query string, or for everything if neither is given.
+func deleteSftpBans(c *gin.Context) {
+	ip, username := c.Query("ip"), c.Query("username")
+	if ip == "" && username == "" {
+		lockout.ClearAll()
+	}
+	if ip != "" {
+		lockout.Clear(lockout.TypeIP, ip)
+	}
+	if username != "" {
+		lockout.Clear(lockout.TypeUsername, username)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getSftpHostKeys returns the host keys currently presented by the SFTP server.
+func getSftpHostKeys(c *gin.Context) {
+	keys, err := sftp.HostKeys()
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"host_keys": keys,
+	})
+}
+
+// postSftpRotateHostKeys replaces the host keys of the SFTP server with newly
+// generated keys. Only the types given in the request are replaced, or every
+// configured type if none are given.
+func postSftpRotateHostKeys(c *gin.Context) {
+	var data struct {
+		Types []string `json:"types"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&data); err != nil {
+			return
+		}
+	}
+
+	keys, err := sftp.RotateHostKeys(data.Types)
+	if err != nil {
+		if errors.Is(err, sftp.ErrUnknownHostKeyType) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Only host key types enabled in the configuration can be rotated.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"host_keys": keys,
+	})
+}
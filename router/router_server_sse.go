@@ -13,6 +13,7 @@ import (
 
 	"github.com/Minenetpro/pelican-wings/environment"
 	"github.com/Minenetpro/pelican-wings/events"
+	"github.com/Minenetpro/pelican-wings/internal/query"
 	"github.com/Minenetpro/pelican-wings/router/middleware"
 	"github.com/Minenetpro/pelican-wings/server"
 	"github.com/Minenetpro/pelican-wings/system"
@@ -38,6 +39,8 @@ type sseStatsData struct {
 	Network          environment.NetworkStats `json:"network"`
 	Uptime           int64                    `json:"uptime"`
 	State            string                   `json:"state"`
+	Health           string                   `json:"health"`
+	Query            *query.Result            `json:"query"`
 	DiskBytes        int64                    `json:"disk_bytes"`
 }
 
@@ -55,6 +58,34 @@ type sseBackupRestoreCompletedData struct {
 	BackupUUID string `json:"backup_uuid"`
 }
 
+type sseFileJobCompletedData struct {
+	ServerID     string `json:"server_id"`
+	Identifier   string `json:"identifier"`
+	Type         string `json:"type"`
+	Status       string `json:"status"`
+	IsSuccessful bool   `json:"is_successful"`
+	Error        string `json:"error"`
+}
+
+type sseFileChangedData struct {
+	ServerID string `json:"server_id"`
+	Path     string `json:"path"`
+	Op       string `json:"op"`
+	Size     int64  `json:"size"`
+}
+
+type sseSftpSessionData struct {
+	ServerID      string `json:"server_id"`
+	Identifier    string `json:"identifier"`
+	User          string `json:"user"`
+	Username      string `json:"username"`
+	IP            string `json:"ip"`
+	ClientVersion string `json:"client_version"`
+	ConnectedAt   string `json:"connected_at"`
+	BytesRead     int64  `json:"bytes_read"`
+	BytesWritten  int64  `json:"bytes_written"`
+}
+
 // ssePayload is the internal fan-in type sent from per-server goroutines to the
 // main SSE write loop.
 type ssePayload struct {
@@ -82,6 +113,8 @@ func procToSSEStats(s *server.Server) sseStatsData {
 		Network:          ru.Network,
 		Uptime:           ru.Uptime,
 		State:            ru.State.Load(),
+		Health:           ru.Health.Load(),
+		Query:            ru.Query,
 		DiskBytes:        ru.Disk,
 	}
 }
@@ -253,6 +286,51 @@ func getServerEvents(c *gin.Context) {
 						case <-ctx.Done():
 							return
 						}
+					case server.FileJobCompletedEvent:
+						raw, err := json.Marshal(e.Data)
+						if err != nil {
+							continue
+						}
+						var jobData sseFileJobCompletedData
+						if err := json.Unmarshal(raw, &jobData); err != nil {
+							continue
+						}
+						jobData.ServerID = sid
+						select {
+						case outChan <- ssePayload{event: "file job completed", data: jobData}:
+						case <-ctx.Done():
+							return
+						}
+					case server.FileChangedEvent:
+						raw, err := json.Marshal(e.Data)
+						if err != nil {
+							continue
+						}
+						var change sseFileChangedData
+						if err := json.Unmarshal(raw, &change); err != nil {
+							continue
+						}
+						change.ServerID = sid
+						select {
+						case outChan <- ssePayload{event: "file changed", data: change}:
+						case <-ctx.Done():
+							return
+						}
+					case server.SftpConnectedEvent, server.SftpDisconnectedEvent:
+						raw, err := json.Marshal(e.Data)
+						if err != nil {
+							continue
+						}
+						var session sseSftpSessionData
+						if err := json.Unmarshal(raw, &session); err != nil {
+							continue
+						}
+						session.ServerID = sid
+						select {
+						case outChan <- ssePayload{event: e.Topic, data: session}:
+						case <-ctx.Done():
+							return
+						}
 					}
 				}
 			}
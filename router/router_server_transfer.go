@@ -63,7 +63,7 @@ func postServerTransfer(c *gin.Context) {
 	// Ensure the server is offline. Sometimes a "No such container" error gets through
 	// which means the server is already stopped. We can ignore that.
 	if s.Environment.State() != environment.ProcessOfflineState {
-		if err := s.Environment.WaitForStop(
+		if err := s.WaitForStop(
 			s.Context(),
 			time.Second*15,
 			false,
@@ -17,12 +17,17 @@ import (
 	"github.com/Minenetpro/pelican-wings/router/tokens"
 	"github.com/Minenetpro/pelican-wings/server"
 	"github.com/Minenetpro/pelican-wings/server/installer"
+	"github.com/Minenetpro/pelican-wings/sftp"
 	"github.com/Minenetpro/pelican-wings/system"
 )
 
 // Returns information about the system that wings is running on.
 func getSystemInformation(c *gin.Context) {
-	i, err := system.GetSystemInformation()
+	get := system.GetSystemInformation
+	if config.Get().Native.Enabled {
+		get = system.GetHostInformation
+	}
+	i, err := get()
 	if err != nil {
 		middleware.CaptureAndAbort(c, err)
 		return
@@ -253,6 +258,10 @@ func postUpdateConfiguration(c *gin.Context) {
 	// Since we wrote it to the disk successfully now update the global configuration
 	// state to use this new configuration struct.
 	config.Set(cfg)
+	// Apply any changes to the SFTP host keys and server listeners.
+	if err := sftp.Reload(); err != nil && !errors.Is(err, sftp.ErrNotRunning) {
+		log.WithField("error", err).Error("failed to reload sftp server configuration")
+	}
 	c.JSON(http.StatusOK, postUpdateConfigurationResponse{
 		Applied: true,
 	})
@@ -285,5 +294,12 @@ func postDeauthorizeUser(c *gin.Context) {
 		}
 	}
 
+	// Cached logins are removed even if the cache is disabled, since it could be
+	// enabled again before they expire.
+	if err := sftp.ForgetCredentials(data.User, data.Servers); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
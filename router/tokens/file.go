@@ -2,13 +2,16 @@ package tokens
 
 import (
 	"github.com/gbrlsnchs/jwt/v3"
+
+	"github.com/Minenetpro/pelican-wings/internal/acl"
 )
 
 type FilePayload struct {
 	jwt.Payload
-	FilePath   string `json:"file_path"`
-	ServerUuid string `json:"server_uuid"`
-	UniqueId   string `json:"unique_id"`
+	FilePath   string    `json:"file_path"`
+	ServerUuid string    `json:"server_uuid"`
+	UniqueId   string    `json:"unique_id"`
+	PathRules  acl.Rules `json:"path_rules"`
 }
 
 // Returns the JWT payload.
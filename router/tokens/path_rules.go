@@ -0,0 +1,23 @@
+package tokens
+
+import (
+	"github.com/gbrlsnchs/jwt/v3"
+
+	"github.com/Minenetpro/pelican-wings/internal/acl"
+)
+
+// PathRulesPayload is sent by the Panel alongside requests to the file API that
+// are made on behalf of a user whose access is limited to specific paths within
+// the server's data directory.
+type PathRulesPayload struct {
+	jwt.Payload
+
+	ServerUuid string    `json:"server_uuid"`
+	UserUuid   string    `json:"user_uuid"`
+	PathRules  acl.Rules `json:"path_rules"`
+}
+
+// GetPayload returns the JWT payload.
+func (p *PathRulesPayload) GetPayload() *jwt.Payload {
+	return &p.Payload
+}
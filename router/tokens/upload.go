@@ -2,14 +2,17 @@ package tokens
 
 import (
 	"github.com/gbrlsnchs/jwt/v3"
+
+	"github.com/Minenetpro/pelican-wings/internal/acl"
 )
 
 type UploadPayload struct {
 	jwt.Payload
 
-	ServerUuid string `json:"server_uuid"`
-	UserUuid   string `json:"user_uuid"`
-	UniqueId   string `json:"unique_id"`
+	ServerUuid string    `json:"server_uuid"`
+	UserUuid   string    `json:"user_uuid"`
+	UniqueId   string    `json:"unique_id"`
+	PathRules  acl.Rules `json:"path_rules"`
 }
 
 // Returns the JWT payload.
@@ -47,6 +47,15 @@ func DenyForServer(s string, u string) {
 	userDenylist.Store(strings.Join([]string{s, u}, ":"), time.Now())
 }
 
+// DeniedForServer returns whether access granted to the user for the server at
+// the given time has since been revoked with DenyForServer.
+func DeniedForServer(s string, u string, at time.Time) bool {
+	if t, ok := userDenylist.Load(strings.Join([]string{s, u}, ":")); ok {
+		return at.Before(t.(time.Time))
+	}
+	return false
+}
+
 // WebsocketPayload defines the JWT payload for a websocket connection. This JWT is passed along to
 // the websocket after it has been connected to by sending an "auth" event.
 type WebsocketPayload struct {
@@ -76,6 +76,9 @@ var e = []string{
 	server.BackupRestoreCompletedEvent,
 	server.TransferLogsEvent,
 	server.TransferStatusEvent,
+	server.FileJobCompletedEvent,
+	server.FileChangedEvent,
+	server.CrashLoopingEvent,
 }
 
 // ListenForServerEvents will listen for different events happening on a server
@@ -20,7 +20,6 @@ import (
 
 	"github.com/Minenetpro/pelican-wings/config"
 	"github.com/Minenetpro/pelican-wings/environment"
-	"github.com/Minenetpro/pelican-wings/environment/docker"
 	"github.com/Minenetpro/pelican-wings/internal/models"
 	"github.com/Minenetpro/pelican-wings/router/tokens"
 	"github.com/Minenetpro/pelican-wings/server"
@@ -36,6 +35,7 @@ const (
 	PermissionReceiveInstall   = "admin.websocket.install"
 	PermissionReceiveTransfer  = "admin.websocket.transfer"
 	PermissionReceiveBackups   = "backup.read"
+	PermissionReceiveFiles     = "file.read"
 )
 
 type Handler struct {
@@ -167,6 +167,14 @@ func (h *Handler) SendJson(v Message) error {
 				return nil
 			}
 		}
+
+		// File change events expose the names of files on the server, only send
+		// them to users who are able to read the server files.
+		if v.Event == server.FileChangedEvent {
+			if !j.HasPermission(PermissionReceiveFiles) {
+				return nil
+			}
+		}
 	}
 
 	if err := h.unsafeSendJson(v); err != nil {
@@ -442,7 +450,7 @@ func (h *Handler) HandleInbound(ctx context.Context, m Message) error {
 			//
 			//  Or maybe just an IsBooted function?
 			if h.server.Environment.State() == environment.ProcessStartingState {
-				if e, ok := h.server.Environment.(*docker.Environment); ok {
+				if e, ok := h.server.Environment.(interface{ IsAttached() bool }); ok {
 					if !e.IsAttached() {
 						return nil
 					}
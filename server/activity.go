@@ -14,6 +14,7 @@ const ActivityPowerPrefix = "server:power."
 
 const (
 	ActivityConsoleCommand      = models.Event("server:console.command")
+	ActivityConsoleExec         = models.Event("server:console.exec")
 	ActivitySftpWrite           = models.Event("server:sftp.write")
 	ActivitySftpCreate          = models.Event("server:sftp.create")
 	ActivitySftpCreateDirectory = models.Event("server:sftp.create-directory")
@@ -21,6 +22,15 @@ const (
 	ActivitySftpDelete          = models.Event("server:sftp.delete")
 	ActivityFileUploaded        = models.Event("server:file.uploaded")
 	ActivityServerCrashed       = models.Event("server:crashed")
+	ActivityScheduleCompleted   = models.Event("server:schedule.completed")
+	ActivityScheduleFailed      = models.Event("server:schedule.failed")
+	ActivityIdleStop            = models.Event("server:idle.stop")
+	ActivityIdleWake            = models.Event("server:idle.wake")
+	ActivityTrafficExceeded     = models.Event("server:traffic.exceeded")
+	// ActivitySftpLoginFailed is deliberately outside of the "server:sftp." namespace so
+	// that failed logins are sent to the Panel as they are, rather than being merged with
+	// other SFTP events.
+	ActivitySftpLoginFailed = models.Event("server:sftp-login.failed")
 
 )
 
@@ -19,12 +19,33 @@ import (
 	"github.com/Minenetpro/pelican-wings/server/filesystem"
 )
 
-var format = archives.CompressedArchive{
-	Compression: archives.Gz{},
-	Archival:    archives.Tar{},
-	Extraction:  archives.Tar{},
+// extract identifies the compression used by a backup archive from its
+// contents and calls the callback for every file within it. Backups are
+// compressed with either gzip or zstd depending on the configuration at the time
+// they were generated.
+func extract(ctx context.Context, r io.Reader, callback RestoreCallback) error {
+	format, input, err := archives.Identify(ctx, "", r)
+	if err != nil {
+		return errors.WrapIf(err, "backup: failed to identify archive format")
+	}
+	ex, ok := format.(archives.Extractor)
+	if !ok {
+		return errors.New("backup: archive is not in a supported format")
+	}
+	return ex.Extract(ctx, input, func(ctx context.Context, f archives.FileInfo) error {
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		return callback(f.NameInArchive, f.FileInfo, r)
+	})
 }
 
+// Extensions are the file extensions used for backups stored on the disk.
+var Extensions = []string{".tar.gz", ".tar.zst"}
+
 type AdapterType string
 
 const (
@@ -102,9 +123,34 @@ func (b *Backup) Identifier() string {
 
 func (b *Backup) ServerId() string { return b.ServerUuid }
 
-// Path returns the path for this specific backup.
+// Path returns the path for this specific backup. Backups compressed with zstd are
+// named with a ".tar.zst" extension, and an existing backup keeps the name it was
+// created with if the compression is changed afterwards.
 func (b *Backup) Path() string {
-	return path.Join(config.Get().System.BackupDirectory, b.ServerId(), b.Identifier()+".tar.gz")
+	p := path.Join(config.Get().System.BackupDirectory, b.ServerId(), b.Identifier())
+	for _, ext := range Extensions {
+		if _, err := os.Stat(p + ext); err == nil {
+			return p + ext
+		}
+	}
+	if usesZstd() {
+		return p + ".tar.zst"
+	}
+	return p + ".tar.gz"
+}
+
+// usesZstd returns true if new backups are compressed with zstd.
+func usesZstd() bool {
+	return config.Get().System.Backups.CompressionLevel == "zstd"
+}
+
+// mediaType returns the media type of the backups generated with the current
+// configuration.
+func mediaType() string {
+	if usesZstd() {
+		return "application/zstd"
+	}
+	return "application/x-gzip"
 }
 
 // Size returns the size of the generated backup.
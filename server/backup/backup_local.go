@@ -8,7 +8,6 @@ import (
 
 	"emperror.dev/errors"
 	"github.com/juju/ratelimit"
-	"github.com/mholt/archives"
 
 	"github.com/Minenetpro/pelican-wings/config"
 	"github.com/Minenetpro/pelican-wings/remote"
@@ -84,6 +83,7 @@ func (b *LocalBackup) Generate(ctx context.Context, fsys *filesystem.Filesystem,
 	a := &filesystem.Archive{
 		Filesystem: fsys,
 		Ignore:     ignore,
+		Zstd:       usesZstd(),
 	}
 
 	b.log().WithField("path", b.Path()).Info("creating backup for server")
@@ -120,16 +120,5 @@ func (b *LocalBackup) Restore(ctx context.Context, _ io.Reader, callback Restore
 	if writeLimit := int64(config.Get().System.Backups.WriteLimit * 1024 * 1024); writeLimit > 0 {
 		reader = ratelimit.Reader(f, ratelimit.NewBucketWithRate(float64(writeLimit), writeLimit))
 	}
-	if err := format.Extract(ctx, reader, func(ctx context.Context, f archives.FileInfo) error {
-		r, err := f.Open()
-		if err != nil {
-			return err
-		}
-		defer r.Close()
-
-		return callback(f.NameInArchive, f.FileInfo, r)
-	}); err != nil {
-		return err
-	}
-	return nil
+	return extract(ctx, reader, callback)
 }
@@ -13,7 +13,6 @@ import (
 	"emperror.dev/errors"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/juju/ratelimit"
-	"github.com/mholt/archives"
 
 	"github.com/Minenetpro/pelican-wings/config"
 	"github.com/Minenetpro/pelican-wings/remote"
@@ -61,6 +60,7 @@ func (s *S3Backup) Generate(ctx context.Context, fsys *filesystem.Filesystem, ig
 	a := &filesystem.Archive{
 		Filesystem: fsys,
 		Ignore:     ignore,
+		Zstd:       usesZstd(),
 	}
 
 	s.log().WithField("path", s.Path()).Info("creating backup for server")
@@ -92,8 +92,8 @@ func (s *S3Backup) Generate(ctx context.Context, fsys *filesystem.Filesystem, ig
 	return ad, nil
 }
 
-// Restore will read from the provided reader assuming that it is a gzipped
-// tar reader. When a file is encountered in the archive the callback function
+// Restore will read from the provided reader assuming that it is a gzip or zstd
+// compressed tar reader. When a file is encountered in the archive the callback function
 // will be triggered. If the callback returns an error the entire process is
 // stopped, otherwise this function will run until all files have been written.
 //
@@ -106,18 +106,7 @@ func (s *S3Backup) Restore(ctx context.Context, r io.Reader, callback RestoreCal
 	if writeLimit := int64(config.Get().System.Backups.WriteLimit * 1024 * 1024); writeLimit > 0 {
 		reader = ratelimit.Reader(r, ratelimit.NewBucketWithRate(float64(writeLimit), writeLimit))
 	}
-	if err := format.Extract(ctx, reader, func(ctx context.Context, f archives.FileInfo) error {
-		r, err := f.Open()
-		if err != nil {
-			return err
-		}
-		defer r.Close()
-
-		return callback(f.NameInArchive, f.FileInfo, r)
-	}); err != nil {
-		return err
-	}
-	return nil
+	return extract(ctx, reader, callback)
 }
 
 // Generates the remote S3 request and begins the upload.
@@ -209,7 +198,7 @@ func (fu *s3FileUploader) uploadPart(ctx context.Context, part string, size int6
 
 	r.ContentLength = size
 	r.Header.Add("Content-Length", strconv.Itoa(int(size)))
-	r.Header.Add("Content-Type", "application/x-gzip")
+	r.Header.Add("Content-Type", mediaType())
 
 	// Limit the reader to the size of the part.
 	r.Body = Reader{Reader: io.LimitReader(fu.ReadCloser, size)}
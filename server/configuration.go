@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"sync"
 
+	"github.com/Minenetpro/pelican-wings/config"
 	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/remote"
 )
 
 type EggConfiguration struct {
@@ -16,6 +18,10 @@ type EggConfiguration struct {
 	// as a per-user denylist, this is defined at the Egg level.
 	FileDenylist []string `json:"file_denylist"`
 
+	// SecurityProfile is the name of the security profile used to confine servers
+	// using this egg, unless the server sets its own.
+	SecurityProfile string `json:"security_profile"`
+
 	// Features is a map of feature identifiers to a list of console output strings
 	// that should trigger a match (e.g., for things like EULA prompts).
 	Features map[string][]string `json:"features"`
@@ -34,6 +40,7 @@ func (egg *EggConfiguration) UnmarshalJSON(b []byte) (err error) {
 
 	egg.ID = AliasEggConfiguration.ID
 	egg.FileDenylist = AliasEggConfiguration.FileDenylist
+	egg.SecurityProfile = AliasEggConfiguration.SecurityProfile
 
 	return nil
 }
@@ -43,6 +50,35 @@ type ConfigurationMeta struct {
 	Description string `json:"description"`
 }
 
+// IdleConfiguration defines when a server is stopped for being idle, and whether
+// it is started again when somebody connects to it.
+type IdleConfiguration struct {
+	// Timeout is the number of seconds a server must be idle for before it is
+	// stopped. If this is 0 the server is never stopped for being idle.
+	Timeout int `json:"timeout"`
+	// Wake holds the allocation ports of the server once it has been stopped for
+	// being idle, starting it again when a client of the game connects to it.
+	Wake bool `json:"wake"`
+	// Message is shown to players that connect while the server is starting, if
+	// the game supports it.
+	Message string `json:"message"`
+}
+
+// TrafficConfiguration defines the amount of network traffic a server can use in
+// a month, and what happens once it has been used.
+type TrafficConfiguration struct {
+	// Quota is the amount of traffic in mebibytes, sent and received, that the server
+	// can use in a calendar month. If this is 0 the traffic is not limited.
+	Quota int64 `json:"quota"`
+	// Action is either "throttle" to limit the bandwidth of the server, or "stop" to
+	// stop it and keep it from starting, until the end of the month.
+	Action string `json:"action"`
+	// ThrottleRate is the rate in kilobits per second that the server is limited to
+	// when it is throttled. If this is 0 the rate from the Wings configuration is
+	// used.
+	ThrottleRate int64 `json:"throttle_rate"`
+}
+
 type Configuration struct {
 	mu sync.RWMutex
 
@@ -77,12 +113,42 @@ type Configuration struct {
 	Mounts                []Mount                 `json:"mounts"`
 	Egg                   EggConfiguration        `json:"egg,omitempty"`
 
+	// Overrides for the SFTP session limits defined in the Wings configuration.
+	SftpLimits config.SftpLimits `json:"sftp_limits"`
+
+	// Overrides the stop policy of the egg for this server, if set.
+	StopSteps []remote.ProcessStopStep `json:"stop_steps"`
+
+	// Overrides the health check of the egg for this server, if set.
+	Health *remote.HealthCheck `json:"health"`
+
+	// Overrides the security profile of the egg for this server, if set.
+	SecurityProfile string `json:"security_profile"`
+
+	// Stops the server once it has been idle for long enough, if set.
+	Idle IdleConfiguration `json:"idle"`
+
+	// Limits the network traffic of the server each month, if set.
+	Traffic TrafficConfiguration `json:"traffic"`
+
 	Container struct {
 		// Defines the Docker image that will be used for this server
 		Image string `json:"image,omitempty"`
 	} `json:"container,omitempty"`
 }
 
+// SecurityProfile returns the name of the security profile used to confine the
+// server, which is the profile set for the server, or else the profile set by its
+// egg. An empty string means the default profile is used.
+func (s *Server) SecurityProfile() string {
+	s.cfg.mu.RLock()
+	defer s.cfg.mu.RUnlock()
+	if s.cfg.SecurityProfile != "" {
+		return s.cfg.SecurityProfile
+	}
+	return s.cfg.Egg.SecurityProfile
+}
+
 func (s *Server) Config() *Configuration {
 	s.cfg.mu.RLock()
 	defer s.cfg.mu.RUnlock()
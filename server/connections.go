@@ -1,6 +1,18 @@
 package server
 
 import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+
+	"github.com/Minenetpro/pelican-wings/config"
 	"github.com/Minenetpro/pelican-wings/system"
 )
 
@@ -17,3 +29,235 @@ func (s *Server) Sftp() *system.ContextBag {
 
 	return s.sftpBag
 }
+
+// SftpSession is a single active SFTP connection to a server.
+type SftpSession struct {
+	Identifier    string
+	Server        string
+	User          string
+	Username      string
+	IP            string
+	ClientVersion string
+	ConnectedAt   time.Time
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+
+	// Bandwidth limiters for this session alone, and those shared by every
+	// session to the server.
+	readLimiter        *rate.Limiter
+	writeLimiter       *rate.Limiter
+	serverReadLimiter  *rate.Limiter
+	serverWriteLimiter *rate.Limiter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Context returns the context for the session, which is canceled when the
+// session is terminated or when all of the user's sessions are canceled
+// through the SFTP connection bag.
+func (ss *SftpSession) Context() context.Context {
+	return ss.ctx
+}
+
+// Terminate disconnects the session.
+func (ss *SftpSession) Terminate() {
+	ss.cancel()
+}
+
+// AddRead records bytes that were read from the server during the session.
+func (ss *SftpSession) AddRead(n int64) {
+	ss.bytesRead.Add(n)
+}
+
+// AddWritten records bytes that were written to the server during the session.
+func (ss *SftpSession) AddWritten(n int64) {
+	ss.bytesWritten.Add(n)
+}
+
+// WaitRead blocks until the session is allowed to read n more bytes from the
+// server without going over its bandwidth limits. An error is returned if the
+// session is terminated while waiting.
+func (ss *SftpSession) WaitRead(n int) error {
+	if err := waitN(ss.ctx, ss.readLimiter, n); err != nil {
+		return err
+	}
+	return waitN(ss.ctx, ss.serverReadLimiter, n)
+}
+
+// WaitWrite blocks until the session is allowed to write n more bytes to the
+// server without going over its bandwidth limits. An error is returned if the
+// session is terminated while waiting.
+func (ss *SftpSession) WaitWrite(n int) error {
+	if err := waitN(ss.ctx, ss.writeLimiter, n); err != nil {
+		return err
+	}
+	return waitN(ss.ctx, ss.serverWriteLimiter, n)
+}
+
+// waitN waits for n tokens from the limiter, in chunks no larger than its
+// burst since the limiter rejects anything larger outright.
+func waitN(ctx context.Context, l *rate.Limiter, n int) error {
+	if l.Limit() == rate.Inf {
+		return nil
+	}
+	for n > 0 {
+		chunk := min(n, l.Burst())
+		if err := l.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// newLimiter returns a limiter for a bandwidth limit in MiB/s, a limit less
+// than 1 is unlimited.
+func newLimiter(limit int) *rate.Limiter {
+	if limit < 1 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	bytes := limit * 1024 * 1024
+	return rate.NewLimiter(rate.Limit(bytes), bytes)
+}
+
+// setLimiter updates the bandwidth limit of an existing limiter.
+func setLimiter(l *rate.Limiter, limit int) {
+	if limit < 1 {
+		l.SetLimit(rate.Inf)
+		return
+	}
+	bytes := limit * 1024 * 1024
+	l.SetLimit(rate.Limit(bytes))
+	l.SetBurst(bytes)
+}
+
+func (ss *SftpSession) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Identifier    string    `json:"identifier"`
+		Server        string    `json:"server"`
+		User          string    `json:"user"`
+		Username      string    `json:"username"`
+		IP            string    `json:"ip"`
+		ClientVersion string    `json:"client_version"`
+		ConnectedAt   time.Time `json:"connected_at"`
+		BytesRead     int64     `json:"bytes_read"`
+		BytesWritten  int64     `json:"bytes_written"`
+	}{
+		Identifier:    ss.Identifier,
+		Server:        ss.Server,
+		User:          ss.User,
+		Username:      ss.Username,
+		IP:            ss.IP,
+		ClientVersion: ss.ClientVersion,
+		ConnectedAt:   ss.ConnectedAt,
+		BytesRead:     ss.bytesRead.Load(),
+		BytesWritten:  ss.bytesWritten.Load(),
+	})
+}
+
+type sftpSessions struct {
+	mu    sync.Mutex
+	items map[string]*SftpSession
+
+	readLimiter  *rate.Limiter
+	writeLimiter *rate.Limiter
+}
+
+// SftpLimits returns the SFTP session limits for the server, which are the
+// limits set for the server with any that are not set taken from the Wings
+// configuration. Limits less than 0 are not enforced.
+func (s *Server) SftpLimits() config.SftpLimits {
+	s.cfg.mu.RLock()
+	defer s.cfg.mu.RUnlock()
+	return s.cfg.SftpLimits.Merge(config.Get().System.Sftp.Limits)
+}
+
+// NewSftpSession registers a new SFTP session for the user connecting from the
+// given address, and publishes a SftpConnectedEvent for it. The session must
+// be removed with RemoveSftpSession once the connection is closed.
+//
+// If the server already has the maximum number of sessions allowed by its
+// limits ErrTooManySftpSessions is returned. The bandwidth limits of the
+// server are refreshed whenever a session is created.
+func (s *Server) NewSftpSession(user, username, addr, clientVersion string) (*SftpSession, error) {
+	limits := s.SftpLimits()
+
+	s.sftpSessions.mu.Lock()
+	if limits.MaxSessions > 0 && len(s.sftpSessions.items) >= limits.MaxSessions {
+		s.sftpSessions.mu.Unlock()
+		return nil, ErrTooManySftpSessions
+	}
+	if s.sftpSessions.items == nil {
+		s.sftpSessions.items = make(map[string]*SftpSession)
+		s.sftpSessions.readLimiter = newLimiter(limits.ServerReadLimit)
+		s.sftpSessions.writeLimiter = newLimiter(limits.ServerWriteLimit)
+	} else {
+		setLimiter(s.sftpSessions.readLimiter, limits.ServerReadLimit)
+		setLimiter(s.sftpSessions.writeLimiter, limits.ServerWriteLimit)
+	}
+
+	ip := addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		ip = host
+	}
+	ctx, cancel := context.WithCancel(s.Sftp().Context(user))
+	ss := &SftpSession{
+		Identifier:         uuid.NewString(),
+		Server:             s.ID(),
+		User:               user,
+		Username:           username,
+		IP:                 ip,
+		ClientVersion:      clientVersion,
+		ConnectedAt:        time.Now(),
+		readLimiter:        newLimiter(limits.SessionReadLimit),
+		writeLimiter:       newLimiter(limits.SessionWriteLimit),
+		serverReadLimiter:  s.sftpSessions.readLimiter,
+		serverWriteLimiter: s.sftpSessions.writeLimiter,
+		ctx:                ctx,
+		cancel:             cancel,
+	}
+	s.sftpSessions.items[ss.Identifier] = ss
+	s.sftpSessions.mu.Unlock()
+
+	s.Events().Publish(SftpConnectedEvent, ss)
+	return ss, nil
+}
+
+// RemoveSftpSession removes a session once its connection has been closed and
+// publishes a SftpDisconnectedEvent for it.
+func (s *Server) RemoveSftpSession(ss *SftpSession) {
+	ss.cancel()
+
+	s.sftpSessions.mu.Lock()
+	delete(s.sftpSessions.items, ss.Identifier)
+	s.sftpSessions.mu.Unlock()
+
+	s.Events().Publish(SftpDisconnectedEvent, ss)
+}
+
+// SftpSessions returns all of the active SFTP sessions for the server, ordered
+// by the time they connected.
+func (s *Server) SftpSessions() []*SftpSession {
+	s.sftpSessions.mu.Lock()
+	defer s.sftpSessions.mu.Unlock()
+
+	out := make([]*SftpSession, 0, len(s.sftpSessions.items))
+	for _, ss := range s.sftpSessions.items {
+		out = append(out, ss)
+	}
+	slices.SortFunc(out, func(a, b *SftpSession) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+	return out
+}
+
+// SftpSession returns the active SFTP session with the given identifier.
+func (s *Server) SftpSession(id string) (*SftpSession, bool) {
+	s.sftpSessions.mu.Lock()
+	defer s.sftpSessions.mu.Unlock()
+
+	ss, ok := s.sftpSessions.items[id]
+	return ss, ok
+}
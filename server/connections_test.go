@@ -0,0 +1,99 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+)
+
+func TestSftpSessions(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Server#NewSftpSession", func() {
+		var s *Server
+
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{
+				AuthenticationToken: "abc",
+				System: config.SystemConfiguration{
+					Sftp: config.SftpConfiguration{
+						Limits: config.SftpLimits{MaxSessions: 2},
+					},
+				},
+			})
+			s, _ = New(nil)
+		})
+
+		g.It("registers the session until it is removed", func() {
+			ss, err := s.NewSftpSession("user-uuid", "user.abcd", "203.0.113.10:52144", "SSH-2.0-OpenSSH_9.6")
+			g.Assert(err).IsNil()
+			g.Assert(ss.IP).Equal("203.0.113.10")
+
+			found, ok := s.SftpSession(ss.Identifier)
+			g.Assert(ok).IsTrue()
+			g.Assert(found == ss).IsTrue()
+			g.Assert(len(s.SftpSessions())).Equal(1)
+
+			s.RemoveSftpSession(ss)
+			_, ok = s.SftpSession(ss.Identifier)
+			g.Assert(ok).IsFalse()
+			g.Assert(len(s.SftpSessions())).Equal(0)
+			g.Assert(ss.Context().Err()).IsNotNil()
+		})
+
+		g.It("terminates the session when the user's connections are canceled", func() {
+			ss, _ := s.NewSftpSession("user-uuid", "user.abcd", "203.0.113.10:52144", "SSH-2.0-OpenSSH_9.6")
+			other, _ := s.NewSftpSession("other-uuid", "other.abcd", "203.0.113.11:52144", "SSH-2.0-OpenSSH_9.6")
+
+			s.Sftp().Cancel("user-uuid")
+			g.Assert(ss.Context().Err()).IsNotNil()
+			g.Assert(other.Context().Err()).IsNil()
+
+			other.Terminate()
+			g.Assert(other.Context().Err()).IsNotNil()
+		})
+
+		g.It("limits the number of concurrent sessions", func() {
+			first, _ := s.NewSftpSession("user-uuid", "user.abcd", "203.0.113.10:52144", "SSH-2.0-OpenSSH_9.6")
+			_, _ = s.NewSftpSession("user-uuid", "user.abcd", "203.0.113.10:52145", "SSH-2.0-OpenSSH_9.6")
+
+			_, err := s.NewSftpSession("user-uuid", "user.abcd", "203.0.113.10:52146", "SSH-2.0-OpenSSH_9.6")
+			g.Assert(err).Equal(ErrTooManySftpSessions)
+
+			s.RemoveSftpSession(first)
+			_, err = s.NewSftpSession("user-uuid", "user.abcd", "203.0.113.10:52146", "SSH-2.0-OpenSSH_9.6")
+			g.Assert(err).IsNil()
+		})
+
+		g.It("uses the limits set for the server over the defaults", func() {
+			s.cfg.SftpLimits = config.SftpLimits{MaxSessions: -1, SessionReadLimit: 1}
+			limits := s.SftpLimits()
+			g.Assert(limits.MaxSessions).Equal(-1)
+			g.Assert(limits.SessionReadLimit).Equal(1)
+
+			for i := 0; i < 3; i++ {
+				_, err := s.NewSftpSession("user-uuid", "user.abcd", "203.0.113.10:52144", "SSH-2.0-OpenSSH_9.6")
+				g.Assert(err).IsNil()
+			}
+		})
+
+		g.It("holds reads back to the bandwidth limit", func() {
+			s.cfg.SftpLimits = config.SftpLimits{SessionReadLimit: 1}
+			ss, _ := s.NewSftpSession("user-uuid", "user.abcd", "203.0.113.10:52144", "SSH-2.0-OpenSSH_9.6")
+
+			start := time.Now()
+			g.Assert(ss.WaitRead(1024 * 1024)).IsNil()
+			g.Assert(ss.WaitWrite(4 * 1024 * 1024)).IsNil()
+			g.Assert(time.Since(start) < time.Millisecond*100).IsTrue()
+
+			g.Assert(ss.WaitRead(512 * 1024)).IsNil()
+			g.Assert(time.Since(start) >= time.Millisecond*400).IsTrue()
+
+			ss.Terminate()
+			g.Assert(ss.WaitRead(1024 * 1024)).IsNotNil()
+		})
+	})
+}
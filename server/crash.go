@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"sync"
@@ -12,7 +13,6 @@ import (
 	"github.com/Minenetpro/pelican-wings/config"
 	"github.com/Minenetpro/pelican-wings/environment"
 	"github.com/Minenetpro/pelican-wings/internal/models"
-
 )
 
 type CrashHandler struct {
@@ -20,6 +20,14 @@ type CrashHandler struct {
 
 	// Tracks the time of the last server crash event.
 	lastCrash time.Time
+
+	// The times of the crashes within the restart window, oldest first, and if the
+	// server has crashed too many times within it to be restarted again.
+	crashes []time.Time
+	looping bool
+
+	// Cancels the restart the server is waiting for after crashing, if any.
+	cancelRestart context.CancelFunc
 }
 
 // Returns the time of the last crash for this server instance.
@@ -37,6 +45,81 @@ func (cd *CrashHandler) SetLastCrash(t time.Time) {
 	cd.mu.Unlock()
 }
 
+// IsCrashLooping returns true if the server has crashed too many times within
+// the restart window, and will not be restarted until it is started manually.
+func (cd *CrashHandler) IsCrashLooping() bool {
+	cd.mu.RLock()
+	defer cd.mu.RUnlock()
+
+	return cd.looping
+}
+
+// recordCrash records a crash that happened at the given time, and returns the
+// number of crashes that have happened within the window before it, including
+// this one.
+func (cd *CrashHandler) recordCrash(t time.Time, window time.Duration) int {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	crashes := cd.crashes[:0]
+	for _, c := range cd.crashes {
+		if t.Sub(c) < window {
+			crashes = append(crashes, c)
+		}
+	}
+	cd.crashes = append(crashes, t)
+	cd.lastCrash = t
+
+	return len(cd.crashes)
+}
+
+// setCrashLooping sets if the server is crash-looping, returning true if this
+// changed.
+func (cd *CrashHandler) setCrashLooping(looping bool) bool {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	if cd.looping == looping {
+		return false
+	}
+	cd.looping = looping
+	// Once a crash-looping server has been started again it gets a fresh set of
+	// restarts, rather than immediately being marked as crash-looping again.
+	if !looping {
+		cd.crashes = nil
+	}
+	return true
+}
+
+// setPendingRestart records the function that cancels the restart the server
+// is waiting for.
+func (cd *CrashHandler) setPendingRestart(cancel context.CancelFunc) {
+	cd.mu.Lock()
+	cd.cancelRestart = cancel
+	cd.mu.Unlock()
+}
+
+// takePendingRestart returns the function that cancels the restart the server
+// is waiting for, or nil if there is none, and forgets it.
+func (cd *CrashHandler) takePendingRestart() context.CancelFunc {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	cancel := cd.cancelRestart
+	cd.cancelRestart = nil
+	return cancel
+}
+
+// crashBackoff returns how long to wait before restarting a server after the
+// given number of crashes within the restart window.
+func crashBackoff(crashes int, base time.Duration, limit time.Duration) time.Duration {
+	d := base
+	for i := 1; i < crashes && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
 // Looks at the environment exit state to determine if the process exited cleanly or
 // if it was the result of an event that we should try to recover from.
 //
@@ -45,9 +128,11 @@ func (cd *CrashHandler) SetLastCrash(t time.Time) {
 // look at the exit state and check if it meets the criteria of being called a crash
 // by Wings.
 //
-// If the server is determined to have crashed, the process will be restarted and the
-// counter for the server will be incremented.
-func (s *Server) handleServerCrash() error {
+// If the server is determined to have crashed a crash report is saved for it, using
+// the last resource usage of the process that is passed through. The process is then
+// restarted according to the restart policy, unless it has crashed too many times and
+// is now crash-looping.
+func (s *Server) handleServerCrash(stats environment.Stats) error {
 	// No point in doing anything here if the server isn't currently offline, there
 	// is no reason to do a crash detection event. If the server crash detection is
 	// disabled we want to skip anything after this as well.
@@ -65,15 +150,17 @@ func (s *Server) handleServerCrash() error {
 		return errors.Wrap(err, "failed to get exit state for server process")
 	}
 
+	cfg := config.Get().System
 	// If the system is not configured to detect a clean exit code as a crash, and the
 	// crash is not the result of the program running out of memory, do nothing.
-	if exitCode == 0 && !oomKilled && !config.Get().System.CrashDetection.DetectCleanExitAsCrash {
+	if exitCode == 0 && !oomKilled && !cfg.CrashDetection.DetectCleanExitAsCrash {
 		s.Log().Debug("server exited with successful exit code; system is configured to not detect this as a crash")
 		return nil
 	}
 
-	// Get the last lines from the output before the crash so we can log it
-	logs, err := s.Environment.Readlog(config.Get().System.CrashActivityLogLines)
+	// Get the last lines from the output before the crash so we can log it, and keep
+	// them in the crash report.
+	logs, err := s.Environment.Readlog(max(cfg.CrashActivityLogLines, cfg.CrashDetection.ReportLines))
 	if err != nil {
 		log.WithField("server_id", s.ID()).Warn("Faild to get the last lines out of the console for the activity logs")
 	}
@@ -82,26 +169,120 @@ func (s *Server) handleServerCrash() error {
 	s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Exit code: %d", exitCode))
 	s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Out of memory: %t", oomKilled))
 
+	report := models.CrashReport{
+		ExitCode:  exitCode,
+		OomKilled: oomKilled,
+		Logs:      logs,
+		Stats: models.CrashStats{
+			Memory:      stats.Memory,
+			MemoryLimit: stats.MemoryLimit,
+			CpuAbsolute: stats.CpuAbsolute,
+			Uptime:      stats.Uptime,
+		},
+		Action:    CrashActionRestarted,
+		Timestamp: time.Now(),
+	}
+
+	delay, err := s.crashRestartDelay(&report)
+	s.saveCrashReport(report)
+
+	// Log that the server has crashed
+	if len(logs) > cfg.CrashActivityLogLines {
+		logs = logs[len(logs)-cfg.CrashActivityLogLines:]
+	}
+	s.SaveActivity(s.NewRequestActivity("", "127.0.0.1"), ActivityServerCrashed, models.ActivityMeta{
+		"exit_code": exitCode,
+		"oomkilled": oomKilled,
+		"logs":      logs,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if delay > 0 {
+		// Any power action sent while waiting cancels the restart, see
+		// cancelPendingRestart.
+		ctx, cancel := context.WithCancel(s.Context())
+		defer cancel()
+		s.crasher.setPendingRestart(cancel)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+		if s.crasher.takePendingRestart() == nil {
+			return nil
+		}
+		// The server may have been started by someone else while waiting to restart it.
+		if s.Environment.State() != environment.ProcessOfflineState {
+			return nil
+		}
+	}
+
+	return errors.Wrap(s.HandlePowerAction(PowerActionStart), "failed to start server after crash detection")
+}
+
+// crashRestartDelay applies the restart policy to a crash, returning how long to
+// wait before restarting the server, or an error if it should not be restarted.
+// The action recorded in the crash report is updated to match.
+//
+// If a maximum number of restarts is configured the server is restarted with an
+// exponential backoff until it crashes more times than that within the window, at
+// which point it is marked as crash-looping. Otherwise, the server is restarted
+// immediately unless it last crashed within the configured timeout.
+func (s *Server) crashRestartDelay(report *models.CrashReport) (time.Duration, error) {
+	cfg := config.Get().System.CrashDetection
+
+	if cfg.MaxRestarts > 0 {
+		window := time.Duration(cfg.Window) * time.Second
+		crashes := s.crasher.recordCrash(report.Timestamp, window)
+		if crashes > cfg.MaxRestarts {
+			report.Action = CrashActionCrashLooping
+			s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Aborting automatic restart, server has crashed %d times in the last %s and is crash-looping.", crashes, window))
+			if s.crasher.setCrashLooping(true) {
+				s.Events().Publish(CrashLoopingEvent, true)
+			}
+			return 0, &crashTooFrequent{}
+		}
+
+		delay := crashBackoff(crashes, time.Duration(cfg.Backoff)*time.Second, time.Duration(cfg.MaxBackoff)*time.Second)
+		if delay > 0 {
+			s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Restarting server in %s (restart %d of %d).", delay, crashes, cfg.MaxRestarts))
+		}
+		return delay, nil
+	}
+
 	c := s.crasher.LastCrashTime()
-	timeout := config.Get().System.CrashDetection.Timeout
+	timeout := cfg.Timeout
 
 	// If the last crash time was within the last `timeout` seconds we do not want to perform
 	// an automatic reboot of the process. Return an error that can be handled.
 	//
 	// If timeout is set to 0, always reboot the server (this is probably a terrible idea, but some people want it)
-	if timeout != 0 && !c.IsZero() && c.Add(time.Second*time.Duration(config.Get().System.CrashDetection.Timeout)).After(time.Now()) {
+	if timeout != 0 && !c.IsZero() && c.Add(time.Second*time.Duration(timeout)).After(time.Now()) {
+		report.Action = CrashActionNone
 		s.PublishConsoleOutputFromDaemon("Aborting automatic restart, last crash occurred less than " + strconv.Itoa(timeout) + " seconds ago.")
-		return &crashTooFrequent{}
+		return 0, &crashTooFrequent{}
 	}
 
-	// Log that the server has crashed
-	s.SaveActivity(s.NewRequestActivity("", "127.0.0.1"), ActivityServerCrashed, models.ActivityMeta{
-		"exit_code": exitCode,
-		"oomkilled": oomKilled,
-		"logs":      logs,
-	})
-	
 	s.crasher.SetLastCrash(time.Now())
+	return 0, nil
+}
 
-	return errors.Wrap(s.HandlePowerAction(PowerActionStart), "failed to start server after crash detection")
+// cancelPendingRestart cancels the restart the server is waiting for after
+// crashing, if any, so that it does not undo a power action sent in the meantime.
+func (s *Server) cancelPendingRestart() {
+	if cancel := s.crasher.takePendingRestart(); cancel != nil {
+		cancel()
+		s.PublishConsoleOutputFromDaemon("Automatic restart cancelled by power action.")
+	}
+}
+
+// clearCrashLooping clears the crash-looping state of the server, publishing
+// the change if it was crash-looping.
+func (s *Server) clearCrashLooping() {
+	if s.crasher.setCrashLooping(false) {
+		s.Events().Publish(CrashLoopingEvent, false)
+	}
 }
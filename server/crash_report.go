@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/database"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+)
+
+// The actions that can be taken by Wings after a server crashes, which are recorded
+// in its crash report.
+const (
+	CrashActionRestarted    = "restarted"
+	CrashActionCrashLooping = "crash_looping"
+	CrashActionNone         = "none"
+)
+
+// saveCrashReport saves a crash report for the server to the database, removing
+// the oldest reports for the server once there are more than the configured amount.
+// If configured, the report is also sent to the Panel in a background routine.
+func (s *Server) saveCrashReport(report models.CrashReport) {
+	cfg := config.Get().System.CrashDetection
+	report.Server = s.ID()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if tx := database.Instance().WithContext(ctx).Create(&report); tx.Error != nil {
+		s.Log().WithField("error", errors.WithStack(tx.Error)).Error("crash: failed to save crash report")
+		return
+	}
+
+	if cfg.Reports > 0 {
+		keep := database.Instance().Model(&models.CrashReport{}).
+			Select("id").
+			Where("server = ?", s.ID()).
+			Order("id DESC").
+			Limit(cfg.Reports)
+		tx := database.Instance().WithContext(ctx).
+			Where("server = ? AND id NOT IN (?)", s.ID(), keep).
+			Delete(&models.CrashReport{})
+		if tx.Error != nil {
+			s.Log().WithField("error", errors.WithStack(tx.Error)).Warn("crash: failed to remove old crash reports")
+		}
+	}
+
+	if cfg.SendReports {
+		go func() {
+			ctx, cancel := context.WithTimeout(s.Context(), time.Second*30)
+			defer cancel()
+			if err := s.client.SendCrashReport(ctx, s.ID(), report); err != nil {
+				s.Log().WithField("error", err).Warn("crash: failed to send crash report to Panel")
+			}
+		}()
+	}
+}
+
+// CrashReports returns the crash reports saved for the server, newest first.
+func (s *Server) CrashReports(ctx context.Context) ([]models.CrashReport, error) {
+	reports := []models.CrashReport{}
+	tx := database.Instance().WithContext(ctx).
+		Where("server = ?", s.ID()).
+		Order("id DESC").
+		Find(&reports)
+	if tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	return reports, nil
+}
+
+// DeleteCrashReports removes every crash report saved for the server.
+func (s *Server) DeleteCrashReports(ctx context.Context) error {
+	tx := database.Instance().WithContext(ctx).Where("server = ?", s.ID()).Delete(&models.CrashReport{})
+	return errors.WithStack(tx.Error)
+}
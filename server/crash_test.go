@@ -0,0 +1,112 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+)
+
+func TestCrashHandler(t *testing.T) {
+	g := Goblin(t)
+
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	g.Describe("crashBackoff", func() {
+		g.It("doubles the backoff with each crash up to the limit", func() {
+			g.Assert(crashBackoff(1, 5*time.Second, time.Minute)).Equal(5 * time.Second)
+			g.Assert(crashBackoff(3, 5*time.Second, time.Minute)).Equal(20 * time.Second)
+			g.Assert(crashBackoff(10, 5*time.Second, time.Minute)).Equal(time.Minute)
+			g.Assert(crashBackoff(3, 0, time.Minute)).Equal(time.Duration(0))
+		})
+	})
+
+	g.Describe("CrashHandler", func() {
+		g.It("only counts the crashes within the window", func() {
+			var cd CrashHandler
+			now := time.Now()
+			g.Assert(cd.recordCrash(now, time.Minute)).Equal(1)
+			g.Assert(cd.recordCrash(now.Add(30*time.Second), time.Minute)).Equal(2)
+			g.Assert(cd.recordCrash(now.Add(75*time.Second), time.Minute)).Equal(2)
+			g.Assert(cd.LastCrashTime()).Equal(now.Add(75 * time.Second))
+		})
+
+		g.It("forgets the crashes once it is no longer crash-looping", func() {
+			var cd CrashHandler
+			cd.recordCrash(time.Now(), time.Minute)
+			g.Assert(cd.setCrashLooping(true)).IsTrue()
+			g.Assert(cd.setCrashLooping(true)).IsFalse()
+			g.Assert(cd.IsCrashLooping()).IsTrue()
+
+			g.Assert(cd.setCrashLooping(false)).IsTrue()
+			g.Assert(cd.IsCrashLooping()).IsFalse()
+			g.Assert(cd.recordCrash(time.Now(), time.Minute)).Equal(1)
+		})
+
+		g.It("hands out a pending restart once", func() {
+			var cd CrashHandler
+			var cancelled bool
+			cd.setPendingRestart(func() { cancelled = true })
+
+			cancel := cd.takePendingRestart()
+			g.Assert(cancel != nil).IsTrue()
+			g.Assert(cd.takePendingRestart() == nil).IsTrue()
+			cancel()
+			g.Assert(cancelled).IsTrue()
+		})
+	})
+
+	g.Describe("Server#crashRestartDelay", func() {
+		var s *Server
+
+		g.BeforeEach(func() {
+			s, _ = New(nil)
+		})
+
+		g.AfterEach(func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection = config.CrashDetection{}
+			})
+		})
+
+		g.It("backs off until the server is crash-looping", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection = config.CrashDetection{MaxRestarts: 2, Window: 60, Backoff: 5, MaxBackoff: 60}
+			})
+
+			var delays []time.Duration
+			for i := 0; i < 2; i++ {
+				report := models.CrashReport{Action: CrashActionRestarted, Timestamp: time.Now()}
+				d, err := s.crashRestartDelay(&report)
+				g.Assert(err).IsNil()
+				g.Assert(report.Action).Equal(CrashActionRestarted)
+				delays = append(delays, d)
+			}
+			g.Assert(delays).Equal([]time.Duration{5 * time.Second, 10 * time.Second})
+
+			report := models.CrashReport{Action: CrashActionRestarted, Timestamp: time.Now()}
+			_, err := s.crashRestartDelay(&report)
+			g.Assert(IsTooFrequentCrashError(err)).IsTrue()
+			g.Assert(report.Action).Equal(CrashActionCrashLooping)
+			g.Assert(s.crasher.IsCrashLooping()).IsTrue()
+		})
+
+		g.It("does not restart a server that crashed within the timeout", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.CrashDetection = config.CrashDetection{Timeout: 60}
+			})
+
+			report := models.CrashReport{Action: CrashActionRestarted, Timestamp: time.Now()}
+			d, err := s.crashRestartDelay(&report)
+			g.Assert(err).IsNil()
+			g.Assert(d).Equal(time.Duration(0))
+
+			_, err = s.crashRestartDelay(&report)
+			g.Assert(IsTooFrequentCrashError(err)).IsTrue()
+			g.Assert(report.Action).Equal(CrashActionNone)
+		})
+	})
+}
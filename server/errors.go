@@ -10,6 +10,11 @@ var (
 	ErrServerIsInstalling   = errors.New("server is currently installing")
 	ErrServerIsTransferring = errors.New("server is currently being transferred")
 	ErrServerIsRestoring    = errors.New("server is currently being restored")
+	ErrTooManySftpSessions  = errors.New("server has too many active sftp sessions")
+	ErrQueryNotSupported    = errors.New("server does not have a query protocol")
+	ErrScheduleNotFound     = errors.New("server schedule does not exist")
+	ErrScheduleRunning      = errors.New("server schedule is already running")
+	ErrTrafficQuota         = errors.New("server has used its traffic quota")
 )
 
 type crashTooFrequent struct{}
@@ -20,6 +20,11 @@ const (
 	TransferStatusEvent         = "transfer status"
 	DeletedEvent                = "deleted"
 	FeatureMatchEvent           = "feature match"
+	FileJobCompletedEvent       = "file job completed"
+	FileChangedEvent            = "file changed"
+	SftpConnectedEvent          = "sftp connected"
+	SftpDisconnectedEvent       = "sftp disconnected"
+	CrashLoopingEvent           = "crash looping"
 )
 
 // Events returns the server's emitter instance.
@@ -13,6 +13,7 @@ import (
 	"emperror.dev/errors"
 	"github.com/apex/log"
 	"github.com/juju/ratelimit"
+	"github.com/klauspost/compress/zstd"
 	"github.com/klauspost/pgzip"
 	ignore "github.com/sabhiram/go-gitignore"
 
@@ -74,6 +75,11 @@ type Archive struct {
 	// Progress wraps the writer of the archive to pass through the progress tracker.
 	Progress *progress.Progress
 
+	// Zstd compresses the archive with zstd rather than gzip when backups are set to
+	// be compressed with it. Archives sent to other nodes are always compressed with
+	// gzip so that any node can read them.
+	Zstd bool
+
 	w *TarProgress
 }
 
@@ -129,24 +135,15 @@ func (a *Archive) Stream(ctx context.Context, w io.Writer) error {
 		a.Files = files
 	}
 
-	// Choose which compression level to use based on the compression_level configuration option
-	var compressionLevel int
-	switch config.Get().System.Backups.CompressionLevel {
-	case "none":
-		compressionLevel = pgzip.NoCompression
-	case "best_compression":
-		compressionLevel = pgzip.BestCompression
-	default:
-		compressionLevel = pgzip.BestSpeed
+	// Create a new compressed writer around the file.
+	cw, err := newCompressedWriter(w, a.Zstd)
+	if err != nil {
+		return err
 	}
+	defer cw.Close()
 
-	// Create a new gzip writer around the file.
-	gw, _ := pgzip.NewWriterLevel(w, compressionLevel)
-	_ = gw.SetConcurrency(1<<20, 1)
-	defer gw.Close()
-
-	// Create a new tar writer around the gzip writer.
-	tw := tar.NewWriter(gw)
+	// Create a new tar writer around the compressed writer.
+	tw := tar.NewWriter(cw)
 	defer tw.Close()
 
 	a.w = NewTarProgress(tw, a.Progress)
@@ -192,6 +189,39 @@ func (a *Archive) Stream(ctx context.Context, w io.Writer) error {
 	})
 }
 
+// newCompressedWriter wraps the writer with the compression algorithm and level
+// chosen by the compression_level configuration option for backups. Gzip at its
+// fastest level is used in place of zstd unless zstd is allowed.
+func newCompressedWriter(w io.Writer, allowZstd bool) (io.WriteCloser, error) {
+	var compressionLevel int
+	switch config.Get().System.Backups.CompressionLevel {
+	case "zstd":
+		if allowZstd {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdEncoderLevel()), zstd.WithEncoderConcurrency(1))
+		}
+		compressionLevel = pgzip.BestSpeed
+	case "none":
+		compressionLevel = pgzip.NoCompression
+	case "best_compression":
+		compressionLevel = pgzip.BestCompression
+	default:
+		compressionLevel = pgzip.BestSpeed
+	}
+
+	gw, err := pgzip.NewWriterLevel(w, compressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	_ = gw.SetConcurrency(1<<20, 1)
+	return gw, nil
+}
+
+// zstdEncoderLevel returns the zstd encoder level matching the configured
+// zstd_compression_level.
+func zstdEncoderLevel() zstd.EncoderLevel {
+	return zstd.EncoderLevelFromZstd(config.Get().System.ZstdCompressionLevel)
+}
+
 // Callback function used to determine if a given file should be included in the archive
 // being generated.
 func (a *Archive) callback(opts ...walkFunc) walkFunc {
@@ -1,6 +1,7 @@
 package filesystem
 
 import (
+	"bytes"
 	"context"
 	iofs "io/fs"
 	"os"
@@ -11,6 +12,8 @@ import (
 
 	. "github.com/franela/goblin"
 	"github.com/mholt/archives"
+
+	"github.com/Minenetpro/pelican-wings/config"
 )
 
 func TestArchive_Stream(t *testing.T) {
@@ -84,6 +87,34 @@ func TestArchive_Stream(t *testing.T) {
 
 			g.Assert(files).Equal(expected)
 		})
+
+		g.It("only uses zstd for archives that allow it", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.Backups.CompressionLevel = "zstd"
+			})
+			defer config.Update(func(c *config.Configuration) {
+				c.System.Backups.CompressionLevel = ""
+			})
+
+			r := strings.NewReader("hello, world!\n")
+			g.Assert(fs.Write("test_file.txt", r, r.Size(), 0o644)).IsNil()
+
+			for _, zstd := range []bool{false, true} {
+				var b bytes.Buffer
+				a := &Archive{Filesystem: fs, Zstd: zstd}
+				g.Assert(a.Stream(context.Background(), &b)).IsNil()
+
+				format, _, err := archives.Identify(context.Background(), "", &b)
+				g.Assert(err).IsNil()
+				c, ok := format.(archives.CompressedArchive)
+				g.Assert(ok).IsTrue()
+				if zstd {
+					g.Assert(c.Compression).Equal(archives.Zstd{})
+				} else {
+					g.Assert(c.Compression).Equal(archives.Gz{})
+				}
+			}
+		})
 	})
 }
 
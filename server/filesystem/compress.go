@@ -12,9 +12,12 @@ import (
 	"time"
 
 	"emperror.dev/errors"
+	"github.com/bodgit/sevenzip"
 	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/mholt/archives"
 
+	"github.com/Minenetpro/pelican-wings/internal/progress"
 	"github.com/Minenetpro/pelican-wings/internal/ufs"
 	"github.com/Minenetpro/pelican-wings/server/filesystem/archiverext"
 )
@@ -28,7 +31,12 @@ import (
 // All paths are relative to the dir that is passed in as the first argument,
 // and the compressed file will be placed at that location named
 // `archive-{date}.tar.gz`.
-func (fs *Filesystem) CompressFiles(dir string, name string, paths []string, extension string) (ufs.FileInfo, string, error) {
+//
+// If a progress tracker is provided its total is set to the combined size of
+// the files being archived, and every byte read from them is reported to it.
+// Canceling the context aborts the archive and removes the partially written
+// file from the disk.
+func (fs *Filesystem) CompressFiles(ctx context.Context, dir string, name string, paths []string, extension string, p *progress.Progress) (ufs.FileInfo, string, error) {
 	var validPaths []string
 	for _, file := range paths {
 		if err := fs.IsIgnored(path.Join(dir, file)); err == nil {
@@ -60,6 +68,9 @@ func (fs *Filesystem) CompressFiles(dir string, name string, paths []string, ext
 	case "tar.xz", "txz":
 		ext = ".tar.xz"
 		mimetype = "application/x-xz"
+	case "tar.zst", "tzst":
+		ext = ".tar.zst"
+		mimetype = "application/zstd"
 	default:
 		// fallback to tar.gz
 		ext = ".tar.gz"
@@ -104,12 +115,13 @@ func (fs *Filesystem) CompressFiles(dir string, name string, paths []string, ext
 		filesMap[absolutePath] = file
 	}
 
-	ctx := context.Background()
-
 	files, err := archives.FilesFromDisk(ctx, nil, filesMap)
 	if err != nil {
 		return nil, "", err
 	}
+	if p != nil {
+		trackArchiveProgress(files, p)
+	}
 
 	f, err := fs.unixFS.OpenFile(destPath, ufs.O_WRONLY|ufs.O_CREATE, 0o644)
 	if err != nil {
@@ -117,51 +129,108 @@ func (fs *Filesystem) CompressFiles(dir string, name string, paths []string, ext
 	}
 	defer f.Close()
 
-	cw := ufs.NewCountedWriter(f)
+	// The archive is accounted against the disk limit as it is written, so the
+	// process is stopped as soon as the server runs out of space rather than
+	// only finding out once the entire archive has been written.
+	cw, err := ufs.NewQuotaWriter(fs.unixFS, f)
+	if err != nil {
+		return nil, "", err
+	}
 
 	// Call the correct archiver
+	var format archives.Archiver
 	switch extension {
 	case "zip":
-		zipper := archives.Zip{}
-		if err := zipper.Archive(ctx, cw, files); err != nil {
-			return nil, "", err
-		}
+		format = archives.Zip{}
 	case "tar.bz2", "tbz2":
-		format := archives.CompressedArchive{
+		format = archives.CompressedArchive{
 			Compression: archives.Bz2{},
 			Archival:    archives.Tar{},
 		}
-		if err := format.Archive(ctx, cw, files); err != nil {
-			return nil, "", err
-		}
 	case "tar.xz", "txz":
-		format := archives.CompressedArchive{
+		format = archives.CompressedArchive{
 			Compression: archives.Xz{},
 			Archival:    archives.Tar{},
 		}
-		if err := format.Archive(ctx, cw, files); err != nil {
-			return nil, "", err
+	case "tar.zst", "tzst":
+		format = archives.CompressedArchive{
+			Compression: archives.Zstd{
+				EncoderOptions: []zstd.EOption{zstd.WithEncoderLevel(zstdEncoderLevel())},
+			},
+			Archival: archives.Tar{},
 		}
 	default: // tar.gz and fallback
-		format := archives.CompressedArchive{
+		format = archives.CompressedArchive{
 			Compression: archives.Gz{},
 			Archival:    archives.Tar{},
 		}
-		if err := format.Archive(ctx, cw, files); err != nil {
-			return nil, "", err
-		}
 	}
-
-	if !fs.unixFS.CanFit(cw.BytesWritten()) {
+	if err := format.Archive(ctx, cw, files); err != nil {
+		// Don't leave a truncated archive behind if the process was canceled
+		// or failed part of the way through.
 		_ = fs.unixFS.Remove(destPath)
-		return nil, "", newFilesystemError(ErrCodeDiskSpace, nil)
+		return nil, "", wrapQuotaError(err)
 	}
 
-	fs.unixFS.Add(cw.BytesWritten())
 	info, err := f.Stat()
 	return info, mimetype, err
 }
 
+// trackArchiveProgress sets the total of the progress tracker to the size of
+// all the regular files being archived and wraps each of them so that the
+// bytes read while building the archive are reported to it.
+func trackArchiveProgress(files []archives.FileInfo, p *progress.Progress) {
+	var total uint64
+	for i, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		total += uint64(file.Size())
+		open := file.Open
+		files[i].Open = func() (iofs.File, error) {
+			f, err := open()
+			if err != nil {
+				return nil, err
+			}
+			return &progressFile{File: f, p: p}, nil
+		}
+	}
+	p.SetTotal(total)
+}
+
+// progressFile reports every byte read from the underlying file to a progress
+// tracker.
+type progressFile struct {
+	iofs.File
+	p *progress.Progress
+}
+
+func (f *progressFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	_, _ = f.p.Write(b[:n])
+	return n, err
+}
+
+// progressReader reports every byte read from an archive on the disk to a
+// progress tracker. Seek and ReadAt remain available since some formats, such
+// as zip, require random access to the archive in order to be extracted.
+type progressReader struct {
+	ufs.File
+	p *progress.Progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.File.Read(b)
+	_, _ = r.p.Write(b[:n])
+	return n, err
+}
+
+func (r *progressReader) ReadAt(b []byte, off int64) (int, error) {
+	n, err := r.File.ReadAt(b, off)
+	_, _ = r.p.Write(b[:n])
+	return n, err
+}
+
 func (fs *Filesystem) archiverFileSystem(ctx context.Context, p string) (iofs.FS, error) {
 	f, err := fs.unixFS.Open(p)
 	if err != nil {
@@ -195,6 +264,10 @@ func (fs *Filesystem) archiverFileSystem(ctx context.Context, p string) (iofs.FS
 			// while ArchiveFS can't.
 			// zip.Reader doesn't suffer from issue #330 and #310 according to local test (but they should be fixed anyway)
 			return zip.NewReader(f, info.Size())
+		case archives.SevenZip:
+			// Walking a 7z archive through ArchiveFS re-reads the entire archive for every
+			// directory visited, while sevenzip.Reader only needs to parse the header once.
+			return sevenzip.NewReader(f, info.Size())
 		case archives.Extraction:
 			return &archives.ArchiveFS{Stream: io.NewSectionReader(f, 0, info.Size()), Format: ff, Context: ctx}, nil
 		case archives.Compression:
@@ -250,15 +323,28 @@ func (fs *Filesystem) SpaceAvailableForDecompression(ctx context.Context, dir st
 // all the files within the given archive and ensure that there is not a
 // zip-slip attack being attempted by validating that the final path is within
 // the server data directory.
-func (fs *Filesystem) DecompressFile(ctx context.Context, dir string, file string) error {
+//
+// If a progress tracker is provided its total is set to the size of the
+// archive, and it is updated as the archive is read from the disk.
+func (fs *Filesystem) DecompressFile(ctx context.Context, dir string, file string, p *progress.Progress) error {
 	f, err := fs.unixFS.Open(filepath.Join(dir, file))
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	var r io.Reader = f
+	if p != nil {
+		st, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		p.SetTotal(uint64(st.Size()))
+		r = &progressReader{File: f, p: p}
+	}
+
 	// Identify the type of archive we are dealing with.
-	format, input, err := archives.Identify(ctx, filepath.Base(file), f)
+	format, input, err := archives.Identify(ctx, filepath.Base(file), r)
 	if err != nil {
 		if errors.Is(err, archives.NoMatch) {
 			return newFilesystemError(ErrCodeUnknownArchive, err)
@@ -276,7 +362,9 @@ func (fs *Filesystem) DecompressFile(ctx context.Context, dir string, file strin
 
 // ExtractStreamUnsafe .
 func (fs *Filesystem) ExtractStreamUnsafe(ctx context.Context, dir string, r io.Reader) error {
-	format, input, err := archives.Identify(ctx, "archive.tar.gz", r)
+	// Identify the stream by its contents alone since it may have been compressed
+	// with either gzip or zstd depending on the configuration of the source node.
+	format, input, err := archives.Identify(ctx, "", r)
 	if err != nil {
 		if errors.Is(err, archives.NoMatch) {
 			return newFilesystemError(ErrCodeUnknownArchive, err)
@@ -326,8 +414,9 @@ func (fs *Filesystem) extractStream(ctx context.Context, opts extractStreamOptio
 		}
 		defer reader.Close()
 
-		// Open the file for creation/writing
-		f, err := fs.unixFS.OpenFile(p, ufs.O_WRONLY|ufs.O_CREATE, 0o644)
+		// Open the file for creation/writing, the writer takes care of checking
+		// and updating the disk usage of the server as each chunk is written.
+		f, err := fs.TouchWriter(p, ufs.O_WRONLY|ufs.O_CREATE|ufs.O_TRUNC, 0o644)
 		if err != nil {
 			return err
 		}
@@ -336,21 +425,15 @@ func (fs *Filesystem) extractStream(ctx context.Context, opts extractStreamOptio
 		// Read in 4 KB chunks
 		buf := make([]byte, 4096)
 		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
 			n, err := reader.Read(buf)
 			if n > 0 {
-
-				// Check quota before writing the chunk
-				if quotaErr := fs.HasSpaceFor(int64(n)); quotaErr != nil {
-					return quotaErr
-				}
-
-				// Write the chunk
 				if _, writeErr := f.Write(buf[:n]); writeErr != nil {
-					return writeErr
+					return wrapQuotaError(writeErr)
 				}
-
-				// Add to quota
-				fs.addDisk(int64(n))
 			}
 
 			if err != nil {
@@ -373,6 +456,11 @@ func (fs *Filesystem) extractStream(ctx context.Context, opts extractStreamOptio
 			return nil
 		}
 		p := filepath.Join(opts.Directory, f.NameInArchive)
+		// Refuse to extract entries that would end up outside the directory the
+		// archive is being extracted into, such as "../../server.properties".
+		if !filepath.IsLocal(strings.TrimLeft(f.NameInArchive, "/")) {
+			return NewBadPathResolution(f.NameInArchive, p)
+		}
 		// If it is ignored, just don't do anything with the file and skip over it.
 		if err := fs.IsIgnored(p); err != nil {
 			return nil
@@ -2,10 +2,13 @@ package filesystem
 
 import (
 	"context"
+	"errors"
 	"os"
 	"testing"
 
 	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/internal/progress"
 )
 
 // Given an archive named test.{ext}, with the following file structure:
@@ -21,7 +24,7 @@ func TestFilesystem_DecompressFile(t *testing.T) {
 	fs, rfs := NewFs()
 
 	g.Describe("Decompress", func() {
-		for _, ext := range []string{"zip", "rar", "tar", "tar.gz"} {
+		for _, ext := range []string{"zip", "rar", "tar", "tar.gz", "tar.zst", "7z"} {
 			g.It("can decompress a "+ext, func() {
 				// copy the file to the new FS
 				c, err := os.ReadFile("./testdata/test." + ext)
@@ -30,7 +33,7 @@ func TestFilesystem_DecompressFile(t *testing.T) {
 				g.Assert(err).IsNil()
 
 				// decompress
-				err = fs.DecompressFile(context.Background(), "/", "test."+ext)
+				err = fs.DecompressFile(context.Background(), "/", "test."+ext, nil)
 				g.Assert(err).IsNil()
 
 				// make sure everything is where it is supposed to be
@@ -47,6 +50,123 @@ func TestFilesystem_DecompressFile(t *testing.T) {
 			})
 		}
 
+		for _, ext := range []string{"tar.gz", "7z"} {
+			g.It("refuses to extract files outside of the target directory from a "+ext, func() {
+				c, err := os.ReadFile("./testdata/escape." + ext)
+				g.Assert(err).IsNil()
+				err = fs.CreateDirectory("nested", "/")
+				g.Assert(err).IsNil()
+				err = rfs.CreateServerFile("./nested/escape."+ext, c)
+				g.Assert(err).IsNil()
+
+				err = fs.DecompressFile(context.Background(), "/nested", "escape."+ext, nil)
+				g.Assert(err == nil).IsFalse()
+				g.Assert(IsErrorCode(err, ErrCodePathResolution)).IsTrue()
+
+				_, err = rfs.StatServerFile("escape.txt")
+				g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+			})
+		}
+
+		g.It("reports progress while decompressing", func() {
+			c, err := os.ReadFile("./testdata/test.tar.gz")
+			g.Assert(err).IsNil()
+			err = rfs.CreateServerFile("./test.tar.gz", c)
+			g.Assert(err).IsNil()
+
+			p := progress.NewProgress(0)
+			err = fs.DecompressFile(context.Background(), "/", "test.tar.gz", p)
+			g.Assert(err).IsNil()
+			g.Assert(p.Total()).Equal(uint64(len(c)))
+			g.Assert(p.Written() >= p.Total()).IsTrue()
+		})
+
+		g.It("stops decompressing when the context is canceled", func() {
+			c, err := os.ReadFile("./testdata/test.tar.gz")
+			g.Assert(err).IsNil()
+			err = rfs.CreateServerFile("./test.tar.gz", c)
+			g.Assert(err).IsNil()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err = fs.DecompressFile(ctx, "/", "test.tar.gz", nil)
+			g.Assert(err == nil).IsFalse()
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+
+	g.Describe("SpaceAvailableForDecompression", func() {
+		g.It("checks the size of the files in a 7z against the disk limit", func() {
+			c, err := os.ReadFile("./testdata/test.7z")
+			g.Assert(err).IsNil()
+			err = rfs.CreateServerFile("./test.7z", c)
+			g.Assert(err).IsNil()
+
+			// The archive contains 16 bytes of files.
+			fs.SetDiskLimit(16)
+			err = fs.SpaceAvailableForDecompression(context.Background(), "/", "test.7z")
+			g.Assert(err).IsNil()
+
+			fs.SetDiskLimit(15)
+			err = fs.SpaceAvailableForDecompression(context.Background(), "/", "test.7z")
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue()
+		})
+
+		g.AfterEach(func() {
+			fs.SetDiskLimit(0)
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
+
+func TestFilesystem_CompressFiles(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("Compress", func() {
+		g.BeforeEach(func() {
+			_ = fs.CreateDirectory("plugins", "/")
+			_ = rfs.CreateServerFileFromString("plugins/config.yml", "enabled: true")
+			_ = rfs.CreateServerFileFromString("server.properties", "motd=hello")
+		})
+
+		for _, ext := range []string{"zip", "tar.gz", "tar.zst"} {
+			g.It("can create a "+ext+" archive that decompresses to the same files", func() {
+				p := progress.NewProgress(0)
+				f, _, err := fs.CompressFiles(context.Background(), "/", "archive", []string{"plugins", "server.properties"}, ext, p)
+				g.Assert(err).IsNil()
+				g.Assert(f.Name()).Equal("archive." + ext)
+				g.Assert(p.Total()).Equal(uint64(len("enabled: true") + len("motd=hello")))
+				g.Assert(p.Written()).Equal(p.Total())
+
+				// Remove the original files so we can be sure they are recreated from
+				// the archive.
+				g.Assert(fs.Delete("plugins")).IsNil()
+				g.Assert(fs.Delete("server.properties")).IsNil()
+
+				err = fs.DecompressFile(context.Background(), "/", f.Name(), nil)
+				g.Assert(err).IsNil()
+
+				_, err = rfs.StatServerFile("plugins/config.yml")
+				g.Assert(err).IsNil()
+				_, err = rfs.StatServerFile("server.properties")
+				g.Assert(err).IsNil()
+			})
+		}
+
+		g.It("removes the partial archive when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, _, err := fs.CompressFiles(ctx, "/", "archive", []string{"plugins"}, "tar.gz", nil)
+			g.Assert(err == nil).IsFalse()
+
+			_, err = rfs.StatServerFile("archive.tar.gz")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
 		g.AfterEach(func() {
 			_ = fs.TruncateRootDirectory()
 		})
@@ -0,0 +1,320 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/Minenetpro/pelican-wings/internal/progress"
+	"github.com/Minenetpro/pelican-wings/internal/ufs"
+)
+
+// ErrPathOverlap is returned when a directory would be copied or moved into
+// itself, or when replacing an existing file would remove the source.
+var ErrPathOverlap = errors.Sentinel("filesystem: source and destination paths overlap")
+
+// ConflictPolicy determines what happens when a file being copied or moved
+// already exists in the destination directory.
+type ConflictPolicy string
+
+const (
+	// ConflictSkip leaves the existing file alone and does not copy or move
+	// the source.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the existing file or directory with the
+	// source. A copy only replaces it once it has completed, so the existing
+	// file is left untouched if the copy fails.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRename copies or moves the source using a new name with a
+	// "copy" suffix, the same way a single file is duplicated in place.
+	ConflictRename ConflictPolicy = "rename"
+)
+
+// IsValid returns whether the conflict policy is one that is supported.
+func (c ConflictPolicy) IsValid() bool {
+	switch c {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+		return true
+	}
+	return false
+}
+
+// CopyFiles copies the given files and directories, relative to root, into
+// the destination directory. Directories are copied recursively, and any
+// symlinks or other special files within them are skipped. Conflicts with
+// existing files in the destination are resolved using the provided policy.
+//
+// The combined size of everything being copied is checked against the disk
+// limit of the server before anything is written. If a progress tracker is
+// provided, its total is set to that size and updated as data is copied. If
+// the copy fails or the context is canceled, the partial copy of the file or
+// directory being copied is removed again. Anything copied before it is kept.
+//
+// When an existing file is overwritten, the source is copied to a temporary
+// name next to it, and the existing file is only replaced once the copy has
+// succeeded. Both need to fit within the disk limit while this happens.
+func (fs *Filesystem) CopyFiles(ctx context.Context, root string, files []string, destination string, policy ConflictPolicy, p *progress.Progress) error {
+	sources, err := fs.resolveSources(root, files)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, src := range sources {
+		size, err := fs.DirectorySize(src)
+		if err != nil {
+			return err
+		}
+		total += size
+	}
+	if err := fs.HasSpaceFor(total); err != nil {
+		return err
+	}
+	if p != nil {
+		p.SetTotal(uint64(total))
+	}
+
+	for _, src := range sources {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		target, skip, err := fs.resolveTarget(src, destination, policy)
+		if err != nil {
+			return err
+		}
+		if skip {
+			continue
+		}
+		dst := target
+		replace := fs.exists(target)
+		if replace {
+			if dst, err = fs.temporarySibling(target); err != nil {
+				return err
+			}
+		}
+		if err := fs.copyTree(ctx, src, dst, p); err != nil {
+			_ = fs.unixFS.RemoveAll(dst)
+			return errors.WrapIf(err, "server/filesystem: copy: failed to copy file")
+		}
+		if replace {
+			if err := fs.replaceWith(target, dst); err != nil {
+				_ = fs.unixFS.RemoveAll(dst)
+				return errors.WrapIf(err, "server/filesystem: copy: failed to replace file")
+			}
+		}
+	}
+	return nil
+}
+
+// MoveFiles moves the given files and directories, relative to root, into the
+// destination directory. Conflicts with existing files in the destination are
+// resolved using the provided policy. Since everything stays within the data
+// directory of the server, files are renamed rather than copied and the disk
+// usage of the server does not change. An existing file that is overwritten is
+// only removed once the source has been moved into its place.
+func (fs *Filesystem) MoveFiles(ctx context.Context, root string, files []string, destination string, policy ConflictPolicy, p *progress.Progress) error {
+	sources, err := fs.resolveSources(root, files)
+	if err != nil {
+		return err
+	}
+
+	sizes := make([]int64, len(sources))
+	var total int64
+	for i, src := range sources {
+		size, err := fs.DirectorySize(src)
+		if err != nil {
+			return err
+		}
+		sizes[i] = size
+		total += size
+	}
+	if p != nil {
+		p.SetTotal(uint64(total))
+	}
+
+	for i, src := range sources {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		target, skip, err := fs.resolveTarget(src, destination, policy)
+		if err != nil {
+			return err
+		}
+		if !skip {
+			if fs.exists(target) {
+				if err := fs.replaceWith(target, src); err != nil {
+					return errors.WrapIf(err, "server/filesystem: move: failed to replace file")
+				}
+			} else if err := fs.unixFS.Rename(src, target); err != nil {
+				return errors.WrapIf(err, "server/filesystem: move: failed to move file")
+			}
+		}
+		if p != nil {
+			p.Add(uint64(sizes[i]))
+		}
+	}
+	return nil
+}
+
+// resolveSources joins each of the files onto the root directory and ensures
+// that all of them exist before any work is started.
+func (fs *Filesystem) resolveSources(root string, files []string) ([]string, error) {
+	sources := make([]string, 0, len(files))
+	for _, f := range files {
+		src := path.Join("/", root, f)
+		if src == "/" {
+			return nil, NewBadPathResolution(f, fs.Path())
+		}
+		if _, err := fs.unixFS.Lstat(src); err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// resolveTarget returns the path that the source should be copied or moved to
+// inside the destination directory, applying the conflict policy if something
+// already exists at that path. If the source should be skipped entirely, skip
+// is returned as true. When overwriting, the existing file is left in place for
+// the caller to replace.
+func (fs *Filesystem) resolveTarget(src, destination string, policy ConflictPolicy) (target string, skip bool, err error) {
+	destination = path.Join("/", destination)
+	target = path.Join(destination, path.Base(src))
+	if strings.HasPrefix(target+"/", src+"/") && target != src {
+		return "", false, ErrPathOverlap
+	}
+
+	if _, err := fs.unixFS.Lstat(target); err != nil {
+		if errors.Is(err, ufs.ErrNotExist) {
+			return target, false, nil
+		}
+		return "", false, err
+	}
+
+	switch policy {
+	case ConflictOverwrite:
+		// Copying or moving something onto itself is a no-op, don't remove
+		// the only copy of the file.
+		if target == src {
+			return "", true, nil
+		}
+		if strings.HasPrefix(src, target+"/") {
+			return "", false, ErrPathOverlap
+		}
+		return target, false, nil
+	case ConflictRename:
+		info, err := fs.unixFS.Lstat(src)
+		if err != nil {
+			return "", false, err
+		}
+		base := path.Base(src)
+		var extension string
+		if !info.IsDir() {
+			extension = fs.Ext(base)
+		}
+		dirfd, _, closeFd, err := fs.unixFS.SafePath(target)
+		defer closeFd()
+		if err != nil {
+			return "", false, err
+		}
+		name, err := fs.findCopySuffix(dirfd, strings.TrimSuffix(base, extension), extension)
+		if err != nil {
+			return "", false, err
+		}
+		return path.Join(destination, name), false, nil
+	default:
+		return "", true, nil
+	}
+}
+
+// exists returns true if there is a file or directory at the path.
+func (fs *Filesystem) exists(p string) bool {
+	_, err := fs.unixFS.Lstat(p)
+	return err == nil
+}
+
+// temporarySibling returns an unused path in the same directory as the given
+// path, which a copy is written to before it replaces the file at the path, or
+// the file at the path is moved to while it is being replaced.
+func (fs *Filesystem) temporarySibling(p string) (string, error) {
+	dir, base := path.Split(p)
+	for i := 0; i < 10; i++ {
+		tmp := path.Join(dir, fmt.Sprintf(".%s.%d.tmp", base, time.Now().UnixNano()))
+		if _, err := fs.unixFS.Lstat(tmp); errors.Is(err, ufs.ErrNotExist) {
+			return tmp, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("server/filesystem: failed to find a temporary file name")
+}
+
+// replaceWith renames src into the place of the file or directory at target. The
+// existing file is moved aside first and only removed once src is in place, so
+// it is put back if the rename fails.
+func (fs *Filesystem) replaceWith(target, src string) error {
+	old, err := fs.temporarySibling(target)
+	if err != nil {
+		return err
+	}
+	if err := fs.unixFS.Rename(target, old); err != nil {
+		return err
+	}
+	if err := fs.unixFS.Rename(src, target); err != nil {
+		_ = fs.unixFS.Rename(old, target)
+		return err
+	}
+	return fs.unixFS.RemoveAll(old)
+}
+
+// copyTree recursively copies the file or directory at src to target. Only
+// directories and regular files are copied.
+func (fs *Filesystem) copyTree(ctx context.Context, src, target string, p *progress.Progress) error {
+	dirfd, name, closeFd, err := fs.unixFS.SafePath(src)
+	defer closeFd()
+	if err != nil {
+		return err
+	}
+
+	return fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dst := path.Join(target, relative)
+		if d.IsDir() {
+			if err := fs.unixFS.MkdirAll(dst, 0o755); err != nil {
+				return err
+			}
+			return fs.chownFile(dst)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := fs.unixFS.OpenFileat(dirfd, name, ufs.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		var r io.Reader = f
+		if p != nil {
+			r = io.TeeReader(f, p)
+		}
+		return fs.Write(dst, r, info.Size(), info.Mode().Perm())
+	})
+}
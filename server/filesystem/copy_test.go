@@ -0,0 +1,229 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/internal/progress"
+)
+
+func TestFilesystem_CopyFiles(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("CopyFiles", func() {
+		g.BeforeEach(func() {
+			_ = fs.CreateDirectory("plugins/config", "/")
+			_ = fs.CreateDirectory("backup", "/")
+			_ = rfs.CreateServerFileFromString("plugins/plugin.jar", "plugin")
+			_ = rfs.CreateServerFileFromString("plugins/config/config.yml", "config")
+			fs.unixFS.SetUsage(12)
+		})
+
+		g.It("recursively copies a directory and tracks progress", func() {
+			p := progress.NewProgress(0)
+			err := fs.CopyFiles(context.Background(), "/", []string{"plugins"}, "/backup", ConflictSkip, p)
+			g.Assert(err).IsNil()
+
+			f, _, err := fs.File("backup/plugins/config/config.yml")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("config")
+
+			_, err = rfs.StatServerFile("plugins/plugin.jar")
+			g.Assert(err).IsNil()
+			g.Assert(p.Total()).Equal(uint64(12))
+			g.Assert(p.Written()).Equal(uint64(12))
+			g.Assert(fs.CachedUsage()).Equal(int64(24))
+		})
+
+		g.It("skips files that already exist", func() {
+			_ = rfs.CreateServerFileFromString("backup/plugin.jar", "existing")
+
+			err := fs.CopyFiles(context.Background(), "/plugins", []string{"plugin.jar"}, "/backup", ConflictSkip, nil)
+			g.Assert(err).IsNil()
+
+			f, _, err := fs.File("backup/plugin.jar")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("existing")
+		})
+
+		g.It("overwrites files that already exist", func() {
+			_ = rfs.CreateServerFileFromString("backup/plugin.jar", "existing")
+
+			err := fs.CopyFiles(context.Background(), "/plugins", []string{"plugin.jar"}, "/backup", ConflictOverwrite, nil)
+			g.Assert(err).IsNil()
+
+			f, _, err := fs.File("backup/plugin.jar")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("plugin")
+
+			entries, err := fs.ReadDir("backup")
+			g.Assert(err).IsNil()
+			g.Assert(len(entries)).Equal(1)
+		})
+
+		g.It("keeps the existing files if overwriting them fails", func() {
+			_ = fs.CreateDirectory("backup/plugins", "/")
+			_ = rfs.CreateServerFileFromString("backup/plugins/plugin.jar", "existing")
+
+			// Cancel the copy once the first directory has been created.
+			ctx := &cancelAfter{Context: context.Background(), n: 2}
+			err := fs.CopyFiles(ctx, "/", []string{"plugins"}, "/backup", ConflictOverwrite, nil)
+			g.Assert(errors.Is(err, context.Canceled)).IsTrue()
+
+			f, _, err := fs.File("backup/plugins/plugin.jar")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("existing")
+
+			entries, err := fs.ReadDir("backup")
+			g.Assert(err).IsNil()
+			g.Assert(len(entries)).Equal(1)
+		})
+
+		g.It("renames files that already exist", func() {
+			err := fs.CopyFiles(context.Background(), "/", []string{"plugins/plugin.jar"}, "/plugins", ConflictRename, nil)
+			g.Assert(err).IsNil()
+
+			f, _, err := fs.File("plugins/plugin copy.jar")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("plugin")
+		})
+
+		g.It("cannot copy a directory into itself", func() {
+			err := fs.CopyFiles(context.Background(), "/", []string{"plugins"}, "/plugins/config", ConflictSkip, nil)
+			g.Assert(errors.Is(err, ErrPathOverlap)).IsTrue("err is not ErrPathOverlap")
+		})
+
+		g.It("cannot copy files that exceed the disk limits", func() {
+			fs.SetDiskLimit(20)
+
+			err := fs.CopyFiles(context.Background(), "/", []string{"plugins"}, "/backup", ConflictSkip, nil)
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue()
+
+			_, err = rfs.StatServerFile("backup/plugins")
+			g.Assert(err).IsNotNil()
+		})
+
+		g.It("removes the partial copy when canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := fs.CopyFiles(ctx, "/", []string{"plugins"}, "/backup", ConflictSkip, nil)
+			g.Assert(errors.Is(err, context.Canceled)).IsTrue()
+
+			_, err = rfs.StatServerFile("backup/plugins")
+			g.Assert(err).IsNotNil()
+		})
+
+		g.AfterEach(func() {
+			fs.SetDiskLimit(0)
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
+
+// cancelAfter is a context that is canceled once its error has been checked n
+// times.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestFilesystem_MoveFiles(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("MoveFiles", func() {
+		g.BeforeEach(func() {
+			_ = fs.CreateDirectory("plugins/config", "/")
+			_ = fs.CreateDirectory("disabled", "/")
+			_ = rfs.CreateServerFileFromString("plugins/plugin.jar", "plugin")
+			_ = rfs.CreateServerFileFromString("plugins/config/config.yml", "config")
+		})
+
+		g.It("moves files into another directory", func() {
+			p := progress.NewProgress(0)
+			err := fs.MoveFiles(context.Background(), "/plugins", []string{"plugin.jar", "config"}, "/disabled", ConflictSkip, p)
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("disabled/plugin.jar")
+			g.Assert(err).IsNil()
+			_, err = rfs.StatServerFile("disabled/config/config.yml")
+			g.Assert(err).IsNil()
+			_, err = rfs.StatServerFile("plugins/plugin.jar")
+			g.Assert(err).IsNotNil()
+			g.Assert(p.Written()).Equal(p.Total())
+		})
+
+		g.It("renames files that already exist", func() {
+			_ = rfs.CreateServerFileFromString("disabled/plugin.jar", "existing")
+
+			err := fs.MoveFiles(context.Background(), "/plugins", []string{"plugin.jar"}, "/disabled", ConflictRename, nil)
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("disabled/plugin copy.jar")
+			g.Assert(err).IsNil()
+		})
+
+		g.It("overwrites files that already exist", func() {
+			_ = rfs.CreateServerFileFromString("disabled/plugin.jar", "existing")
+
+			err := fs.MoveFiles(context.Background(), "/plugins", []string{"plugin.jar"}, "/disabled", ConflictOverwrite, nil)
+			g.Assert(err).IsNil()
+
+			f, _, err := fs.File("disabled/plugin.jar")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("plugin")
+
+			entries, err := fs.ReadDir("disabled")
+			g.Assert(err).IsNil()
+			g.Assert(len(entries)).Equal(1)
+		})
+
+		g.It("keeps the existing file if replacing it fails", func() {
+			_ = rfs.CreateServerFileFromString("disabled/plugin.jar", "existing")
+
+			err := fs.replaceWith("/disabled/plugin.jar", "/plugins/missing.jar")
+			g.Assert(err).IsNotNil()
+
+			f, _, err := fs.File("disabled/plugin.jar")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("existing")
+
+			entries, err := fs.ReadDir("disabled")
+			g.Assert(err).IsNil()
+			g.Assert(len(entries)).Equal(1)
+		})
+
+		g.It("does not remove the source when it would replace its own parent", func() {
+			_ = fs.CreateDirectory("plugins/plugins", "/")
+
+			err := fs.MoveFiles(context.Background(), "/plugins", []string{"plugins"}, "/", ConflictOverwrite, nil)
+			g.Assert(errors.Is(err, ErrPathOverlap)).IsTrue("err is not ErrPathOverlap")
+
+			_, err = rfs.StatServerFile("plugins/plugin.jar")
+			g.Assert(err).IsNil()
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
@@ -7,6 +7,7 @@ import (
 
 	"emperror.dev/errors"
 	"github.com/apex/log"
+	"golang.org/x/sys/unix"
 
 	"github.com/Minenetpro/pelican-wings/internal/ufs"
 )
@@ -45,6 +46,9 @@ func (fs *Filesystem) MaxDisk() int64 {
 // SetDiskLimit sets the disk space limit for this Filesystem instance.
 func (fs *Filesystem) SetDiskLimit(i int64) {
 	fs.unixFS.SetLimit(i)
+	if err := fs.updateProjectLimit(); err != nil {
+		log.WithField("root", fs.Path()).WithField("error", err).Warn("failed to update project quota limit")
+	}
 }
 
 // The same concept as HasSpaceAvailable however this will return an error if there is
@@ -100,12 +104,25 @@ func (fs *Filesystem) CachedUsage() int64 {
 //
 // This is primarily to avoid a bunch of I/O operations from piling up on the server, especially on servers
 // with a large amount of files.
+//
+// Writes made through the filesystem are accounted for as they happen, so the walk performed here only
+// serves to reconcile the cached value with changes made to the disk outside of Wings.
 func (fs *Filesystem) DiskUsage(allowStaleValue bool) (int64, error) {
 	// A disk check interval of 0 means this functionality is completely disabled.
 	if fs.diskCheckInterval == 0 {
 		return 0, nil
 	}
 
+	// When the data directory has a project quota the kernel already knows how
+	// much space is being used, so there is no need to walk the directory.
+	if id := fs.projectID.Load(); id != 0 {
+		usage, err := fs.commitProjectUsage(id)
+		if err == nil {
+			return usage, nil
+		}
+		log.WithField("root", fs.Path()).WithField("error", err).Warn("failed to read project quota usage, falling back to directory walk")
+	}
+
 	if !fs.lastLookupTime.Get().After(time.Now().Add(time.Second * fs.diskCheckInterval * -1)) {
 		// If we are now allowing a stale response go ahead  and perform the lookup and return the fresh
 		// value. This is a blocking operation to the calling process.
@@ -165,6 +182,7 @@ func (fs *Filesystem) DirectorySize(root string) (int64, error) {
 	}
 
 	var size atomic.Int64
+	links := make(map[inode]struct{})
 	err = fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, _ string, d ufs.DirEntry, err error) error {
 		if err != nil {
 			return errors.Wrap(err, "walkdirat err")
@@ -180,8 +198,14 @@ func (fs *Filesystem) DirectorySize(root string) (int64, error) {
 			return errors.Wrap(err, "lstatat err")
 		}
 
-		// TODO: detect if info is a hard-link and de-duplicate it.
-		// ref; https://github.com/Minenetpro/pelican-wings/pull/181/files
+		// Files with multiple hard-links only take up space on the disk once,
+		// so only count the first link that is found.
+		if ino, ok := hardLink(info); ok {
+			if _, seen := links[ino]; seen {
+				return nil
+			}
+			links[ino] = struct{}{}
+		}
 
 		size.Add(info.Size())
 		return nil
@@ -189,6 +213,22 @@ func (fs *Filesystem) DirectorySize(root string) (int64, error) {
 	return size.Load(), errors.WrapIf(err, "server/filesystem: directorysize: failed to walk directory")
 }
 
+// inode uniquely identifies a file on the system.
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// hardLink returns the inode of the file if it has more than one hard-link.
+func hardLink(info ufs.FileInfo) (inode, bool) {
+	st, ok := info.Sys().(*unix.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return inode{}, false
+	}
+	// Do not remove these "redundant" type-casts, they are required for 32-bit builds to work.
+	return inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
 func (fs *Filesystem) HasSpaceFor(size int64) error {
 	if !fs.unixFS.CanFit(size) {
 		return newFilesystemError(ErrCodeDiskSpace, nil)
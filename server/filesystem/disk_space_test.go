@@ -0,0 +1,42 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_DirectorySize(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("DirectorySize", func() {
+		g.BeforeEach(func() {
+			_ = fs.CreateDirectory("world", "/")
+			_ = rfs.CreateServerFileFromString("world/level.dat", "level data")
+		})
+
+		g.It("calculates the size of all files in the directory", func() {
+			_ = rfs.CreateServerFileFromString("world/session.lock", "lock")
+
+			size, err := fs.DirectorySize("/")
+			g.Assert(err).IsNil()
+			g.Assert(size).Equal(int64(14))
+		})
+
+		g.It("only counts files with multiple hard-links once", func() {
+			err := os.Link(filepath.Join(rfs.root, "server/world/level.dat"), filepath.Join(rfs.root, "server/level.dat"))
+			g.Assert(err).IsNil()
+
+			size, err := fs.DirectorySize("/")
+			g.Assert(err).IsNil()
+			g.Assert(size).Equal(int64(10))
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
@@ -130,6 +130,16 @@ func NewBadPathResolution(path string, resolved string) error {
 	return errors.WithStackDepth(&Error{code: ErrCodePathResolution, path: path, resolved: resolved}, 1)
 }
 
+// wrapQuotaError converts the error returned by a ufs.QuotaWriter when the disk
+// limit is reached into the same disk space error that is returned when a write
+// is rejected before it starts.
+func wrapQuotaError(err error) error {
+	if errors.Is(err, ufs.ErrQuotaExceeded) {
+		return errors.WithStackDepth(&Error{code: ErrCodeDiskSpace, err: err}, 1)
+	}
+	return err
+}
+
 // wrapError wraps the provided error as a Filesystem error and attaches the
 // provided resolved source to it. If the error is already a Filesystem error
 // no action is taken.
@@ -30,6 +30,14 @@ type Filesystem struct {
 	diskCheckInterval time.Duration
 	denylist          *ignore.GitIgnore
 
+	// projectID is the kernel project quota the data directory has been
+	// assigned, or 0 if disk usage is tracked by walking the directory.
+	projectID atomic.Uint32
+
+	// The user and group that own the files of the server, if they are not owned
+	// by the Wings system user.
+	uid, gid *int
+
 	isTest bool
 }
 
@@ -99,45 +107,63 @@ func (fs *Filesystem) Touch(p string, flag int) (ufs.File, error) {
 	return fs.unixFS.Touch(p, flag, 0o644)
 }
 
+// TouchWriter acts like Touch, but returns a writer for the file that accounts
+// for everything written to it against the disk limit of the server as it is
+// written, failing once that limit is reached. If the file is truncated, the
+// space used by its previous contents is released.
+func (fs *Filesystem) TouchWriter(p string, flag int, mode ufs.FileMode) (*ufs.QuotaWriter, error) {
+	var currentSize int64
+	if flag&ufs.O_TRUNC != 0 {
+		if st, err := fs.unixFS.Stat(p); err == nil && st.Mode().IsRegular() {
+			currentSize = st.Size()
+		}
+	}
+
+	file, err := fs.unixFS.Touch(p, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	fs.unixFS.Add(-currentSize)
+
+	w, err := ufs.NewQuotaWriter(fs.unixFS, file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
 // Writefile writes a file to the system. If the file does not already exist one
 // will be created. This will also properly recalculate the disk space used by
 // the server when writing new files or modifying existing ones.
 //
 // DEPRECATED: use `Write` instead.
 func (fs *Filesystem) Writefile(p string, r io.Reader) error {
-	var currentSize int64
 	st, err := fs.unixFS.Stat(p)
 	if err != nil && !errors.Is(err, ufs.ErrNotExist) {
 		return errors.Wrap(err, "server/filesystem: writefile: failed to stat file")
-	} else if err == nil {
-		if st.IsDir() {
-			// TODO: resolved
-			return errors.WithStack(&Error{code: ErrCodeIsDirectory, resolved: ""})
-		}
-		currentSize = st.Size()
+	} else if err == nil && st.IsDir() {
+		// TODO: resolved
+		return errors.WithStack(&Error{code: ErrCodeIsDirectory, resolved: ""})
 	}
 
 	// Touch the file and return the handle to it at this point. This will
 	// create or truncate the file, and create any necessary parent directories
 	// if they are missing.
-	file, err := fs.unixFS.Touch(p, ufs.O_RDWR|ufs.O_TRUNC, 0o644)
+	file, err := fs.TouchWriter(p, ufs.O_RDWR|ufs.O_TRUNC, 0o644)
 	if err != nil {
 		return fmt.Errorf("error touching file: %w", err)
 	}
 	defer file.Close()
 
-	// Do not use CopyBuffer here, it is wasteful as the file implements
-	// io.ReaderFrom, which causes it to not use the buffer anyways.
-	n, err := io.Copy(file, r)
-
-	// Adjust the disk usage to account for the old size and the new size of the file.
-	fs.unixFS.Add(n - currentSize)
+	// The disk usage is adjusted by the writer as the data is written.
+	_, err = io.Copy(file, r)
 
 	if err := fs.chownFile(p); err != nil {
 		return fmt.Errorf("error chowning file: %w", err)
 	}
 	// Return the error from io.Copy.
-	return err
+	return wrapQuotaError(err)
 }
 
 func (fs *Filesystem) Write(p string, r io.Reader, newSize int64, mode ufs.FileMode) error {
@@ -163,30 +189,47 @@ func (fs *Filesystem) Write(p string, r io.Reader, newSize int64, mode ufs.FileM
 	// Touch the file and return the handle to it at this point. This will
 	// create or truncate the file, and create any necessary parent directories
 	// if they are missing.
-	file, err := fs.unixFS.Touch(p, ufs.O_RDWR|ufs.O_TRUNC, mode)
+	file, err := fs.TouchWriter(p, ufs.O_RDWR|ufs.O_TRUNC, mode)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	if newSize == 0 {
-		// Subtract the previous size of the file if the new size is 0.
-		fs.unixFS.Add(-currentSize)
-	} else {
-		// Do not use CopyBuffer here, it is wasteful as the file implements
-		// io.ReaderFrom, which causes it to not use the buffer anyways.
-		var n int64
-		n, err = io.Copy(file, io.LimitReader(r, newSize))
-
-		// Adjust the disk usage to account for the old size and the new size of the file.
-		fs.unixFS.Add(n - currentSize)
+	// The writer adjusts the disk usage as data is written and stops the copy
+	// part of the way through if the server runs out of space, which can still
+	// happen if something else wrote to the disk since the check above.
+	if newSize > 0 {
+		_, err = io.Copy(file, io.LimitReader(r, newSize))
 	}
 
 	if err := fs.chownFile(p); err != nil {
 		return err
 	}
 	// Return any remaining error.
-	return err
+	return wrapQuotaError(err)
+}
+
+// Append writes up to size bytes from the reader to the end of the file at p,
+// creating the file if it does not already exist. The available disk space is
+// checked before anything is written, and the disk usage of the server is then
+// adjusted by the number of bytes that were actually appended.
+func (fs *Filesystem) Append(p string, r io.Reader, size int64) (int64, error) {
+	if err := fs.HasSpaceFor(size); err != nil {
+		return 0, err
+	}
+
+	file, err := fs.TouchWriter(p, ufs.O_WRONLY|ufs.O_APPEND, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, io.LimitReader(r, size))
+
+	if err := fs.chownFile(p); err != nil {
+		return n, err
+	}
+	return n, wrapQuotaError(err)
 }
 
 // CreateDirectory creates a new directory (name) at a specified path (p) for
@@ -199,17 +242,42 @@ func (fs *Filesystem) Rename(oldpath, newpath string) error {
 	return fs.unixFS.Rename(oldpath, newpath)
 }
 
+// Replace renames oldpath to newpath, replacing the file at newpath if there is
+// one. Rename refuses to replace existing files, so the file is removed first,
+// which also releases the space it used from the disk usage of the server.
+func (fs *Filesystem) Replace(oldpath, newpath string) error {
+	if st, err := fs.unixFS.Lstat(newpath); err == nil && !st.IsDir() {
+		if err := fs.unixFS.Remove(newpath); err != nil {
+			return err
+		}
+	}
+	return fs.unixFS.Rename(oldpath, newpath)
+}
+
 func (fs *Filesystem) Symlink(oldpath, newpath string) error {
 	return fs.unixFS.Symlink(oldpath, newpath)
 }
 
+// SetOwner sets the user and group that own the files of the server, in place of
+// the Wings system user. This must be called before the filesystem is used.
+func (fs *Filesystem) SetOwner(uid, gid int) {
+	fs.uid, fs.gid = &uid, &gid
+}
+
+// owner returns the user and group that own the files of the server.
+func (fs *Filesystem) owner() (int, int) {
+	if fs.uid != nil {
+		return *fs.uid, *fs.gid
+	}
+	return config.Get().System.User.Uid, config.Get().System.User.Gid
+}
+
 func (fs *Filesystem) chownFile(name string) error {
 	if fs.isTest {
 		return nil
 	}
 
-	uid := config.Get().System.User.Uid
-	gid := config.Get().System.User.Gid
+	uid, gid := fs.owner()
 	return fs.unixFS.Lchown(name, uid, gid)
 }
 
@@ -222,8 +290,7 @@ func (fs *Filesystem) Chown(p string) error {
 		return nil
 	}
 
-	uid := config.Get().System.User.Uid
-	gid := config.Get().System.User.Gid
+	uid, gid := fs.owner()
 
 	dirfd, name, closeFd, err := fs.unixFS.SafePath(p)
 	defer closeFd()
@@ -337,23 +404,26 @@ func (fs *Filesystem) Copy(p string) error {
 	if err != nil {
 		return err
 	}
-	dst, err := fs.unixFS.OpenFileat(dirfd, newName, ufs.O_WRONLY|ufs.O_CREATE, info.Mode())
+	f, err := fs.unixFS.OpenFileat(dirfd, newName, ufs.O_WRONLY|ufs.O_CREATE, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dst, err := ufs.NewQuotaWriter(fs.unixFS, f)
 	if err != nil {
 		return err
 	}
 
-	// Do not use CopyBuffer here, it is wasteful as the file implements
-	// io.ReaderFrom, which causes it to not use the buffer anyways.
-	n, err := io.Copy(dst, io.LimitReader(source, currentSize))
-	fs.unixFS.Add(n)
+	_, err = io.Copy(dst, io.LimitReader(source, currentSize))
 
 	if !fs.isTest {
-		if err := fs.unixFS.Lchownat(dirfd, newName, config.Get().System.User.Uid, config.Get().System.User.Gid); err != nil {
+		uid, gid := fs.owner()
+		if err := fs.unixFS.Lchownat(dirfd, newName, uid, gid); err != nil {
 			return err
 		}
 	}
 	// Return the error from io.Copy.
-	return err
+	return wrapQuotaError(err)
 }
 
 func (fs *Filesystem) Ext(n string) string {
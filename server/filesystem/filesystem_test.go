@@ -196,6 +196,53 @@ func TestFilesystem_Writefile(t *testing.T) {
 			g.Assert(getFileContent(f)).Equal("new data")
 		})
 
+		g.It("appends to the end of an existing file", func() {
+			r := bytes.NewReader([]byte("original data"))
+			err := fs.Write("test.txt", r, r.Size(), 0o644)
+			g.Assert(err).IsNil()
+
+			r = bytes.NewReader([]byte(" and more"))
+			n, err := fs.Append("test.txt", r, r.Size())
+			g.Assert(err).IsNil()
+			g.Assert(n).Equal(int64(9))
+
+			f, _, err := fs.File("test.txt")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("original data and more")
+			g.Assert(fs.CachedUsage()).Equal(int64(22))
+		})
+
+		g.It("cannot append data that exceeds the disk limits", func() {
+			fs.SetDiskLimit(1024)
+
+			r := bytes.NewReader(make([]byte, 1025))
+			_, err := fs.Append("test.txt", r, r.Size())
+			g.Assert(err).IsNotNil()
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue()
+		})
+
+		g.It("stops writing once the disk limit is reached", func() {
+			fs.SetDiskLimit(1024)
+
+			r := bytes.NewReader(make([]byte, 2048))
+			err := fs.Writefile("test.txt", r)
+			g.Assert(err).IsNotNil()
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue()
+			g.Assert(fs.CachedUsage() <= 1024).IsTrue()
+		})
+
+		g.It("releases the space used by the previous contents of a file", func() {
+			r := bytes.NewReader([]byte("original data"))
+			err := fs.Write("test.txt", r, r.Size(), 0o644)
+			g.Assert(err).IsNil()
+
+			r = bytes.NewReader([]byte("new data"))
+			err = fs.Write("test.txt", r, r.Size(), 0o644)
+			g.Assert(err).IsNil()
+			g.Assert(fs.CachedUsage()).Equal(int64(8))
+		})
+
 		g.AfterEach(func() {
 			buf.Truncate(0)
 			_ = fs.TruncateRootDirectory()
@@ -0,0 +1,316 @@
+package filesystem
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"emperror.dev/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/Minenetpro/pelican-wings/internal/ufs"
+)
+
+// ErrProjectQuotaUnsupported is returned when the filesystem that a data
+// directory is stored on does not support project quotas, or when they have
+// not been enabled for it (e.g. it was not mounted with "prjquota").
+var ErrProjectQuotaUnsupported = errors.Sentinel("filesystem: project quotas are not supported")
+
+// Constants from linux/quota.h and linux/fs.h, these are not exposed by the
+// unix package. The ioctl numbers are only valid for architectures using the
+// generic ioctl encoding, which includes every architecture Wings is built for.
+const (
+	qGetQuota = 0x800007
+	qSetQuota = 0x800008
+	prjQuota  = 2
+
+	qifBLimits = 1
+
+	fsIocFsGetXattr     = 0x801c581f
+	fsIocFsSetXattr     = 0x401c5820
+	fsXflagProjInherit  = 0x00000200
+	quotaBlockSizeBytes = 1024
+)
+
+// dqblk is struct if_dqblk from linux/quota.h.
+type dqblk struct {
+	BHardLimit uint64
+	BSoftLimit uint64
+	CurSpace   uint64
+	IHardLimit uint64
+	ISoftLimit uint64
+	CurInodes  uint64
+	BTime      uint64
+	ITime      uint64
+	Valid      uint32
+}
+
+// fsxattr is struct fsxattr from linux/fs.h.
+type fsxattr struct {
+	XFlags     uint32
+	ExtSize    uint32
+	NExtents   uint32
+	ProjID     uint32
+	CowExtSize uint32
+	Pad        [8]byte
+}
+
+// EnableProjectQuota switches the filesystem over to using a kernel project
+// quota to track and limit its disk usage. The data directory is assigned a
+// project ID, derived from its inode number so that it is unique on the
+// filesystem without needing to be stored anywhere, and every file within it
+// is moved into that project. The disk limit is then enforced by the kernel,
+// including for writes made by the server process itself, and the disk usage
+// is read from the kernel rather than by walking the directory.
+//
+// If the filesystem does not support project quotas an error wrapping
+// ErrProjectQuotaUnsupported is returned, and disk usage continues to be
+// calculated by walking the directory.
+func (fs *Filesystem) EnableProjectQuota() error {
+	fd, err := fs.openRoot()
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return errors.Wrap(os.NewSyscallError("fstat", err), "server/filesystem: project quota: failed to stat root directory")
+	}
+	if st.Ino > math.MaxUint32 {
+		return fmt.Errorf("%w: inode number of root directory does not fit in a project ID", ErrProjectQuotaUnsupported)
+	}
+	id := uint32(st.Ino)
+
+	// Reading the quota of the project first ensures that project quotas are
+	// actually enabled before every file in the directory is touched.
+	var q dqblk
+	if err := quotactl(fd, qGetQuota, id, &q); err != nil {
+		return wrapProjectQuotaError(err, "failed to read quota")
+	}
+
+	var attr fsxattr
+	if err := ioctlFsxattr(fd, fsIocFsGetXattr, &attr); err != nil {
+		return wrapProjectQuotaError(err, "failed to read project of root directory")
+	}
+	// The root directory is only moved into the project once everything
+	// within it has been, so if it is already in the project there is no
+	// need to walk the entire directory again.
+	if attr.ProjID != id || attr.XFlags&fsXflagProjInherit == 0 {
+		if err := fs.setProjectTree(id); err != nil {
+			return err
+		}
+		if err := setProjectID(fd, id, true); err != nil {
+			return wrapProjectQuotaError(err, "failed to set project of root directory")
+		}
+	}
+
+	if err := setProjectLimit(fd, id, fs.MaxDisk()); err != nil {
+		return wrapProjectQuotaError(err, "failed to set quota limit")
+	}
+	fs.projectID.Store(id)
+	return nil
+}
+
+// projectUsage returns the disk usage of the project from the kernel.
+func (fs *Filesystem) projectUsage(id uint32) (int64, error) {
+	fd, err := fs.openRoot()
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(fd)
+
+	var q dqblk
+	if err := quotactl(fd, qGetQuota, id, &q); err != nil {
+		return 0, errors.Wrap(err, "server/filesystem: project quota: failed to read quota")
+	}
+	return int64(q.CurSpace), nil
+}
+
+// updateProjectLimit sets the limit of the project quota to the disk limit of
+// the filesystem, if the filesystem is using a project quota.
+func (fs *Filesystem) updateProjectLimit() error {
+	id := fs.projectID.Load()
+	if id == 0 {
+		return nil
+	}
+	fd, err := fs.openRoot()
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	if err := setProjectLimit(fd, id, fs.MaxDisk()); err != nil {
+		return errors.Wrap(err, "server/filesystem: project quota: failed to set quota limit")
+	}
+	return nil
+}
+
+// setProjectTree moves every directory and regular file within the data
+// directory, except the root itself, into the project.
+func (fs *Filesystem) setProjectTree(id uint32) error {
+	dirfd, name, closeFd, err := fs.unixFS.SafePath("/")
+	defer closeFd()
+	if err != nil {
+		return err
+	}
+	err = fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, ufs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if relative == "." || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+		// Files may be swapped out for something that blocks when opened at
+		// any point, such as a fifo.
+		f, err := fs.unixFS.OpenFileat(dirfd, name, ufs.O_RDONLY|unix.O_NONBLOCK, 0)
+		if err != nil {
+			if errors.Is(err, ufs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		defer f.Close()
+		return setProjectID(int(f.Fd()), id, d.IsDir())
+	})
+	return wrapProjectQuotaError(err, "failed to set project of files")
+}
+
+// openRoot opens the root directory of the filesystem.
+func (fs *Filesystem) openRoot() (int, error) {
+	fd, err := unix.Open(fs.Path(), unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, errors.Wrap(os.NewSyscallError("open", err), "server/filesystem: project quota: failed to open root directory")
+	}
+	return fd, nil
+}
+
+// setProjectID sets the project of the file, directories are also marked so
+// that anything created within them inherits the project.
+func setProjectID(fd int, id uint32, dir bool) error {
+	var attr fsxattr
+	if err := ioctlFsxattr(fd, fsIocFsGetXattr, &attr); err != nil {
+		return err
+	}
+	attr.ProjID = id
+	if dir {
+		attr.XFlags |= fsXflagProjInherit
+	}
+	return ioctlFsxattr(fd, fsIocFsSetXattr, &attr)
+}
+
+// setProjectLimit sets the hard limit of the project in bytes. A limit of 0
+// removes the limit.
+func setProjectLimit(fd int, id uint32, limit int64) error {
+	var blocks uint64
+	if limit > 0 {
+		blocks = uint64((limit + quotaBlockSizeBytes - 1) / quotaBlockSizeBytes)
+	}
+	q := dqblk{BHardLimit: blocks, Valid: qifBLimits}
+	return quotactl(fd, qSetQuota, id, &q)
+}
+
+func ioctlFsxattr(fd int, req uint, attr *fsxattr) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(unsafe.Pointer(attr)))
+	if errno != 0 {
+		return os.NewSyscallError("ioctl", errno)
+	}
+	return nil
+}
+
+// quotactl runs a project quota command against the filesystem that fd is on.
+// Kernels older than 5.14 do not support quotactl_fd, in which case the block
+// device the filesystem is mounted from is used instead.
+func quotactl(fd int, cmd int, id uint32, q *dqblk) error {
+	cmd = cmd<<8 | prjQuota
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL_FD, uintptr(fd), uintptr(cmd), uintptr(id), uintptr(unsafe.Pointer(q)), 0, 0)
+	if errno != unix.ENOSYS {
+		if errno != 0 {
+			return os.NewSyscallError("quotactl_fd", errno)
+		}
+		return nil
+	}
+
+	dev, err := mountDevice(fd)
+	if err != nil {
+		return err
+	}
+	p, err := unix.BytePtrFromString(dev)
+	if err != nil {
+		return err
+	}
+	_, _, errno = unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd), uintptr(unsafe.Pointer(p)), uintptr(id), uintptr(unsafe.Pointer(q)), 0, 0)
+	if errno != 0 {
+		return os.NewSyscallError("quotactl", errno)
+	}
+	return nil
+}
+
+// mountDevice returns the source that the filesystem fd is on was mounted
+// from by looking up its device number in the mountinfo of the process.
+func mountDevice(fd int) (string, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return "", os.NewSyscallError("fstat", err)
+	}
+	want := strconv.FormatUint(uint64(unix.Major(uint64(st.Dev))), 10) + ":" + strconv.FormatUint(uint64(unix.Minor(uint64(st.Dev))), 10)
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[2] != want {
+			continue
+		}
+		for i, field := range fields {
+			if field == "-" && i+2 < len(fields) {
+				return fields[i+2], nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", unix.ENODEV
+}
+
+// wrapProjectQuotaError wraps errors that indicate project quotas cannot be
+// used on the filesystem with ErrProjectQuotaUnsupported.
+func wrapProjectQuotaError(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	for _, errno := range []unix.Errno{unix.ENOSYS, unix.ESRCH, unix.ENOTSUP, unix.EINVAL, unix.ENOTTY, unix.ENODEV} {
+		if errors.Is(err, errno) {
+			return fmt.Errorf("%w: %s: %w", ErrProjectQuotaUnsupported, msg, err)
+		}
+	}
+	return errors.Wrap(err, "server/filesystem: project quota: "+msg)
+}
+
+// commitProjectUsage reads the disk usage of the project from the kernel and
+// stores it as the cached disk usage of the filesystem. This is an O(1)
+// operation, no matter how many files are in the data directory.
+func (fs *Filesystem) commitProjectUsage(id uint32) (int64, error) {
+	usage, err := fs.projectUsage(id)
+	if err != nil {
+		return 0, err
+	}
+	fs.unixFS.SetUsage(usage)
+	fs.lastLookupTime.Set(time.Now())
+	return usage, nil
+}
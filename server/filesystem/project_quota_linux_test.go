@@ -0,0 +1,107 @@
+package filesystem
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"golang.org/x/sys/unix"
+
+	"github.com/Minenetpro/pelican-wings/config"
+)
+
+func TestFilesystem_EnableProjectQuota(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("EnableProjectQuota", func() {
+		g.It("falls back to walking the directory when project quotas are not supported", func() {
+			_ = rfs.CreateServerFileFromString("test.txt", "test content")
+
+			err := fs.EnableProjectQuota()
+			if err == nil {
+				// The temporary directory supports project quotas, which is
+				// covered by TestFilesystem_ProjectQuota instead.
+				return
+			}
+			g.Assert(errors.Is(err, ErrProjectQuotaUnsupported)).IsTrue("err is not ErrProjectQuotaUnsupported")
+			g.Assert(fs.projectID.Load()).Equal(uint32(0))
+
+			size, err := fs.DiskUsage(false)
+			g.Assert(err).IsNil()
+			g.Assert(size).Equal(int64(12))
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
+
+// TestFilesystem_ProjectQuota requires a directory on a filesystem mounted with
+// project quotas enabled, for example a loopback image created with:
+//
+//	truncate -s 64M prj.img && mkfs.xfs prj.img
+//	mount -o loop,prjquota prj.img /mnt/prj
+//	WINGS_TEST_PROJECT_QUOTA_DIR=/mnt/prj go test ./server/filesystem
+func TestFilesystem_ProjectQuota(t *testing.T) {
+	dir := os.Getenv("WINGS_TEST_PROJECT_QUOTA_DIR")
+	if dir == "" {
+		t.Skip("WINGS_TEST_PROJECT_QUOTA_DIR is not set")
+	}
+	root, err := os.MkdirTemp(dir, "pelican")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	config.Set(&config.Configuration{
+		AuthenticationToken: "abc",
+		System: config.SystemConfiguration{
+			DiskCheckInterval: 150,
+		},
+	})
+
+	g := Goblin(t)
+	fs, err := New(root, 1024*1024, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.isTest = true
+
+	g.Describe("ProjectQuota", func() {
+		g.It("moves existing files into the project", func() {
+			err := os.WriteFile(filepath.Join(root, "existing.txt"), make([]byte, 64*1024), 0o644)
+			g.Assert(err).IsNil()
+
+			err = fs.EnableProjectQuota()
+			g.Assert(err).IsNil()
+			g.Assert(fs.projectID.Load() != 0).IsTrue()
+
+			size, err := fs.DiskUsage(false)
+			g.Assert(err).IsNil()
+			g.Assert(size >= 64*1024).IsTrue()
+		})
+
+		g.It("enforces the limit for writes made outside of Wings", func() {
+			err := os.WriteFile(filepath.Join(root, "large.bin"), make([]byte, 2*1024*1024), 0o644)
+			g.Assert(errors.Is(err, unix.EDQUOT)).IsTrue("err is not EDQUOT")
+		})
+
+		g.It("returns a disk space error for writes made through Wings", func() {
+			r := bytes.NewReader(make([]byte, 2*1024*1024))
+			err := fs.Writefile("large.bin", r)
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue()
+		})
+
+		g.It("updates the limit of the project", func() {
+			fs.SetDiskLimit(4 * 1024 * 1024)
+
+			err := os.WriteFile(filepath.Join(root, "large.bin"), make([]byte, 2*1024*1024), 0o644)
+			g.Assert(err).IsNil()
+		})
+	})
+}
@@ -0,0 +1,381 @@
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	ignore "github.com/sabhiram/go-gitignore"
+	"golang.org/x/sys/unix"
+
+	"github.com/Minenetpro/pelican-wings/internal/ufs"
+)
+
+// ChangeOp is the type of change that was made to a file.
+type ChangeOp string
+
+const (
+	ChangeCreate ChangeOp = "create"
+	ChangeModify ChangeOp = "modify"
+	ChangeDelete ChangeOp = "delete"
+)
+
+// FileChange is a single change made to a file or directory within the data
+// directory of a server.
+type FileChange struct {
+	Path string   `json:"path"`
+	Op   ChangeOp `json:"op"`
+	Size int64    `json:"size"`
+}
+
+// The inotify events a directory is watched for. Attribute changes are not
+// watched since Wings itself chowns files constantly.
+const watchMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_DELETE | unix.IN_MOVED_FROM |
+	unix.IN_MOVED_TO | unix.IN_ONLYDIR | unix.IN_EXCL_UNLINK
+
+// Watch watches the data directory of the server for changes made by anything
+// on the system, not just Wings, and calls fn with the changes that were made.
+// Changes are coalesced so that fn is called at most once every debounce
+// interval, with a single change for each path. Paths matching any of the
+// gitignore style patterns are not watched or reported.
+//
+// While the watcher is running it also adjusts the cached disk usage of the
+// filesystem as files are changed by other processes, so that the usage stays
+// accurate between the walks of the directory made every DiskCheckInterval.
+//
+// This function blocks until the context is canceled or the data directory is
+// removed.
+func (fs *Filesystem) Watch(ctx context.Context, debounce time.Duration, patterns []string, fn func([]FileChange)) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return errors.Wrap(os.NewSyscallError("inotify_init1", err), "server/filesystem: watch: failed to initialize inotify")
+	}
+	// Wrapping the non-blocking descriptor in a file registers it with the
+	// runtime poller, which allows Close to interrupt a pending read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+
+	w := &watcher{
+		fs:      fs,
+		fd:      fd,
+		ignore:  ignore.CompileIgnoreLines(patterns...),
+		watches: make(map[int]string),
+		dirs:    make(map[string]int),
+		sizes:   make(map[string]int64),
+		pending: make(map[string]ChangeOp),
+	}
+	if err := w.addTree("/"); err != nil {
+		return err
+	}
+	w.resetUsage()
+
+	events := make(chan []byte)
+	go func() {
+		defer close(events)
+		for {
+			buf := make([]byte, unix.SizeofInotifyEvent*4096)
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case events <- buf[:n]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	// Closing the file is the only way to stop the reader above.
+	go func() {
+		<-ctx.Done()
+		_ = f.Close()
+	}()
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	var armed bool
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case buf, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if w.handle(buf) {
+				// The root directory is gone, report whatever was pending
+				// and stop watching.
+				w.flush(fn)
+				return nil
+			}
+			if len(w.pending) > 0 && !armed {
+				timer.Reset(debounce)
+				armed = true
+			}
+		case <-timer.C:
+			armed = false
+			w.flush(fn)
+		}
+	}
+}
+
+type watcher struct {
+	fs     *Filesystem
+	fd     int
+	ignore *ignore.GitIgnore
+
+	// watches maps inotify watch descriptors to the directory they are
+	// watching, and dirs is the reverse of that.
+	watches map[int]string
+	dirs    map[string]int
+
+	// sizes tracks the size of every regular file in the data directory so
+	// that the disk usage can be adjusted as files change.
+	sizes map[string]int64
+	total int64
+
+	// The total size of the files and the adjustments made to the usage of the
+	// filesystem when the usage was last committed.
+	committed int64
+	added     int64
+
+	pending map[string]ChangeOp
+	order   []string
+	limited bool
+}
+
+// handle processes a buffer of raw inotify events. It returns true if the root
+// directory is no longer being watched.
+func (w *watcher) handle(buf []byte) bool {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		name := string(buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(raw.Len)])
+		name = strings.TrimRight(name, "\x00")
+		offset += unix.SizeofInotifyEvent + int(raw.Len)
+
+		if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+			// Events were dropped by the kernel, the only way to recover is
+			// to start over and report the whole directory as modified.
+			w.sizes = make(map[string]int64)
+			w.total = 0
+			if err := w.addTree("/"); err != nil {
+				log.WithField("root", w.fs.Path()).WithField("error", err).Warn("failed to rescan directory after inotify queue overflow")
+			}
+			// The changes that were dropped are left for the next walk of the
+			// directory to account for.
+			w.resetUsage()
+			w.queue("/", ChangeModify)
+			continue
+		}
+
+		dir, ok := w.watches[int(raw.Wd)]
+		if !ok {
+			continue
+		}
+		if raw.Mask&unix.IN_IGNORED != 0 {
+			delete(w.watches, int(raw.Wd))
+			delete(w.dirs, dir)
+			if dir == "/" {
+				return true
+			}
+			continue
+		}
+		if name == "" {
+			continue
+		}
+
+		p := path.Join(dir, name)
+		if w.ignore.MatchesPath(p) {
+			continue
+		}
+		isDir := raw.Mask&unix.IN_ISDIR != 0
+		switch {
+		case raw.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			if isDir {
+				if err := w.addTree(p); err != nil {
+					log.WithField("root", w.fs.Path()).WithField("path", p).WithField("error", err).Debug("failed to watch new directory")
+				}
+			}
+			w.queue(p, ChangeCreate)
+		case raw.Mask&unix.IN_MODIFY != 0:
+			w.queue(p, ChangeModify)
+		case raw.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+			if isDir {
+				w.removeTree(p)
+			}
+			w.queue(p, ChangeDelete)
+		}
+	}
+	return false
+}
+
+// queue marks the path as changed. A path that was created within the same
+// debounce interval is still reported as created if it is then modified.
+func (w *watcher) queue(p string, op ChangeOp) {
+	if prev, ok := w.pending[p]; ok {
+		if !(prev == ChangeCreate && op == ChangeModify) {
+			w.pending[p] = op
+		}
+		return
+	}
+	w.pending[p] = op
+	w.order = append(w.order, p)
+}
+
+// flush reports all the pending changes, using the current state of each path
+// on the disk to determine its size, and then updates the cached disk usage.
+func (w *watcher) flush(fn func([]FileChange)) {
+	if len(w.order) == 0 {
+		return
+	}
+	changes := make([]FileChange, 0, len(w.order))
+	for _, p := range w.order {
+		c := FileChange{Path: p, Op: w.pending[p]}
+		if c.Op != ChangeDelete {
+			st, err := w.fs.unixFS.Lstat(p)
+			if err != nil {
+				c.Op = ChangeDelete
+			} else if st.Mode().IsRegular() {
+				c.Size = st.Size()
+				w.setSize(p, c.Size)
+			}
+		}
+		if c.Op == ChangeDelete {
+			w.setSize(p, -1)
+		}
+		changes = append(changes, c)
+	}
+	w.pending = make(map[string]ChangeOp)
+	w.order = w.order[:0]
+
+	w.commitUsage()
+	fn(changes)
+}
+
+// setSize records the size of a file, a negative size removes the file.
+func (w *watcher) setSize(p string, size int64) {
+	w.total -= w.sizes[p]
+	if size < 0 {
+		delete(w.sizes, p)
+		return
+	}
+	w.sizes[p] = size
+	w.total += size
+}
+
+// commitUsage adjusts the cached disk usage of the filesystem by the change in
+// the size of the files since it was last committed, less the adjustments Wings
+// has already made itself for the files it wrote or removed in the meantime. The
+// usage is never replaced, so the periodic walk of the directory remains the
+// authoritative value. The usage reported by a project quota is more accurate,
+// so it is never adjusted.
+func (w *watcher) commitUsage() {
+	added := w.fs.unixFS.Added()
+	delta := (w.total - w.committed) - (added - w.added)
+	w.committed, w.added = w.total, added
+	if delta == 0 || w.fs.diskCheckInterval == 0 || w.fs.projectID.Load() != 0 || w.fs.unixFS.Usage() < 0 {
+		return
+	}
+	w.fs.unixFS.Add(delta)
+	// Don't mistake this adjustment for one made by Wings the next time.
+	w.added += delta
+}
+
+// resetUsage starts tracking changes to the disk usage from the current size of
+// the files, without adjusting the cached disk usage.
+func (w *watcher) resetUsage() {
+	w.committed, w.added = w.total, w.fs.unixFS.Added()
+}
+
+// addTree adds a watch to the directory at p and all of its descendants, and
+// records the size of every file within them.
+func (w *watcher) addTree(p string) error {
+	dirfd, name, closeFd, err := w.fs.unixFS.SafePath(p)
+	defer closeFd()
+	if err != nil {
+		return err
+	}
+	return w.fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			// Files and directories can disappear while being walked, that
+			// is not a reason to stop watching everything else.
+			if errors.Is(err, ufs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel := path.Join(p, relative)
+		if rel != "/" && w.ignore.MatchesPath(rel) {
+			if d.IsDir() {
+				return ufs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return w.addWatch(dirfd, name, rel)
+		}
+		if d.Type().IsRegular() {
+			if st, err := w.fs.unixFS.Lstatat(dirfd, name); err == nil {
+				w.setSize(rel, st.Size())
+			}
+		}
+		return nil
+	})
+}
+
+// addWatch adds an inotify watch to a single directory. The directory is
+// opened relative to its parent descriptor and the watch is added through
+// procfs so that a directory swapped for a symlink can never cause a
+// location outside the data directory to be watched.
+func (w *watcher) addWatch(dirfd int, name, rel string) error {
+	f, err := w.fs.unixFS.OpenFileat(dirfd, name, ufs.O_DIRECTORY|ufs.O_RDONLY, 0)
+	if err != nil {
+		if errors.Is(err, ufs.ErrNotExist) {
+			return ufs.SkipDir
+		}
+		return err
+	}
+	defer f.Close()
+
+	wd, err := unix.InotifyAddWatch(w.fd, "/proc/self/fd/"+strconv.Itoa(int(f.Fd())), watchMask)
+	if err != nil {
+		if errors.Is(err, unix.ENOSPC) {
+			// The system has run out of inotify watches, keep watching what
+			// we can rather than failing outright.
+			if !w.limited {
+				w.limited = true
+				log.WithField("root", w.fs.Path()).Warn("reached the inotify watch limit, some directories will not be watched (see fs.inotify.max_user_watches)")
+			}
+			return ufs.SkipDir
+		}
+		return errors.Wrap(os.NewSyscallError("inotify_add_watch", err), "server/filesystem: watch: failed to watch directory")
+	}
+	w.watches[wd] = rel
+	w.dirs[rel] = wd
+	return nil
+}
+
+// removeTree stops watching the directory at p and all of its descendants, and
+// forgets the size of every file within them. This is used when a directory is
+// moved, since the kernel keeps watching the directory at its new location.
+func (w *watcher) removeTree(p string) {
+	prefix := p + "/"
+	for dir, wd := range w.dirs {
+		if dir == p || strings.HasPrefix(dir, prefix) {
+			_, _ = unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, dir)
+			delete(w.watches, wd)
+		}
+	}
+	for f := range w.sizes {
+		if strings.HasPrefix(f, prefix) {
+			w.setSize(f, -1)
+		}
+	}
+}
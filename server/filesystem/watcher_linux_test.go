@@ -0,0 +1,128 @@
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_Watch(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("Watch", func() {
+		var cancel context.CancelFunc
+		var changes chan []FileChange
+
+		// next waits for the next batch of changes reported by the watcher.
+		next := func() []FileChange {
+			select {
+			case c := <-changes:
+				return c
+			case <-time.After(time.Second * 2):
+				return nil
+			}
+		}
+
+		g.BeforeEach(func() {
+			_ = fs.CreateDirectory("logs", "/")
+			_ = rfs.CreateServerFileFromString("existing.txt", "existing")
+			fs.unixFS.SetUsage(8)
+
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			changes = make(chan []FileChange, 8)
+			go func() {
+				_ = fs.Watch(ctx, time.Millisecond*50, []string{"logs/"}, func(c []FileChange) {
+					changes <- c
+				})
+			}()
+
+			// Touch an empty file until the watcher reports it, at which point it
+			// has finished its initial walk, then discard anything else it reports.
+			for ready := false; !ready; {
+				_ = rfs.CreateServerFileFromString("ready.txt", "")
+				select {
+				case <-changes:
+					ready = true
+				case <-time.After(time.Millisecond * 100):
+				}
+			}
+			time.Sleep(time.Millisecond * 100)
+			for len(changes) > 0 {
+				<-changes
+			}
+		})
+
+		g.It("reports files created by other processes", func() {
+			err := rfs.CreateServerFileFromString("test.txt", "test content")
+			g.Assert(err).IsNil()
+
+			c := next()
+			g.Assert(c).Equal([]FileChange{{Path: "/test.txt", Op: ChangeCreate, Size: 12}})
+			g.Assert(fs.CachedUsage()).Equal(int64(20))
+		})
+
+		g.It("does not count files written by Wings twice", func() {
+			r := strings.NewReader("wings")
+			g.Assert(fs.Write("wings.txt", r, r.Size(), 0o644)).IsNil()
+			g.Assert(fs.CachedUsage()).Equal(int64(13))
+
+			c := next()
+			g.Assert(c).Equal([]FileChange{{Path: "/wings.txt", Op: ChangeCreate, Size: 5}})
+			g.Assert(fs.CachedUsage()).Equal(int64(13))
+		})
+
+		g.It("adjusts the usage from the last walk of the directory", func() {
+			// The walk also counts files the watcher ignores.
+			fs.unixFS.SetUsage(100)
+			err := rfs.CreateServerFileFromString("test.txt", "test content")
+			g.Assert(err).IsNil()
+
+			g.Assert(next()).IsNotNil()
+			g.Assert(fs.CachedUsage()).Equal(int64(112))
+			g.Assert(fs.lastLookupTime.Get().IsZero()).IsTrue()
+		})
+
+		g.It("reports deleted files", func() {
+			err := os.Remove(filepath.Join(rfs.root, "server/existing.txt"))
+			g.Assert(err).IsNil()
+
+			c := next()
+			g.Assert(c).Equal([]FileChange{{Path: "/existing.txt", Op: ChangeDelete}})
+			g.Assert(fs.CachedUsage()).Equal(int64(0))
+		})
+
+		g.It("watches directories created after it started", func() {
+			err := os.MkdirAll(filepath.Join(rfs.root, "server/a/b"), 0o755)
+			g.Assert(err).IsNil()
+			g.Assert(next()).IsNotNil()
+
+			err = rfs.CreateServerFileFromString("a/b/nested.txt", "nested")
+			g.Assert(err).IsNil()
+
+			c := next()
+			g.Assert(c).Equal([]FileChange{{Path: "/a/b/nested.txt", Op: ChangeCreate, Size: 6}})
+		})
+
+		g.It("does not report ignored files", func() {
+			err := rfs.CreateServerFileFromString("logs/latest.log", "log line")
+			g.Assert(err).IsNil()
+			err = rfs.CreateServerFileFromString("test.txt", "test content")
+			g.Assert(err).IsNil()
+
+			c := next()
+			g.Assert(c).Equal([]FileChange{{Path: "/test.txt", Op: ChangeCreate, Size: 12}})
+		})
+
+		g.AfterEach(func() {
+			cancel()
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/internal/query"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/system"
+)
+
+// The health of a server, as reported in its resource usage.
+const (
+	// HealthNone is used when the server has no health check, or is not running.
+	HealthNone      = "none"
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// healthChecker tracks the health checks that are running for a server.
+type healthChecker struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// healthCheck returns the health check for the server, if it has one. A check
+// set for the server itself takes priority over the one set by its egg.
+func (s *Server) healthCheck() *remote.HealthCheck {
+	if hc := s.Config().Health; hc != nil {
+		return hc
+	}
+	return s.ProcessConfiguration().Health
+}
+
+// Health returns the current health of the server.
+func (s *Server) Health() string {
+	return s.resources.Health.Load()
+}
+
+// setHealth sets the health of the server, publishing the resource usage of the
+// server if it changed so that listeners see the change immediately.
+func (s *Server) setHealth(health string) {
+	if s.resources.Health.Load() == health {
+		return
+	}
+	s.resources.Health.Store(health)
+	s.Events().Publish(StatsEvent, s.Proc())
+}
+
+// startHealthChecks starts running the health check of the server in the
+// background, if it has one and it is not already running.
+func (s *Server) startHealthChecks() {
+	hc := s.healthCheck()
+	if hc == nil {
+		return
+	}
+
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	if s.health.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.Context())
+	s.health.cancel = cancel
+	s.setHealth(HealthStarting)
+	go s.runHealthChecks(ctx, *hc)
+}
+
+// stopHealthChecks stops the health checks of the server, if they are running.
+func (s *Server) stopHealthChecks() {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	if s.health.cancel != nil {
+		s.health.cancel()
+		s.health.cancel = nil
+	}
+	s.setHealth(HealthNone)
+}
+
+// runHealthChecks runs the health check every interval until the context is
+// canceled. The server is marked as unhealthy once enough checks have failed in
+// a row, and restarted if it is configured to be.
+func (s *Server) runHealthChecks(ctx context.Context, hc remote.HealthCheck) {
+	ticker := time.NewTicker(hc.Every())
+	defer ticker.Stop()
+
+	var failures int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cctx, cancel := context.WithTimeout(ctx, hc.Wait())
+		err := s.probe(cctx, hc)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+			s.setHealth(HealthHealthy)
+			continue
+		}
+
+		failures++
+		s.Log().WithField("error", err).WithField("failures", failures).Debug("server failed health check")
+		if failures == hc.FailureThreshold() {
+			s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Server failed %d health checks in a row and is unhealthy.", failures))
+			s.setHealth(HealthUnhealthy)
+		}
+		if hc.RestartAfter > 0 && failures >= hc.RestartAfter {
+			s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Server failed %d health checks in a row, restarting...", failures))
+			s.Log().WithField("failures", failures).Warn("restarting server after failed health checks")
+			go func() {
+				if err := s.HandlePowerAction(PowerActionRestart, 10); err != nil {
+					s.Log().WithField("error", err).Error("failed to restart server after failed health checks")
+				}
+			}()
+			return
+		}
+	}
+}
+
+// probe runs a health check once, returning an error if it failed.
+func (s *Server) probe(ctx context.Context, hc remote.HealthCheck) error {
+	switch hc.Type {
+	case remote.HealthCheckTCP:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", s.allocationAddress(hc.Port))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return conn.Close()
+	case remote.HealthCheckQuery:
+		_, err := query.Query(ctx, hc.Protocol, s.allocationAddress(hc.Port))
+		return err
+	case remote.HealthCheckCommand:
+		return s.probeCommand(ctx, hc)
+	case remote.HealthCheckExec:
+		return s.probeExec(ctx, hc)
+	}
+	return errors.New("server: unknown health check type: " + hc.Type)
+}
+
+// allocationAddress returns the address of the default allocation of the server,
+// using the given port instead of the port of the allocation if it is set.
+func (s *Server) allocationAddress(port int) string {
+	m := s.Config().Allocations.DefaultMapping
+	ip := "127.0.0.1"
+	if m != nil {
+		if m.Ip != "" && m.Ip != "0.0.0.0" {
+			ip = m.Ip
+		}
+		if port == 0 {
+			port = m.Port
+		}
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// probeCommand sends the command of the health check to the server console and
+// waits for a line of output matching the expected output.
+func (s *Server) probeCommand(ctx context.Context, hc remote.HealthCheck) error {
+	if hc.Expect == nil {
+		return errors.New("server: command health check has no expected output")
+	}
+
+	c := make(chan []byte, 16)
+	s.Sink(system.LogSink).On(c)
+	defer s.Sink(system.LogSink).Off(c)
+
+	if err := s.Environment.SendCommand(hc.Command); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case line := <-c:
+			if hc.Expect.Matches(line) {
+				return nil
+			}
+		}
+	}
+}
+
+// probeExec runs the command of the health check inside of the server, checking
+// that it exits successfully and that its output matches, if there is expected
+// output.
+func (s *Server) probeExec(ctx context.Context, hc remote.HealthCheck) error {
+	e, ok := s.Environment.(environment.Executor)
+	if !ok {
+		return errors.New("server: environment does not support exec health checks")
+	}
+
+	p, err := e.Exec(ctx, []string{"/bin/sh", "-c", hc.Command}, environment.ExecOptions{})
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	_ = p.CloseStdin()
+
+	var out bytes.Buffer
+	if err := p.Output(&out, &out); err != nil {
+		return err
+	}
+	code, err := p.ExitCode(ctx)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return errors.New("server: health check exited with code " + strconv.Itoa(code))
+	}
+	if hc.Expect != nil && !hc.Expect.Matches(out.Bytes()) {
+		return errors.New("server: health check output did not match")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	"github.com/goccy/go-json"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/environment/fake"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/system"
+)
+
+func TestHealth(t *testing.T) {
+	g := Goblin(t)
+
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	g.Describe("Health checks", func() {
+		var s *Server
+		var e *fake.Environment
+
+		g.BeforeEach(func() {
+			s, _ = New(nil)
+			s.procConfig = &remote.ProcessConfiguration{}
+			e = fake.New("health", nil)
+			s.Environment = e
+		})
+
+		g.It("prefers the check set for the server over that of its egg", func() {
+			egg := &remote.HealthCheck{Type: remote.HealthCheckTCP}
+			s.procConfig.Health = egg
+			g.Assert(s.healthCheck()).Equal(egg)
+
+			own := &remote.HealthCheck{Type: remote.HealthCheckCommand}
+			s.cfg.Health = own
+			g.Assert(s.healthCheck()).Equal(own)
+		})
+
+		g.It("checks the default allocation of the server", func() {
+			g.Assert(s.allocationAddress(25575)).Equal("127.0.0.1:25575")
+
+			s.cfg.Allocations.DefaultMapping = &environment.DefaultAllocationMapping{Ip: "0.0.0.0", Port: 25565}
+			g.Assert(s.allocationAddress(0)).Equal("127.0.0.1:25565")
+
+			s.cfg.Allocations.DefaultMapping.Ip = "10.0.0.5"
+			g.Assert(s.allocationAddress(0)).Equal("10.0.0.5:25565")
+			g.Assert(s.allocationAddress(25575)).Equal("10.0.0.5:25575")
+		})
+
+		g.It("connects to the server for a tcp check", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			g.Assert(err).IsNil()
+			port := l.Addr().(*net.TCPAddr).Port
+			hc := remote.HealthCheck{Type: remote.HealthCheckTCP, Port: port}
+
+			g.Assert(s.probe(context.Background(), hc)).IsNil()
+			_ = l.Close()
+			g.Assert(s.probe(context.Background(), hc) != nil).IsTrue()
+		})
+
+		g.It("waits for the expected output of a command check", func() {
+			var expect remote.OutputLineMatcher
+			g.Assert(json.Unmarshal([]byte(strconv.Quote(`regex:^There are \d+ players`)), &expect)).IsNil()
+			hc := remote.HealthCheck{Type: remote.HealthCheckCommand, Command: "list", Expect: &expect}
+
+			e.OnCommand("list", func() {
+				s.Sink(system.LogSink).Push([]byte("Unknown command"))
+				s.Sink(system.LogSink).Push([]byte("There are 2 players online"))
+			})
+			g.Assert(e.Start(context.Background())).IsNil()
+			g.Assert(s.probe(context.Background(), hc)).IsNil()
+
+			e.OnCommand("list", func() {})
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			g.Assert(s.probe(ctx, hc) != nil).IsTrue()
+		})
+
+		g.It("fails a check that cannot be run", func() {
+			g.Assert(s.probe(context.Background(), remote.HealthCheck{Type: remote.HealthCheckCommand, Command: "list"}) != nil).IsTrue()
+			g.Assert(s.probe(context.Background(), remote.HealthCheck{Type: remote.HealthCheckExec, Command: "true"}) != nil).IsTrue()
+			g.Assert(s.probe(context.Background(), remote.HealthCheck{Type: "ping"}) != nil).IsTrue()
+		})
+	})
+}
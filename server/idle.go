@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+	"github.com/Minenetpro/pelican-wings/internal/query"
+)
+
+// idleMonitor tracks the activity checks running for a server, and the listeners
+// holding its ports after it has been stopped for being idle.
+type idleMonitor struct {
+	mu        sync.Mutex
+	cancel    context.CancelFunc
+	listeners []io.Closer
+	// Closes the listeners if the server is deleted while they are open.
+	release context.CancelFunc
+}
+
+// IsSleeping returns true if the server was stopped for being idle, and will be
+// started again when a connection is received.
+func (s *Server) IsSleeping() bool {
+	s.idle.mu.Lock()
+	defer s.idle.mu.Unlock()
+	return len(s.idle.listeners) > 0
+}
+
+// startIdleMonitor starts checking the server for activity in the background, if
+// it has an idle timeout and is not already being checked.
+func (s *Server) startIdleMonitor() {
+	timeout := s.Config().Idle.Timeout
+	if timeout <= 0 {
+		return
+	}
+
+	s.idle.mu.Lock()
+	defer s.idle.mu.Unlock()
+	if s.idle.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.Context())
+	s.idle.cancel = cancel
+	go s.runIdleMonitor(ctx, time.Duration(timeout)*time.Second)
+}
+
+// stopIdleMonitor stops checking the server for activity, if it is being checked.
+func (s *Server) stopIdleMonitor() {
+	s.idle.mu.Lock()
+	defer s.idle.mu.Unlock()
+	if s.idle.cancel != nil {
+		s.idle.cancel()
+		s.idle.cancel = nil
+	}
+}
+
+// runIdleMonitor checks the server for activity every interval until the context
+// is canceled, stopping the server once it has been idle for the timeout.
+func (s *Server) runIdleMonitor(ctx context.Context, timeout time.Duration) {
+	interval := time.Duration(config.Get().System.Idle.CheckInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var since time.Time
+	traffic := s.networkTraffic()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Players online are the best measure of activity, but network traffic has
+		// to be used for servers that cannot be queried.
+		idle := false
+		if res := s.QueryResult(); res != nil {
+			idle = res.Players == 0
+		} else {
+			t := s.networkTraffic()
+			idle = int64(float64(t-traffic)/interval.Seconds()) < config.Get().System.Idle.NetworkThreshold
+			traffic = t
+		}
+		if !idle {
+			since = time.Time{}
+			continue
+		}
+		if since.IsZero() {
+			since = time.Now()
+		}
+		if time.Since(since) < timeout {
+			continue
+		}
+
+		s.Log().WithField("idle", timeout).Info("stopping server after being idle")
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Server has been idle for %s, stopping...", timeout))
+		s.SaveActivity(s.NewRequestActivity("", "127.0.0.1"), ActivityIdleStop, models.ActivityMeta{
+			"idle": int(timeout.Seconds()),
+		})
+		go func() {
+			if err := s.HandlePowerAction(PowerActionStop, 10); err != nil {
+				s.Log().WithField("error", err).Error("failed to stop idle server")
+				return
+			}
+			if s.Config().Idle.Wake {
+				s.startWakeListeners()
+			}
+		}()
+		return
+	}
+}
+
+// networkTraffic returns the total number of bytes the server has sent and
+// received since it was started.
+func (s *Server) networkTraffic() uint64 {
+	n := s.resources.Snapshot().Network
+	return n.RxBytes + n.TxBytes
+}
+
+// startWakeListeners listens on the allocation ports of the server while it is
+// stopped, starting it again when a client of the game tries to connect. Clients
+// are recognised by the query protocol of the server, so nothing is listened on
+// for a server without one, and only the network used by the game is listened
+// on. A port that cannot be listened on is skipped.
+func (s *Server) startWakeListeners() {
+	s.idle.mu.Lock()
+	defer s.idle.mu.Unlock()
+	if len(s.idle.listeners) > 0 {
+		return
+	}
+
+	qc := s.queryConfiguration()
+	if qc == nil || query.Network(qc.Protocol) == "" {
+		s.Log().Warn("server has no query protocol to recognise connections with, not listening for connections to idle server")
+		return
+	}
+	for _, addr := range s.allocationAddresses() {
+		if query.Network(qc.Protocol) == "tcp" {
+			if l, err := net.Listen("tcp", addr); err != nil {
+				s.Log().WithField("address", addr).WithField("error", err).Warn("failed to listen for connections to idle server")
+			} else {
+				s.idle.listeners = append(s.idle.listeners, l)
+				go s.acceptWakeConnections(l)
+			}
+		} else if c, err := net.ListenPacket("udp", addr); err != nil {
+			s.Log().WithField("address", addr).WithField("error", err).Warn("failed to listen for packets to idle server")
+		} else {
+			s.idle.listeners = append(s.idle.listeners, c)
+			go s.readWakePackets(c, qc.Protocol)
+		}
+	}
+	if len(s.idle.listeners) == 0 {
+		return
+	}
+
+	s.Log().Info("waiting for a connection to start idle server")
+	ctx, cancel := context.WithCancel(s.Context())
+	s.idle.release = cancel
+	go func() {
+		<-ctx.Done()
+		s.stopWakeListeners()
+	}()
+}
+
+// stopWakeListeners closes the listeners holding the ports of the server,
+// returning false if there were none.
+func (s *Server) stopWakeListeners() bool {
+	s.idle.mu.Lock()
+	defer s.idle.mu.Unlock()
+	if len(s.idle.listeners) == 0 {
+		return false
+	}
+	for _, l := range s.idle.listeners {
+		_ = l.Close()
+	}
+	s.idle.listeners = nil
+	s.idle.release()
+	return true
+}
+
+// acceptWakeConnections waits for a client of the game to connect to the server,
+// starting it and telling the player that it is starting. Connections that do
+// not begin with the handshake of the game are closed and ignored.
+func (s *Server) acceptWakeConnections(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleWakeConnection(conn)
+	}
+}
+
+func (s *Server) handleWakeConnection(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	h, err := query.ReadHandshake(br)
+	if err != nil {
+		return
+	}
+	// The listener is closed before the server is started, so that the server is
+	// free to use the port.
+	s.wake(conn.RemoteAddr())
+
+	message := s.Config().Idle.Message
+	if message == "" {
+		message = "Server is starting, please try again in a moment."
+	}
+	if err := query.Starting(conn, br, h, message); err != nil {
+		s.Log().WithField("error", err).Debug("failed to respond to connection to starting server")
+	}
+}
+
+// readWakePackets waits for a client of the game to send a packet to the server,
+// starting it. Any other packets are ignored.
+func (s *Server) readWakePackets(c net.PacketConn, protocol string) {
+	b := make([]byte, 2048)
+	for {
+		n, addr, err := c.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		if query.IsClientPacket(protocol, b[:n]) {
+			s.wake(addr)
+			return
+		}
+	}
+}
+
+// wake starts a server that was stopped for being idle after a connection to it
+// was received. Only the first connection starts the server.
+func (s *Server) wake(addr net.Addr) {
+	if !s.stopWakeListeners() {
+		return
+	}
+
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	s.Log().WithField("remote", ip).Info("starting idle server after receiving a connection")
+	s.PublishConsoleOutputFromDaemon("Received a connection, starting idle server...")
+	s.SaveActivity(s.NewRequestActivity("", ip), ActivityIdleWake, nil)
+	go func() {
+		if err := s.HandlePowerAction(PowerActionStart, 30); err != nil {
+			s.Log().WithField("error", err).Error("failed to start idle server")
+		}
+	}()
+}
+
+// allocationAddresses returns the address of every allocation of the server.
+func (s *Server) allocationAddresses() []string {
+	a := s.Config().Allocations
+	seen := make(map[string]bool)
+	var out []string
+	add := func(ip string, port int) {
+		addr := net.JoinHostPort(ip, strconv.Itoa(port))
+		if port > 0 && port <= 65535 && !seen[addr] {
+			seen[addr] = true
+			out = append(out, addr)
+		}
+	}
+	if a.DefaultMapping != nil {
+		add(a.DefaultMapping.Ip, a.DefaultMapping.Port)
+	}
+	for ip, ports := range a.Mappings {
+		for _, port := range ports {
+			add(ip, port)
+		}
+	}
+	return out
+}
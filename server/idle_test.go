@@ -0,0 +1,89 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sort"
+	"testing"
+
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/remote"
+)
+
+func TestIdle(t *testing.T) {
+	g := Goblin(t)
+
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	g.Describe("Idle servers", func() {
+		var s *Server
+
+		g.BeforeEach(func() {
+			s, _ = New(nil)
+			s.procConfig = &remote.ProcessConfiguration{}
+		})
+
+		g.It("lists every allocation of the server once", func() {
+			s.cfg.Allocations = environment.Allocations{
+				DefaultMapping: &environment.DefaultAllocationMapping{Ip: "127.0.0.1", Port: 25565},
+				Mappings: map[string][]int{
+					"127.0.0.1": {25565, 25575, 0},
+					"10.0.0.5":  {70000, 19132},
+				},
+			}
+			addrs := s.allocationAddresses()
+			sort.Strings(addrs)
+			g.Assert(addrs).Equal([]string{"10.0.0.5:19132", "127.0.0.1:25565", "127.0.0.1:25575"})
+		})
+
+		g.It("counts the traffic sent and received by the server", func() {
+			s.resources.UpdateStats(environment.Stats{Network: environment.NetworkStats{RxBytes: 300, TxBytes: 200}})
+			g.Assert(s.networkTraffic()).Equal(uint64(500))
+		})
+
+		g.It("does not check a server without an idle timeout", func() {
+			s.startIdleMonitor()
+			g.Assert(s.idle.cancel == nil).IsTrue()
+			s.stopIdleMonitor()
+		})
+
+		g.It("does not hold the ports of a server without a query protocol", func() {
+			s.cfg.Allocations.DefaultMapping = &environment.DefaultAllocationMapping{Ip: "127.0.0.1", Port: 25565}
+			s.startWakeListeners()
+			g.Assert(s.IsSleeping()).IsFalse()
+		})
+
+		g.It("holds the ports of a sleeping server until they are released", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			g.Assert(err).IsNil()
+			port := l.Addr().(*net.TCPAddr).Port
+			_ = l.Close()
+			s.cfg.Allocations.DefaultMapping = &environment.DefaultAllocationMapping{Ip: "127.0.0.1", Port: port}
+			s.procConfig.Query = &remote.QueryConfiguration{Protocol: "slp"}
+
+			s.startWakeListeners()
+			g.Assert(s.IsSleeping()).IsTrue()
+
+			// A connection that is not from a client of the game is closed without
+			// waking the server.
+			conn, err := net.Dial("tcp", l.Addr().String())
+			g.Assert(err).IsNil()
+			_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+			g.Assert(err).IsNil()
+			_ = conn.(*net.TCPConn).CloseWrite()
+			_, _ = io.ReadAll(conn)
+			_ = conn.Close()
+			g.Assert(s.IsSleeping()).IsTrue()
+
+			g.Assert(s.stopWakeListeners()).IsTrue()
+			g.Assert(s.stopWakeListeners()).IsFalse()
+			g.Assert(s.IsSleeping()).IsFalse()
+			l, err = net.Listen("tcp", l.Addr().String())
+			g.Assert(err).IsNil()
+			_ = l.Close()
+		})
+	})
+}
@@ -39,7 +39,11 @@ func (s *Server) Install() error {
 
 func (s *Server) install(reinstall bool) error {
 	var err error
-	if !s.Config().SkipEggScripts {
+	if s.Environment.Type() != "docker" {
+		// Installation scripts are run in a container using the image defined by the
+		// egg, which is not possible in other environments.
+		s.Log().WithField("environment", s.Environment.Type()).Info("installation scripts can only be run in a docker environment, not executing process")
+	} else if !s.Config().SkipEggScripts {
 		// Send the start event so the Panel can automatically update. We don't
 		// send this unless the process is actually going to run, otherwise all
 		// sorts of weird rapid UI behavior happens since there isn't an actual
@@ -83,7 +87,7 @@ func (s *Server) install(reinstall bool) error {
 func (s *Server) Reinstall() error {
 	if s.Environment.State() != environment.ProcessOfflineState {
 		s.Log().Debug("waiting for server instance to enter a stopped state")
-		if err := s.Environment.WaitForStop(s.Context(), time.Second*10, true); err != nil {
+		if err := s.WaitForStop(s.Context(), time.Second*10, true); err != nil {
 			return errors.WrapIf(err, "install: failed to stop running environment")
 		}
 	}
@@ -54,7 +54,7 @@ func (dsl *diskSpaceLimiter) Reset() {
 func (dsl *diskSpaceLimiter) Trigger() {
 	dsl.o.Do(func() {
 		dsl.server.PublishConsoleOutputFromDaemon("Server is exceeding the assigned disk space limit, stopping process now.")
-		if err := dsl.server.Environment.WaitForStop(dsl.server.Context(), time.Minute, true); err != nil {
+		if err := dsl.server.WaitForStop(dsl.server.Context(), time.Minute, true); err != nil {
 			dsl.server.Log().WithField("error", err).Error("failed to stop server after exceeding space limit!")
 		}
 	})
@@ -100,23 +100,34 @@ func (s *Server) StartEventListeners() {
 		for {
 			select {
 			case v := <-c:
-				go func(v []byte, limit *diskSpaceLimiter) {
-					var e events.Event
-					if err := events.DecodeTo(v, &e); err != nil {
-						return
+				var e events.Event
+				if err := events.DecodeTo(v, &e); err != nil {
+					continue
+				}
+				var stats struct {
+					Topic string
+					Data  environment.Stats
+				}
+				// Network traffic is counted from the difference between reports, so the
+				// reports and the start of the process must be handled in the order they
+				// are received, rather than in the goroutine below.
+				switch e.Topic {
+				case environment.ResourceEvent:
+					if err := events.DecodeTo(v, &stats); err != nil {
+						s.Log().WithField("error", err).Warn("failed to decode server resource event")
+						continue
+					}
+					s.recordTraffic(stats.Data.Network)
+				case environment.StateChangeEvent:
+					if e.Data == environment.ProcessStartingState {
+						s.resetTraffic()
 					}
+				}
+				go func(e events.Event, stats environment.Stats, limit *diskSpaceLimiter) {
 					switch e.Topic {
 					case environment.ResourceEvent:
 						{
-							var stats struct {
-								Topic string
-								Data  environment.Stats
-							}
-							if err := events.DecodeTo(v, &stats); err != nil {
-								s.Log().WithField("error", err).Warn("failed to decode server resource event")
-								return
-							}
-							s.resources.UpdateStats(stats.Data)
+							s.resources.UpdateStats(stats)
 							// If there is no disk space available at this point, trigger the server
 							// disk limiter logic which will start to stop the running instance.
 							if !s.Filesystem().HasSpaceAvailable(true) {
@@ -141,7 +152,7 @@ func (s *Server) StartEventListeners() {
 						s.PublishConsoleOutputFromDaemon("Finished pulling Docker container image")
 					default:
 					}
-				}(v, limit)
+				}(e, stats.Data, limit)
 			case <-s.Context().Done():
 				return
 			}
@@ -18,6 +18,7 @@ import (
 	"github.com/Minenetpro/pelican-wings/config"
 	"github.com/Minenetpro/pelican-wings/environment"
 	"github.com/Minenetpro/pelican-wings/environment/docker"
+	"github.com/Minenetpro/pelican-wings/environment/native"
 	"github.com/Minenetpro/pelican-wings/remote"
 	"github.com/Minenetpro/pelican-wings/server/filesystem"
 )
@@ -25,11 +26,16 @@ import (
 // ServerAddHook is a callback function invoked when a server is added to the manager.
 type ServerAddHook func(*Server)
 
+// EnvironmentFactory creates the process environment for a server using the
+// environment configuration built from its settings.
+type EnvironmentFactory func(s *Server, c *environment.Configuration) (environment.ProcessEnvironment, error)
+
 type Manager struct {
-	mu       sync.RWMutex
-	client   remote.Client
-	servers  []*Server
-	addHooks []ServerAddHook
+	mu             sync.RWMutex
+	client         remote.Client
+	servers        []*Server
+	addHooks       []ServerAddHook
+	newEnvironment EnvironmentFactory
 }
 
 // NewManager returns a new server manager instance. This will boot up all the
@@ -111,6 +117,16 @@ func (m *Manager) OnServerAdd(hook ServerAddHook) {
 	m.mu.Unlock()
 }
 
+// SetEnvironmentFactory replaces the function used to create the environment
+// for servers initialized by the manager, which is otherwise a Docker or native
+// environment depending on the configuration of the node. This allows servers to
+// be run in a different environment, such as a fake one during tests.
+func (m *Manager) SetEnvironmentFactory(f EnvironmentFactory) {
+	m.mu.Lock()
+	m.newEnvironment = f
+	m.mu.Unlock()
+}
+
 // Get returns a single server instance and a boolean value indicating if it was
 // found in the global collection or not.
 func (m *Manager) Get(uuid string) (*Server, bool) {
@@ -220,33 +236,53 @@ func (m *Manager) InitServer(data remote.ServerConfigurationResponse) (*Server,
 		return nil, errors.WithStackIf(err)
 	}
 
-	// Right now we only support a Docker based environment, so I'm going to hard code
-	// this logic in. When we're ready to support other environment we'll need to make
-	// some modifications here, obviously.
+	// Servers are run in Docker containers unless the native environment is enabled
+	// for this node, in which case they are run directly on the host.
 	settings := environment.Settings{
-		Mounts:      s.Mounts(),
-		Allocations: s.cfg.Allocations,
-		Limits:      s.cfg.Build,
-		Labels:      s.cfg.Labels,
+		Mounts:          s.Mounts(),
+		Allocations:     s.cfg.Allocations,
+		Limits:          s.Limits(),
+		Labels:          s.cfg.Labels,
+		SecurityProfile: s.SecurityProfile(),
 	}
 
 	envCfg := environment.NewConfiguration(settings, s.GetEnvironmentVariables())
-	meta := docker.Metadata{
-		Image: s.Config().Container.Image,
-	}
-
-	if env, err := docker.New(s.ID(), &meta, envCfg); err != nil {
-		return nil, err
-	} else {
+	m.mu.RLock()
+	factory := m.newEnvironment
+	m.mu.RUnlock()
+	if factory != nil {
+		if s.Environment, err = factory(s, envCfg); err != nil {
+			return nil, err
+		}
+	} else if config.Get().Native.Enabled {
+		meta := native.Metadata{
+			Stop: s.ProcessConfiguration().Stop,
+		}
+		env, err := native.New(s.ID(), &meta, envCfg)
+		if err != nil {
+			return nil, err
+		}
+		// The files of the server must be owned by the user its process is run as.
+		s.fs.SetOwner(env.User())
 		s.Environment = env
-		s.StartEventListeners()
+	} else {
+		meta := docker.Metadata{
+			Image: s.Config().Container.Image,
+		}
+		if s.Environment, err = docker.New(s.ID(), &meta, envCfg); err != nil {
+			return nil, err
+		}
 	}
+	s.StartEventListeners()
 
 	// If the server's data directory exists, force disk usage calculation.
 	if _, err := os.Stat(s.Filesystem().Path()); err == nil {
+		s.EnableProjectQuota()
 		s.Filesystem().HasSpaceAvailable(true)
 	}
 
+	s.StartFileWatcher()
+
 	return s, nil
 }
 
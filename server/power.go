@@ -63,6 +63,8 @@ func (s *Server) HandlePowerAction(action PowerAction, waitSeconds ...int) error
 		return ErrServerIsInstalling
 	}
 
+	s.cancelPendingRestart()
+
 	lockId, _ := uuid.NewUUID()
 	log := s.Log().WithField("lock_id", lockId.String()).WithField("action", action)
 
@@ -137,7 +139,7 @@ func (s *Server) HandlePowerAction(action PowerAction, waitSeconds ...int) error
 	case PowerActionRestart:
 		// We're specifically waiting for the process to be stopped here, otherwise the lock is
 		// released too soon, and you can rack up all sorts of issues.
-		if err := s.Environment.WaitForStop(s.Context(), time.Minute*10, true); err != nil {
+		if err := s.WaitForStop(s.Context(), time.Minute*10, true); err != nil {
 			// Even timeout errors should be bubbled back up the stack. If the process didn't stop
 			// nicely, but the terminate argument was passed then the server is stopped without an
 			// error being returned.
@@ -180,6 +182,13 @@ func (s *Server) onBeforeStart() error {
 		return ErrSuspended
 	}
 
+	// Servers that are stopped once they have used their traffic quota cannot be
+	// started again until the next month, or until their quota is raised.
+	if s.Config().Traffic.Action == TrafficActionStop && s.TrafficUsage().Exceeded {
+		s.PublishConsoleOutputFromDaemon("Server has used its traffic quota for this month and cannot be started.")
+		return ErrTrafficQuota
+	}
+
 	// Ensure we sync the server information with the environment so that any new environment variables
 	// and process resource limits are correctly applied.
 	s.SyncWithEnvironment()
@@ -221,6 +230,13 @@ func (s *Server) onBeforeStart() error {
 		}
 	}
 
+	// A server that was crash-looping gets a fresh set of restarts once it is started again.
+	s.clearCrashLooping()
+
+	// A server that was stopped for being idle no longer needs its ports held, and
+	// they have to be free before it is started.
+	s.stopWakeListeners()
+
 	s.Log().Info("completed server preflight, starting boot process...")
 	return nil
 }
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/query"
+	"github.com/Minenetpro/pelican-wings/remote"
+)
+
+// queryPoller tracks the polling of a server for its status.
+type queryPoller struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// queryConfiguration returns the query protocol configuration of the server,
+// if its egg has one.
+func (s *Server) queryConfiguration() *remote.QueryConfiguration {
+	qc := s.ProcessConfiguration().Query
+	if qc == nil || qc.Protocol == "" {
+		return nil
+	}
+	return qc
+}
+
+// CanQuery returns true if the server can be queried for its status.
+func (s *Server) CanQuery() bool {
+	return s.queryConfiguration() != nil
+}
+
+// QueryResult returns the status last reported by the server when it was
+// queried, or nil if it has not responded to a query since it was started.
+func (s *Server) QueryResult() *query.Result {
+	s.resources.mu.RLock()
+	defer s.resources.mu.RUnlock()
+
+	return s.resources.Query
+}
+
+// Query queries the server for its status immediately, updating the status
+// reported in its resource usage.
+func (s *Server) Query(ctx context.Context) (*query.Result, error) {
+	qc := s.queryConfiguration()
+	if qc == nil {
+		return nil, ErrQueryNotSupported
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.Get().System.Query.Timeout)*time.Second)
+	defer cancel()
+	res, err := query.Query(ctx, qc.Protocol, s.allocationAddress(qc.Port))
+	s.resources.mu.Lock()
+	s.resources.Query = res
+	s.resources.mu.Unlock()
+	s.Events().Publish(StatsEvent, s.Proc())
+	return res, err
+}
+
+// startQueries starts polling the server for its status in the background, if
+// its egg has a query protocol and it is not already being polled.
+func (s *Server) startQueries() {
+	if !config.Get().System.Query.Enabled || s.queryConfiguration() == nil {
+		return
+	}
+
+	s.querier.mu.Lock()
+	defer s.querier.mu.Unlock()
+	if s.querier.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.Context())
+	s.querier.cancel = cancel
+	go s.runQueries(ctx)
+}
+
+// stopQueries stops polling the server for its status, if it is being polled.
+func (s *Server) stopQueries() {
+	s.querier.mu.Lock()
+	defer s.querier.mu.Unlock()
+	if s.querier.cancel != nil {
+		s.querier.cancel()
+		s.querier.cancel = nil
+	}
+}
+
+// runQueries queries the server every interval until the context is canceled.
+// If the server does not respond, the status from the last query is cleared so
+// that an outdated player count is not reported.
+func (s *Server) runQueries(ctx context.Context) {
+	for {
+		if _, err := s.Query(ctx); err != nil && ctx.Err() == nil {
+			s.Log().WithField("error", err).Debug("failed to query server for its status")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(config.Get().System.Query.Interval) * time.Second):
+		}
+	}
+}
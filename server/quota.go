@@ -0,0 +1,29 @@
+package server
+
+import (
+	"emperror.dev/errors"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/server/filesystem"
+)
+
+// EnableProjectQuota moves the data directory of the server over to a kernel
+// project quota if that backend is enabled in the configuration. If project
+// quotas are not supported for the directory, the disk usage of the server
+// continues to be tracked by walking the directory.
+func (s *Server) EnableProjectQuota() {
+	if config.Get().System.DiskQuotaBackend != "project" {
+		return
+	}
+
+	err := s.fs.EnableProjectQuota()
+	if err == nil {
+		s.Log().Debug("server: using project quota for disk usage and limits")
+		return
+	}
+	if errors.Is(err, filesystem.ErrProjectQuotaUnsupported) {
+		s.Log().WithField("error", err).Warn("server: project quotas are not supported for data directory, falling back to directory walk")
+		return
+	}
+	s.Log().WithField("error", err).Error("server: failed to enable project quota for data directory")
+}
@@ -5,6 +5,7 @@ import (
 	"sync/atomic"
 
 	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/internal/query"
 	"github.com/Minenetpro/pelican-wings/system"
 )
 
@@ -20,10 +21,22 @@ type ResourceUsage struct {
 	// The current server status.
 	State *system.AtomicString `json:"state"`
 
+	// The current health of the server, as determined by its health check.
+	Health *system.AtomicString `json:"health"`
+
+	// The status last reported by the server when it was queried, such as the number
+	// of players online. This is nil if the server cannot be queried, or has not
+	// responded to a query.
+	Query *query.Result `json:"query"`
+
 	// The current disk space being used by the server. This value is not guaranteed to be accurate
 	// at all times. It is "manually" set whenever server.Proc() is called. This is kind of just a
 	// hacky solution for now to avoid passing events all over the place.
 	Disk int64 `json:"disk_bytes"`
+
+	// The network traffic used by the server this month, which unlike the network
+	// stats of the environment is kept when the server is restarted.
+	Traffic TrafficUsage `json:"traffic"`
 }
 
 // Proc returns the current resource usage stats for the server instance. This returns
@@ -34,6 +47,7 @@ func (s *Server) Proc() ResourceUsage {
 	defer s.resources.mu.Unlock()
 	// Store the updated disk usage when requesting process usage.
 	atomic.StoreInt64(&s.resources.Disk, s.Filesystem().CachedUsage())
+	s.resources.Traffic = s.TrafficUsage()
 	//goland:noinspection GoVetCopyLock
 	return s.resources
 }
@@ -45,6 +59,14 @@ func (ru *ResourceUsage) UpdateStats(stats environment.Stats) {
 	ru.mu.Unlock()
 }
 
+// Snapshot returns a copy of the current environment stats.
+func (ru *ResourceUsage) Snapshot() environment.Stats {
+	ru.mu.RLock()
+	defer ru.mu.RUnlock()
+
+	return ru.Stats
+}
+
 // Reset resets the usages values to zero, used when a server is stopped to ensure we don't hold
 // onto any values incorrectly.
 func (ru *ResourceUsage) Reset() {
@@ -56,4 +78,5 @@ func (ru *ResourceUsage) Reset() {
 	ru.Uptime = 0
 	ru.Network.TxBytes = 0
 	ru.Network.RxBytes = 0
+	ru.Query = nil
 }
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/internal/database"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/server/backup"
+)
+
+// The actions that can be performed by a task of a schedule.
+const (
+	ScheduleActionCommand = "command"
+	ScheduleActionPower   = "power"
+	ScheduleActionBackup  = "backup"
+	ScheduleActionDelay   = "delay"
+)
+
+// The conditions that can be set on a task of a schedule.
+const (
+	ScheduleConditionOnline  = "online"
+	ScheduleConditionOffline = "offline"
+)
+
+// scheduleRunner tracks the schedules that are currently running for a server,
+// so that a schedule is never run twice at the same time.
+type scheduleRunner struct {
+	mu      sync.Mutex
+	running map[int]bool
+}
+
+func (r *scheduleRunner) acquire(id int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil {
+		r.running = make(map[int]bool)
+	}
+	if r.running[id] {
+		return false
+	}
+	r.running[id] = true
+	return true
+}
+
+func (r *scheduleRunner) release(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.running, id)
+}
+
+// ValidateSchedule checks that the cron expression and every task of a schedule
+// are valid, returning an error describing the first problem found.
+func ValidateSchedule(sc models.Schedule) error {
+	if sc.ID <= 0 {
+		return errors.New("schedule must have an id")
+	}
+	// Schedules always run in the timezone of the server.
+	if strings.HasPrefix(sc.Cron, "TZ=") || strings.HasPrefix(sc.Cron, "CRON_TZ=") {
+		return errors.New("schedule cron expression cannot set a timezone")
+	}
+	if _, err := cron.ParseStandard(sc.Cron); err != nil {
+		return errors.Wrap(err, "invalid schedule cron expression")
+	}
+	for i, t := range sc.Tasks {
+		if err := validateScheduleTask(t); err != nil {
+			return errors.Wrapf(err, "invalid schedule task %d", i)
+		}
+	}
+	return nil
+}
+
+func validateScheduleTask(t models.ScheduleTask) error {
+	switch t.Condition {
+	case "", ScheduleConditionOnline, ScheduleConditionOffline:
+	default:
+		return errors.New("unknown condition: " + t.Condition)
+	}
+
+	switch t.Action {
+	case ScheduleActionCommand:
+		if t.Payload == "" {
+			return errors.New("command cannot be empty")
+		}
+	case ScheduleActionPower:
+		if !PowerAction(t.Payload).IsValid() {
+			return errors.New("unknown power action: " + t.Payload)
+		}
+	case ScheduleActionBackup:
+		switch backup.AdapterType(t.Payload) {
+		case "", backup.LocalBackupAdapter, backup.S3BackupAdapter, backup.ResticBackupAdapter:
+		default:
+			return errors.New("unknown backup adapter: " + t.Payload)
+		}
+	case ScheduleActionDelay:
+		if d, err := strconv.Atoi(t.Payload); err != nil || d < 0 {
+			return errors.New("delay must be a number of seconds")
+		}
+	default:
+		return errors.New("unknown action: " + t.Action)
+	}
+	return nil
+}
+
+// Schedules returns the schedules saved for the server, ordered by their ID.
+func (s *Server) Schedules(ctx context.Context) ([]models.Schedule, error) {
+	schedules := []models.Schedule{}
+	tx := database.Instance().WithContext(ctx).
+		Where("server = ?", s.ID()).
+		Order("id ASC").
+		Find(&schedules)
+	if tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	return schedules, nil
+}
+
+// Schedule returns a single schedule saved for the server, or ErrScheduleNotFound
+// if there is no schedule with the given ID.
+func (s *Server) Schedule(ctx context.Context, id int) (models.Schedule, error) {
+	var sc models.Schedule
+	tx := database.Instance().WithContext(ctx).
+		Where("server = ? AND id = ?", s.ID(), id).
+		First(&sc)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return sc, ErrScheduleNotFound
+		}
+		return sc, errors.WithStack(tx.Error)
+	}
+	return sc, nil
+}
+
+// SetSchedules replaces the schedules saved for the server with the ones given.
+// The time a schedule last ran at is kept if it is not set on the new schedule.
+func (s *Server) SetSchedules(ctx context.Context, schedules []models.Schedule) error {
+	existing, err := s.Schedules(ctx)
+	if err != nil {
+		return err
+	}
+	lastRuns := make(map[int]*time.Time, len(existing))
+	for _, sc := range existing {
+		lastRuns[sc.ID] = sc.LastRunAt
+	}
+	for i := range schedules {
+		schedules[i].Server = s.ID()
+		if schedules[i].LastRunAt == nil {
+			schedules[i].LastRunAt = lastRuns[schedules[i].ID]
+		}
+	}
+
+	err = database.Instance().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("server = ?", s.ID()).Delete(&models.Schedule{}).Error; err != nil {
+			return err
+		}
+		if len(schedules) == 0 {
+			return nil
+		}
+		return tx.Create(&schedules).Error
+	})
+	return errors.WithStack(err)
+}
+
+// DeleteSchedule removes a single schedule saved for the server, returning
+// ErrScheduleNotFound if there is no schedule with the given ID.
+func (s *Server) DeleteSchedule(ctx context.Context, id int) error {
+	tx := database.Instance().WithContext(ctx).Where("server = ? AND id = ?", s.ID(), id).Delete(&models.Schedule{})
+	if tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// DeleteSchedules removes every schedule saved for the server.
+func (s *Server) DeleteSchedules(ctx context.Context) error {
+	tx := database.Instance().WithContext(ctx).Where("server = ?", s.ID()).Delete(&models.Schedule{})
+	return errors.WithStack(tx.Error)
+}
+
+// IsScheduleRunning returns true if the schedule with the given ID is currently
+// being run for the server.
+func (s *Server) IsScheduleRunning(id int) bool {
+	s.scheduler.mu.Lock()
+	defer s.scheduler.mu.Unlock()
+	return s.scheduler.running[id]
+}
+
+// RunSchedule runs the tasks of a schedule in order, stopping at the first task
+// that fails unless it is set to continue on failure. The result is recorded in
+// the activity log of the server. ErrScheduleRunning is returned if the schedule
+// is already running, any errors from the tasks themselves are only recorded.
+func (s *Server) RunSchedule(ctx context.Context, sc models.Schedule) error {
+	if !s.scheduler.acquire(sc.ID) {
+		return ErrScheduleRunning
+	}
+	defer s.scheduler.release(sc.ID)
+
+	logger := s.Log().WithFields(log.Fields{"schedule": sc.ID, "name": sc.Name})
+	if sc.OnlyWhenOnline && !s.IsRunning() {
+		logger.Debug("schedule: skipping schedule because server is not running")
+		return nil
+	}
+
+	now := time.Now()
+	tx := database.Instance().WithContext(ctx).
+		Model(&models.Schedule{}).
+		Where("server = ? AND id = ?", s.ID(), sc.ID).
+		Update("last_run_at", now)
+	if tx.Error != nil {
+		logger.WithField("error", errors.WithStack(tx.Error)).Warn("schedule: failed to update last run time")
+	}
+
+	logger.Info("schedule: running schedule")
+	var failures []models.ActivityMeta
+	for i, t := range sc.Tasks {
+		if ctx.Err() != nil {
+			failures = append(failures, models.ActivityMeta{"task": i, "error": ctx.Err().Error()})
+			break
+		}
+		if !s.scheduleConditionMet(t.Condition) {
+			continue
+		}
+		if err := s.runScheduleTask(ctx, sc.ID, t); err != nil {
+			logger.WithFields(log.Fields{"task": i, "action": t.Action, "error": err}).Warn("schedule: task failed")
+			failures = append(failures, models.ActivityMeta{"task": i, "error": err.Error()})
+			if !t.ContinueOnFailure {
+				break
+			}
+		}
+	}
+
+	event := ActivityScheduleCompleted
+	meta := models.ActivityMeta{"schedule": sc.ID, "name": sc.Name}
+	if len(failures) > 0 {
+		event = ActivityScheduleFailed
+		meta["failures"] = failures
+	}
+	s.SaveActivity(s.NewRequestActivity("", "127.0.0.1"), event, meta)
+	return nil
+}
+
+// scheduleConditionMet returns true if a task with the given condition should be
+// run given the current state of the server.
+func (s *Server) scheduleConditionMet(condition string) bool {
+	switch condition {
+	case ScheduleConditionOnline:
+		return s.IsRunning()
+	case ScheduleConditionOffline:
+		return s.Environment.State() == environment.ProcessOfflineState
+	}
+	return true
+}
+
+// runScheduleTask runs a single task of a schedule, returning once it has
+// completed.
+func (s *Server) runScheduleTask(ctx context.Context, schedule int, t models.ScheduleTask) error {
+	switch t.Action {
+	case ScheduleActionCommand:
+		return s.Environment.SendCommand(t.Payload)
+	case ScheduleActionPower:
+		return s.HandlePowerAction(PowerAction(t.Payload), 30)
+	case ScheduleActionBackup:
+		return s.scheduledBackup(ctx, schedule, backup.AdapterType(t.Payload))
+	case ScheduleActionDelay:
+		d, err := strconv.Atoi(t.Payload)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-time.After(time.Duration(d) * time.Second):
+			return nil
+		}
+	}
+	return errors.New("server: unknown schedule task action: " + t.Action)
+}
+
+// scheduledBackup creates a backup of the server using the given adapter, which
+// defaults to a local backup. The backup is given a new UUID, which is registered
+// with the Panel before the backup is started so that the Panel has a record of
+// it when it is sent the status of the backup.
+func (s *Server) scheduledBackup(ctx context.Context, schedule int, adapter backup.AdapterType) error {
+	if adapter == "" {
+		adapter = backup.LocalBackupAdapter
+	}
+	id := uuid.New().String()
+
+	var b backup.BackupInterface
+	switch adapter {
+	case backup.LocalBackupAdapter:
+		b = backup.NewLocal(s.client, id, s.ID(), "")
+	case backup.S3BackupAdapter:
+		b = backup.NewS3(s.client, id, s.ID(), "")
+	case backup.ResticBackupAdapter:
+		if !config.Get().System.Backups.Restic.Enabled {
+			return errors.New("server: restic backup adapter is not enabled")
+		}
+		b = backup.NewRestic(s.client, id, s.ID(), "")
+	default:
+		return errors.New("server: unknown backup adapter: " + string(adapter))
+	}
+
+	req := remote.ScheduledBackupRequest{Uuid: id, Adapter: string(adapter), Schedule: schedule}
+	if err := s.client.RegisterScheduledBackup(ctx, s.ID(), req); err != nil {
+		return errors.WrapIf(err, "server: failed to register scheduled backup with the Panel")
+	}
+	b.WithLogContext(map[string]interface{}{"server": s.ID(), "schedule": schedule})
+	return s.Backup(b)
+}
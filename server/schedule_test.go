@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment/fake"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/server/backup"
+)
+
+// backupClient is a Panel client that records the scheduled backups it is asked
+// to register, and rejects them with err. Every other call panics.
+type backupClient struct {
+	remote.Client
+	err     error
+	backups []remote.ScheduledBackupRequest
+}
+
+func (c *backupClient) RegisterScheduledBackup(_ context.Context, _ string, data remote.ScheduledBackupRequest) error {
+	c.backups = append(c.backups, data)
+	return c.err
+}
+
+func TestSchedule(t *testing.T) {
+	g := Goblin(t)
+
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	task := func(action string, payload string) models.Schedule {
+		return models.Schedule{ID: 1, Cron: "0 4 * * *", Tasks: []models.ScheduleTask{{Action: action, Payload: payload}}}
+	}
+
+	g.Describe("ValidateSchedule", func() {
+		g.It("accepts a valid schedule", func() {
+			g.Assert(ValidateSchedule(models.Schedule{ID: 1, Cron: "*/5 * * * *", Tasks: []models.ScheduleTask{
+				{Action: ScheduleActionCommand, Payload: "save-all"},
+				{Action: ScheduleActionDelay, Payload: "10"},
+				{Action: ScheduleActionBackup},
+				{Action: ScheduleActionBackup, Payload: string(backup.S3BackupAdapter)},
+				{Action: ScheduleActionPower, Payload: "restart", Condition: ScheduleConditionOnline},
+			}})).IsNil()
+		})
+
+		g.It("rejects an invalid schedule", func() {
+			invalid := []models.Schedule{
+				{Cron: "0 4 * * *"},
+				{ID: 1, Cron: "TZ=UTC 0 4 * * *"},
+				{ID: 1, Cron: "at dawn"},
+				{ID: 1, Cron: "0 4 * * *", Tasks: []models.ScheduleTask{{Action: ScheduleActionCommand, Condition: "sometimes"}}},
+				task(ScheduleActionCommand, ""),
+				task(ScheduleActionPower, "explode"),
+				task(ScheduleActionBackup, "tape"),
+				task(ScheduleActionDelay, "-1"),
+				task(ScheduleActionDelay, "soon"),
+				task("reinstall", ""),
+			}
+			for _, sc := range invalid {
+				g.Assert(ValidateSchedule(sc) != nil).IsTrue()
+			}
+		})
+	})
+
+	g.Describe("scheduleRunner", func() {
+		g.It("only runs a schedule once at a time", func() {
+			var r scheduleRunner
+			g.Assert(r.acquire(1)).IsTrue()
+			g.Assert(r.acquire(1)).IsFalse()
+			g.Assert(r.acquire(2)).IsTrue()
+			r.release(1)
+			g.Assert(r.acquire(1)).IsTrue()
+		})
+	})
+
+	g.Describe("Server#runScheduleTask", func() {
+		var s *Server
+		var e *fake.Environment
+
+		g.BeforeEach(func() {
+			s, _ = New(nil)
+			e = fake.New("schedule", nil)
+			s.Environment = e
+		})
+
+		g.It("checks the condition of a task against the state of the server", func() {
+			g.Assert(s.scheduleConditionMet("")).IsTrue()
+			g.Assert(s.scheduleConditionMet(ScheduleConditionOffline)).IsTrue()
+			g.Assert(s.scheduleConditionMet(ScheduleConditionOnline)).IsFalse()
+		})
+
+		g.It("sends a command to the server", func() {
+			g.Assert(e.Start(context.Background())).IsNil()
+			g.Assert(s.runScheduleTask(context.Background(), 1, models.ScheduleTask{Action: ScheduleActionCommand, Payload: "say hi"})).IsNil()
+			g.Assert(e.Commands()).Equal([]string{"say hi"})
+		})
+
+		g.It("stops waiting for a delay once the schedule is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := s.runScheduleTask(ctx, 1, models.ScheduleTask{Action: ScheduleActionDelay, Payload: "60"})
+			g.Assert(errors.Is(err, context.Canceled)).IsTrue()
+		})
+
+		g.It("does not create a backup the Panel has not registered", func() {
+			c := &backupClient{err: errors.New("panel is unavailable")}
+			s.client = c
+
+			err := s.runScheduleTask(context.Background(), 3, models.ScheduleTask{Action: ScheduleActionBackup})
+			g.Assert(err != nil).IsTrue()
+			g.Assert(len(c.backups)).Equal(1)
+			g.Assert(c.backups[0].Adapter).Equal(string(backup.LocalBackupAdapter))
+			g.Assert(c.backups[0].Schedule).Equal(3)
+			g.Assert(c.backups[0].Uuid != "").IsTrue()
+		})
+
+		g.It("does not register a backup for an adapter that is not enabled", func() {
+			c := &backupClient{}
+			s.client = c
+
+			err := s.runScheduleTask(context.Background(), 3, models.ScheduleTask{Action: ScheduleActionBackup, Payload: string(backup.ResticBackupAdapter)})
+			g.Assert(err != nil).IsTrue()
+			g.Assert(len(c.backups)).Equal(0)
+		})
+	})
+}
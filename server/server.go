@@ -46,6 +46,22 @@ type Server struct {
 	// The crash handler for this server instance.
 	crasher CrashHandler
 
+	// The health checks running for this server instance.
+	health healthChecker
+
+	// Polls this server instance for its status using its query protocol.
+	querier queryPoller
+
+	// Tracks the schedules currently running for this server instance.
+	scheduler scheduleRunner
+
+	// Stops this server instance when it is idle, and starts it again when somebody
+	// connects to it.
+	idle idleMonitor
+
+	// Counts the network traffic of this server towards its monthly quota.
+	traffic trafficCounter
+
 	resources   ResourceUsage
 	Environment environment.ProcessEnvironment `json:"-"`
 
@@ -71,6 +87,7 @@ type Server struct {
 	throttler    *ConsoleThrottle
 	throttleOnce sync.Once
 	sftpBag      *system.ContextBag
+	sftpSessions sftpSessions
 
 	// Tracks open websocket connections for the server.
 	wsBag       *WebsocketBag
@@ -106,6 +123,7 @@ func New(client remote.Client) (*Server, error) {
 		return nil, errors.Wrap(err, "server: could not set defaults for server configuration")
 	}
 	s.resources.State = system.NewAtomicString(environment.ProcessOfflineState)
+	s.resources.Health = system.NewAtomicString(HealthNone)
 	return &s, nil
 }
 
@@ -378,6 +396,7 @@ func (s *Server) EnsureDataDirectoryExists() error {
 			if err := s.fs.Chown("/"); err != nil {
 				s.Log().WithField("error", err).Warn("server: failed to chown server data directory")
 			}
+			s.EnableProjectQuota()
 		} else {
 			return errors.WrapIf(err, "server: failed to stat server root directory")
 		}
@@ -400,11 +419,28 @@ func (s *Server) OnStateChange() {
 		s.Events().Publish(StatusEvent, st)
 	}
 
+	// Keep the last resource usage of the process so that it can be included in the
+	// crash report if the process crashed.
+	stats := s.resources.Snapshot()
+
 	// Reset the resource usage to 0 when the process fully stops so that all the UI
 	// views in the Panel correctly display 0.
 	if st == environment.ProcessOfflineState {
 		s.resources.Reset()
 		s.Events().Publish(StatsEvent, s.Proc())
+		s.flushTraffic()
+	}
+
+	// Health checks and queries only run once the server has finished starting, and are
+	// stopped as soon as it is no longer running.
+	if st == environment.ProcessRunningState {
+		s.startHealthChecks()
+		s.startQueries()
+		s.startIdleMonitor()
+	} else {
+		s.stopHealthChecks()
+		s.stopQueries()
+		s.stopIdleMonitor()
 	}
 
 	// If server was in an online state, and is now in an offline state we should handle
@@ -419,9 +455,9 @@ func (s *Server) OnStateChange() {
 		s.Log().Info("detected server as entering a crashed state; running crash handler")
 
 		go func(server *Server) {
-			if err := server.handleServerCrash(); err != nil {
+			if err := server.handleServerCrash(stats); err != nil {
 				if IsTooFrequentCrashError(err) {
-					server.Log().Info("did not restart server after crash; occurred too soon after the last")
+					server.Log().Info("did not restart server after crash; crashed too frequently")
 				} else {
 					s.PublishConsoleOutputFromDaemon("Server crash was detected but an error occurred while handling it.")
 					server.Log().WithField("error", err).Error("failed to handle server crash")
@@ -454,6 +490,8 @@ func (s *Server) IsRunning() bool {
 type APIResponse struct {
 	State         string        `json:"state"`
 	IsSuspended   bool          `json:"is_suspended"`
+	CrashLooping  bool          `json:"crash_looping"`
+	Sleeping      bool          `json:"sleeping"`
 	Utilization   ResourceUsage `json:"utilization"`
 	Configuration Configuration `json:"configuration"`
 }
@@ -464,6 +502,8 @@ func (s *Server) ToAPIResponse() APIResponse {
 	return APIResponse{
 		State:         s.Environment.State(),
 		IsSuspended:   s.IsSuspended(),
+		CrashLooping:  s.crasher.IsCrashLooping(),
+		Sleeping:      s.IsSleeping(),
 		Utilization:   s.Proc(),
 		Configuration: *s.Config(),
 	}
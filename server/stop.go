@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/remote"
+)
+
+// stopSteps returns the stop policy for the server. Steps set for the server
+// itself take priority over those set by its egg.
+func (s *Server) stopSteps() []remote.ProcessStopStep {
+	if steps := s.Config().StopSteps; len(steps) > 0 {
+		return steps
+	}
+	return s.ProcessConfiguration().Stop.Steps
+}
+
+// WaitForStop stops the server process and waits for it to exit. If the server
+// has a stop policy its steps are run in order, each one waiting for the process
+// to exit before moving on to the next, and each step is reported in the console.
+// Servers without a policy are stopped using the stop configuration of their
+// environment.
+//
+// If the process is still running once every step has run, or after duration has
+// passed, it is killed if terminate is true. Otherwise, an error is returned and
+// the process is left running.
+func (s *Server) WaitForStop(ctx context.Context, duration time.Duration, terminate bool) error {
+	steps := s.stopSteps()
+	if len(steps) == 0 {
+		return s.Environment.WaitForStop(ctx, duration, terminate)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	// Mark the server as stopping before running any of the steps, since only the
+	// stop command of the environment does this itself. Otherwise, the process
+	// exiting would be detected as a crash.
+	if s.Environment.State() != environment.ProcessOfflineState {
+		s.Environment.SetState(environment.ProcessStoppingState)
+	}
+
+	for i, step := range steps {
+		if s.Environment.State() == environment.ProcessOfflineState {
+			return nil
+		}
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Stopping server (step %d of %d): %s", i+1, len(steps), step))
+		if err := s.runStopStep(tctx, step); err != nil {
+			s.Log().WithField("step", i+1).WithField("error", err).Warn("failed to run server stop step")
+		}
+		if s.waitForExit(tctx, step.Wait()) {
+			return nil
+		}
+		if tctx.Err() != nil {
+			break
+		}
+	}
+
+	if !terminate {
+		if err := tctx.Err(); err != nil {
+			return err
+		}
+		return errors.WithStack(context.DeadlineExceeded)
+	}
+
+	s.PublishConsoleOutputFromDaemon("Server did not stop in time, killing process...")
+	s.Log().WithField("duration", duration).Warn("server process did not stop using stop policy, terminating process...")
+	return s.Environment.Terminate(context.Background(), "SIGKILL")
+}
+
+// runStopStep sends the command or signal of a stop step to the server process.
+func (s *Server) runStopStep(ctx context.Context, step remote.ProcessStopStep) error {
+	switch step.Type {
+	case remote.ProcessStopCommand:
+		return s.Environment.SendCommand(step.Value)
+	case remote.ProcessStopSignal:
+		signal := strings.ToUpper(step.Value)
+		if e, ok := s.Environment.(environment.Signaler); ok {
+			return e.Signal(ctx, signal)
+		}
+		return s.Environment.Terminate(ctx, signal)
+	}
+	return errors.New("server: unknown stop step type: " + step.Type)
+}
+
+// waitForExit waits up to the given duration for the server process to exit,
+// returning true if it has.
+func (s *Server) waitForExit(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if s.Environment.State() == environment.ProcessOfflineState {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return s.Environment.State() == environment.ProcessOfflineState
+		case <-ticker.C:
+		}
+	}
+}
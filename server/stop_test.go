@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/environment/fake"
+	"github.com/Minenetpro/pelican-wings/remote"
+)
+
+func TestStop(t *testing.T) {
+	g := Goblin(t)
+
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	g.Describe("Stop policy", func() {
+		var s *Server
+		var e *fake.Environment
+
+		g.BeforeEach(func() {
+			s, _ = New(nil)
+			s.procConfig = &remote.ProcessConfiguration{}
+			e = fake.New("stop", nil)
+			s.Environment = e
+		})
+
+		g.Describe("Server#stopSteps", func() {
+			g.It("prefers the steps set for the server over those of its egg", func() {
+				egg := []remote.ProcessStopStep{{Type: remote.ProcessStopCommand, Value: "stop"}}
+				s.procConfig.Stop.Steps = egg
+				g.Assert(s.stopSteps()).Equal(egg)
+
+				own := []remote.ProcessStopStep{{Type: remote.ProcessStopSignal, Value: "SIGINT"}}
+				s.cfg.StopSteps = own
+				g.Assert(s.stopSteps()).Equal(own)
+			})
+		})
+
+		g.Describe("Server#runStopStep", func() {
+			g.It("sends the command of a step to the process", func() {
+				g.Assert(e.Start(context.Background())).IsNil()
+				g.Assert(s.runStopStep(context.Background(), remote.ProcessStopStep{Type: remote.ProcessStopCommand, Value: "save-all"})).IsNil()
+				g.Assert(e.Commands()).Equal([]string{"save-all"})
+			})
+
+			g.It("sends the signal of a step to the process", func() {
+				g.Assert(e.Start(context.Background())).IsNil()
+				g.Assert(s.runStopStep(context.Background(), remote.ProcessStopStep{Type: remote.ProcessStopSignal, Value: "sigint"})).IsNil()
+				<-e.Exited()
+				code, _, _ := e.ExitState()
+				g.Assert(code).Equal(uint32(130))
+			})
+
+			g.It("rejects an unknown type of step", func() {
+				g.Assert(s.runStopStep(context.Background(), remote.ProcessStopStep{Type: "pause"}) != nil).IsTrue()
+			})
+		})
+
+		g.Describe("Server#WaitForStop", func() {
+			g.It("does not run any steps if the server is offline", func() {
+				s.cfg.StopSteps = []remote.ProcessStopStep{{Type: remote.ProcessStopCommand, Value: "stop"}}
+				g.Assert(s.WaitForStop(context.Background(), time.Second, true)).IsNil()
+				g.Assert(len(e.Commands())).Equal(0)
+			})
+
+			g.It("leaves the process running when it is not stopped in time", func() {
+				s.cfg.StopSteps = []remote.ProcessStopStep{{Type: remote.ProcessStopCommand, Value: "save-all"}}
+				e.OnCommand("save-all", func() {})
+				g.Assert(e.Start(context.Background())).IsNil()
+
+				err := s.WaitForStop(context.Background(), 200*time.Millisecond, false)
+				g.Assert(errors.Is(err, context.DeadlineExceeded)).IsTrue()
+				g.Assert(e.Commands()).Equal([]string{"save-all"})
+				g.Assert(e.State()).Equal(environment.ProcessStoppingState)
+				ok, _ := e.IsRunning(context.Background())
+				g.Assert(ok).IsTrue()
+			})
+
+			g.It("kills the process when it is not stopped in time", func() {
+				s.cfg.StopSteps = []remote.ProcessStopStep{{Type: remote.ProcessStopCommand, Value: "save-all"}}
+				e.OnCommand("save-all", func() {})
+				g.Assert(e.Start(context.Background())).IsNil()
+
+				g.Assert(s.WaitForStop(context.Background(), 200*time.Millisecond, true)).IsNil()
+				code, _, _ := e.ExitState()
+				g.Assert(code).Equal(uint32(137))
+			})
+		})
+
+		g.Describe("Server#waitForExit", func() {
+			g.It("returns once the process has exited", func() {
+				g.Assert(s.waitForExit(context.Background(), time.Second)).IsTrue()
+			})
+
+			g.It("gives up once the duration has passed", func() {
+				g.Assert(e.Start(context.Background())).IsNil()
+				g.Assert(s.waitForExit(context.Background(), 150*time.Millisecond)).IsFalse()
+			})
+		})
+	})
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/internal/database"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+)
+
+const (
+	TrafficActionThrottle = "throttle"
+	TrafficActionStop     = "stop"
+)
+
+// TrafficUsage is the network traffic a server has used during the current month.
+type TrafficUsage struct {
+	Month   string `json:"month"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+	// Exceeded is true if the server has used its traffic quota for the month.
+	Exceeded bool `json:"exceeded"`
+}
+
+// trafficCounter counts the network traffic reported for a server towards the
+// current month, saving it to the database so that it is kept across restarts.
+type trafficCounter struct {
+	mu       sync.Mutex
+	loaded   bool
+	location *time.Location
+	usage    models.Traffic
+	changed  bool
+	saved    time.Time
+	// Whether the server was over its quota when the traffic was last counted.
+	exceeded bool
+	// The network traffic last reported by the environment, which counts from zero
+	// each time the server is started, when it is reset by resetTraffic. The first
+	// report is only used as a starting point if the server was already running
+	// when it was received.
+	counting bool
+	last     environment.NetworkStats
+}
+
+// TrafficUsage returns the network traffic the server has used this month.
+func (s *Server) TrafficUsage() TrafficUsage {
+	s.traffic.mu.Lock()
+	defer s.traffic.mu.Unlock()
+	if err := s.loadTraffic(); err != nil {
+		s.Log().WithField("error", err).Warn("failed to load server traffic usage")
+		return TrafficUsage{}
+	}
+	s.rolloverTraffic()
+	return TrafficUsage{
+		Month:    s.traffic.usage.Month,
+		RxBytes:  s.traffic.usage.RxBytes,
+		TxBytes:  s.traffic.usage.TxBytes,
+		Exceeded: s.trafficQuotaExceeded(),
+	}
+}
+
+// Limits returns the resource limits of the server, with the bandwidth of the
+// server limited to the throttle rate if it has used its traffic quota and is set
+// to be throttled.
+func (s *Server) Limits() environment.Limits {
+	cfg := s.Config()
+	l := cfg.Build
+	if cfg.Traffic.Action != TrafficActionThrottle || !s.TrafficUsage().Exceeded {
+		return l
+	}
+
+	rate := cfg.Traffic.ThrottleRate
+	if rate <= 0 {
+		rate = config.Get().System.Traffic.ThrottleRate
+	}
+	if l.IngressLimit <= 0 || l.IngressLimit > rate {
+		l.IngressLimit = rate
+	}
+	if l.EgressLimit <= 0 || l.EgressLimit > rate {
+		l.EgressLimit = rate
+	}
+	return l
+}
+
+// DeleteTraffic removes the traffic saved for the server.
+func (s *Server) DeleteTraffic(ctx context.Context) error {
+	tx := database.Instance().WithContext(ctx).Where("server = ?", s.ID()).Delete(&models.Traffic{})
+	return errors.WithStack(tx.Error)
+}
+
+// recordTraffic counts the network traffic reported by the environment towards
+// the current month, and takes the quota action of the server once it has used
+// its quota.
+func (s *Server) recordTraffic(n environment.NetworkStats) {
+	s.traffic.mu.Lock()
+	if err := s.loadTraffic(); err != nil {
+		s.traffic.mu.Unlock()
+		s.Log().WithField("error", err).Warn("failed to load server traffic usage")
+		return
+	}
+	s.rolloverTraffic()
+
+	t := &s.traffic
+	if t.counting {
+		t.usage.RxBytes += delta(n.RxBytes, t.last.RxBytes)
+		t.usage.TxBytes += delta(n.TxBytes, t.last.TxBytes)
+		t.changed = t.changed || n != t.last
+	}
+	t.counting = true
+	t.last = n
+	if time.Since(t.saved) >= time.Duration(config.Get().System.Traffic.SaveInterval)*time.Second {
+		if err := s.saveTraffic(); err != nil {
+			s.Log().WithField("error", err).Warn("failed to save server traffic usage")
+		}
+	}
+
+	was := t.exceeded
+	t.exceeded = s.trafficQuotaExceeded()
+	exceeded := t.exceeded
+	s.traffic.mu.Unlock()
+
+	if exceeded != was {
+		s.onTrafficQuota(exceeded)
+	}
+}
+
+// resetTraffic marks the server as being started, so that all the traffic next
+// reported by the environment is counted.
+func (s *Server) resetTraffic() {
+	s.traffic.mu.Lock()
+	defer s.traffic.mu.Unlock()
+	s.traffic.counting = true
+	s.traffic.last = environment.NetworkStats{}
+}
+
+// flushTraffic saves the traffic counted for the server if it has changed since
+// it was last saved.
+func (s *Server) flushTraffic() {
+	s.traffic.mu.Lock()
+	defer s.traffic.mu.Unlock()
+	if err := s.saveTraffic(); err != nil {
+		s.Log().WithField("error", err).Warn("failed to save server traffic usage")
+	}
+}
+
+// onTrafficQuota takes the quota action of the server once it has used its
+// traffic quota, or removes the throttle once it is no longer over it.
+func (s *Server) onTrafficQuota(exceeded bool) {
+	cfg := s.Config().Traffic
+	if !exceeded {
+		s.Log().Info("server is no longer over its traffic quota")
+		if cfg.Action == TrafficActionThrottle {
+			s.SyncWithEnvironment()
+		}
+		return
+	}
+
+	s.Log().WithField("quota", cfg.Quota).WithField("action", cfg.Action).Warn("server has used its traffic quota")
+	s.SaveActivity(s.NewRequestActivity("", "127.0.0.1"), ActivityTrafficExceeded, models.ActivityMeta{
+		"quota":  cfg.Quota,
+		"action": cfg.Action,
+	})
+	switch cfg.Action {
+	case TrafficActionThrottle:
+		s.PublishConsoleOutputFromDaemon("Server has used its traffic quota for this month, limiting its bandwidth...")
+		s.SyncWithEnvironment()
+	case TrafficActionStop:
+		s.PublishConsoleOutputFromDaemon("Server has used its traffic quota for this month, stopping...")
+		go func() {
+			if err := s.HandlePowerAction(PowerActionStop, 10); err != nil {
+				s.Log().WithField("error", err).Error("failed to stop server over its traffic quota")
+			}
+		}()
+	}
+}
+
+// loadTraffic loads the traffic saved for the current month, if it has not been
+// loaded yet. The lock on the traffic counter must be held.
+func (s *Server) loadTraffic() error {
+	t := &s.traffic
+	if t.loaded {
+		return nil
+	}
+
+	location, err := time.LoadLocation(config.Get().System.Timezone)
+	if err != nil {
+		location = time.Local
+	}
+	usage := models.Traffic{Server: s.ID(), Month: time.Now().In(location).Format("2006-01")}
+	err = database.Instance().Where("server = ? AND month = ?", usage.Server, usage.Month).First(&usage).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.WithStack(err)
+	}
+
+	t.loaded = true
+	t.location = location
+	t.usage = usage
+	t.saved = time.Now()
+	t.exceeded = s.trafficQuotaExceeded()
+	return nil
+}
+
+// rolloverTraffic starts counting the traffic of the server from zero once a new
+// month has started, saving the traffic counted for the previous month. The lock
+// on the traffic counter must be held.
+func (s *Server) rolloverTraffic() {
+	t := &s.traffic
+	month := time.Now().In(t.location).Format("2006-01")
+	if month == t.usage.Month {
+		return
+	}
+	if err := s.saveTraffic(); err != nil {
+		s.Log().WithField("error", err).Warn("failed to save server traffic usage")
+	}
+	t.usage = models.Traffic{Server: s.ID(), Month: month}
+}
+
+// saveTraffic saves the traffic counted for the current month if it has changed.
+// The lock on the traffic counter must be held.
+func (s *Server) saveTraffic() error {
+	t := &s.traffic
+	if !t.loaded || !t.changed {
+		return nil
+	}
+	err := database.Instance().Clauses(clause.OnConflict{UpdateAll: true}).Create(&t.usage).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	t.changed = false
+	t.saved = time.Now()
+	return nil
+}
+
+// trafficQuotaExceeded returns true if the server has a traffic quota and has used
+// it this month. The lock on the traffic counter must be held.
+func (s *Server) trafficQuotaExceeded() bool {
+	quota := s.Config().Traffic.Quota
+	if quota <= 0 {
+		return false
+	}
+	return s.traffic.usage.RxBytes+s.traffic.usage.TxBytes >= uint64(quota)*1024*1024
+}
+
+// delta returns the traffic reported since the last report. A counter is only
+// reset when the server is started, so a report lower than the last one adds
+// nothing rather than being counted again from zero.
+func delta(current uint64, last uint64) uint64 {
+	if current < last {
+		return 0
+	}
+	return current - last
+}
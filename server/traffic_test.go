@@ -0,0 +1,109 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/internal/database"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+)
+
+func TestTrafficRollover(t *testing.T) {
+	g := Goblin(t)
+
+	config.Set(&config.Configuration{
+		AuthenticationToken: "abc",
+		System:              config.SystemConfiguration{RootDirectory: t.TempDir(), Timezone: "UTC"},
+	})
+	if err := database.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	const id = "4d7e2b1a-6c3f-4a8e-9b0d-1f2e3c4d5a6b"
+	month := time.Now().UTC().Format("2006-01")
+
+	g.Describe("Traffic rollover", func() {
+		var s *Server
+
+		g.BeforeEach(func() {
+			database.Instance().Where("server = ?", id).Delete(&models.Traffic{})
+			s, _ = New(nil)
+			s.cfg.Uuid = id
+			// The traffic counted last month, which has not been saved yet.
+			s.traffic = trafficCounter{
+				loaded:   true,
+				location: time.UTC,
+				usage:    models.Traffic{Server: id, Month: "2020-01", RxBytes: 3 * 1024 * 1024, TxBytes: 1024},
+				changed:  true,
+			}
+		})
+
+		g.It("saves the previous month and counts the new month from zero", func() {
+			usage := s.TrafficUsage()
+			g.Assert(usage.Month).Equal(month)
+			g.Assert(usage.RxBytes).Equal(uint64(0))
+			g.Assert(usage.TxBytes).Equal(uint64(0))
+
+			var saved models.Traffic
+			g.Assert(database.Instance().Where("server = ? AND month = ?", id, "2020-01").First(&saved).Error).IsNil()
+			g.Assert(saved.RxBytes).Equal(uint64(3 * 1024 * 1024))
+			g.Assert(saved.TxBytes).Equal(uint64(1024))
+		})
+
+		g.It("counts new traffic towards the new month", func() {
+			s.traffic.counting = true
+			s.recordTraffic(environment.NetworkStats{RxBytes: 100, TxBytes: 20})
+			s.flushTraffic()
+
+			var saved models.Traffic
+			g.Assert(database.Instance().Where("server = ? AND month = ?", id, month).First(&saved).Error).IsNil()
+			g.Assert(saved.RxBytes).Equal(uint64(100))
+			g.Assert(saved.TxBytes).Equal(uint64(20))
+		})
+
+		g.It("is no longer over the quota of the previous month", func() {
+			s.cfg.Traffic.Quota = 1
+			g.Assert(s.trafficQuotaExceeded()).IsTrue()
+			g.Assert(s.TrafficUsage().Exceeded).IsFalse()
+		})
+	})
+
+	g.Describe("Traffic counting", func() {
+		var s *Server
+
+		g.BeforeEach(func() {
+			database.Instance().Where("server = ?", id).Delete(&models.Traffic{})
+			s, _ = New(nil)
+			s.cfg.Uuid = id
+			s.traffic = trafficCounter{loaded: true, location: time.UTC, usage: models.Traffic{Server: id, Month: month}}
+		})
+
+		g.It("only uses the first report of a running server as a starting point", func() {
+			s.recordTraffic(environment.NetworkStats{RxBytes: 500, TxBytes: 50})
+			s.recordTraffic(environment.NetworkStats{RxBytes: 600, TxBytes: 70})
+			g.Assert(s.TrafficUsage().RxBytes).Equal(uint64(100))
+			g.Assert(s.TrafficUsage().TxBytes).Equal(uint64(20))
+		})
+
+		g.It("counts from zero once the server is started", func() {
+			s.recordTraffic(environment.NetworkStats{RxBytes: 500, TxBytes: 50})
+			s.resetTraffic()
+			s.recordTraffic(environment.NetworkStats{RxBytes: 30, TxBytes: 3})
+			g.Assert(s.TrafficUsage().RxBytes).Equal(uint64(30))
+			g.Assert(s.TrafficUsage().TxBytes).Equal(uint64(3))
+		})
+
+		g.It("does not count a report lower than the last one again", func() {
+			s.resetTraffic()
+			s.recordTraffic(environment.NetworkStats{RxBytes: 100, TxBytes: 10})
+			s.recordTraffic(environment.NetworkStats{RxBytes: 90, TxBytes: 10})
+			s.recordTraffic(environment.NetworkStats{RxBytes: 120, TxBytes: 15})
+			g.Assert(s.TrafficUsage().RxBytes).Equal(uint64(130))
+			g.Assert(s.TrafficUsage().TxBytes).Equal(uint64(15))
+		})
+	})
+}
@@ -9,11 +9,11 @@ import (
 	"mime/multipart"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/apex/log"
 	"github.com/Minenetpro/pelican-wings/config"
 	"github.com/Minenetpro/pelican-wings/internal/progress"
+	"github.com/Minenetpro/pelican-wings/server/backup"
 	"github.com/Minenetpro/pelican-wings/server/filesystem"
 )
 
@@ -58,15 +58,16 @@ func (a *Archive) StreamBackups(ctx context.Context, mp *multipart.Writer) error
     // Create a set of backup UUIDs for quick lookup
     backupSet := make(map[string]bool)
     for _, uuid := range a.transfer.BackupUUIDs {
-        backupSet[uuid+".tar.gz"] = true // Backup files are stored as UUID.tar.gz
+        // Backup files are stored as UUID.tar.gz, or UUID.tar.zst if compressed with zstd.
+        for _, ext := range backup.Extensions {
+            backupSet[uuid+ext] = true
+        }
     }
 
     var backupsToTransfer []os.DirEntry
     for _, entry := range entries {
-        if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".tar.gz") {
-            if backupSet[entry.Name()] {
-                backupsToTransfer = append(backupsToTransfer, entry)
-            }
+        if !entry.IsDir() && backupSet[entry.Name()] {
+            backupsToTransfer = append(backupsToTransfer, entry)
         }
     }
 
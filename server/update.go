@@ -6,6 +6,7 @@ import (
 	"github.com/Minenetpro/pelican-wings/environment/docker"
 
 	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/remote"
 )
 
 // SyncWithEnvironment updates the environment for the server to match any of
@@ -25,17 +26,22 @@ func (s *Server) SyncWithEnvironment() {
 
 	// Update the environment settings using the new information from this server.
 	s.Environment.Config().SetSettings(environment.Settings{
-		Mounts:      s.Mounts(),
-		Allocations: cfg.Allocations,
-		Limits:      cfg.Build,
-		Labels:      cfg.Labels,
+		Mounts:          s.Mounts(),
+		Allocations:     cfg.Allocations,
+		Limits:          s.Limits(),
+		Labels:          cfg.Labels,
+		SecurityProfile: s.SecurityProfile(),
 	})
 
-	// For Docker specific environments we also want to update the configured image
-	// and stop configuration.
+	// For Docker specific environments we also want to update the configured image,
+	// and for any environment that supports it, the stop configuration.
 	if e, ok := s.Environment.(*docker.Environment); ok {
 		s.Log().Debug("syncing stop configuration with configured docker environment")
 		e.SetImage(cfg.Container.Image)
+	}
+	if e, ok := s.Environment.(interface {
+		SetStopConfiguration(remote.ProcessStopConfiguration)
+	}); ok {
 		e.SetStopConfiguration(s.ProcessConfiguration().Stop)
 	}
 
@@ -59,7 +65,7 @@ func (s *Server) SyncWithEnvironment() {
 			s.Log().Info("server suspended with running process state, terminating now")
 
 			go func(s *Server) {
-				if err := s.Environment.WaitForStop(s.Context(), time.Minute, true); err != nil {
+				if err := s.WaitForStop(s.Context(), time.Minute, true); err != nil {
 					s.Log().WithField("error", err).Warn("failed to terminate server environment after suspension")
 				}
 			}(s)
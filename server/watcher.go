@@ -0,0 +1,31 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/server/filesystem"
+)
+
+// StartFileWatcher starts watching the data directory of the server in the
+// background if the file watcher is enabled in the configuration. Every change
+// is published to the server's event bus as a FileChangedEvent. The watcher is
+// stopped when the server context is canceled.
+func (s *Server) StartFileWatcher() {
+	cfg := config.Get().System.FileWatcher
+	if !cfg.Enabled {
+		return
+	}
+
+	go func() {
+		debounce := time.Duration(cfg.Debounce) * time.Millisecond
+		err := s.fs.Watch(s.Context(), debounce, cfg.Ignore, func(changes []filesystem.FileChange) {
+			for _, c := range changes {
+				s.Events().Publish(FileChangedEvent, c)
+			}
+		})
+		if err != nil {
+			s.Log().WithField("error", err).Warn("failed to watch server data directory for changes")
+		}
+	}()
+}
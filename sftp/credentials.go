@@ -0,0 +1,100 @@
+package sftp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"emperror.dev/errors"
+	"gorm.io/gorm/clause"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/database"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/router/tokens"
+)
+
+// credentialHash returns the hash that a public key login is cached under. The
+// username is included since it determines which server the key is used for.
+func credentialHash(username string, key string) string {
+	h := sha256.Sum256([]byte(username + "\x00" + key))
+	return hex.EncodeToString(h[:])
+}
+
+// cacheCredentials stores a public key login that was validated by the Panel so
+// that it can be used if the Panel becomes unreachable. Any existing entry for
+// the same key is replaced, which also extends its expiry.
+func cacheCredentials(request remote.SftpAuthRequest, resp remote.SftpAuthResponse) error {
+	now := time.Now()
+	cred := models.SftpCredential{
+		KeyHash:     credentialHash(request.User, request.Pass),
+		User:        resp.User,
+		Server:      resp.Server,
+		Permissions: resp.Permissions,
+		PathRules:   resp.PathRules,
+		ValidatedAt: now,
+		ExpiresAt:   now.Add(time.Duration(config.Get().System.Sftp.CredentialCache.TTL) * time.Second),
+	}
+	tx := database.Instance().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key_hash"}},
+		UpdateAll: true,
+	}).Create(&cred)
+	if tx.Error != nil {
+		return errors.Wrap(tx.Error, "sftp: failed to cache credentials")
+	}
+	if tx := database.Instance().Where("expires_at <= ?", now).Delete(&models.SftpCredential{}); tx.Error != nil {
+		return errors.Wrap(tx.Error, "sftp: failed to remove expired credentials")
+	}
+	return nil
+}
+
+// cachedCredentials returns the cached login for a public key, or nil if there
+// is no cached login that is still valid. A cached login is not valid once it
+// has expired, if the user has been deauthorized from the server since it was
+// cached, or if the server is suspended or no longer exists.
+func (c *SFTPServer) cachedCredentials(request remote.SftpAuthRequest) (*remote.SftpAuthResponse, error) {
+	var cred models.SftpCredential
+	tx := database.Instance().
+		Where("key_hash = ? AND expires_at > ?", credentialHash(request.User, request.Pass), time.Now()).
+		Limit(1).
+		Find(&cred)
+	if tx.Error != nil {
+		return nil, errors.Wrap(tx.Error, "sftp: failed to look up cached credentials")
+	}
+	if tx.RowsAffected == 0 || tokens.DeniedForServer(cred.Server, cred.User, cred.ValidatedAt) {
+		return nil, nil
+	}
+	if s, ok := c.manager.Get(cred.Server); !ok || s.IsSuspended() {
+		return nil, nil
+	}
+	return &remote.SftpAuthResponse{
+		Server:      cred.Server,
+		User:        cred.User,
+		Permissions: cred.Permissions,
+		PathRules:   cred.PathRules,
+	}, nil
+}
+
+// forgetCredential removes the cached login for a public key, if there is one.
+func forgetCredential(request remote.SftpAuthRequest) error {
+	tx := database.Instance().Where("key_hash = ?", credentialHash(request.User, request.Pass)).Delete(&models.SftpCredential{})
+	if tx.Error != nil {
+		return errors.Wrap(tx.Error, "sftp: failed to remove cached credentials")
+	}
+	return nil
+}
+
+// ForgetCredentials removes every cached login for the user on the given
+// servers, or on every server if none are given. This is called whenever a
+// user is deauthorized so that they cannot log in while the Panel is down.
+func ForgetCredentials(user string, servers []string) error {
+	tx := database.Instance().Where("user = ?", user)
+	if len(servers) > 0 {
+		tx = tx.Where("server IN ?", servers)
+	}
+	if tx := tx.Delete(&models.SftpCredential{}); tx.Error != nil {
+		return errors.Wrap(tx.Error, "sftp: failed to remove cached credentials")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package sftp
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/franela/goblin"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/acl"
+	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/router/tokens"
+	"github.com/Minenetpro/pelican-wings/server"
+)
+
+func TestCredentialCache(t *testing.T) {
+	g := Goblin(t)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "pelican")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	setTTL := func(ttl int) {
+		config.Set(&config.Configuration{
+			AuthenticationToken: "abc",
+			System: config.SystemConfiguration{
+				RootDirectory: dir,
+				Sftp: config.SftpConfiguration{
+					CredentialCache: config.SftpCredentialCacheConfiguration{Enabled: true, TTL: ttl},
+				},
+			},
+		})
+	}
+	setTTL(60)
+	initDatabase(t)
+
+	s, _ := server.New(nil)
+	s.Config().Uuid = "3f4c2b8e-0d6a-4c1e-9b7a-2e5d8c1f6a90"
+	m := server.NewEmptyManager(nil)
+	m.Add(s)
+	c := &SFTPServer{manager: m}
+
+	g.Describe("Credential cache", func() {
+		request := remote.SftpAuthRequest{Type: remote.SftpAuthPublicKey, User: "alice.3f4c2b8e", Pass: "ssh-ed25519 AAAA"}
+		resp := remote.SftpAuthResponse{
+			Server:      s.ID(),
+			User:        "5a1e6f0c-8b2d-4e3a-9c7f-1d0b6a4e2f83",
+			Permissions: []string{"file.read"},
+			PathRules:   acl.Rules{{Path: "plugins"}},
+		}
+
+		g.BeforeEach(func() {
+			setTTL(60)
+		})
+
+		g.AfterEach(func() {
+			_ = ForgetCredentials(resp.User, nil)
+		})
+
+		g.It("returns a cached login for the same username and key", func() {
+			g.Assert(cacheCredentials(request, resp)).IsNil()
+
+			cached, err := c.cachedCredentials(request)
+			g.Assert(err).IsNil()
+			g.Assert(*cached).Equal(resp)
+
+			other := request
+			other.Pass = "ssh-ed25519 BBBB"
+			cached, err = c.cachedCredentials(other)
+			g.Assert(err).IsNil()
+			g.Assert(cached == nil).IsTrue()
+
+			other = request
+			other.User = "alice.00000000"
+			cached, _ = c.cachedCredentials(other)
+			g.Assert(cached == nil).IsTrue()
+		})
+
+		g.It("does not return an expired login", func() {
+			setTTL(0)
+			g.Assert(cacheCredentials(request, resp)).IsNil()
+
+			cached, err := c.cachedCredentials(request)
+			g.Assert(err).IsNil()
+			g.Assert(cached == nil).IsTrue()
+		})
+
+		g.It("forgets logins for deauthorized users", func() {
+			g.Assert(cacheCredentials(request, resp)).IsNil()
+			g.Assert(ForgetCredentials(resp.User, []string{"d2a9b1c4-7e3f-4a6b-8c5d-0f1e2a3b4c5d"})).IsNil()
+			cached, _ := c.cachedCredentials(request)
+			g.Assert(cached == nil).IsFalse()
+
+			g.Assert(ForgetCredentials(resp.User, []string{s.ID()})).IsNil()
+			cached, _ = c.cachedCredentials(request)
+			g.Assert(cached == nil).IsTrue()
+		})
+
+		g.It("does not return logins cached before the user was denied", func() {
+			g.Assert(cacheCredentials(request, resp)).IsNil()
+			tokens.DenyForServer(s.ID(), resp.User)
+
+			cached, _ := c.cachedCredentials(request)
+			g.Assert(cached == nil).IsTrue()
+		})
+	})
+}
@@ -3,16 +3,20 @@ package sftp
 import (
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 
 	"emperror.dev/errors"
 	"github.com/apex/log"
+	"github.com/goccy/go-json"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/acl"
 	"github.com/Minenetpro/pelican-wings/server"
 	"github.com/Minenetpro/pelican-wings/server/filesystem"
 )
@@ -31,18 +35,28 @@ type Handler struct {
 	fs          *filesystem.Filesystem
 	events      *eventHandler
 	permissions []string
+	rules       acl.Rules
+	session     *server.SftpSession
 	logger      *log.Entry
 	ro          bool
 }
 
 // NewHandler returns a new connection handler for the SFTP server. This allows a given user
-// to access the underlying filesystem.
-func NewHandler(sc *ssh.ServerConn, srv *server.Server) (*Handler, error) {
+// to access the underlying filesystem. Data transferred through the handler is recorded
+// against the provided session.
+func NewHandler(sc *ssh.ServerConn, srv *server.Server, session *server.SftpSession) (*Handler, error) {
 	uuid, ok := sc.Permissions.Extensions["user"]
 	if !ok {
 		return nil, errors.New("sftp: mismatched Wings and Panel versions — Panel 1.10 is required for this version of Wings.")
 	}
 
+	var rules acl.Rules
+	if v := sc.Permissions.Extensions["path_rules"]; v != "" {
+		if err := json.Unmarshal([]byte(v), &rules); err != nil {
+			return nil, errors.Wrap(err, "sftp: failed to parse path rules")
+		}
+	}
+
 	events := eventHandler{
 		ip:     sc.RemoteAddr().String(),
 		user:   uuid,
@@ -51,6 +65,8 @@ func NewHandler(sc *ssh.ServerConn, srv *server.Server) (*Handler, error) {
 
 	return &Handler{
 		permissions: strings.Split(sc.Permissions.Extensions["permissions"], ","),
+		rules:       rules,
+		session:     session,
 		server:      srv,
 		fs:          srv.Filesystem(),
 		events:      &events,
@@ -74,7 +90,7 @@ func (h *Handler) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	// Check first if the user can actually open and view a file. This permission is named
 	// really poorly, but it is checking if they can read. There is an addition permission,
 	// "save-files" which determines if they can write that file.
-	if !h.can(PermissionFileReadContent) {
+	if !h.can(PermissionFileReadContent) || !h.rules.Allows(request.Filepath, acl.Read) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 	h.mu.Lock()
@@ -90,7 +106,7 @@ func (h *Handler) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 		}
 		return nil, sftp.ErrSSHFxNoSuchFile
 	}
-	return f, nil
+	return &sessionReaderAt{File: f, session: h.session}, nil
 }
 
 // Filewrite handles the write actions for a file on the system.
@@ -125,10 +141,10 @@ func (h *Handler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	// Confirm the user has permission to perform this action BEFORE calling Touch, otherwise
 	// you'll potentially create a file on the system and then fail out because of user
 	// permission checking after the fact.
-	if !h.can(permission) {
+	if !h.can(permission) || !h.rules.Allows(request.Filepath, acl.Write) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
-	f, err := h.fs.Touch(request.Filepath, os.O_RDWR|os.O_TRUNC)
+	f, err := h.fs.TouchWriter(request.Filepath, os.O_RDWR|os.O_TRUNC, 0o644)
 	if err != nil {
 		l.WithField("flags", request.Flags).WithField("error", err).Error("failed to open existing file on system")
 		return nil, sftp.ErrSSHFxFailure
@@ -141,7 +157,7 @@ func (h *Handler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 		event = server.ActivitySftpCreate
 	}
 	h.events.MustLog(event, FileAction{Entity: request.Filepath})
-	return f, nil
+	return &quotaWriterAt{QuotaWriter: f, session: h.session}, nil
 }
 
 // Filecmd hander for basic SFTP system calls related to files, but not anything to do with reading
@@ -163,7 +179,7 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 	// Allows a user to make changes to the permissions of a given file or directory
 	// on their server using their SFTP client.
 	case "Setstat":
-		if !h.can(PermissionFileUpdate) {
+		if !h.can(PermissionFileUpdate) || !h.rules.Allows(request.Filepath, acl.Write) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		mode := request.Attributes().FileMode().Perm()
@@ -185,7 +201,7 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 		break
 	// Support renaming a file (aka Move).
 	case "Rename":
-		if !h.can(PermissionFileUpdate) {
+		if !h.can(PermissionFileUpdate) || !h.rules.AllowsTree(request.Filepath, acl.Delete) || !h.rules.AllowsTree(request.Target, acl.Write) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		if err := h.fs.Rename(request.Filepath, request.Target); err != nil {
@@ -201,7 +217,7 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 	// folders within that directory if it is not already empty (unlike a lot of SFTP
 	// clients that must delete each file individually).
 	case "Rmdir":
-		if !h.can(PermissionFileDelete) {
+		if !h.can(PermissionFileDelete) || !h.rules.AllowsTree(request.Filepath, acl.Delete) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		p := filepath.Clean(request.Filepath)
@@ -213,7 +229,7 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 		return sftp.ErrSSHFxOk
 	// Handle requests to create a new Directory.
 	case "Mkdir":
-		if !h.can(PermissionFileCreate) {
+		if !h.can(PermissionFileCreate) || !h.rules.Allows(request.Filepath, acl.Write) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		name := strings.Split(filepath.Clean(request.Filepath), "/")
@@ -227,7 +243,9 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 	// Support creating symlinks between files. The source and target must resolve within
 	// the server home directory.
 	case "Symlink":
-		if !h.can(PermissionFileCreate) {
+		// The link can be used to read whatever it points to, so the user must
+		// also be able to read the target.
+		if !h.can(PermissionFileCreate) || !h.rules.Allows(request.Target, acl.Write) || !h.rules.AllowsTree(request.Filepath, acl.Read) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		if err := h.fs.Symlink(request.Filepath, request.Target); err != nil {
@@ -237,7 +255,7 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 		break
 	// Called when deleting a file.
 	case "Remove":
-		if !h.can(PermissionFileDelete) {
+		if !h.can(PermissionFileDelete) || !h.rules.Allows(request.Filepath, acl.Delete) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		if err := h.fs.Delete(request.Filepath); err != nil {
@@ -270,7 +288,7 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 // Filelist is the handler for SFTP filesystem list calls. This will handle calls to list the contents of
 // a directory as well as perform file/folder stat calls.
 func (h *Handler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
-	if !h.can(PermissionFileRead) {
+	if !h.can(PermissionFileRead) || !h.rules.Visible(request.Filepath) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
@@ -281,6 +299,13 @@ func (h *Handler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 			h.logger.WithField("source", request.Filepath).WithField("error", err).Error("error while listing directory")
 			return nil, sftp.ErrSSHFxFailure
 		}
+		// Only list the entries the user is able to see, a user that only has
+		// access to a subdirectory can still navigate to it.
+		if len(h.rules) > 0 {
+			entries = slices.DeleteFunc(entries, func(e os.FileInfo) bool {
+				return !h.rules.Visible(filepath.Join(request.Filepath, e.Name()))
+			})
+		}
 		return ListerAt(entries), nil
 	case "Stat":
 		st, err := h.fs.Stat(request.Filepath)
@@ -313,6 +338,69 @@ func (h *Handler) can(permission string) bool {
 	return false
 }
 
+// canWrite checks that the user can write the file at p, which requires the
+// update permission if the file already exists and the create permission if it
+// does not. This applies the same checks as Filewrite for transfers that write
+// files directly, and returns whether the file already exists.
+func (h *Handler) canWrite(p string) (bool, error) {
+	if h.ro {
+		return false, sftp.ErrSSHFxOpUnsupported
+	}
+	if err := h.fs.IsIgnored(p); err != nil {
+		return false, err
+	}
+	permission := PermissionFileUpdate
+	st, err := h.fs.Stat(p)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		permission = PermissionFileCreate
+	} else if st.IsDir() {
+		return false, errors.New("is a directory")
+	}
+	if !h.can(permission) || !h.rules.Allows(p, acl.Write) {
+		return false, sftp.ErrSSHFxPermissionDenied
+	}
+	return err == nil, nil
+}
+
+// mkdir creates the directory at p, applying the same checks as a Mkdir
+// request. Nothing is done if the directory already exists.
+func (h *Handler) mkdir(p string) error {
+	if st, err := h.fs.Stat(p); err == nil {
+		if !st.IsDir() {
+			return errors.New("not a directory")
+		}
+		return nil
+	}
+	if h.ro {
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	if err := h.fs.IsIgnored(p); err != nil {
+		return err
+	}
+	if !h.can(PermissionFileCreate) || !h.rules.Allows(p, acl.Write) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if err := h.fs.CreateDirectory(path.Base(p), path.Dir(p)); err != nil {
+		return err
+	}
+	if err := h.fs.Chown(p); err != nil {
+		h.logger.WithField("source", p).WithField("error", err).Warn("error chowning directory")
+	}
+	h.events.MustLog(server.ActivitySftpCreateDirectory, FileAction{Entity: p})
+	return nil
+}
+
+// canRead checks that the user can read the contents of the file at p.
+func (h *Handler) canRead(p string) error {
+	if !h.can(PermissionFileReadContent) || !h.rules.Allows(p, acl.Read) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return h.fs.IsIgnored(p)
+}
+
 func (h *Handler) User() string {
 	return h.events.user
 }
@@ -0,0 +1,159 @@
+package sftp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Minenetpro/pelican-wings/config"
+)
+
+// ErrUnknownHostKeyType is returned when a host key type other than "ed25519",
+// "ecdsa" or "rsa" is requested.
+var ErrUnknownHostKeyType = errors.Sentinel("sftp: unknown host key type")
+
+// hostKeyTypes are the types of host key that can be generated, in the order
+// they are presented to clients.
+var hostKeyTypes = []string{"ed25519", "ecdsa", "rsa"}
+
+// HostKey is the public half of a host key presented by the SFTP server.
+type HostKey struct {
+	Type        string `json:"type"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+func newHostKey(t string, signer ssh.Signer) HostKey {
+	return HostKey{
+		Type:        t,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+	}
+}
+
+// hostKeyPath returns the path of the host key of the given type. The ED25519
+// key keeps the path it has always had.
+func (c *SFTPServer) hostKeyPath(t string) string {
+	return path.Join(c.BasePath, ".sftp/id_"+t)
+}
+
+// loadHostKeys reads the host keys of the given types from the disk, generating
+// any that do not exist yet.
+func (c *SFTPServer) loadHostKeys(types []string) ([]ssh.Signer, []HostKey, error) {
+	var signers []ssh.Signer
+	var keys []HostKey
+	for _, t := range types {
+		if !slices.Contains(hostKeyTypes, t) {
+			return nil, nil, errors.WithMessage(ErrUnknownHostKeyType, t)
+		}
+		signer, err := readHostKey(c.hostKeyPath(t), t)
+		if err != nil {
+			return nil, nil, err
+		}
+		signers = append(signers, signer)
+		keys = append(keys, newHostKey(t, signer))
+	}
+	if len(signers) == 0 {
+		return nil, nil, errors.New("sftp: no host keys are configured")
+	}
+	return signers, keys, nil
+}
+
+// loadListenerHostKeys reads the host keys of the listeners that have their own,
+// keyed by their path, generating an ED25519 key for any that does not exist yet.
+func loadListenerHostKeys(listeners []config.SftpListener) (map[string]ssh.Signer, error) {
+	signers := make(map[string]ssh.Signer)
+	for _, l := range listeners {
+		if l.HostKey == "" {
+			continue
+		}
+		if _, ok := signers[l.HostKey]; ok {
+			continue
+		}
+		signer, err := readHostKey(l.HostKey, "ed25519")
+		if err != nil {
+			return nil, errors.WithMessagef(err, "listener %s", net.JoinHostPort(l.Address, strconv.Itoa(l.Port)))
+		}
+		signers[l.HostKey] = signer
+	}
+	return signers, nil
+}
+
+// readHostKey reads the host key at the path, generating a key of the given type
+// there if it does not exist yet.
+func readHostKey(p string, t string) (ssh.Signer, error) {
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		if err := writeHostKey(p, t); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, errors.Wrap(err, "sftp: could not stat private key file")
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "sftp: could not read private key file")
+	}
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sftp: could not parse private key %s", p)
+	}
+	return signer, nil
+}
+
+// generateHostKey generates a new host key of the given type, replacing any
+// existing key of that type.
+func (c *SFTPServer) generateHostKey(t string) error {
+	return writeHostKey(c.hostKeyPath(t), t)
+}
+
+// writeHostKey generates a new host key of the given type at the path. The key
+// is written to a temporary file first so that a partially written key is never
+// read.
+func writeHostKey(p string, t string) error {
+	var priv crypto.PrivateKey
+	var err error
+	switch t {
+	case "ed25519":
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	case "ecdsa":
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "rsa":
+		priv, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return errors.WithMessage(ErrUnknownHostKeyType, t)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "sftp: failed to generate %s private key", t)
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return errors.Wrap(err, "sftp: failed to marshal private key into bytes")
+	}
+
+	if err := os.MkdirAll(path.Dir(p), 0o755); err != nil {
+		return errors.Wrap(err, "sftp: could not create internal sftp data directory")
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}), 0o600); err != nil {
+		return errors.Wrapf(err, "sftp: failed to write %s private key to disk", t)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package sftp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Minenetpro/pelican-wings/config"
+)
+
+func TestHostKeys(t *testing.T) {
+	g := Goblin(t)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "pelican")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	setConfig := func(keys []string, listeners ...config.SftpListener) {
+		config.Set(&config.Configuration{
+			AuthenticationToken: "abc",
+			System: config.SystemConfiguration{
+				Sftp: config.SftpConfiguration{HostKeys: keys, Listeners: listeners},
+			},
+		})
+	}
+
+	g.Describe("Host keys", func() {
+		g.BeforeEach(func() {
+			_ = os.RemoveAll(dir)
+			setConfig([]string{"ed25519", "ecdsa", "rsa"})
+		})
+
+		g.It("generates each configured key once", func() {
+			c := &SFTPServer{BasePath: dir}
+			signers, keys, err := c.loadHostKeys(hostKeyTypes)
+			g.Assert(err).IsNil()
+			g.Assert(len(signers)).Equal(3)
+			g.Assert(signers[0].PublicKey().Type()).Equal("ssh-ed25519")
+			g.Assert(signers[1].PublicKey().Type()).Equal("ecdsa-sha2-nistp256")
+			g.Assert(signers[2].PublicKey().Type()).Equal("ssh-rsa")
+
+			_, again, err := c.loadHostKeys(hostKeyTypes)
+			g.Assert(err).IsNil()
+			g.Assert(again).Equal(keys)
+		})
+
+		g.It("rejects unknown key types", func() {
+			c := &SFTPServer{BasePath: dir}
+			_, _, err := c.loadHostKeys([]string{"dsa"})
+			g.Assert(errors.Is(err, ErrUnknownHostKeyType)).IsTrue()
+		})
+
+		g.It("rotates keys of the running server", func() {
+			c := &SFTPServer{BasePath: dir}
+			g.Assert(c.reloadHostKeys()).IsNil()
+			current.Store(c)
+			defer current.Store(nil)
+			before := c.HostKeys()
+
+			after, err := RotateHostKeys([]string{"ecdsa"})
+			g.Assert(err).IsNil()
+			g.Assert(after[0]).Equal(before[0])
+			g.Assert(after[1].Fingerprint == before[1].Fingerprint).IsFalse()
+			g.Assert(after[2]).Equal(before[2])
+
+			_, err = RotateHostKeys([]string{"dsa"})
+			g.Assert(errors.Is(err, ErrUnknownHostKeyType)).IsTrue()
+		})
+	})
+
+	g.Describe("Server listeners", func() {
+		const uuid = "3f4c2b8e-0d6a-4c1e-9b7a-2e5d8c1f6a90"
+
+		g.It("adds the server suffix to usernames", func() {
+			g.Assert(serverUsername("alice", uuid)).Equal("alice.3f4c2b8e")
+			g.Assert(serverUsername("alice.3F4C2B8E", uuid)).Equal("alice.3F4C2B8E")
+			g.Assert(serverUsername("alice.abcdef12", uuid)).Equal("alice.abcdef12.3f4c2b8e")
+		})
+
+		g.It("presents the host key of a listener that has its own", func() {
+			// hostKey returns the host key presented by the configuration in a
+			// handshake, which is abandoned once the key has been received.
+			hostKey := func(conf *ssh.ServerConfig) ssh.PublicKey {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				g.Assert(err).IsNil()
+				defer l.Close()
+				go func() {
+					if server, err := l.Accept(); err == nil {
+						_, _, _, _ = ssh.NewServerConn(server, conf)
+						_ = server.Close()
+					}
+				}()
+				client, err := net.Dial("tcp", l.Addr().String())
+				g.Assert(err).IsNil()
+				defer client.Close()
+				var key ssh.PublicKey
+				_, _, _, _ = ssh.NewClientConn(client, "sftp", &ssh.ClientConfig{
+					User: "alice",
+					HostKeyCallback: func(_ string, _ net.Addr, k ssh.PublicKey) error {
+						key = k
+						return errors.New("done")
+					},
+				})
+				return key
+			}
+
+			p := filepath.Join(dir, "listener_key")
+			setConfig([]string{"ed25519"}, config.SftpListener{Address: "127.0.0.1", Port: 2022, Server: uuid, HostKey: p})
+			c := &SFTPServer{BasePath: dir}
+			g.Assert(c.reloadHostKeys()).IsNil()
+			_, err := os.Stat(p)
+			g.Assert(err).IsNil()
+
+			main := c.HostKeys()[0].Fingerprint
+			own := ssh.FingerprintSHA256(hostKey(c.serverConfig(uuid, p)))
+			g.Assert(ssh.FingerprintSHA256(hostKey(c.serverConfig("", "")))).Equal(main)
+			g.Assert(own == main).IsFalse()
+
+			// The key is read again when the host keys are reloaded.
+			g.Assert(os.Remove(p)).IsNil()
+			g.Assert(c.reloadHostKeys()).IsNil()
+			g.Assert(ssh.FingerprintSHA256(hostKey(c.serverConfig(uuid, p))) == own).IsFalse()
+		})
+
+		g.It("starts and stops listeners to match the configuration", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			g.Assert(err).IsNil()
+			port := l.Addr().(*net.TCPAddr).Port
+			_ = l.Close()
+			addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+			c := &SFTPServer{BasePath: dir, listeners: make(map[string]*listener)}
+			setConfig([]string{"ed25519"}, config.SftpListener{Address: "127.0.0.1", Port: port, Server: uuid})
+			c.reconcileListeners()
+			g.Assert(len(c.listeners)).Equal(1)
+			g.Assert(c.listeners[addr].server).Equal(uuid)
+
+			conn, err := net.Dial("tcp", addr)
+			g.Assert(err).IsNil()
+			_ = conn.Close()
+
+			setConfig([]string{"ed25519"})
+			c.reconcileListeners()
+			g.Assert(len(c.listeners)).Equal(0)
+			_, err = net.Dial("tcp", addr)
+			g.Assert(err == nil).IsFalse()
+		})
+	})
+}
@@ -0,0 +1,842 @@
+package sftp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Minenetpro/pelican-wings/internal/acl"
+	"github.com/Minenetpro/pelican-wings/internal/ufs"
+	"github.com/Minenetpro/pelican-wings/server"
+)
+
+// rsyncProtocolVersion is the version of the rsync protocol spoken by the
+// receiver. Clients negotiate down to the lowest version both sides support,
+// and version 27 is the newest that does not use the variable length encodings
+// and capability negotiation added in version 30. Every rsync release since
+// 2.6.0 supports it.
+const rsyncProtocolVersion = 27
+
+// Exit codes used by rsync, which the client uses to explain why a transfer
+// failed.
+const (
+	rsyncErrSyntax      = 1
+	rsyncErrProtocol    = 2
+	rsyncErrUnsupported = 4
+	rsyncErrStreamIO    = 12
+	rsyncErrPartial     = 23
+)
+
+// Flags sent before each entry in the file list.
+const (
+	rsyncXmitSameMode      = 1 << 1
+	rsyncXmitSameRdevPre28 = 1 << 2
+	rsyncXmitSameUID       = 1 << 3
+	rsyncXmitSameGID       = 1 << 4
+	rsyncXmitSameName      = 1 << 5
+	rsyncXmitLongName      = 1 << 6
+	rsyncXmitSameTime      = 1 << 7
+)
+
+// File types, as sent in the mode of each file.
+const (
+	rsyncModeType    = 0o170000
+	rsyncModeDir     = 0o040000
+	rsyncModeRegular = 0o100000
+	rsyncModeLink    = 0o120000
+	rsyncModeChar    = 0o020000
+	rsyncModeBlock   = 0o060000
+	rsyncModeFifo    = 0o010000
+	rsyncModeSocket  = 0o140000
+)
+
+// rsyncMaxPath limits the length of the names in the file list.
+const rsyncMaxPath = 4096
+
+type rsyncOptions struct {
+	links          bool
+	perms          bool
+	times          bool
+	owner          bool
+	group          bool
+	devices        bool
+	specials       bool
+	checksum       bool
+	ignoreTimes    bool
+	sizeOnly       bool
+	update         bool
+	wholeFile      bool
+	numericIDs     bool
+	ignoreExisting bool
+	existing       bool
+}
+
+// rsyncFile is a single entry in the file list sent by the client.
+type rsyncFile struct {
+	name  string
+	mode  uint32
+	size  int64
+	mtime int64
+	link  string
+	// target is the path that the file is written to on the server.
+	target string
+}
+
+func (f *rsyncFile) isDir() bool {
+	return f.mode&rsyncModeType == rsyncModeDir
+}
+
+func (f *rsyncFile) isRegular() bool {
+	return f.mode&rsyncModeType == rsyncModeRegular
+}
+
+func (f *rsyncFile) isLink() bool {
+	return f.mode&rsyncModeType == rsyncModeLink
+}
+
+// rsyncReceiver is the server side of "rsync -e ssh <files> user@host:<dest>",
+// which the client runs as "rsync --server ... . <dest>". The client sends a
+// list of its files, the receiver sends back the checksums of the blocks of
+// any it already has that are out of date, and the client then only sends the
+// parts of the files that have changed. Files are written with the same checks
+// as the SFTP handler.
+//
+// Only uploads are supported, and options that change the protocol in ways the
+// receiver does not implement, such as compression or deleting files, are
+// refused up front.
+type rsyncReceiver struct {
+	h       *Handler
+	channel ssh.Channel
+	r       *bufio.Reader
+	mux     *rsyncMux
+	w       *bufio.Writer
+	opts    rsyncOptions
+	seed    int32
+	files   []*rsyncFile
+	errors  atomic.Int32
+}
+
+func newRsyncReceiver(h *Handler, channel ssh.Channel) *rsyncReceiver {
+	return &rsyncReceiver{h: h, channel: channel, r: bufio.NewReader(channel)}
+}
+
+// run runs the rsync command sent by the client and returns the exit status for
+// the command.
+func (rs *rsyncReceiver) run(args []string) uint32 {
+	dest, err := rs.parseArgs(args)
+	if err != nil {
+		_, _ = rs.channel.Stderr().Write([]byte("rsync: " + err.Error() + "\n"))
+		if errors.Is(err, errRsyncUnsupported) {
+			return rsyncErrUnsupported
+		}
+		return rsyncErrSyntax
+	}
+
+	if err := rs.writeInt(rsyncProtocolVersion); err != nil {
+		return rsyncErrStreamIO
+	}
+	version, err := rs.readInt()
+	if err != nil {
+		return rsyncErrStreamIO
+	}
+	if version < rsyncProtocolVersion {
+		_, _ = rs.channel.Stderr().Write([]byte(fmt.Sprintf("rsync: protocol version %d is not supported, rsync 2.6.0 or newer is required\n", version)))
+		return rsyncErrProtocol
+	}
+	rs.seed = int32(time.Now().Unix())
+	if err := rs.writeInt(rs.seed); err != nil {
+		return rsyncErrStreamIO
+	}
+	// Everything sent by the server from this point on is multiplexed.
+	rs.mux = &rsyncMux{w: rs.channel}
+	rs.w = bufio.NewWriterSize(rs.mux, 32*1024)
+
+	if err := rs.readFileList(); err != nil {
+		return rs.fatal(err)
+	}
+	if err := rs.resolveTargets(dest); err != nil {
+		return rs.fatal(err)
+	}
+
+	phase := make(chan struct{})
+	done := make(chan struct{})
+	generated := make(chan error, 1)
+	go func() {
+		generated <- rs.generate(phase, done)
+	}()
+	err = rs.receive(phase)
+	close(done)
+	if err != nil {
+		return rs.fatal(err)
+	}
+	if err := <-generated; err != nil {
+		return rs.fatal(err)
+	}
+	rs.setDirectoryTimes()
+
+	// The final goodbye tells the client that everything has been received.
+	if err := rs.writeInt(-1); err != nil {
+		return rsyncErrStreamIO
+	}
+	if err := rs.w.Flush(); err != nil {
+		return rsyncErrStreamIO
+	}
+	if rs.errors.Load() > 0 {
+		return rsyncErrPartial
+	}
+	return 0
+}
+
+var errRsyncUnsupported = errors.Sentinel("option is not supported")
+
+// parseArgs parses the arguments that the client runs the server with, which
+// are a subset of the options given to the client, and returns the destination
+// of the transfer.
+func (rs *rsyncReceiver) parseArgs(args []string) (string, error) {
+	if !slices.Contains(args, "--server") {
+		return "", errors.New("only transfers started by an rsync client are supported")
+	}
+	var paths []string
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--server":
+		case arg == "--sender":
+			return "", errors.WithMessage(errRsyncUnsupported, "downloading files with rsync is not supported, use SFTP instead")
+		case strings.HasPrefix(arg, "--"):
+			if err := rs.parseLongOption(strings.TrimPrefix(arg, "--")); err != nil {
+				return "", err
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			if err := rs.parseShortOptions(arg[1:]); err != nil {
+				return "", err
+			}
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	// The first path is always "." when the server is the receiver.
+	switch len(paths) {
+	case 1:
+		return ".", nil
+	case 2:
+		return paths[1], nil
+	default:
+		return "", errors.New("expected a single destination")
+	}
+}
+
+func (rs *rsyncReceiver) parseShortOptions(flags string) error {
+	for _, f := range flags {
+		switch f {
+		case 'l':
+			rs.opts.links = true
+		case 'p':
+			rs.opts.perms = true
+		case 't':
+			rs.opts.times = true
+		case 'o':
+			rs.opts.owner = true
+		case 'g':
+			rs.opts.group = true
+		case 'D':
+			rs.opts.devices, rs.opts.specials = true, true
+		case 'c':
+			rs.opts.checksum = true
+		case 'I':
+			rs.opts.ignoreTimes = true
+		case 'u':
+			rs.opts.update = true
+		case 'W':
+			rs.opts.wholeFile = true
+		case 'e':
+			// Everything after "e" describes the capabilities of the client,
+			// none of which are used by this protocol version.
+			return nil
+		case 'r', 'v', 'q', 'i', 'h', 'd', 'x', 'S', 'O', 'J', 'k', 'K', 'L', 'C', 'R', 'E':
+			// Either handled entirely by the client, or not applicable to
+			// files written through the server filesystem.
+		case 'n':
+			return errors.WithMessage(errRsyncUnsupported, "--dry-run is not supported")
+		case 'z':
+			return errors.WithMessage(errRsyncUnsupported, "--compress is not supported")
+		case 'H':
+			return errors.WithMessage(errRsyncUnsupported, "--hard-links is not supported")
+		default:
+			return errors.WithMessage(errRsyncUnsupported, fmt.Sprintf("-%c is not supported", f))
+		}
+	}
+	return nil
+}
+
+func (rs *rsyncReceiver) parseLongOption(opt string) error {
+	name, _, _ := strings.Cut(opt, "=")
+	switch name {
+	case "numeric-ids":
+		rs.opts.numericIDs = true
+	case "size-only":
+		rs.opts.sizeOnly = true
+	case "ignore-existing":
+		rs.opts.ignoreExisting = true
+	case "existing", "ignore-non-existing":
+		rs.opts.existing = true
+	case "partial", "partial-dir", "inplace", "delay-updates", "temp-dir", "timeout", "contimeout",
+		"modify-window", "log-format", "out-format", "info", "debug", "safe-links", "munge-links",
+		"omit-dir-times", "omit-link-times", "ignore-errors", "force", "checksum-seed", "no-implied-dirs":
+		// The receiver always writes to a temporary file and replaces the
+		// original once it has been received, and does not log transfers.
+	default:
+		if strings.HasPrefix(name, "delete") || name == "del" || name == "remove-source-files" {
+			return errors.WithMessage(errRsyncUnsupported, "deleting files is not supported, use SFTP instead")
+		}
+		return errors.WithMessage(errRsyncUnsupported, "--"+name+" is not supported")
+	}
+	return nil
+}
+
+// readFileList reads the list of files sent by the client. Each entry only
+// includes the fields that differ from the previous one, along with however
+// much of the start of the name is the same.
+func (rs *rsyncReceiver) readFileList() error {
+	var last rsyncFile
+	var lastName string
+	for {
+		flags, err := rs.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if flags == 0 {
+			break
+		}
+
+		var prefix int
+		if flags&rsyncXmitSameName != 0 {
+			b, err := rs.r.ReadByte()
+			if err != nil {
+				return err
+			}
+			prefix = int(b)
+		}
+		var n int
+		if flags&rsyncXmitLongName != 0 {
+			v, err := rs.readInt()
+			if err != nil {
+				return err
+			}
+			n = int(v)
+		} else {
+			b, err := rs.r.ReadByte()
+			if err != nil {
+				return err
+			}
+			n = int(b)
+		}
+		if prefix > len(lastName) || n < 0 || prefix+n > rsyncMaxPath {
+			return errors.New("invalid file name in file list")
+		}
+		suffix, err := rs.readBytes(n)
+		if err != nil {
+			return err
+		}
+		lastName = lastName[:prefix] + string(suffix)
+
+		f := &rsyncFile{name: path.Clean(lastName), mode: last.mode, mtime: last.mtime}
+		if f.size, err = rs.readLongInt(); err != nil {
+			return err
+		}
+		if flags&rsyncXmitSameTime == 0 {
+			v, err := rs.readInt()
+			if err != nil {
+				return err
+			}
+			f.mtime = int64(v)
+		}
+		if flags&rsyncXmitSameMode == 0 {
+			v, err := rs.readInt()
+			if err != nil {
+				return err
+			}
+			f.mode = uint32(v)
+		}
+		// Ownership is not kept since everything is owned by the server user,
+		// but it still has to be read.
+		if rs.opts.owner && flags&rsyncXmitSameUID == 0 {
+			if _, err := rs.readInt(); err != nil {
+				return err
+			}
+		}
+		if rs.opts.group && flags&rsyncXmitSameGID == 0 {
+			if _, err := rs.readInt(); err != nil {
+				return err
+			}
+		}
+		typ := f.mode & rsyncModeType
+		device := typ == rsyncModeChar || typ == rsyncModeBlock
+		special := typ == rsyncModeFifo || typ == rsyncModeSocket
+		if ((rs.opts.devices && device) || (rs.opts.specials && special)) && flags&rsyncXmitSameRdevPre28 == 0 {
+			if _, err := rs.readInt(); err != nil {
+				return err
+			}
+		}
+		if rs.opts.links && f.isLink() {
+			v, err := rs.readInt()
+			if err != nil {
+				return err
+			}
+			if v < 0 || v > rsyncMaxPath {
+				return errors.New("invalid symlink in file list")
+			}
+			link, err := rs.readBytes(int(v))
+			if err != nil {
+				return err
+			}
+			f.link = string(link)
+		}
+		if rs.opts.checksum {
+			if _, err := rs.readBytes(rsyncSumLength); err != nil {
+				return err
+			}
+		}
+		last = *f
+		rs.files = append(rs.files, f)
+	}
+
+	if !rs.opts.numericIDs {
+		if rs.opts.owner {
+			if err := rs.readIDList(); err != nil {
+				return err
+			}
+		}
+		if rs.opts.group {
+			if err := rs.readIDList(); err != nil {
+				return err
+			}
+		}
+	}
+	// Whether the client had any errors building the list, which it has
+	// already shown to the user.
+	if _, err := rs.readInt(); err != nil {
+		return err
+	}
+
+	// Files are referred to by their index in the sorted list, which has to
+	// be sorted in exactly the same way as the client sorts it.
+	slices.SortStableFunc(rs.files, func(a, b *rsyncFile) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return nil
+}
+
+// readIDList reads the names of the users or groups that own the files in the
+// file list, which are not used.
+func (rs *rsyncReceiver) readIDList() error {
+	for {
+		id, err := rs.readInt()
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			return nil
+		}
+		n, err := rs.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if _, err := rs.readBytes(int(n)); err != nil {
+			return err
+		}
+	}
+}
+
+// resolveTargets works out where each file in the list is written to. If the
+// client sent a single file and the destination is not a directory the file is
+// written to the destination, otherwise the destination is a directory that
+// the files are written into, which is created if it does not exist.
+func (rs *rsyncReceiver) resolveTargets(dest string) error {
+	target := path.Join("/", dest)
+	st, err := rs.h.fs.Stat(target)
+	isDir := err == nil && st.IsDir()
+	if len(rs.files) == 1 && !rs.files[0].isDir() && !isDir && !strings.HasSuffix(dest, "/") {
+		rs.files[0].target = target
+		return nil
+	}
+	if err == nil && !isDir {
+		return errors.New(dest + ": destination is not a directory")
+	}
+	if !isDir {
+		if err := rs.h.mkdir(target); err != nil {
+			return errors.WrapIf(err, dest)
+		}
+	}
+	for _, f := range rs.files {
+		if f.name == ".." || strings.HasPrefix(f.name, "../") || strings.HasPrefix(f.name, "/") {
+			return errors.New(f.name + ": invalid file name")
+		}
+		f.target = path.Join(target, f.name)
+	}
+	return nil
+}
+
+// generate goes through the file list, creating directories and symlinks and
+// asking the client for each file that is missing or out of date. The checksums
+// of the blocks of the existing file are sent with each request so that the
+// client can send just the parts that have changed.
+func (rs *rsyncReceiver) generate(phase <-chan struct{}, done <-chan struct{}) error {
+	for i, f := range rs.files {
+		var err error
+		switch {
+		case f.isDir():
+			err = rs.h.mkdir(f.target)
+		case f.isLink():
+			if rs.opts.links {
+				err = rs.symlink(f)
+			}
+		case f.isRegular():
+			err = rs.request(i, f)
+		default:
+			_ = rs.mux.message(rsyncMsgInfo, fmt.Sprintf("skipping non-regular file \"%s\"\n", f.name))
+		}
+		if err != nil {
+			rs.error(f, err)
+		}
+		if err := rs.w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	// Once every file has been requested the client is told that the first
+	// phase is over, and then once the client has sent everything that the
+	// second phase, which is used to retry files that failed, is too.
+	if err := rs.writeInt(-1); err != nil {
+		return err
+	}
+	if err := rs.w.Flush(); err != nil {
+		return err
+	}
+	select {
+	case <-phase:
+	case <-done:
+		return nil
+	}
+	if err := rs.writeInt(-1); err != nil {
+		return err
+	}
+	return rs.w.Flush()
+}
+
+// request asks the client for a file if it needs to be transferred.
+func (rs *rsyncReceiver) request(i int, f *rsyncFile) error {
+	exists, err := rs.h.canWrite(f.target)
+	if err != nil {
+		return err
+	}
+	var st os.FileInfo
+	if exists {
+		s, err := rs.h.fs.Stat(f.target)
+		if err != nil {
+			return err
+		}
+		st = s.FileInfo
+		if rs.opts.ignoreExisting || (rs.opts.update && st.ModTime().Unix() > f.mtime) {
+			return nil
+		}
+		if !rs.opts.ignoreTimes && !rs.opts.checksum && st.Size() == f.size && (rs.opts.sizeOnly || st.ModTime().Unix() == f.mtime) {
+			return nil
+		}
+	} else if rs.opts.existing {
+		return nil
+	}
+	if !rs.h.fs.HasSpaceAvailable(true) {
+		return ErrSSHQuotaExceeded
+	}
+
+	sums := &rsyncSums{}
+	if exists && !rs.opts.wholeFile && st.Size() > 0 {
+		basis, _, err := rs.h.fs.File(f.target)
+		if err != nil {
+			return err
+		}
+		sums, err = generateRsyncSums(basis, st.Size(), rs.seed)
+		_ = basis.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := rs.writeInt(int32(i)); err != nil {
+		return err
+	}
+	for _, v := range []int32{sums.count, sums.blength, sums.s2length, sums.remainder} {
+		if err := rs.writeInt(v); err != nil {
+			return err
+		}
+	}
+	for _, b := range sums.blocks {
+		if err := rs.writeInt(int32(b.rolling)); err != nil {
+			return err
+		}
+		if _, err := rs.w.Write(b.strong[:sums.s2length]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symlink creates a symlink sent by the client, applying the same checks as a
+// Symlink request. An existing file at the same path is left alone.
+func (rs *rsyncReceiver) symlink(f *rsyncFile) error {
+	linked := f.link
+	if !path.IsAbs(linked) {
+		linked = path.Join(path.Dir(f.target), linked)
+	}
+	if rs.h.ro {
+		return errors.New("the server is read-only")
+	}
+	if err := rs.h.fs.IsIgnored(f.target); err != nil {
+		return err
+	}
+	if !rs.h.can(PermissionFileCreate) || !rs.h.rules.Allows(f.target, acl.Write) || !rs.h.rules.AllowsTree(linked, acl.Read) {
+		return errors.New("permission denied")
+	}
+	if err := rs.h.fs.Symlink(f.link, f.target); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// receive reads the files sent by the client, in the order they were requested,
+// until the client has sent everything. The phase channel is closed once the
+// client has finished the first phase.
+func (rs *rsyncReceiver) receive(phase chan<- struct{}) error {
+	phases := 0
+	for {
+		ndx, err := rs.readInt()
+		if err != nil {
+			return err
+		}
+		if ndx == -1 {
+			phases++
+			if phases > 1 {
+				return nil
+			}
+			close(phase)
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(rs.files) || !rs.files[ndx].isRegular() {
+			return errors.New("invalid file index")
+		}
+		var sums rsyncSums
+		for _, v := range []*int32{&sums.count, &sums.blength, &sums.s2length, &sums.remainder} {
+			if *v, err = rs.readInt(); err != nil {
+				return err
+			}
+		}
+		if sums.count < 0 || sums.blength < 0 || sums.s2length < 0 || sums.s2length > rsyncSumLength || sums.remainder < 0 || sums.remainder > sums.blength {
+			return errors.New("invalid checksum header")
+		}
+		if err := rs.receiveFile(rs.files[ndx], &sums); err != nil {
+			return err
+		}
+	}
+}
+
+// receiveFile receives a single file, which is made up of literal data and of
+// blocks of the existing file. The file is written to a temporary file that
+// replaces the existing one once it has been verified. An error is only
+// returned if the transfer cannot continue, if the file cannot be written the
+// rest of it is still read from the client.
+func (rs *rsyncReceiver) receiveFile(f *rsyncFile, sums *rsyncSums) error {
+	tmp := path.Join(path.Dir(f.target), "."+path.Base(f.target)+"."+uuid.NewString()[:8])
+	sum := newRsyncFileSum(rs.seed)
+	out := &stickyErrWriter{w: io.Discard}
+
+	var basis ufs.File
+	if sums.count > 0 {
+		b, _, err := rs.h.fs.File(f.target)
+		if err != nil {
+			out.err = err
+		} else {
+			basis = b
+			defer basis.Close()
+		}
+	}
+	var w *ufs.QuotaWriter
+	if out.err == nil {
+		var err error
+		if w, err = rs.h.fs.TouchWriter(tmp, ufs.O_RDWR|ufs.O_TRUNC, 0o644); err != nil {
+			out.err = err
+		} else {
+			defer w.Close()
+			out.w = w
+		}
+	}
+	dst := io.MultiWriter(sum, out)
+	literal := &sessionWriter{w: dst, session: rs.h.session}
+
+	buf := make([]byte, max(sums.blength, 1))
+	for {
+		token, err := rs.readInt()
+		if err != nil {
+			return err
+		}
+		if token == 0 {
+			break
+		}
+		if token > 0 {
+			if _, err := io.CopyN(literal, rs.r, int64(token)); err != nil {
+				return err
+			}
+			continue
+		}
+		block := -(int64(token) + 1)
+		if block >= int64(sums.count) {
+			return errors.New("invalid block index")
+		}
+		n := int(sums.blength)
+		if block == int64(sums.count)-1 && sums.remainder != 0 {
+			n = int(sums.remainder)
+		}
+		if basis == nil {
+			continue
+		}
+		m, err := basis.ReadAt(buf[:n], block*int64(sums.blength))
+		if err != nil && !errors.Is(err, io.EOF) {
+			out.err = err
+			continue
+		}
+		_, _ = dst.Write(buf[:m])
+	}
+
+	expected, err := rs.readBytes(rsyncSumLength)
+	if err != nil {
+		return err
+	}
+	if out.err == nil && !bytes.Equal(sum.Sum(nil), expected) {
+		out.err = errors.New("file changed while it was being transferred")
+	}
+	if out.err == nil {
+		out.err = rs.replace(f, tmp, w)
+	}
+	if out.err != nil {
+		if w != nil {
+			_ = rs.h.fs.Delete(tmp)
+		}
+		if errors.Is(out.err, ufs.ErrQuotaExceeded) {
+			out.err = ErrSSHQuotaExceeded
+		}
+		rs.error(f, out.err)
+	}
+	return nil
+}
+
+// replace moves a file that has been received into place and applies the mode
+// and modification time sent by the client.
+func (rs *rsyncReceiver) replace(f *rsyncFile, tmp string, w *ufs.QuotaWriter) error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	_, err := rs.h.fs.Stat(f.target)
+	exists := err == nil
+	if err := rs.h.fs.Replace(tmp, f.target); err != nil {
+		return err
+	}
+	_ = rs.h.fs.Chown(f.target)
+	if rs.opts.perms && f.mode&0o777 != 0 {
+		_ = rs.h.fs.Chmod(f.target, os.FileMode(f.mode&0o777))
+	}
+	if rs.opts.times {
+		mtime := time.Unix(f.mtime, 0)
+		_ = rs.h.fs.Chtimes(f.target, mtime, mtime)
+	}
+	event := server.ActivitySftpCreate
+	if exists {
+		event = server.ActivitySftpWrite
+	}
+	rs.h.events.MustLog(event, FileAction{Entity: f.target})
+	return nil
+}
+
+// setDirectoryTimes sets the modification times of the directories sent by the
+// client, which is done last since writing files into them changes it.
+func (rs *rsyncReceiver) setDirectoryTimes() {
+	if !rs.opts.times {
+		return
+	}
+	for _, f := range rs.files {
+		if f.isDir() && f.target != "" {
+			mtime := time.Unix(f.mtime, 0)
+			_ = rs.h.fs.Chtimes(f.target, mtime, mtime)
+		}
+	}
+}
+
+// error tells the client that a single file could not be transferred.
+func (rs *rsyncReceiver) error(f *rsyncFile, err error) {
+	rs.errors.Add(1)
+	if errors.Is(err, os.ErrNotExist) {
+		err = errors.New("no such file or directory")
+	}
+	_ = rs.mux.message(rsyncMsgErrorXfer, fmt.Sprintf("rsync: %s: %s\n", f.name, err.Error()))
+}
+
+// fatal tells the client about an error that ends the transfer and returns the
+// exit status for it.
+func (rs *rsyncReceiver) fatal(err error) uint32 {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return rsyncErrStreamIO
+	}
+	rs.h.logger.WithField("error", err).Warn("rsync: transfer failed")
+	_ = rs.mux.message(rsyncMsgError, "rsync: "+err.Error()+"\n")
+	return rsyncErrProtocol
+}
+
+func (rs *rsyncReceiver) readInt() (int32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(rs.r, b[:]); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b[:])), nil
+}
+
+// readLongInt reads a 64-bit integer, which is sent as a 32-bit integer unless
+// it does not fit, in which case -1 is sent followed by the full value.
+func (rs *rsyncReceiver) readLongInt() (int64, error) {
+	v, err := rs.readInt()
+	if err != nil || v != -1 {
+		return int64(v), err
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(rs.r, b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b[:])), nil
+}
+
+func (rs *rsyncReceiver) readBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rs.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// writeInt writes a 32-bit integer, which is multiplexed once the protocol has
+// been set up.
+func (rs *rsyncReceiver) writeInt(v int32) error {
+	var w io.Writer = rs.channel
+	if rs.w != nil {
+		w = rs.w
+	}
+	return binary.Write(w, binary.LittleEndian, v)
+}
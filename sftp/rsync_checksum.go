@@ -0,0 +1,151 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"hash"
+	"io"
+	"math"
+	"sync"
+
+	"golang.org/x/crypto/md4"
+)
+
+const (
+	// rsyncBlockLength is the block length used for files up to rsyncBlockLength
+	// squared in size, larger files use the square root of their size.
+	rsyncBlockLength = 700
+	// rsyncMaxBlockLength limits the block length of very large files.
+	rsyncMaxBlockLength = 1 << 17
+	// rsyncSumLength is the length of the strong checksums of each block and of
+	// the whole file, which are MD4 digests in the protocol version used.
+	rsyncSumLength = md4.Size
+)
+
+// rsyncBlockSize returns the block length that the checksums of a file of the
+// given size are generated with.
+func rsyncBlockSize(size int64) int {
+	if size <= rsyncBlockLength*rsyncBlockLength {
+		return rsyncBlockLength
+	}
+	b := int64(math.Sqrt(float64(size))) &^ 7
+	return int(min(b, rsyncMaxBlockLength))
+}
+
+// rsyncRollingSum returns the weak rolling checksum of a block, which the
+// sender uses to find blocks it may already have before checking the strong
+// checksum. The bytes are treated as signed, as they are by rsync.
+func rsyncRollingSum(b []byte) uint32 {
+	var s1, s2 uint32
+	for _, c := range b {
+		s1 += uint32(int8(c))
+		s2 += s1
+	}
+	return (s1 & 0xffff) + (s2 << 16)
+}
+
+// rsyncBlockSum returns the strong checksum of a block, which is the MD4 digest
+// of the block followed by the checksum seed, unless the seed is zero.
+func rsyncBlockSum(b []byte, seed int32) []byte {
+	h := md4.New()
+	h.Write(b)
+	if seed != 0 {
+		_ = binary.Write(h, binary.LittleEndian, seed)
+	}
+	return h.Sum(nil)
+}
+
+// newRsyncFileSum returns the hash used to verify a whole file once it has been
+// received, which is the MD4 digest of the checksum seed followed by the file.
+func newRsyncFileSum(seed int32) hash.Hash {
+	h := md4.New()
+	_ = binary.Write(h, binary.LittleEndian, seed)
+	return h
+}
+
+// rsyncSums are the checksums of each block of a file, which the sender uses
+// to work out which parts of the file the receiver already has.
+type rsyncSums struct {
+	count     int32
+	blength   int32
+	s2length  int32
+	remainder int32
+	blocks    []rsyncBlock
+}
+
+type rsyncBlock struct {
+	rolling uint32
+	strong  []byte
+}
+
+// generateRsyncSums reads a file and returns the checksums of its blocks.
+func generateRsyncSums(r io.Reader, size int64, seed int32) (*rsyncSums, error) {
+	blength := rsyncBlockSize(size)
+	sums := &rsyncSums{blength: int32(blength), s2length: rsyncSumLength}
+	buf := make([]byte, blength)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sums.blocks = append(sums.blocks, rsyncBlock{
+				rolling: rsyncRollingSum(buf[:n]),
+				strong:  rsyncBlockSum(buf[:n], seed),
+			})
+			if n < blength {
+				sums.remainder = int32(n)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sums.count = int32(len(sums.blocks))
+	return sums, nil
+}
+
+// rsyncMux writes the multiplexed stream that the server sends to the client,
+// in which data is sent in frames alongside messages for the user.
+type rsyncMux struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+const (
+	rsyncMsgData      = 0
+	rsyncMsgErrorXfer = 1
+	rsyncMsgInfo      = 2
+	rsyncMsgError     = 3
+
+	rsyncMplexBase  = 7
+	rsyncMaxFrame   = 0xffffff
+	rsyncHeaderSize = 4
+)
+
+// Write writes data to the client.
+func (m *rsyncMux) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), rsyncMaxFrame)]
+		if err := m.frame(rsyncMsgData, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// message sends a message to the client, which is shown to the user.
+func (m *rsyncMux) message(code byte, msg string) error {
+	return m.frame(code, []byte(msg))
+}
+
+func (m *rsyncMux) frame(code byte, p []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := make([]byte, rsyncHeaderSize, rsyncHeaderSize+len(p))
+	binary.LittleEndian.PutUint32(b, uint32(rsyncMplexBase+code)<<24|uint32(len(p)))
+	_, err := m.w.Write(append(b, p...))
+	return err
+}
@@ -0,0 +1,440 @@
+package sftp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Minenetpro/pelican-wings/internal/ufs"
+	"github.com/Minenetpro/pelican-wings/server"
+)
+
+// scp serves the legacy SCP protocol, which is used by "scp -O" and by clients
+// that do not support SFTP. The client runs "scp -t <target>" to upload files
+// and "scp -f <paths>" to download them, and the files are then transferred
+// over the channel using a line based protocol. Files are read and written
+// with the same checks as the SFTP handler.
+type scp struct {
+	h         *Handler
+	channel   ssh.Channel
+	r         *bufio.Reader
+	recursive bool
+	preserve  bool
+	errors    int
+}
+
+func newScp(h *Handler, channel ssh.Channel) *scp {
+	return &scp{h: h, channel: channel, r: bufio.NewReader(channel)}
+}
+
+// run runs the scp command sent by the client and returns the exit status for
+// the command.
+func (s *scp) run(args []string) uint32 {
+	var sink, source, dir bool
+	var paths []string
+	for i := 1; i < len(args); i++ {
+		if args[i] == "--" {
+			paths = append(paths, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(args[i], "-") || args[i] == "-" {
+			paths = append(paths, args[i])
+			continue
+		}
+		for _, f := range args[i][1:] {
+			switch f {
+			case 't':
+				sink = true
+			case 'f':
+				source = true
+			case 'r':
+				s.recursive = true
+			case 'p':
+				s.preserve = true
+			case 'd':
+				dir = true
+			case 'v':
+			default:
+				s.fatal(fmt.Sprintf("unknown option -%c", f))
+				return 1
+			}
+		}
+	}
+	for i, p := range paths {
+		paths[i] = path.Join("/", p)
+	}
+
+	var err error
+	switch {
+	case sink && !source && len(paths) == 1:
+		err = s.sink(paths[0], dir)
+	case source && !sink && len(paths) > 0:
+		err = s.source(paths)
+	default:
+		s.fatal("either -t with a single target or -f must be given")
+		return 1
+	}
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.h.logger.WithField("error", err).Warn("scp: transfer failed")
+		}
+		return 1
+	}
+	if s.errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// sink receives files from the client. The target is either a directory that
+// everything is received into, or the path to write a single file to.
+func (s *scp) sink(target string, mustBeDir bool) error {
+	st, err := s.h.fs.Stat(target)
+	isDir := err == nil && st.IsDir()
+	if mustBeDir && !isDir {
+		s.fatal(target + ": not a directory")
+		return nil
+	}
+	return s.receive(target, isDir)
+}
+
+// receive handles the messages sent by the client for a single directory level,
+// returning once the client ends the directory or the transfer.
+func (s *scp) receive(target string, isDir bool) error {
+	s.ack()
+	var mtime time.Time
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) && line == "" {
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			s.fatal("protocol error: empty line")
+			return nil
+		}
+
+		switch line[0] {
+		case '\x01', '\x02':
+			// An error from the client, which has already been shown to the user.
+			if line[0] == '\x02' {
+				return nil
+			}
+			continue
+		case 'E':
+			s.ack()
+			return nil
+		case 'T':
+			var m, ma, a, aa int64
+			if _, err := fmt.Sscanf(line, "T%d %d %d %d", &m, &ma, &a, &aa); err != nil {
+				s.fatal("protocol error: invalid times")
+				return nil
+			}
+			mtime = time.Unix(m, 0)
+			s.ack()
+			continue
+		case 'C', 'D':
+		default:
+			s.fatal(fmt.Sprintf("protocol error: unexpected <%q>", line[0]))
+			return nil
+		}
+
+		mode, size, name, err := parseScpHeader(line)
+		if err != nil {
+			s.fatal("protocol error: " + err.Error())
+			return nil
+		}
+		p := target
+		if isDir {
+			p = path.Join(target, name)
+		}
+		if line[0] == 'D' {
+			if !s.recursive {
+				s.fatal("received directory without -r")
+				return nil
+			}
+			if err := s.h.mkdir(p); err != nil {
+				s.error(p, err)
+				continue
+			}
+			if err := s.receive(p, true); err != nil {
+				return err
+			}
+			if s.preserve && !mtime.IsZero() {
+				_ = s.h.fs.Chtimes(p, mtime, mtime)
+			}
+		} else if err := s.receiveFile(p, mode, size, mtime); err != nil {
+			return err
+		}
+		mtime = time.Time{}
+	}
+}
+
+// receiveFile receives the contents of a single file. If the file cannot be
+// written the client is told before it sends anything, but if writing fails
+// part of the way through the rest of the file still has to be read.
+func (s *scp) receiveFile(p string, mode os.FileMode, size int64, mtime time.Time) error {
+	exists, err := s.h.canWrite(p)
+	if err == nil && !s.h.fs.HasSpaceAvailable(true) {
+		err = ErrSSHQuotaExceeded
+	}
+	var f *ufs.QuotaWriter
+	if err == nil {
+		f, err = s.h.fs.TouchWriter(p, ufs.O_RDWR|ufs.O_TRUNC, 0o644)
+	}
+	if err != nil {
+		s.error(p, err)
+		return nil
+	}
+	defer f.Close()
+	event := server.ActivitySftpWrite
+	if !exists {
+		event = server.ActivitySftpCreate
+	}
+	s.h.events.MustLog(event, FileAction{Entity: p})
+
+	s.ack()
+	w := &stickyErrWriter{w: &sessionWriter{w: f, session: s.h.session}}
+	if _, err := io.CopyN(w, s.r, size); err != nil {
+		return err
+	}
+	if err := s.response(); err != nil {
+		return err
+	}
+
+	_ = s.h.fs.Chown(p)
+	if s.preserve {
+		if mode.Perm() != 0 {
+			_ = s.h.fs.Chmod(p, mode.Perm())
+		}
+		if !mtime.IsZero() {
+			_ = s.h.fs.Chtimes(p, mtime, mtime)
+		}
+	}
+	if w.err != nil {
+		if errors.Is(w.err, ufs.ErrQuotaExceeded) {
+			w.err = ErrSSHQuotaExceeded
+		}
+		s.error(p, w.err)
+		return nil
+	}
+	s.ack()
+	return nil
+}
+
+// source sends the files at the given paths to the client.
+func (s *scp) source(paths []string) error {
+	if err := s.response(); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		st, err := s.h.fs.Stat(p)
+		if err != nil || !s.h.can(PermissionFileRead) || !s.h.rules.Visible(p) {
+			s.error(p, os.ErrNotExist)
+			continue
+		}
+		if st.IsDir() {
+			if !s.recursive {
+				s.error(p, errors.New("not a regular file"))
+				continue
+			}
+			err = s.sendDir(p, st.ModTime())
+		} else {
+			err = s.sendFile(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendDir sends a directory and everything in it that the user can see.
+func (s *scp) sendDir(p string, mtime time.Time) error {
+	if err := s.h.fs.IsIgnored(p); err != nil {
+		s.error(p, err)
+		return nil
+	}
+	entries, err := s.h.fs.ReadDirStat(p)
+	if err != nil {
+		s.error(p, err)
+		return nil
+	}
+	if s.preserve {
+		if ok, err := s.send(fmt.Sprintf("T%d 0 %d 0\n", mtime.Unix(), mtime.Unix())); !ok {
+			return err
+		}
+	}
+	if ok, err := s.send(fmt.Sprintf("D%04o 0 %s\n", 0o755, path.Base(p))); !ok {
+		return err
+	}
+	for _, e := range entries {
+		child := path.Join(p, e.Name())
+		if !s.h.rules.Visible(child) {
+			continue
+		}
+		switch {
+		case e.IsDir():
+			err = s.sendDir(child, e.ModTime())
+		case e.Mode().IsRegular():
+			err = s.sendFile(child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.send("E\n")
+	return err
+}
+
+// sendFile sends the contents of a single file. If reading the file fails part
+// of the way through the rest of it is padded out so that the client stays in
+// sync, and the client is then told that the file failed.
+func (s *scp) sendFile(p string) error {
+	if err := s.h.canRead(p); err != nil {
+		s.error(p, err)
+		return nil
+	}
+	f, st, err := s.h.fs.File(p)
+	if err != nil {
+		s.error(p, err)
+		return nil
+	}
+	defer f.Close()
+
+	if s.preserve {
+		mtime := st.ModTime().Unix()
+		if ok, err := s.send(fmt.Sprintf("T%d 0 %d 0\n", mtime, mtime)); !ok {
+			return err
+		}
+	}
+	if ok, err := s.send(fmt.Sprintf("C%04o %d %s\n", st.Mode().Perm(), st.Size(), path.Base(p))); !ok {
+		return err
+	}
+	n, rerr := io.CopyN(s.channel, &sessionReader{r: f, session: s.h.session}, st.Size())
+	if rerr != nil {
+		if _, err := io.CopyN(s.channel, zeroReader{}, st.Size()-n); err != nil {
+			return err
+		}
+		s.error(p, rerr)
+	} else {
+		s.ack()
+	}
+	return s.response()
+}
+
+// send sends a message to the client and waits for its response. The message
+// was accepted if true is returned, otherwise an error is only returned if the
+// transfer cannot continue.
+func (s *scp) send(msg string) (bool, error) {
+	if _, err := s.channel.Write([]byte(msg)); err != nil {
+		return false, err
+	}
+	if err := s.response(); err != nil {
+		if errors.Is(err, errScpRejected) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+var errScpRejected = errors.Sentinel("scp: rejected by client")
+
+// response reads the response of the client to the last message. The client
+// sends a single zero byte on success, or an error that it has already shown
+// to the user.
+func (s *scp) response() error {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		if _, err := s.r.ReadString('\n'); err != nil {
+			return err
+		}
+		if b == 2 {
+			return io.EOF
+		}
+		return errScpRejected
+	default:
+		return errors.New("scp: protocol error: invalid response")
+	}
+}
+
+func (s *scp) ack() {
+	_, _ = s.channel.Write([]byte{0})
+}
+
+// error sends an error for a single file to the client, after which the
+// transfer continues with the next file.
+func (s *scp) error(p string, err error) {
+	s.errors++
+	if errors.Is(err, os.ErrNotExist) {
+		err = errors.New("no such file or directory")
+	}
+	_, _ = s.channel.Write([]byte("\x01scp: " + p + ": " + err.Error() + "\n"))
+}
+
+// fatal sends an error to the client that ends the transfer.
+func (s *scp) fatal(msg string) {
+	s.errors++
+	_, _ = s.channel.Write([]byte("\x02scp: " + msg + "\n"))
+}
+
+// parseScpHeader parses the "C" and "D" lines that are sent before each file
+// and directory, in the form "C0644 <size> <name>".
+func parseScpHeader(line string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(line[1:], " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", errors.New("invalid header")
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", errors.New("invalid mode")
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", errors.New("invalid size")
+	}
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", errors.New("invalid name")
+	}
+	return os.FileMode(mode), size, name, nil
+}
+
+// stickyErrWriter stops writing after the first error but continues to accept
+// data, so that the rest of a file can still be read from the client.
+type stickyErrWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *stickyErrWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+	return len(p), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
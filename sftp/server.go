@@ -2,25 +2,26 @@ package sftp
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/x509"
-	"encoding/pem"
 	"io"
 	"net"
-	"os"
-	"path"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"emperror.dev/errors"
 	"github.com/apex/log"
+	"github.com/goccy/go-json"
 	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/lockout"
+	"github.com/Minenetpro/pelican-wings/internal/models"
 	"github.com/Minenetpro/pelican-wings/remote"
+	"github.com/Minenetpro/pelican-wings/router/websocket"
 	"github.com/Minenetpro/pelican-wings/server"
 )
 
@@ -35,38 +36,221 @@ type SFTPServer struct {
 	BasePath string
 	ReadOnly bool
 	Listen   string
+
+	// The host keys presented to clients, which are replaced when the keys are
+	// reloaded or rotated. Listeners with their own host key present the key with
+	// the same path in listenerSigners instead.
+	signers         atomic.Pointer[[]ssh.Signer]
+	listenerSigners atomic.Pointer[map[string]ssh.Signer]
+
+	mu        sync.Mutex
+	keys      []HostKey
+	listeners map[string]*listener
 }
 
+// listener is an additional address the SFTP server listens on, dedicated to a
+// single server.
+type listener struct {
+	net.Listener
+	server  string
+	hostKey string
+}
+
+// current is the running SFTP server, which is reloaded when the configuration
+// is updated through the API.
+var current atomic.Pointer[SFTPServer]
+
+// ErrNotRunning is returned when the SFTP server is reloaded before it has
+// started.
+var ErrNotRunning = errors.Sentinel("sftp: server is not running")
+
 func New(m *server.Manager) *SFTPServer {
 	cfg := config.Get().System
 	return &SFTPServer{
-		manager:  m,
-		BasePath: cfg.Data,
-		ReadOnly: cfg.Sftp.ReadOnly,
-		Listen:   cfg.Sftp.Address + ":" + strconv.Itoa(cfg.Sftp.Port),
+		manager:   m,
+		BasePath:  cfg.Data,
+		ReadOnly:  cfg.Sftp.ReadOnly,
+		Listen:    cfg.Sftp.Address + ":" + strconv.Itoa(cfg.Sftp.Port),
+		listeners: make(map[string]*listener),
 	}
 }
 
 // Run starts the SFTP server and add a persistent listener to handle inbound
-// SFTP connections. This will automatically generate an ED25519 key if one does
-// not already exist on the system for host key verification purposes.
+// SFTP connections. This will automatically generate any configured host key
+// that does not already exist on the system for host key verification purposes.
+// The additional listeners for individual servers are started alongside it.
 func (c *SFTPServer) Run() error {
-	if _, err := os.Stat(c.PrivateKeyPath()); os.IsNotExist(err) {
-		if err := c.generateED25519PrivateKey(); err != nil {
-			return err
+	if err := c.reloadHostKeys(); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return err
+	}
+	current.Store(c)
+	c.reconcileListeners()
+
+	fields := log.Fields{"listen": c.Listen}
+	for _, k := range c.HostKeys() {
+		fields["public_key_"+k.Type] = k.PublicKey
+	}
+	log.WithFields(fields).Info("sftp server listening for connections")
+
+	c.serve(l, "", "")
+	return nil
+}
+
+// Reload reloads the host keys of the running SFTP server from the disk, and
+// starts and stops the additional listeners to match the configuration. The
+// main listener is not changed until Wings is restarted.
+func Reload() error {
+	c := current.Load()
+	if c == nil {
+		return ErrNotRunning
+	}
+	if err := c.reloadHostKeys(); err != nil {
+		return err
+	}
+	c.reconcileListeners()
+	return nil
+}
+
+// HostKeys returns the host keys of the running SFTP server.
+func HostKeys() ([]HostKey, error) {
+	c := current.Load()
+	if c == nil {
+		return nil, ErrNotRunning
+	}
+	return c.HostKeys(), nil
+}
+
+// RotateHostKeys replaces the host keys of the given types with newly generated
+// keys, or every configured host key if no types are given. Connections that are
+// already open are not affected.
+func RotateHostKeys(types []string) ([]HostKey, error) {
+	c := current.Load()
+	if c == nil {
+		return nil, ErrNotRunning
+	}
+	if len(types) == 0 {
+		types = config.Get().System.Sftp.HostKeys
+	}
+	for _, t := range types {
+		if !slices.Contains(config.Get().System.Sftp.HostKeys, t) {
+			return nil, errors.WithMessage(ErrUnknownHostKeyType, t)
+		}
+	}
+	for _, t := range types {
+		if err := c.generateHostKey(t); err != nil {
+			return nil, err
 		}
-	} else if err != nil {
-		return errors.Wrap(err, "sftp: could not stat private key file")
 	}
-	pb, err := os.ReadFile(c.PrivateKeyPath())
+	if err := c.reloadHostKeys(); err != nil {
+		return nil, err
+	}
+	return c.HostKeys(), nil
+}
+
+// HostKeys returns the host keys currently presented to clients.
+func (c *SFTPServer) HostKeys() []HostKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.keys)
+}
+
+func (c *SFTPServer) reloadHostKeys() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cfg := config.Get().System.Sftp
+	signers, keys, err := c.loadHostKeys(cfg.HostKeys)
 	if err != nil {
-		return errors.Wrap(err, "sftp: could not read private key file")
+		return err
 	}
-	private, err := ssh.ParsePrivateKey(pb)
+	listenerSigners, err := loadListenerHostKeys(cfg.Listeners)
 	if err != nil {
 		return err
 	}
+	c.signers.Store(&signers)
+	c.listenerSigners.Store(&listenerSigners)
+	c.keys = keys
+	return nil
+}
+
+// reconcileListeners starts a listener for each additional address in the
+// configuration that is not already being listened on, and closes those that
+// have been removed. An address that cannot be listened on is logged rather
+// than stopping the others from starting.
+func (c *SFTPServer) reconcileListeners() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	want := make(map[string]config.SftpListener)
+	for _, l := range config.Get().System.Sftp.Listeners {
+		addr := net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+		if len(l.Server) < 8 {
+			log.WithField("listen", addr).Warn("sftp: ignoring listener without a valid server uuid")
+			continue
+		}
+		want[addr] = l
+	}
+
+	for addr, l := range c.listeners {
+		if w, ok := want[addr]; !ok || w.Server != l.server || w.HostKey != l.hostKey {
+			log.WithField("listen", addr).WithField("server", l.server).Info("sftp: closing server listener")
+			_ = l.Close()
+			delete(c.listeners, addr)
+		}
+	}
+	for addr, w := range want {
+		if _, ok := c.listeners[addr]; ok {
+			continue
+		}
+		nl, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.WithField("listen", addr).WithField("server", w.Server).WithField("error", err).Error("sftp: failed to start server listener")
+			continue
+		}
+		l := &listener{Listener: nl, server: w.Server, hostKey: w.HostKey}
+		c.listeners[addr] = l
+		log.WithField("listen", addr).WithField("server", w.Server).Info("sftp: listening for connections to server")
+		go c.serve(l, w.Server, w.HostKey)
+	}
+}
+
+// serve accepts connections on a listener until it is closed. Connections on a
+// listener dedicated to a server can only log in to that server, and are
+// presented the host key at hostKey if it is set.
+func (c *SFTPServer) serve(l net.Listener, uuid string, hostKey string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		// Addresses that are denied or locked out are dropped before the
+		// handshake, so they cost as little as possible.
+		if !lockout.Connect(conn.RemoteAddr().String()) {
+			log.WithField("ip", conn.RemoteAddr().String()).Debug("sftp: refusing connection from denied or locked out address")
+			_ = conn.Close()
+			continue
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if err := c.AcceptInbound(conn, c.serverConfig(uuid, hostKey)); err != nil {
+				log.WithField("error", err).WithField("ip", conn.RemoteAddr().String()).Error("sftp: failed to accept inbound connection")
+			}
+		}(conn)
+	}
+}
 
+// serverConfig returns the SSH configuration for a new connection, using the
+// host keys at the time it was made. If uuid is set every login is made to that
+// server, and if hostKey is set the key loaded from that path is presented
+// instead of the host keys of the main listener.
+func (c *SFTPServer) serverConfig(uuid string, hostKey string) *ssh.ServerConfig {
 	conf := &ssh.ServerConfig{
 		Config: ssh.Config{
 			KeyExchanges: []string{
@@ -86,32 +270,37 @@ func (c *SFTPServer) Run() error {
 		NoClientAuth: false,
 		MaxAuthTries: 6,
 		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
-			return c.makeCredentialsRequest(conn, remote.SftpAuthPassword, string(password))
+			return c.makeCredentialsRequest(conn, uuid, remote.SftpAuthPassword, string(password))
 		},
 		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-			return c.makeCredentialsRequest(conn, remote.SftpAuthPublicKey, string(ssh.MarshalAuthorizedKey(key)))
+			return c.makeCredentialsRequest(conn, uuid, remote.SftpAuthPublicKey, string(ssh.MarshalAuthorizedKey(key)))
 		},
 	}
-	conf.AddHostKey(private)
-
-	listener, err := net.Listen("tcp", c.Listen)
-	if err != nil {
-		return err
+	if hostKey != "" {
+		if signers := c.listenerSigners.Load(); signers != nil {
+			if s, ok := (*signers)[hostKey]; ok {
+				conf.AddHostKey(s)
+			}
+		}
+		return conf
 	}
-
-	public := string(ssh.MarshalAuthorizedKey(private.PublicKey()))
-	log.WithField("listen", c.Listen).WithField("public_key", strings.Trim(public, "\n")).Info("sftp server listening for connections")
-
-	for {
-		if conn, _ := listener.Accept(); conn != nil {
-			go func(conn net.Conn) {
-				defer conn.Close()
-				if err := c.AcceptInbound(conn, conf); err != nil {
-					log.WithField("error", err).WithField("ip", conn.RemoteAddr().String()).Error("sftp: failed to accept inbound connection")
-				}
-			}(conn)
+	if signers := c.signers.Load(); signers != nil {
+		for _, s := range *signers {
+			conf.AddHostKey(s)
 		}
 	}
+	return conf
+}
+
+// serverUsername returns the username that is sent to the Panel for a login on
+// a listener dedicated to a server. The server suffix is added to the username
+// unless the user already included it.
+func serverUsername(username, uuid string) string {
+	suffix := "." + uuid[:8]
+	if len(username) > len(suffix) && strings.EqualFold(username[len(username)-len(suffix):], suffix) {
+		return username
+	}
+	return username + suffix
 }
 
 // AcceptInbound handles an inbound connection to the instance and determines if we should
@@ -125,6 +314,9 @@ func (c *SFTPServer) AcceptInbound(conn net.Conn, config *ssh.ServerConfig) erro
 	defer sconn.Close()
 	go ssh.DiscardRequests(reqs)
 
+	// Commands sent from every shell of the connection share a rate limit, in the
+	// same way as the console of a websocket connection.
+	limiter := websocket.NewLimiter()
 	for ch := range chans {
 		// If not a session channel we just move on because it's not something we
 		// know how to handle at this point.
@@ -133,45 +325,142 @@ func (c *SFTPServer) AcceptInbound(conn net.Conn, config *ssh.ServerConfig) erro
 			continue
 		}
 
+		srv, ok := c.manager.Get(sconn.Permissions.Extensions["uuid"])
+		if !ok {
+			_ = ch.Reject(ssh.ConnectionFailed, "server not found")
+			continue
+		}
+		// Sessions are registered before the channel is accepted so that a
+		// client over the session limit is told why it was refused.
+		session, err := srv.NewSftpSession(sconn.Permissions.Extensions["user"], sconn.User(), sconn.RemoteAddr().String(), string(sconn.ClientVersion()))
+		if err != nil {
+			if errors.Is(err, server.ErrTooManySftpSessions) {
+				_ = ch.Reject(ssh.ResourceShortage, "too many active sftp sessions for this server")
+				continue
+			}
+			return err
+		}
+
 		channel, requests, err := ch.Accept()
 		if err != nil {
+			srv.RemoveSftpSession(session)
 			continue
 		}
 
-		go func(in <-chan *ssh.Request) {
-			for req := range in {
-				// Channels have a type that is dependent on the protocol. For SFTP
-				// this is "subsystem" with a payload that (should) be "sftp". Discard
-				// anything else we receive ("pty", "shell", etc)
-				_ = req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
-			}
-		}(requests)
+		if err := c.serveChannel(sconn, srv, session, channel, requests, limiter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serveChannel waits for the client to request the SFTP subsystem, a shell, or a
+// command on a session channel and then serves it. The session is removed from
+// the server once the channel is closed.
+func (c *SFTPServer) serveChannel(conn *ssh.ServerConn, srv *server.Server, session *server.SftpSession, channel ssh.Channel, requests <-chan *ssh.Request, limiter *websocket.LimiterBucket) error {
+	defer srv.RemoveSftpSession(session)
 
-		if srv, ok := c.manager.Get(sconn.Permissions.Extensions["uuid"]); ok {
-			if err := c.Handle(sconn, srv, channel); err != nil {
-				return err
+	var pty *ptyRequest
+	for req := range requests {
+		switch req.Type {
+		case "subsystem":
+			var r subsystemRequest
+			ok := ssh.Unmarshal(req.Payload, &r) == nil && r.Name == "sftp"
+			_ = req.Reply(ok, nil)
+			if ok {
+				go ssh.DiscardRequests(requests)
+				return c.Handle(conn, srv, session, channel)
+			}
+		case "pty-req":
+			var r ptyRequest
+			ok := (canShell(conn, srv) || canExec(conn, srv)) && ssh.Unmarshal(req.Payload, &r) == nil
+			if ok {
+				pty = &r
+			}
+			_ = req.Reply(ok, nil)
+		case "shell":
+			if !canShell(conn, srv) {
+				_ = req.Reply(false, nil)
+				continue
 			}
+			_ = req.Reply(true, nil)
+			newShell(conn, srv, session, channel, pty, limiter).console(requests)
+			return nil
+		case "exec":
+			var r execRequest
+			if ssh.Unmarshal(req.Payload, &r) != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			// SCP and rsync transfers are served directly rather than being run
+			// in the container, and are always available.
+			if args, err := splitCommand(r.Command); err == nil && len(args) > 0 && (args[0] == "scp" || args[0] == "rsync") {
+				_ = req.Reply(true, nil)
+				go ssh.DiscardRequests(requests)
+				return c.transfer(conn, srv, session, channel, args)
+			}
+			if !canExec(conn, srv) {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			newShell(conn, srv, session, channel, pty, limiter).exec(r.Command, requests)
+			return nil
+		default:
+			_ = req.Reply(false, nil)
 		}
 	}
 	return nil
 }
 
+// transfer serves an scp or rsync command, which read and write files using the
+// same handler as the SFTP subsystem.
+func (c *SFTPServer) transfer(conn *ssh.ServerConn, srv *server.Server, session *server.SftpSession, channel ssh.Channel, args []string) error {
+	handler, err := NewHandler(conn, srv, session)
+	if err != nil {
+		return errors.WithStackIf(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-session.Context().Done():
+			srv.Log().WithField("user", conn.User()).Warn("sftp: terminating active session")
+			_ = channel.Close()
+		case <-done:
+		}
+	}()
+
+	var status uint32
+	if args[0] == "scp" {
+		status = newScp(handler, channel).run(args)
+	} else {
+		status = newRsyncReceiver(handler, channel).run(args)
+	}
+	exitChannel(channel, status)
+	return nil
+}
+
 // Handle spins up a SFTP server instance for the authenticated user's server allowing
 // them access to the underlying filesystem.
-func (c *SFTPServer) Handle(conn *ssh.ServerConn, srv *server.Server, channel ssh.Channel) error {
-	handler, err := NewHandler(conn, srv)
+func (c *SFTPServer) Handle(conn *ssh.ServerConn, srv *server.Server, session *server.SftpSession, channel ssh.Channel) error {
+	handler, err := NewHandler(conn, srv, session)
 	if err != nil {
 		return errors.WithStackIf(err)
 	}
 
-	ctx := srv.Sftp().Context(handler.User())
+	ctx := session.Context()
 	rs := sftp.NewRequestServer(channel, handler.Handlers())
 
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
 		select {
 		case <-ctx.Done():
 			srv.Log().WithField("user", conn.User()).Warn("sftp: terminating active session")
 			_ = rs.Close()
+		case <-done:
 		}
 	}()
 
@@ -182,36 +471,17 @@ func (c *SFTPServer) Handle(conn *ssh.ServerConn, srv *server.Server, channel ss
 	return nil
 }
 
-// Generates a new ED25519 private key that is used for host authentication when
-// a user connects to the SFTP server.
-func (c *SFTPServer) generateED25519PrivateKey() error {
-	_, priv, err := ed25519.GenerateKey(rand.Reader)
-	if err != nil {
-		return errors.Wrap(err, "sftp: failed to generate ED25519 private key")
-	}
-	if err := os.MkdirAll(path.Dir(c.PrivateKeyPath()), 0o755); err != nil {
-		return errors.Wrap(err, "sftp: could not create internal sftp data directory")
-	}
-	o, err := os.OpenFile(c.PrivateKeyPath(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	defer o.Close()
-
-	b, err := x509.MarshalPKCS8PrivateKey(priv)
-	if err != nil {
-		return errors.Wrap(err, "sftp: failed to marshal private key into bytes")
+// makeCredentialsRequest validates the credentials for a login with the Panel. If
+// uuid is set the login was made on a listener dedicated to that server, and is
+// rejected for any other server.
+func (c *SFTPServer) makeCredentialsRequest(conn ssh.ConnMetadata, uuid string, t remote.SftpAuthRequestType, p string) (*ssh.Permissions, error) {
+	username := conn.User()
+	if uuid != "" {
+		username = serverUsername(username, uuid)
 	}
-	if err := pem.Encode(o, &pem.Block{Type: "PRIVATE KEY", Bytes: b}); err != nil {
-		return errors.Wrap(err, "sftp: failed to write ED25519 private key to disk")
-	}
-	return nil
-}
-
-func (c *SFTPServer) makeCredentialsRequest(conn ssh.ConnMetadata, t remote.SftpAuthRequestType, p string) (*ssh.Permissions, error) {
 	request := remote.SftpAuthRequest{
 		Type:          t,
-		User:          conn.User(),
+		User:          username,
 		Pass:          p,
 		IP:            conn.RemoteAddr().String(),
 		SessionID:     conn.SessionID(),
@@ -221,8 +491,15 @@ func (c *SFTPServer) makeCredentialsRequest(conn ssh.ConnMetadata, t remote.Sftp
 	logger := log.WithFields(log.Fields{"subsystem": "sftp", "method": request.Type, "username": request.User, "ip": request.IP})
 	logger.Debug("validating credentials for SFTP connection")
 
+	if err := lockout.Check(request.IP, request.User); err != nil {
+		logger.Warn("failed to validate user credentials (too many failed attempts)")
+		return nil, err
+	}
+
 	if !validUsernameRegexp.MatchString(request.User) {
 		logger.Warn("failed to validate user credentials (invalid format)")
+		// The username is not tracked since it could be anything at all.
+		lockout.Fail(request.IP, "")
 		return nil, &remote.SftpInvalidCredentialsError{}
 	}
 
@@ -231,30 +508,87 @@ func (c *SFTPServer) makeCredentialsRequest(conn ssh.ConnMetadata, t remote.Sftp
 		return nil, &remote.SftpKeyOnlyError{}
 	}
 
+	cache := t == remote.SftpAuthPublicKey && config.Get().System.Sftp.CredentialCache.Enabled
 	resp, err := c.manager.Client().ValidateSftpCredentials(context.Background(), request)
 	if err != nil {
 		if _, ok := err.(*remote.SftpInvalidCredentialsError); ok {
 			logger.Warn("failed to validate user credentials (invalid username or password)")
-		} else {
-			logger.WithField("error", err).Error("encountered an error while trying to validate user credentials")
+			// Clients commonly offer several public keys before finding the
+			// right one, so only failed passwords count towards a lockout.
+			if t == remote.SftpAuthPassword {
+				c.recordFailedLogin(request)
+			}
+			// A key the Panel rejects must not be usable while the Panel is
+			// unreachable either.
+			if cache {
+				if err := forgetCredential(request); err != nil {
+					logger.WithField("error", err).Warn("failed to remove cached credentials")
+				}
+			}
+			return nil, err
+		}
+		logger.WithField("error", err).Error("encountered an error while trying to validate user credentials")
+		if !cache {
+			return nil, err
+		}
+		cached, cerr := c.cachedCredentials(request)
+		if cerr != nil {
+			logger.WithField("error", cerr).Error("failed to look up cached credentials")
+		}
+		if cached == nil {
+			return nil, err
+		}
+		logger.WithField("server", cached.Server).Warn("Panel is unreachable, using cached credentials for SFTP connection")
+		resp = *cached
+	} else if cache {
+		if err := cacheCredentials(request, resp); err != nil {
+			logger.WithField("error", err).Warn("failed to cache credentials")
 		}
-		return nil, err
+	}
+
+	if uuid != "" && resp.Server != uuid {
+		logger.WithField("server", resp.Server).Warn("failed to validate user credentials (server does not match the listener)")
+		return nil, &remote.SftpInvalidCredentialsError{}
 	}
 
 	logger.WithField("server", resp.Server).Debug("credentials validated and matched to server instance")
+	lockout.Succeed(request.User)
+	rules, err := json.Marshal(resp.PathRules)
+	if err != nil {
+		return nil, errors.Wrap(err, "sftp: failed to marshal path rules")
+	}
 	permissions := ssh.Permissions{
 		Extensions: map[string]string{
 			"ip":          conn.RemoteAddr().String(),
 			"uuid":        resp.Server,
 			"user":        resp.User,
 			"permissions": strings.Join(resp.Permissions, ","),
+			"path_rules":  string(rules),
 		},
 	}
 
 	return &permissions, nil
 }
 
-// PrivateKeyPath returns the path the host private key for this server instance.
-func (c *SFTPServer) PrivateKeyPath() string {
-	return path.Join(c.BasePath, ".sftp/id_ed25519")
+// recordFailedLogin counts a failed login towards locking out the IP address
+// and username, and records it in the activity log of the server the username
+// belongs to so that it can be shown in the Panel.
+func (c *SFTPServer) recordFailedLogin(request remote.SftpAuthRequest) {
+	lockout.Fail(request.IP, request.User)
+
+	match := validUsernameRegexp.FindStringSubmatch(request.User)
+	if match == nil {
+		return
+	}
+	prefix := strings.ToLower(match[2])
+	s := c.manager.Find(func(s *server.Server) bool {
+		return strings.HasPrefix(s.ID(), prefix)
+	})
+	if s == nil {
+		return
+	}
+	s.SaveActivity(s.NewRequestActivity("", request.IP), server.ActivitySftpLoginFailed, models.ActivityMeta{
+		"username": request.User,
+		"method":   string(request.Type),
+	})
 }
@@ -0,0 +1,333 @@
+package sftp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/environment"
+	"github.com/Minenetpro/pelican-wings/internal/acl"
+	"github.com/Minenetpro/pelican-wings/internal/models"
+	"github.com/Minenetpro/pelican-wings/router/websocket"
+	"github.com/Minenetpro/pelican-wings/server"
+	"github.com/Minenetpro/pelican-wings/system"
+)
+
+// PermissionSendCommand is required to use a shell or run commands over SSH,
+// it is the same permission used to send commands over the websocket.
+const PermissionSendCommand = "control.console"
+
+// Payloads of the SSH channel requests, see RFC 4254 section 6.
+type subsystemRequest struct {
+	Name string
+}
+
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+type windowChangeRequest struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type execRequest struct {
+	Command string
+}
+
+type exitStatusRequest struct {
+	Status uint32
+}
+
+// canShell returns whether the user is allowed to attach to the server console.
+func canShell(conn *ssh.ServerConn, srv *server.Server) bool {
+	return config.Get().System.Sftp.Shell.Enabled && canSendCommands(conn, srv)
+}
+
+// canExec returns whether the user is allowed to run commands in the server
+// container.
+func canExec(conn *ssh.ServerConn, srv *server.Server) bool {
+	return len(config.Get().System.Sftp.Shell.AllowedCommands) > 0 && canSendCommands(conn, srv)
+}
+
+// canSendCommands returns whether the user may send commands to the server.
+// Neither the console nor a command run in the container is restricted to the
+// paths the user has access to, so they are refused for users with path rules
+// and on nodes where SFTP is read-only.
+func canSendCommands(conn *ssh.ServerConn, srv *server.Server) bool {
+	if srv.IsSuspended() || config.Get().System.Sftp.ReadOnly || hasPathRules(conn) {
+		return false
+	}
+	for _, p := range strings.Split(conn.Permissions.Extensions["permissions"], ",") {
+		if p == PermissionSendCommand || p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPathRules returns whether the access of the user to the files of the server
+// is limited by path rules. Rules that cannot be parsed are treated as limiting
+// access, as the SFTP handler refuses the session in that case.
+func hasPathRules(conn *ssh.ServerConn) bool {
+	v := conn.Permissions.Extensions["path_rules"]
+	if v == "" {
+		return false
+	}
+	var rules acl.Rules
+	if err := json.Unmarshal([]byte(v), &rules); err != nil {
+		return true
+	}
+	return len(rules) > 0
+}
+
+// commandAllowed returns whether the arguments of a command match one of the
+// allowed commands. Every argument of the command must match, a "*" in an
+// allowed command matches any single argument other than an option.
+func commandAllowed(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	for _, allowed := range config.Get().System.Sftp.Shell.AllowedCommands {
+		pattern, err := splitCommand(allowed)
+		if err != nil || len(pattern) != len(args) {
+			continue
+		}
+		match := true
+		for i, p := range pattern {
+			if p == args[i] || (p == "*" && i > 0 && !strings.HasPrefix(args[i], "-")) {
+				continue
+			}
+			match = false
+			break
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// shell serves a "shell" or "exec" request on an SSH session channel.
+type shell struct {
+	server  *server.Server
+	session *server.SftpSession
+	channel ssh.Channel
+	pty     *ptyRequest
+	ra      server.RequestActivity
+	limiter *websocket.LimiterBucket
+}
+
+func newShell(conn *ssh.ServerConn, srv *server.Server, session *server.SftpSession, channel ssh.Channel, pty *ptyRequest, limiter *websocket.LimiterBucket) *shell {
+	return &shell{
+		server:  srv,
+		session: session,
+		channel: channel,
+		pty:     pty,
+		ra:      srv.NewRequestActivity(conn.Permissions.Extensions["user"], conn.RemoteAddr().String()),
+		limiter: limiter,
+	}
+}
+
+// console attaches the channel to the server console. The recent console
+// output is sent first, followed by every new line of output, and every line
+// the user enters is sent to the server as a command. If the client requested
+// a pty, line editing is handled by Wings since the client sends every key as
+// it is pressed.
+func (sh *shell) console(requests <-chan *ssh.Request) {
+	ctx, cancel := context.WithCancel(sh.session.Context())
+	defer cancel()
+
+	var out io.Writer = sh.channel
+	var readLine func() (string, error)
+	if sh.pty != nil {
+		t := term.NewTerminal(sh.channel, "> ")
+		_ = t.SetSize(int(sh.pty.Columns), int(sh.pty.Rows))
+		go sh.handleRequests(requests, func(r windowChangeRequest) {
+			_ = t.SetSize(int(r.Columns), int(r.Rows))
+		})
+		out = t
+		readLine = t.ReadLine
+	} else {
+		go sh.handleRequests(requests, nil)
+		scanner := bufio.NewScanner(sh.channel)
+		readLine = func() (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+	}
+
+	if lines, err := sh.server.Environment.Readlog(config.Get().System.WebsocketLogCount); err == nil {
+		for _, line := range lines {
+			_, _ = out.Write([]byte(line + "\n"))
+		}
+	}
+
+	logs := make(chan []byte, 64)
+	sh.server.Sink(system.LogSink).On(logs)
+	defer sh.server.Sink(system.LogSink).Off(logs)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case b, ok := <-logs:
+				if !ok {
+					return
+				}
+				_, _ = out.Write([]byte(string(b) + "\n"))
+			}
+		}
+	}()
+
+	// Closing the channel is the only way to stop a pending read when the
+	// session is terminated.
+	go func() {
+		<-ctx.Done()
+		_ = sh.channel.Close()
+	}()
+
+	for {
+		line, err := readLine()
+		if err != nil {
+			break
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := sh.sendCommand(line); err != nil {
+			_, _ = out.Write([]byte("error: " + err.Error() + "\n"))
+		}
+	}
+	sh.exit(0)
+}
+
+// sendCommand sends a command entered by the user to the server, and logs it
+// in the same way as a command sent over the websocket. Commands are subject to
+// the same rate limit as those sent over a websocket connection.
+func (sh *shell) sendCommand(line string) error {
+	if !sh.limiter.For(websocket.SendCommandEvent).Allow() {
+		return errors.New("too many commands, wait a moment before sending another")
+	}
+	if sh.server.Environment.State() == environment.ProcessOfflineState {
+		return errors.New("server is not running")
+	}
+	if err := sh.server.Environment.SendCommand(line); err != nil {
+		return err
+	}
+	sh.server.SaveActivity(sh.ra, server.ActivityConsoleCommand, models.ActivityMeta{
+		"command": line,
+	})
+	return nil
+}
+
+// exec runs a command inside the server container, if it matches one of the
+// allowed commands. The command is not passed through a shell.
+func (sh *shell) exec(command string, requests <-chan *ssh.Request) {
+	ctx := sh.session.Context()
+	args, err := splitCommand(command)
+	if err != nil || !commandAllowed(args) {
+		_, _ = sh.channel.Stderr().Write([]byte("command is not allowed\n"))
+		sh.exit(126)
+		return
+	}
+	executor, ok := sh.server.Environment.(environment.Executor)
+	if !ok {
+		_, _ = sh.channel.Stderr().Write([]byte("commands are not supported by this server\n"))
+		sh.exit(1)
+		return
+	}
+	if sh.server.Environment.State() == environment.ProcessOfflineState {
+		_, _ = sh.channel.Stderr().Write([]byte("server is not running\n"))
+		sh.exit(1)
+		return
+	}
+
+	opts := environment.ExecOptions{}
+	if sh.pty != nil {
+		opts = environment.ExecOptions{
+			Tty:    true,
+			Width:  uint(sh.pty.Columns),
+			Height: uint(sh.pty.Rows),
+			Env:    []string{"TERM=" + sh.pty.Term},
+		}
+	}
+	p, err := executor.Exec(ctx, args, opts)
+	if err != nil {
+		sh.server.Log().WithField("error", err).WithField("command", command).Error("sftp: failed to run command in container")
+		_, _ = sh.channel.Stderr().Write([]byte("failed to run command\n"))
+		sh.exit(1)
+		return
+	}
+	defer p.Close()
+	sh.server.SaveActivity(sh.ra, server.ActivityConsoleExec, models.ActivityMeta{
+		"command": command,
+	})
+
+	go sh.handleRequests(requests, func(r windowChangeRequest) {
+		_ = p.Resize(uint(r.Columns), uint(r.Rows))
+	})
+	go func() {
+		_, _ = io.Copy(p, sh.channel)
+		_ = p.CloseStdin()
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = p.Close()
+	}()
+
+	if err := p.Output(sh.channel, sh.channel.Stderr()); err != nil {
+		sh.server.Log().WithField("error", err).WithField("command", command).Warn("sftp: failed to read command output")
+	}
+	code, err := p.ExitCode(ctx)
+	if err != nil {
+		code = 1
+	}
+	sh.exit(uint32(code))
+}
+
+// handleRequests handles requests sent on the channel after the shell has been
+// started, the only one of which that is supported is resizing the pty.
+func (sh *shell) handleRequests(requests <-chan *ssh.Request, resize func(windowChangeRequest)) {
+	for req := range requests {
+		if req.Type == "window-change" && resize != nil {
+			var r windowChangeRequest
+			if err := ssh.Unmarshal(req.Payload, &r); err == nil {
+				resize(r)
+			}
+		}
+		if req.WantReply {
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+func (sh *shell) exit(status uint32) {
+	exitChannel(sh.channel, status)
+}
+
+// exitChannel sends the exit status of a command to the client and closes the
+// channel.
+func exitChannel(channel ssh.Channel, status uint32) {
+	_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(exitStatusRequest{Status: status}))
+	_ = channel.Close()
+}
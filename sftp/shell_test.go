@@ -0,0 +1,124 @@
+package sftp
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/router/websocket"
+	"github.com/Minenetpro/pelican-wings/server"
+)
+
+func TestShellPermissions(t *testing.T) {
+	g := Goblin(t)
+
+	conn := func(permissions string) *ssh.ServerConn {
+		return &ssh.ServerConn{Permissions: &ssh.Permissions{
+			Extensions: map[string]string{"permissions": permissions},
+		}}
+	}
+
+	g.Describe("Shell permissions", func() {
+		var s *server.Server
+
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{
+				AuthenticationToken: "abc",
+				System: config.SystemConfiguration{
+					Sftp: config.SftpConfiguration{
+						Shell: config.SftpShellConfiguration{Enabled: true},
+					},
+				},
+			})
+			s, _ = server.New(nil)
+		})
+
+		g.It("requires the console permission", func() {
+			g.Assert(canShell(conn("file.read,control.console"), s)).IsTrue()
+			g.Assert(canShell(conn("*"), s)).IsTrue()
+			g.Assert(canShell(conn("file.read,control.start"), s)).IsFalse()
+		})
+
+		g.It("only allows exec when commands are allowed", func() {
+			g.Assert(canExec(conn("control.console"), s)).IsFalse()
+
+			config.Update(func(c *config.Configuration) {
+				c.System.Sftp.Shell.AllowedCommands = []string{"ls"}
+			})
+			g.Assert(canExec(conn("control.console"), s)).IsTrue()
+		})
+
+		g.It("is not allowed for suspended servers", func() {
+			s.Config().SetSuspended(true)
+			g.Assert(canShell(conn("*"), s)).IsFalse()
+		})
+
+		g.It("is not allowed for users with path rules", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.Sftp.Shell.AllowedCommands = []string{"ls"}
+			})
+			c := conn("*")
+			c.Permissions.Extensions["path_rules"] = `[{"path":"/plugins","permissions":["file.read"]}]`
+			g.Assert(canShell(c, s)).IsFalse()
+			g.Assert(canExec(c, s)).IsFalse()
+
+			c.Permissions.Extensions["path_rules"] = "[]"
+			g.Assert(canShell(c, s)).IsTrue()
+			g.Assert(canExec(c, s)).IsTrue()
+
+			c.Permissions.Extensions["path_rules"] = "invalid"
+			g.Assert(canShell(c, s)).IsFalse()
+		})
+
+		g.It("is not allowed when SFTP is read-only", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.Sftp.ReadOnly = true
+				c.System.Sftp.Shell.AllowedCommands = []string{"ls"}
+			})
+			g.Assert(canShell(conn("*"), s)).IsFalse()
+			g.Assert(canExec(conn("*"), s)).IsFalse()
+		})
+
+		g.It("limits commands to the rate of the websocket console", func() {
+			limiter := websocket.NewLimiter()
+			for limiter.For(websocket.SendCommandEvent).Allow() {
+			}
+			sh := &shell{server: s, limiter: limiter}
+			err := sh.sendCommand("say hello")
+			g.Assert(err).IsNotNil()
+			g.Assert(strings.HasPrefix(err.Error(), "too many commands")).IsTrue()
+		})
+	})
+
+	g.Describe("Allowed commands", func() {
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{
+				AuthenticationToken: "abc",
+				System: config.SystemConfiguration{
+					Sftp: config.SftpConfiguration{
+						Shell: config.SftpShellConfiguration{AllowedCommands: []string{"ls -la", "cat *", "find . -name *"}},
+					},
+				},
+			})
+		})
+
+		g.It("matches every argument of the command", func() {
+			g.Assert(commandAllowed([]string{"ls", "-la"})).IsTrue()
+			g.Assert(commandAllowed([]string{"ls"})).IsFalse()
+			g.Assert(commandAllowed([]string{"ls", "-la", "logs"})).IsFalse()
+			g.Assert(commandAllowed([]string{"find", ".", "-name", "*.jar"})).IsTrue()
+			g.Assert(commandAllowed([]string{"find", ".", "-exec", "sh"})).IsFalse()
+			g.Assert(commandAllowed(nil)).IsFalse()
+		})
+
+		g.It("only matches arguments that are not options with a wildcard", func() {
+			g.Assert(commandAllowed([]string{"cat", "server.properties"})).IsTrue()
+			g.Assert(commandAllowed([]string{"cat", "-A"})).IsFalse()
+			g.Assert(commandAllowed([]string{"cat", "a", "b"})).IsFalse()
+			g.Assert(commandAllowed([]string{"*", "a"})).IsFalse()
+		})
+	})
+}
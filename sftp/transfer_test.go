@@ -0,0 +1,571 @@
+package sftp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	. "github.com/franela/goblin"
+	"github.com/goccy/go-json"
+
+	"github.com/Minenetpro/pelican-wings/config"
+	"github.com/Minenetpro/pelican-wings/internal/database"
+	"github.com/Minenetpro/pelican-wings/server"
+	"github.com/Minenetpro/pelican-wings/server/filesystem"
+)
+
+var databaseOnce sync.Once
+
+// initDatabase initializes the database for the tests in the package, which
+// can only be done once per process.
+func initDatabase(t *testing.T) {
+	databaseOnce.Do(func() {
+		if err := database.Initialize(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// testChannel is an SSH channel that is connected to a client in the test. The
+// pipes are buffered like an SSH channel, so both ends can write at once.
+type testChannel struct {
+	in     *os.File
+	out    *os.File
+	stderr bytes.Buffer
+}
+
+func (c *testChannel) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *testChannel) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *testChannel) CloseWrite() error           { return c.out.Close() }
+func (c *testChannel) Stderr() io.ReadWriter       { return &c.stderr }
+
+func (c *testChannel) Close() error {
+	_ = c.in.Close()
+	return c.out.Close()
+}
+
+func (c *testChannel) SendRequest(string, bool, []byte) (bool, error) {
+	return true, nil
+}
+
+// testClient is the client end of a testChannel.
+type testClient struct {
+	r *bufio.Reader
+	w *os.File
+}
+
+func newTestChannel(t *testing.T) (*testChannel, *testClient) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = inW.Close()
+		_ = outR.Close()
+	})
+	return &testChannel{in: inR, out: outW}, &testClient{r: bufio.NewReader(outR), w: inW}
+}
+
+func newTestHandler(t *testing.T, root string, permissions ...string) *Handler {
+	fs, err := filesystem.New(root, 0, []string{"*.secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := server.New(nil)
+	session, err := s.NewSftpSession("5a1e6f0c-8b2d-4e3a-9c7f-1d0b6a4e2f83", "alice", "127.0.0.1:52144", "SSH-2.0-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.RemoveSftpSession(session)
+	})
+	return &Handler{
+		server:      s,
+		fs:          fs,
+		permissions: permissions,
+		session:     session,
+		events:      &eventHandler{ip: "127.0.0.1:52144", user: "5a1e6f0c-8b2d-4e3a-9c7f-1d0b6a4e2f83", server: s.ID()},
+		logger:      log.WithField("subsystem", "sftp"),
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("splitCommand", func() {
+		g.It("splits a command like a shell", func() {
+			args, err := splitCommand(`rsync --server -vlogDtpre.iLsfxC . 'my plugins/' "a \"b\"" c\ d`)
+			g.Assert(err).IsNil()
+			g.Assert(args).Equal([]string{"rsync", "--server", "-vlogDtpre.iLsfxC", ".", "my plugins/", `a "b"`, "c d"})
+		})
+
+		g.It("returns an error for unterminated quotes", func() {
+			_, err := splitCommand(`scp -t 'plugins`)
+			g.Assert(err == nil).IsFalse()
+		})
+	})
+}
+
+func TestScp(t *testing.T) {
+	g := Goblin(t)
+
+	var root string
+	var h *Handler
+	run := func(args ...string) (*testClient, chan uint32) {
+		channel, client := newTestChannel(t)
+		status := make(chan uint32, 1)
+		go func() {
+			status <- newScp(h, channel).run(args)
+			_ = channel.Close()
+		}()
+		return client, status
+	}
+	expect := func(client *testClient, msg string) {
+		b := make([]byte, len(msg))
+		_, err := io.ReadFull(client.r, b)
+		g.Assert(err).IsNil()
+		g.Assert(string(b)).Equal(msg)
+	}
+
+	g.Describe("SCP", func() {
+		g.BeforeEach(func() {
+			root = t.TempDir()
+			config.Set(&config.Configuration{AuthenticationToken: "abc", System: config.SystemConfiguration{RootDirectory: root}})
+			initDatabase(t)
+			h = newTestHandler(t, root, "*")
+		})
+
+		g.It("receives files and directories", func() {
+			client, status := run("scp", "-r", "-t", "--", ".")
+			expect(client, "\x00")
+			_, _ = client.w.Write([]byte("C0644 5 server.properties\n"))
+			expect(client, "\x00")
+			_, _ = client.w.Write([]byte("hello\x00"))
+			expect(client, "\x00")
+			_, _ = client.w.Write([]byte("D0755 0 plugins\n"))
+			expect(client, "\x00")
+			_, _ = client.w.Write([]byte("C0644 3 a.jar\n"))
+			expect(client, "\x00")
+			_, _ = client.w.Write([]byte("abc\x00"))
+			expect(client, "\x00")
+			_, _ = client.w.Write([]byte("E\n"))
+			expect(client, "\x00")
+			_ = client.w.Close()
+			g.Assert(<-status).Equal(uint32(0))
+
+			b, _ := os.ReadFile(filepath.Join(root, "server.properties"))
+			g.Assert(string(b)).Equal("hello")
+			b, _ = os.ReadFile(filepath.Join(root, "plugins/a.jar"))
+			g.Assert(string(b)).Equal("abc")
+		})
+
+		g.It("rejects files the user cannot write", func() {
+			h.permissions = []string{"file.read"}
+			client, status := run("scp", "-t", "server.properties")
+			expect(client, "\x00")
+			_, _ = client.w.Write([]byte("C0644 5 server.properties\n"))
+			line, _ := client.r.ReadString('\n')
+			g.Assert(line[0]).Equal(byte(1))
+			_ = client.w.Close()
+			g.Assert(<-status).Equal(uint32(1))
+
+			_, err := os.Stat(filepath.Join(root, "server.properties"))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+
+		g.It("rejects files on the denylist", func() {
+			client, status := run("scp", "-t", "/")
+			expect(client, "\x00")
+			_, _ = client.w.Write([]byte("C0644 5 token.secret\n"))
+			line, _ := client.r.ReadString('\n')
+			g.Assert(line[0]).Equal(byte(1))
+			_ = client.w.Close()
+			g.Assert(<-status).Equal(uint32(1))
+		})
+
+		g.It("sends files", func() {
+			_ = os.WriteFile(filepath.Join(root, "server.properties"), []byte("hello"), 0o644)
+			client, status := run("scp", "-f", "server.properties")
+			_, _ = client.w.Write([]byte{0})
+			expect(client, "C0644 5 server.properties\n")
+			_, _ = client.w.Write([]byte{0})
+			expect(client, "hello\x00")
+			_, _ = client.w.Write([]byte{0})
+			g.Assert(<-status).Equal(uint32(0))
+		})
+	})
+}
+
+// testRsyncSender is a minimal rsync client, which sends files to the receiver
+// in the same way as "rsync -rt".
+type testRsyncSender struct {
+	client   *testClient
+	seed     int32
+	data     bytes.Buffer
+	messages []string
+	literal  int
+}
+
+func (s *testRsyncSender) writeInt(v int32) {
+	_ = binary.Write(s.client.w, binary.LittleEndian, v)
+}
+
+func (s *testRsyncSender) readRawInt() int32 {
+	var v int32
+	if err := binary.Read(s.client.r, binary.LittleEndian, &v); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// read reads from the multiplexed stream sent by the receiver, keeping any
+// messages that are sent along with the data.
+func (s *testRsyncSender) read(n int) []byte {
+	for s.data.Len() < n {
+		header := uint32(s.readRawInt())
+		b := make([]byte, header&0xffffff)
+		if _, err := io.ReadFull(s.client.r, b); err != nil {
+			panic(err)
+		}
+		if header>>24 == rsyncMplexBase {
+			s.data.Write(b)
+		} else {
+			s.messages = append(s.messages, string(b))
+		}
+	}
+	return s.data.Next(n)
+}
+
+func (s *testRsyncSender) readInt() int32 {
+	return int32(binary.LittleEndian.Uint32(s.read(4)))
+}
+
+type testRsyncFile struct {
+	name  string
+	mode  uint32
+	mtime int64
+	data  []byte
+}
+
+// send sends the files to the receiver and responds to its requests, sending
+// the blocks that the receiver already has by reference.
+func (s *testRsyncSender) send(files []testRsyncFile) {
+	s.writeInt(31)
+	if v := s.readRawInt(); v != rsyncProtocolVersion {
+		panic("unexpected protocol version")
+	}
+	s.seed = s.readRawInt()
+
+	for i, f := range files {
+		// Send the second file with the start of its name shared with the
+		// first, which is how rsync compresses the list.
+		if i == 1 {
+			_, _ = s.client.w.Write([]byte{rsyncXmitSameName, 0, byte(len(f.name))})
+			_, _ = s.client.w.Write([]byte(f.name))
+		} else {
+			_, _ = s.client.w.Write([]byte{rsyncXmitLongName})
+			s.writeInt(int32(len(f.name)))
+			_, _ = s.client.w.Write([]byte(f.name))
+		}
+		s.writeInt(int32(len(f.data)))
+		s.writeInt(int32(f.mtime))
+		s.writeInt(int32(f.mode))
+	}
+	_, _ = s.client.w.Write([]byte{0})
+	s.writeInt(0)
+
+	sorted := slices.Clone(files)
+	slices.SortFunc(sorted, func(a, b testRsyncFile) int {
+		return bytes.Compare([]byte(a.name), []byte(b.name))
+	})
+
+	phase := 0
+	for {
+		ndx := s.readInt()
+		if ndx == -1 {
+			phase++
+			if phase > 1 {
+				break
+			}
+			s.writeInt(-1)
+			continue
+		}
+		var head [4]int32
+		for i := range head {
+			head[i] = s.readInt()
+		}
+		count, blength, s2length := int(head[0]), int(head[1]), int(head[2])
+		sums := make([][]byte, count)
+		for i := range sums {
+			s.readInt()
+			sums[i] = s.read(s2length)
+		}
+
+		data := sorted[ndx].data
+		s.writeInt(ndx)
+		for _, v := range head {
+			s.writeInt(v)
+		}
+		step := blength
+		if step == 0 {
+			step = len(data)
+		}
+		for off := 0; off < len(data); off += step {
+			block := data[off:min(off+step, len(data))]
+			if i := off / step; blength > 0 && i < count && bytes.Equal(rsyncBlockSum(block, s.seed)[:s2length], sums[i]) {
+				s.writeInt(-int32(i + 1))
+				continue
+			}
+			s.literal += len(block)
+			s.writeInt(int32(len(block)))
+			_, _ = s.client.w.Write(block)
+		}
+		s.writeInt(0)
+		sum := newRsyncFileSum(s.seed)
+		sum.Write(data)
+		_, _ = s.client.w.Write(sum.Sum(nil))
+	}
+	s.writeInt(-1)
+	if s.readInt() != -1 {
+		panic("missing final goodbye")
+	}
+}
+
+func TestRsyncReceiver(t *testing.T) {
+	g := Goblin(t)
+
+	var root string
+	var h *Handler
+	run := func(args ...string) (*testRsyncSender, chan uint32, *testChannel) {
+		channel, client := newTestChannel(t)
+		status := make(chan uint32, 1)
+		go func() {
+			status <- newRsyncReceiver(h, channel).run(args)
+			_ = channel.Close()
+		}()
+		return &testRsyncSender{client: client}, status, channel
+	}
+
+	g.Describe("rsync receiver", func() {
+		g.BeforeEach(func() {
+			root = t.TempDir()
+			config.Set(&config.Configuration{AuthenticationToken: "abc", System: config.SystemConfiguration{RootDirectory: root}})
+			initDatabase(t)
+			h = newTestHandler(t, root, "*")
+		})
+
+		g.It("only sends the parts of files that have changed", func() {
+			jar := make([]byte, 4000)
+			rand.New(rand.NewSource(1)).Read(jar)
+			_ = os.MkdirAll(filepath.Join(root, "plugins"), 0o755)
+			_ = os.WriteFile(filepath.Join(root, "plugins/a.jar"), jar, 0o644)
+			mtime := time.Unix(1700000000, 0)
+			_ = os.WriteFile(filepath.Join(root, "server.properties"), []byte("motd=hello"), 0o644)
+			_ = os.Chtimes(filepath.Join(root, "server.properties"), mtime, mtime)
+
+			updated := slices.Clone(jar)
+			copy(updated[1500:], "changed")
+
+			sender, status, _ := run("rsync", "--server", "-rte.iLsfxC", ".", ".")
+			sender.send([]testRsyncFile{
+				{name: "plugins/a.jar", mode: 0o100644, mtime: mtime.Unix(), data: updated},
+				{name: "plugins/new.txt", mode: 0o100644, mtime: mtime.Unix(), data: []byte("new file")},
+				{name: "server.properties", mode: 0o100644, mtime: mtime.Unix(), data: []byte("motd=hello")},
+				{name: "plugins", mode: 0o040755, mtime: mtime.Unix()},
+				{name: ".", mode: 0o040755, mtime: mtime.Unix()},
+			})
+			g.Assert(sender.messages).Equal([]string(nil))
+			g.Assert(<-status).Equal(uint32(0))
+
+			b, _ := os.ReadFile(filepath.Join(root, "plugins/a.jar"))
+			g.Assert(bytes.Equal(b, updated)).IsTrue()
+			b, _ = os.ReadFile(filepath.Join(root, "plugins/new.txt"))
+			g.Assert(string(b)).Equal("new file")
+			// Only the block that changed and the new file should have been sent.
+			g.Assert(sender.literal).Equal(rsyncBlockLength + len("new file"))
+
+			st, _ := os.Stat(filepath.Join(root, "plugins/a.jar"))
+			g.Assert(st.ModTime().Unix()).Equal(mtime.Unix())
+			entries, _ := os.ReadDir(filepath.Join(root, "plugins"))
+			g.Assert(len(entries)).Equal(2)
+		})
+
+		g.It("does not write files the user cannot write", func() {
+			h.permissions = []string{"file.read", "file.update"}
+			_ = os.MkdirAll(filepath.Join(root, "plugins"), 0o755)
+			sender, status, _ := run("rsync", "--server", "-re.iLsfxC", ".", "plugins/")
+			sender.send([]testRsyncFile{
+				{name: "a.jar", mode: 0o100644, mtime: 1700000000, data: []byte("abc")},
+			})
+			g.Assert(<-status).Equal(uint32(rsyncErrPartial))
+			g.Assert(len(sender.messages)).Equal(1)
+
+			_, err := os.Stat(filepath.Join(root, "plugins/a.jar"))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+
+		g.It("refuses options that are not supported", func() {
+			_, status, channel := run("rsync", "--server", "-rz", "--delete", ".", ".")
+			g.Assert(<-status).Equal(uint32(rsyncErrUnsupported))
+			g.Assert(channel.stderr.String()).Equal("rsync: --compress is not supported: option is not supported\n")
+		})
+	})
+}
+
+// rsyncConn is an SSH channel backed by the connection from the remote shell that
+// TestRsyncClient runs rsync with.
+type rsyncConn struct {
+	*net.TCPConn
+	stderr bytes.Buffer
+}
+
+func (c *rsyncConn) Stderr() io.ReadWriter { return &c.stderr }
+
+func (c *rsyncConn) SendRequest(string, bool, []byte) (bool, error) {
+	return true, nil
+}
+
+// TestRsyncHelper is not a test, it is the remote shell that rsync runs in
+// TestRsyncClient. It sends the remote command to the address in the
+// WINGS_RSYNC_HELPER environment variable and connects rsync to it, then exits
+// with the status the receiver wrote to the WINGS_RSYNC_STATUS file.
+func TestRsyncHelper(t *testing.T) {
+	addr := os.Getenv("WINGS_RSYNC_HELPER")
+	if addr == "" {
+		return
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		os.Exit(255)
+	}
+	// The arguments are the host followed by the remote command.
+	args := os.Args[slices.Index(os.Args, "--")+2:]
+	b, _ := json.Marshal(args)
+	_, _ = conn.Write(append(b, '\n'))
+	go func() {
+		_, _ = io.Copy(conn, os.Stdin)
+		_ = conn.(*net.TCPConn).CloseWrite()
+	}()
+	_, _ = io.Copy(os.Stdout, conn)
+	b, _ = os.ReadFile(os.Getenv("WINGS_RSYNC_STATUS"))
+	code, err := strconv.Atoi(string(b))
+	if err != nil {
+		os.Exit(255)
+	}
+	os.Exit(code)
+}
+
+func TestRsyncClient(t *testing.T) {
+	if _, err := exec.LookPath("rsync"); err != nil {
+		t.Skip("rsync is not installed")
+	}
+	g := Goblin(t)
+
+	var root string
+	var h *Handler
+	// rsync runs the test binary as its remote shell, which connects the
+	// transfer to a receiver started for the remote command.
+	rsync := func(args ...string) (uint32, error) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		defer l.Close()
+		statusFile := filepath.Join(t.TempDir(), "status")
+		status := make(chan uint32, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				status <- rsyncErrStreamIO
+				return
+			}
+			channel := &rsyncConn{TCPConn: conn.(*net.TCPConn)}
+			defer channel.Close()
+			line, err := bufio.NewReader(io.LimitReader(conn, 4096)).ReadBytes('\n')
+			var command []string
+			if err != nil || json.Unmarshal(line, &command) != nil {
+				status <- rsyncErrSyntax
+				return
+			}
+			code := newRsyncReceiver(h, channel).run(command)
+			_ = os.WriteFile(statusFile, []byte(strconv.Itoa(int(code))), 0o600)
+			status <- code
+		}()
+
+		exe, err := os.Executable()
+		if err != nil {
+			return 0, err
+		}
+		cmd := exec.Command("rsync", append([]string{"-e", exe + " -test.run=^TestRsyncHelper$ --"}, args...)...)
+		cmd.Env = append(os.Environ(), "WINGS_RSYNC_HELPER="+l.Addr().String(), "WINGS_RSYNC_STATUS="+statusFile)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return 0, errors.New(string(out))
+		}
+		return <-status, nil
+	}
+
+	g.Describe("rsync client", func() {
+		g.BeforeEach(func() {
+			root = t.TempDir()
+			config.Set(&config.Configuration{AuthenticationToken: "abc", System: config.SystemConfiguration{RootDirectory: root}})
+			initDatabase(t)
+			h = newTestHandler(t, root, "*")
+		})
+
+		g.It("uploads files with rsync", func() {
+			src := t.TempDir()
+			_ = os.MkdirAll(filepath.Join(src, "config"), 0o755)
+			_ = os.WriteFile(filepath.Join(src, "plugin.jar"), bytes.Repeat([]byte("jar"), 4096), 0o644)
+			_ = os.WriteFile(filepath.Join(src, "config/config.yml"), []byte("enabled: true"), 0o644)
+			mtime := time.Unix(1700000000, 0)
+			_ = os.Chtimes(filepath.Join(src, "plugin.jar"), mtime, mtime)
+
+			status, err := rsync("-rt", src+"/", "server:plugins/")
+			g.Assert(err).IsNil()
+			g.Assert(status).Equal(uint32(0))
+
+			b, _ := os.ReadFile(filepath.Join(root, "plugins/plugin.jar"))
+			g.Assert(bytes.Equal(b, bytes.Repeat([]byte("jar"), 4096))).IsTrue()
+			b, _ = os.ReadFile(filepath.Join(root, "plugins/config/config.yml"))
+			g.Assert(string(b)).Equal("enabled: true")
+			st, _ := os.Stat(filepath.Join(root, "plugins/plugin.jar"))
+			g.Assert(st.ModTime().Unix()).Equal(mtime.Unix())
+
+			// Running it again updates the files that have changed.
+			_ = os.WriteFile(filepath.Join(src, "config/config.yml"), []byte("enabled: false"), 0o644)
+			status, err = rsync("-rt", src+"/", "server:plugins/")
+			g.Assert(err).IsNil()
+			g.Assert(status).Equal(uint32(0))
+			b, _ = os.ReadFile(filepath.Join(root, "plugins/config/config.yml"))
+			g.Assert(string(b)).Equal("enabled: false")
+		})
+
+		g.It("fails for files the user cannot write", func() {
+			h.permissions = []string{"file.read", "file.update"}
+			src := t.TempDir()
+			_ = os.WriteFile(filepath.Join(src, "a.jar"), []byte("abc"), 0o644)
+
+			_, err := rsync("-rt", src+"/", "server:.")
+			g.Assert(err).IsNotNil()
+			_, err = os.Stat(filepath.Join(root, "a.jar"))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+	})
+}
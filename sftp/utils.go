@@ -3,6 +3,12 @@ package sftp
 import (
 	"io"
 	"os"
+	"strings"
+
+	"emperror.dev/errors"
+
+	"github.com/Minenetpro/pelican-wings/internal/ufs"
+	"github.com/Minenetpro/pelican-wings/server"
 )
 
 const (
@@ -38,3 +44,132 @@ func (e fxErr) Error() string {
 		return "Failure"
 	}
 }
+
+// quotaWriterAt returns a quota exceeded error to the client once a write to
+// the file would put the server over its disk limit, rather than a generic
+// failure. Everything written is recorded against the SFTP session, and writes
+// are held back to the bandwidth limits of the session.
+type quotaWriterAt struct {
+	*ufs.QuotaWriter
+	session *server.SftpSession
+}
+
+func (w *quotaWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if err := w.session.WaitWrite(len(p)); err != nil {
+		return 0, err
+	}
+	n, err := w.QuotaWriter.WriteAt(p, off)
+	w.session.AddWritten(int64(n))
+	if errors.Is(err, ufs.ErrQuotaExceeded) {
+		return n, ErrSSHQuotaExceeded
+	}
+	return n, err
+}
+
+// sessionReaderAt records everything read from the file against the SFTP
+// session, and holds reads back to the bandwidth limits of the session. The
+// file is embedded so that it is still closed once the transfer is complete.
+type sessionReaderAt struct {
+	ufs.File
+	session *server.SftpSession
+}
+
+func (r *sessionReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.session.WaitRead(len(p)); err != nil {
+		return 0, err
+	}
+	n, err := r.File.ReadAt(p, off)
+	r.session.AddRead(int64(n))
+	return n, err
+}
+
+// sessionWriter records everything written through it against the SFTP
+// session, and holds writes back to the bandwidth limits of the session. This
+// is used by transfers that stream files rather than writing at offsets.
+type sessionWriter struct {
+	w       io.Writer
+	session *server.SftpSession
+}
+
+func (w *sessionWriter) Write(p []byte) (int, error) {
+	if err := w.session.WaitWrite(len(p)); err != nil {
+		return 0, err
+	}
+	n, err := w.w.Write(p)
+	w.session.AddWritten(int64(n))
+	return n, err
+}
+
+// sessionReader records everything read through it against the SFTP session,
+// and holds reads back to the bandwidth limits of the session.
+type sessionReader struct {
+	r       io.Reader
+	session *server.SftpSession
+}
+
+func (r *sessionReader) Read(p []byte) (int, error) {
+	if err := r.session.WaitRead(len(p)); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.session.AddRead(int64(n))
+	return n, err
+}
+
+// splitCommand splits the command sent with an exec request into its arguments
+// in the same way as a shell would, so that quoted and escaped arguments sent
+// by scp and rsync are handled. Nothing other than quoting is interpreted.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	var quote rune
+	var inArg bool
+	r := []rune(s)
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case quote == '\'':
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case quote == '"':
+			// Within double quotes a backslash only escapes the characters that
+			// would otherwise be special.
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && i+1 < len(r) && strings.ContainsRune("$`\"\\\n", r[i+1]) {
+				i++
+				arg.WriteRune(r[i])
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\\':
+			if i+1 == len(r) {
+				return nil, errors.New("sftp: unterminated escape in command")
+			}
+			i++
+			arg.WriteRune(r[i])
+			inArg = true
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("sftp: unterminated quote in command")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -167,6 +167,43 @@ func GetSystemInformation() (*Information, error) {
 	}, nil
 }
 
+// GetHostInformation returns information about the system without contacting
+// Docker, for nodes that run servers without it. The Docker information is left
+// empty.
+func GetHostInformation() (*Information, error) {
+	k, err := kernel.GetKernelVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := osrelease.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, err
+	}
+
+	os := release["PRETTY_NAME"]
+	if os == "" {
+		os = release["NAME"]
+	}
+
+	return &Information{
+		Version: Version,
+		System: System{
+			Architecture:  runtime.GOARCH,
+			CPUThreads:    runtime.NumCPU(),
+			MemoryBytes:   int64(m.Total),
+			KernelVersion: k.String(),
+			OS:            os,
+			OSType:        runtime.GOOS,
+		},
+	}, nil
+}
+
 func GetSystemIps() ([]string, error) {
 	var ip_addrs []string
 	iface_addrs, err := net.InterfaceAddrs()